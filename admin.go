@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultPageLimit ограничивает размер страницы списков административного API по умолчанию
+const defaultPageLimit = 100
+
+// maxPageLimit ограничивает максимальный размер страницы списков административного API
+const maxPageLimit = 1000
+
+// ItemPage представляет страницу списка элементов
+type ItemPage[T any] struct {
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+	Items  []T `json:"items"`
+}
+
+// requeueRequest представляет тело запроса на возврат элементов из очереди недоставленных
+type requeueRequest struct {
+	IDs  []uint64          `json:"ids"`
+	Set  map[string]string `json:"set,omitempty"`  // поля, заменяемые в каждом элементе перед возвратом
+	Item map[string]string `json:"item,omitempty"` // новые данные элемента; только вместе с одним ids
+}
+
+// edit возвращает функцию изменения данных элемента по запросу или nil, если изменений нет
+func (r requeueRequest) edit() func(map[string]string) map[string]string {
+	if r.Item == nil && len(r.Set) == 0 {
+		return nil
+	}
+	return func(item map[string]string) map[string]string {
+		if r.Item != nil {
+			item = r.Item
+		}
+		if item == nil {
+			item = make(map[string]string, len(r.Set))
+		}
+		for key, value := range r.Set {
+			item[key] = value
+		}
+		return item
+	}
+}
+
+// updateRequest представляет тело запроса на изменение ожидающего элемента
+type updateRequest struct {
+	Set  map[string]string `json:"set,omitempty"`  // заменяемые поля
+	Item map[string]string `json:"item,omitempty"` // новые данные элемента целиком
+}
+
+// purgeRequest представляет тело запроса на удаление недоставленных элементов
+type purgeRequest struct {
+	IDs []uint64 `json:"ids"`
+	All bool     `json:"all"` // удалить все элементы; без него пустой ids ничего не удаляет
+}
+
+// NewAdminHandler создает административный HTTP API буфера, доступный по токену в заголовке Authorization.
+//
+//	GET  /stats              статистика буфера
+//	GET  /queue              ожидающие элементы (?offset=&limit=)
+//	GET  /queue/next         элемент, который будет отправлен следующим
+//	POST /queue/{id}/cancel  убрать ожидающий элемент из очереди
+//	POST /queue/{id}/update  изменить ожидающий элемент ({"set": {...}} или {"item": {...}})
+//	GET  /inflight           элементы, отправляемые в данный момент
+//	GET  /dlq                недоставленные элементы (?offset=&limit=&since=&until=&error=&indicator=)
+//	GET  /dlq/{id}           недоставленный элемент с историей попыток
+//	POST /dlq/purge          удалить недоставленные элементы ({"ids": [...]} или {"all": true})
+//	POST /pause              приостановить отправку
+//	POST /resume             возобновить отправку
+//	POST /flush              отправить все накопленные элементы
+//	POST /requeue            вернуть недоставленные элементы в очередь ({"ids": [...], "set": {...}, "item": {...}}, без ids - все)
+//	GET  /recent             последние попытки отправки
+//	GET  /config             действующие настройки буфера
+//	GET  /metrics            метрики в формате Prometheus
+//	GET  /ui/                веб-панель мониторинга (без авторизации, данные запрашиваются по токену)
+func NewAdminHandler(b *Buffer, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, b.Stats())
+	})
+	mux.HandleFunc("GET /queue", func(w http.ResponseWriter, r *http.Request) {
+		offset, limit := pageParams(r)
+		items, total := b.queuedItems(offset, limit)
+		writeJSON(w, http.StatusOK, ItemPage[ItemInfo]{Total: total, Offset: offset, Limit: limit, Items: items})
+	})
+	mux.HandleFunc("GET /queue/next", func(w http.ResponseWriter, r *http.Request) {
+		item, ok := b.Peek()
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("queue is empty"))
+			return
+		}
+		writeJSON(w, http.StatusOK, item)
+	})
+	mux.HandleFunc("POST /queue/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid item ID %q", r.PathValue("id")))
+			return
+		}
+		if err := b.Cancel(id); err != nil {
+			writeError(w, queueErrorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]uint64{"canceled": id})
+	})
+	mux.HandleFunc("POST /queue/{id}/update", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid item ID %q", r.PathValue("id")))
+			return
+		}
+		var req updateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		edit := requeueRequest{Set: req.Set, Item: req.Item}.edit()
+		if edit == nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("set or item is required"))
+			return
+		}
+		if err := b.Update(id, edit); err != nil {
+			writeError(w, queueErrorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]uint64{"updated": id})
+	})
+	mux.HandleFunc("GET /inflight", func(w http.ResponseWriter, r *http.Request) {
+		items := b.inFlightItems()
+		writeJSON(w, http.StatusOK, ItemPage[ItemInfo]{Total: len(items), Limit: len(items), Items: items})
+	})
+	mux.HandleFunc("GET /dlq", func(w http.ResponseWriter, r *http.Request) {
+		offset, limit := pageParams(r)
+		filter, err := deadLetterFilterParams(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		letters := b.dlq.Find(filter)
+		p := pageBounds(offset, limit, len(letters))
+		writeJSON(w, http.StatusOK, ItemPage[DeadLetter]{Total: len(letters), Offset: offset, Limit: limit, Items: letters[p.start:p.end]})
+	})
+	mux.HandleFunc("GET /dlq/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid dead letter ID %q", r.PathValue("id")))
+			return
+		}
+		letter, ok := b.dlq.Get(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("dead letter %d not found", id))
+			return
+		}
+		writeJSON(w, http.StatusOK, letter)
+	})
+	mux.HandleFunc("POST /dlq/purge", func(w http.ResponseWriter, r *http.Request) {
+		var req purgeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		purged := 0
+		if len(req.IDs) > 0 || req.All {
+			purged = len(b.dlq.Take(req.IDs...))
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"purged": purged})
+	})
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		b.Pause()
+		writeJSON(w, http.StatusOK, b.Stats())
+	})
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		b.Resume()
+		writeJSON(w, http.StatusOK, b.Stats())
+	})
+	mux.HandleFunc("POST /flush", func(w http.ResponseWriter, r *http.Request) {
+		b.Flush()
+		writeJSON(w, http.StatusOK, b.Stats())
+	})
+	mux.HandleFunc("POST /requeue", func(w http.ResponseWriter, r *http.Request) {
+		var req requeueRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+				return
+			}
+		}
+		if req.Item != nil && len(req.IDs) != 1 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("item requires exactly one ID"))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"requeued": b.RequeueEdited(req.IDs, req.edit())})
+	})
+	mux.HandleFunc("GET /recent", func(w http.ResponseWriter, r *http.Request) {
+		items := b.RecentSends()
+		writeJSON(w, http.StatusOK, ItemPage[RecentSend]{Total: len(items), Limit: len(items), Items: items})
+	})
+	mux.HandleFunc("GET /config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, b.ConfigInfo())
+	})
+	mux.Handle("GET /metrics", b.MetricsHandler())
+
+	root := http.NewServeMux()
+	root.Handle("GET /ui/", dashboardHandler())
+	root.Handle("/", requireToken(token, mux))
+	return root
+}
+
+// queueErrorStatus возвращает код ответа для ошибки Cancel или Update
+func queueErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotQueued):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInFlight):
+		return http.StatusConflict
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// requireToken пропускает только запросы с заголовком "Authorization: Bearer <token>"
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// pageParams читает параметры постраничного вывода из строки запроса
+func pageParams(r *http.Request) (offset, limit int) {
+	offset, _ = strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 {
+		limit = defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	return offset, limit
+}
+
+// deadLetterFilterParams разбирает фильтр недоставленных элементов из параметров since, until, error и indicator
+func deadLetterFilterParams(r *http.Request) (DeadLetterFilter, error) {
+	q := r.URL.Query()
+	filter := DeadLetterFilter{Error: q.Get("error"), Indicator: q.Get("indicator")}
+	var err error
+	if filter.Since, err = parseTimeFlag(q.Get("since")); err != nil {
+		return filter, fmt.Errorf("invalid since: %w", err)
+	}
+	if filter.Until, err = parseTimeFlag(q.Get("until")); err != nil {
+		return filter, fmt.Errorf("invalid until: %w", err)
+	}
+	return filter, nil
+}
+
+// writeJSON отправляет значение в виде JSON с указанным кодом ответа
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Println("Error writing admin response:", err)
+	}
+}
+
+// writeError отправляет описание ошибки в виде JSON
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
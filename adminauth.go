@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// AdminTLSConfig задает TLS административного API. Поля cert_file, key_file и client_ca_file
+// использует демон, остальные - команды stats, top, dlq и управления, обращающиеся к нему
+type AdminTLSConfig struct {
+	CertFile       string `yaml:"cert_file"`        // сертификат сервера; задан - API доступен только по HTTPS
+	KeyFile        string `yaml:"key_file"`         // закрытый ключ сертификата сервера
+	ClientCAFile   string `yaml:"client_ca_file"`   // УЦ клиентских сертификатов; задан - API требует сертификат клиента
+	CAFile         string `yaml:"ca_file"`          // УЦ сертификата сервера для команд, по умолчанию системные
+	ClientCertFile string `yaml:"client_cert_file"` // сертификат клиента для команд
+	ClientKeyFile  string `yaml:"client_key_file"`  // закрытый ключ сертификата клиента
+}
+
+// validate проверяет, что сертификаты заданы вместе с ключами
+func (c AdminTLSConfig) validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("tls.cert_file and tls.key_file must be set together")
+	}
+	if c.ClientCAFile != "" && c.CertFile == "" {
+		return fmt.Errorf("tls.client_ca_file requires tls.cert_file")
+	}
+	if (c.ClientCertFile == "") != (c.ClientKeyFile == "") {
+		return fmt.Errorf("tls.client_cert_file and tls.client_key_file must be set together")
+	}
+	return nil
+}
+
+// serverConfig загружает сертификат сервера и УЦ клиентов
+func (c AdminTLSConfig) serverConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading admin API certificate %s: %w", c.CertFile, err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if c.ClientCAFile != "" {
+		if config.ClientCAs, err = loadCAFile(c.ClientCAFile); err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// clientConfig загружает УЦ сервера и сертификат клиента для обращения к API
+func (c AdminTLSConfig) clientConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.CAFile != "" {
+		roots, err := loadCAFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = roots
+	}
+	if c.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCertFile, c.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading admin API client certificate %s: %w", c.ClientCertFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// validate проверяет список разрешенных адресов и настройки TLS
+func (c AdminConfig) validate() error {
+	if _, err := parseAllowlist(c.Allow); err != nil {
+		return err
+	}
+	return c.TLS.validate()
+}
+
+// listen открывает адрес административного API, с TLS, если задан сертификат сервера
+func (c AdminConfig) listen(addr string) (net.Listener, error) {
+	var config *tls.Config
+	if c.TLS.CertFile != "" {
+		var err error
+		if config, err = c.TLS.serverConfig(); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil || config == nil {
+		return ln, err
+	}
+	return tls.NewListener(ln, config), nil
+}
+
+// protect ограничивает доступ к административному API адресами из allow
+func (c AdminConfig) protect(next http.Handler) (http.Handler, error) {
+	allow, err := parseAllowlist(c.Allow)
+	if err != nil || len(allow) == 0 {
+		return next, err
+	}
+	return allowFrom(allow, next), nil
+}
+
+// parseAllowlist разбирает адреса и подсети CIDR; адрес без длины префикса задает один узел
+func parseAllowlist(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("allow: %q is not an IP address or CIDR", entry)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("allow: %q is not an IP address or CIDR", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// allowFrom пропускает только запросы с адресов из allow. Проверяется адрес соединения,
+// заголовки X-Forwarded-For не учитываются, чтобы их нельзя было подделать
+func allowFrom(allow []netip.Prefix, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+		if err == nil {
+			addr := addrPort.Addr().Unmap()
+			for _, prefix := range allow {
+				if prefix.Contains(addr) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		logDebugf("Admin API request from %s rejected by admin.allow", r.RemoteAddr)
+		writeError(w, http.StatusForbidden, fmt.Errorf("forbidden"))
+	})
+}
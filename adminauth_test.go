@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminAllowlist(t *testing.T) {
+	SetLogLevel(LevelError)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	handler, err := AdminConfig{Allow: []string{"10.0.0.0/8", "192.168.1.5", "2001:db8::1/32"}}.protect(ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remote, forwarded string
+		status            int
+	}{
+		{"10.1.2.3:40000", "", http.StatusOK},
+		{"192.168.1.5:40000", "", http.StatusOK},
+		{"192.168.1.6:40000", "", http.StatusForbidden},
+		{"[::ffff:10.0.0.1]:40000", "", http.StatusOK}, // IPv4 в записи IPv6
+		{"[2001:db8:ffff::1]:40000", "", http.StatusOK},
+		{"8.8.8.8:40000", "10.0.0.1", http.StatusForbidden}, // заголовок прокси не учитывается
+		{"@", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/stats", nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("request from %s (forwarded %q) got %d, want %d", tt.remote, tt.forwarded, w.Code, tt.status)
+		}
+	}
+
+	// Без списка запросы не ограничиваются, а неверные записи отвергаются при проверке настроек
+	open, err := AdminConfig{}.protect(ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	open.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("request without admin.allow got %d", w.Code)
+	}
+	for _, entry := range []string{"10.0.0.0/33", "localhost", "10.0.0.300"} {
+		if err := (AdminConfig{Allow: []string{entry}}).validate(); err == nil {
+			t.Errorf("admin.allow %q accepted", entry)
+		}
+	}
+}
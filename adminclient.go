@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adminClient обращается к административному API запущенного буфера
+type adminClient struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+// newAdminClient создает клиента административного API; адрес без схемы дополняется http://
+func newAdminClient(addr, token string) *adminClient {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &adminClient{
+		addr:   strings.TrimRight(addr, "/"),
+		token:  token,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// adminClient создает клиента административного API по настройкам admin.tls: адрес без схемы
+// дополняется https://, если у API есть сертификат. Для настроек клиента из tenants запросы
+// направляются в его раздел /tenants/{name}
+func (c *Config) adminClient(addr, token string) (*adminClient, error) {
+	tlsConfig := c.Admin.TLS
+	if tlsConfig.CertFile != "" && !strings.Contains(addr, "://") {
+		addr = "https://" + addr
+	}
+	client := newAdminClient(addr, token)
+	if strings.HasPrefix(client.addr, "https://") {
+		config, err := tlsConfig.clientConfig()
+		if err != nil {
+			return nil, err
+		}
+		if config, err = applyTLSPolicy(config); err != nil {
+			return nil, fmt.Errorf("admin.tls: %w", err)
+		}
+		client.client.Transport = &http.Transport{TLSClientConfig: config, Proxy: http.ProxyFromEnvironment}
+	}
+	if c.tenant != "" {
+		client.addr += tenantPrefix(c.tenant)
+	}
+	return client, nil
+}
+
+// do выполняет запрос к API и декодирует JSON-ответ в out
+func (c *adminClient) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.addr+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("admin API responded with %s: %s", resp.Status, apiErr.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// stats запрашивает статистику буфера
+func (c *adminClient) stats() (Stats, error) {
+	var stats Stats
+	err := c.do(http.MethodGet, "/stats", nil, &stats)
+	return stats, err
+}
+
+// deadLetters запрашивает страницу очереди недоставленных элементов, подходящих под фильтр
+func (c *adminClient) deadLetters(filter DeadLetterFilter, offset, limit int) (ItemPage[DeadLetter], error) {
+	params := url.Values{}
+	params.Set("offset", strconv.Itoa(offset))
+	params.Set("limit", strconv.Itoa(limit))
+	if !filter.Since.IsZero() {
+		params.Set("since", filter.Since.Format(time.RFC3339))
+	}
+	if !filter.Until.IsZero() {
+		params.Set("until", filter.Until.Format(time.RFC3339))
+	}
+	if filter.Error != "" {
+		params.Set("error", filter.Error)
+	}
+	if filter.Indicator != "" {
+		params.Set("indicator", filter.Indicator)
+	}
+	var page ItemPage[DeadLetter]
+	err := c.do(http.MethodGet, "/dlq?"+params.Encode(), nil, &page)
+	return page, err
+}
+
+// find запрашивает все недоставленные элементы, подходящие под фильтр, постранично
+func (c *adminClient) find(filter DeadLetterFilter) ([]DeadLetter, error) {
+	var letters []DeadLetter
+	for {
+		page, err := c.deadLetters(filter, len(letters), maxPageLimit)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, page.Items...)
+		if len(page.Items) == 0 || len(letters) >= page.Total {
+			return letters, nil
+		}
+	}
+}
+
+// get запрашивает недоставленный элемент по идентификатору
+func (c *adminClient) get(id uint64) (DeadLetter, error) {
+	var letter DeadLetter
+	err := c.do(http.MethodGet, fmt.Sprintf("/dlq/%d", id), nil, &letter)
+	return letter, err
+}
+
+// requeue возвращает недоставленные элементы в очередь; без ids - все
+func (c *adminClient) requeue(req requeueRequest) (int, error) {
+	var resp struct {
+		Requeued int `json:"requeued"`
+	}
+	err := c.do(http.MethodPost, "/requeue", req, &resp)
+	return resp.Requeued, err
+}
+
+// purge удаляет недоставленные элементы
+func (c *adminClient) purge(req purgeRequest) (int, error) {
+	var resp struct {
+		Purged int `json:"purged"`
+	}
+	err := c.do(http.MethodPost, "/dlq/purge", req, &resp)
+	return resp.Purged, err
+}
+
+// control выполняет действие pause, resume или flush и возвращает статистику после него
+func (c *adminClient) control(action string) (Stats, error) {
+	var stats Stats
+	err := c.do(http.MethodPost, "/"+action, nil, &stats)
+	return stats, err
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Условия, при которых срабатывает оповещение
+const (
+	AlertFailureRate = "failure_rate"
+	AlertDLQGrowth   = "dlq_growth"
+	AlertQueueSize   = "queue_size"
+)
+
+// Alert описывает сработавшее условие оповещения
+type Alert struct {
+	Condition string    `json:"condition"`
+	Message   string    `json:"message"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Time      time.Time `json:"time"`
+}
+
+// AlertHook получает оповещения о проблемах с доставкой
+type AlertHook interface {
+	Alert(alert Alert) error
+}
+
+// AlertConfig задает пороги срабатывания оповещений; нулевой порог отключает условие
+type AlertConfig struct {
+	Interval      time.Duration // период проверки условий
+	FailureRate   float64       // доля неуспешных попыток за период (от 0 до 1)
+	MinAttempts   uint64        // минимальное число попыток за период для оценки доли ошибок
+	DLQSize       int           // размер очереди недоставленных элементов, при росте сверх которого срабатывает оповещение
+	QueueSize     int           // размер очереди, который не должен держаться дольше QueueDuration
+	QueueDuration time.Duration
+}
+
+// Alerter периодически проверяет состояние буфера и вызывает обработчики оповещений
+type Alerter struct {
+	buffer *Buffer
+	cfg    AlertConfig
+	hooks  []AlertHook
+
+	lastAttempts uint64
+	lastFailed   uint64
+	lastDLQ      int
+	queueSince   time.Time
+	active       map[string]bool
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewAlerter создает проверку оповещений для буфера
+func NewAlerter(b *Buffer, cfg AlertConfig, hooks ...AlertHook) *Alerter {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Minute
+	}
+	return &Alerter{
+		buffer: b,
+		cfg:    cfg,
+		hooks:  hooks,
+		active: make(map[string]bool),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Start запускает периодическую проверку условий в отдельной горутине
+func (a *Alerter) Start() {
+	stats := a.buffer.Stats()
+	a.lastAttempts = stats.Attempts
+	a.lastFailed = stats.Failed
+	a.lastDLQ = stats.DeadLetters
+	go a.loop()
+}
+
+// Stop останавливает проверку и дожидается ее завершения
+func (a *Alerter) Stop() {
+	a.once.Do(func() { close(a.stop) })
+	<-a.done
+}
+
+// loop выполняет проверку условий с заданным периодом
+func (a *Alerter) loop() {
+	defer close(a.done)
+	ticker := a.buffer.clock.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case now := <-ticker.C():
+			a.check(now)
+		}
+	}
+}
+
+// check оценивает условия и отправляет оповещения при переходе условия в активное состояние
+func (a *Alerter) check(now time.Time) {
+	stats := a.buffer.Stats()
+
+	if a.cfg.FailureRate > 0 {
+		attempts := stats.Attempts - a.lastAttempts
+		failed := stats.Failed - a.lastFailed
+		var rate float64
+		if attempts > 0 {
+			rate = float64(failed) / float64(attempts)
+		}
+		firing := attempts > 0 && attempts >= a.cfg.MinAttempts && rate > a.cfg.FailureRate
+		a.update(AlertFailureRate, firing, Alert{
+			Message:   fmt.Sprintf("failure rate %.1f%% over last %s (%d of %d attempts)", rate*100, a.cfg.Interval, failed, attempts),
+			Value:     rate,
+			Threshold: a.cfg.FailureRate,
+			Time:      now,
+		})
+	}
+	a.lastAttempts = stats.Attempts
+	a.lastFailed = stats.Failed
+
+	if a.cfg.DLQSize > 0 {
+		firing := stats.DeadLetters > a.cfg.DLQSize && stats.DeadLetters > a.lastDLQ
+		a.update(AlertDLQGrowth, firing, Alert{
+			Message:   fmt.Sprintf("dead letter queue grew from %d to %d items", a.lastDLQ, stats.DeadLetters),
+			Value:     float64(stats.DeadLetters),
+			Threshold: float64(a.cfg.DLQSize),
+			Time:      now,
+		})
+	}
+	a.lastDLQ = stats.DeadLetters
+
+	if a.cfg.QueueSize > 0 {
+		if stats.Queued <= a.cfg.QueueSize {
+			a.queueSince = time.Time{}
+		} else if a.queueSince.IsZero() {
+			a.queueSince = now
+		}
+		firing := !a.queueSince.IsZero() && now.Sub(a.queueSince) >= a.cfg.QueueDuration
+		a.update(AlertQueueSize, firing, Alert{
+			Message:   fmt.Sprintf("queue has %d items, above %d for %s", stats.Queued, a.cfg.QueueSize, now.Sub(a.queueSince).Round(time.Second)),
+			Value:     float64(stats.Queued),
+			Threshold: float64(a.cfg.QueueSize),
+			Time:      now,
+		})
+	}
+}
+
+// update запоминает состояние условия и оповещает обработчики только при его срабатывании
+func (a *Alerter) update(condition string, firing bool, alert Alert) {
+	wasActive := a.active[condition]
+	a.active[condition] = firing
+	if !firing || (wasActive && condition != AlertDLQGrowth) {
+		return
+	}
+	alert.Condition = condition
+	for _, hook := range a.hooks {
+		if err := hook.Alert(alert); err != nil {
+			fmt.Println("Error sending alert:", err)
+		}
+	}
+}
+
+// WebhookHook отправляет оповещения POST-запросом с JSON-описанием условия
+type WebhookHook struct {
+	URL    string
+	Client *http.Client
+}
+
+// Alert отправляет оповещение на URL вебхука
+func (h *WebhookHook) Alert(alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	resp, err := notifierClient(h.Client).Post(h.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
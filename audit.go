@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditFormat связывает зашифрованные записи с журналом аудита; заголовка формата у журнала нет
+const auditFormat = "buffer-audit"
+
+// AuditEntry представляет запись журнала аудита об одной попытке отправки
+type AuditEntry struct {
+	Time          time.Time         `json:"time"`
+	RequestID     string            `json:"request_id"`
+	CorrelationID string            `json:"correlation_id,omitempty"`
+	Provenance    *Provenance       `json:"provenance,omitempty"`
+	URL           string            `json:"url"`
+	Endpoint      string            `json:"endpoint,omitempty"` // адрес элемента вместо адреса буфера
+	Payload       map[string]string `json:"payload"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Status        int               `json:"status"`
+	Response      string            `json:"response,omitempty"`
+	LatencyMs     int64             `json:"latency_ms"`
+	Error         string            `json:"error,omitempty"`
+	Warnings      []string          `json:"warnings,omitempty"` // MESSAGES.warning ответа со STATUS OK
+	Final         bool              `json:"final"`
+}
+
+// AuditLog записывает каждую попытку отправки в файл только на дозапись (одна JSON-запись на строку)
+type AuditLog struct {
+	mu     sync.Mutex
+	f      *os.File
+	cipher *FileCipher
+}
+
+// OpenAuditLog открывает (или создает) файл журнала аудита для дозаписи; если задан cipher,
+// каждая запись шифруется отдельно
+func OpenAuditLog(path string, cipher *FileCipher) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{f: f, cipher: cipher}, nil
+}
+
+// Write добавляет запись в журнал и сбрасывает ее на диск
+func (a *AuditLog) Write(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.f.Write(append(a.cipher.Seal(auditFormat, line), '\n')); err != nil {
+		return err
+	}
+	return a.f.Sync()
+}
+
+// Close закрывает файл журнала
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Close()
+}
+
+// sanitizeHeaders возвращает копию заголовков запроса со скрытыми учетными данными: заголовками
+// авторизации и cookie, а также любыми заголовками с токеном, например ключом API
+func sanitizeHeaders(h http.Header, token string) map[string]string {
+	out := make(map[string]string, len(h))
+	for key := range h {
+		value := h.Get(key)
+		if sensitiveHeaders[key] || token != "" && strings.Contains(value, token) {
+			value = "***"
+		}
+		out[key] = value
+	}
+	return out
+}
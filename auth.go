@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultSessionTTL задает время жизни сессии, если сервер не указал срок действия cookie
+const defaultSessionTTL = 30 * time.Minute
+
+// Authenticator добавляет к запросу к API учетные данные; secret - значение из источника
+// токена: токен, ключ API или пароль, в зависимости от схемы
+type Authenticator interface {
+	Authorize(client *http.Client, req *http.Request, secret string) error
+}
+
+// bearerAuth передает токен в заголовке Authorization: Bearer, как ожидает API KPI-Drive
+type bearerAuth struct{}
+
+func (bearerAuth) Authorize(_ *http.Client, req *http.Request, secret string) error {
+	req.Header.Set("Authorization", "Bearer "+secret)
+	return nil
+}
+
+// apiKeyAuth передает ключ API в отдельном заголовке
+type apiKeyAuth struct {
+	header string
+}
+
+func (a apiKeyAuth) Authorize(_ *http.Client, req *http.Request, secret string) error {
+	req.Header.Set(a.header, secret)
+	return nil
+}
+
+// basicAuth передает имя пользователя и пароль по схеме HTTP Basic
+type basicAuth struct {
+	username string
+}
+
+func (a basicAuth) Authorize(_ *http.Client, req *http.Request, secret string) error {
+	req.SetBasicAuth(a.username, secret)
+	return nil
+}
+
+// sessionAuth входит на сервер запросом к login_url и передает полученные cookie сессии.
+// Сессия открывается заново по истечении срока действия и после ответа 401
+type sessionAuth struct {
+	loginURL      string
+	username      string
+	usernameField string
+	passwordField string
+	ttl           time.Duration
+	clock         Clock
+
+	mu      sync.Mutex
+	cookies []*http.Cookie
+	expires time.Time
+}
+
+func (a *sessionAuth) Authorize(client *http.Client, req *http.Request, secret string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cookies == nil || !a.clock.Now().Before(a.expires) {
+		if err := a.loginLocked(client, secret); err != nil {
+			return err
+		}
+	}
+	for _, cookie := range a.cookies {
+		req.AddCookie(cookie)
+	}
+	return nil
+}
+
+// Invalidate закрывает сессию, чтобы следующий запрос вошел заново
+func (a *sessionAuth) Invalidate() {
+	a.mu.Lock()
+	a.cookies = nil
+	a.mu.Unlock()
+}
+
+// loginLocked отправляет имя пользователя и пароль формой на login_url и сохраняет cookie
+// из ответа. Вызывается с захваченным a.mu
+func (a *sessionAuth) loginLocked(client *http.Client, password string) error {
+	form := url.Values{a.usernameField: {a.username}, a.passwordField: {password}}
+	resp, err := client.PostForm(a.loginURL, form)
+	if err != nil {
+		return fmt.Errorf("logging in: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("logging in: server responded with %s", resp.Status)
+	}
+	cookies := resp.Cookies()
+	if len(cookies) == 0 {
+		return fmt.Errorf("logging in: response has no session cookie")
+	}
+	now := a.clock.Now()
+	expires := now.Add(a.ttl)
+	for _, cookie := range cookies {
+		var lifetime time.Duration
+		switch {
+		case cookie.MaxAge > 0:
+			lifetime = time.Duration(cookie.MaxAge) * time.Second
+		case !cookie.Expires.IsZero():
+			lifetime = cookie.Expires.Sub(now)
+		default:
+			continue
+		}
+		// Сессия обновляется немного раньше срока, указанного сервером
+		if t := now.Add(lifetime * 9 / 10); t.Before(expires) {
+			expires = t
+		}
+	}
+	a.cookies, a.expires = cookies, expires
+	logDebugf("Logged in to %s, session valid until %s", a.loginURL, expires.Format(time.RFC3339))
+	return nil
+}
+
+// AuthDestination задает схему и учетные данные для серверов hosts вместо общих настроек auth
+type AuthDestination struct {
+	Hosts      []string `yaml:"hosts"`
+	AuthConfig `yaml:",inline"`
+}
+
+// ForURL возвращает настройки авторизации для адреса: из destinations, если сервер указан там,
+// иначе общие
+func (a AuthConfig) ForURL(rawURL string) AuthConfig {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return a
+	}
+	for _, d := range a.Destinations {
+		for _, host := range d.Hosts {
+			if strings.EqualFold(host, u.Hostname()) {
+				return d.AuthConfig
+			}
+		}
+	}
+	return a
+}
+
+// Authenticator возвращает схему авторизации по настройкам
+func (a AuthConfig) Authenticator() (Authenticator, error) {
+	switch a.Scheme {
+	case "", "bearer":
+		return bearerAuth{}, nil
+	case "api_key":
+		header := a.APIKeyHeader
+		if header == "" {
+			header = "X-API-Key"
+		}
+		return apiKeyAuth{header: header}, nil
+	case "basic":
+		if a.Username == "" {
+			return nil, fmt.Errorf("auth.username is required for basic auth")
+		}
+		return basicAuth{username: a.Username}, nil
+	case "session":
+		if a.LoginURL == "" || a.Username == "" {
+			return nil, fmt.Errorf("auth.login_url and auth.username are required for session auth")
+		}
+		s := &sessionAuth{
+			loginURL:      a.LoginURL,
+			username:      a.Username,
+			usernameField: a.LoginUsernameField,
+			passwordField: a.LoginPasswordField,
+			ttl:           a.SessionTTL,
+			clock:         systemClock,
+		}
+		if s.usernameField == "" {
+			s.usernameField = "login"
+		}
+		if s.passwordField == "" {
+			s.passwordField = "password"
+		}
+		if s.ttl <= 0 {
+			s.ttl = defaultSessionTTL
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown auth.scheme %q, expected bearer, api_key, basic or session", a.Scheme)
+	}
+}
+
+// validate проверяет схему авторизации общих настроек и каждого получателя
+func (a AuthConfig) validate() error {
+	if _, err := a.Authenticator(); err != nil {
+		return err
+	}
+	for i, d := range a.Destinations {
+		if len(d.Hosts) == 0 {
+			return fmt.Errorf("auth.destinations[%d]: hosts is required", i)
+		}
+		if len(d.Destinations) > 0 {
+			return fmt.Errorf("auth.destinations[%d]: nested destinations are not supported", i)
+		}
+		if _, err := d.Authenticator(); err != nil {
+			return fmt.Errorf("auth.destinations[%d]: %w", i, err)
+		}
+		if _, err := d.TokenProvider(); err != nil {
+			return fmt.Errorf("auth.destinations[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// WithAuthenticator задает схему авторизации запросов к API вместо Bearer
+func WithAuthenticator(a Authenticator) Option {
+	return func(b *Buffer) {
+		b.auth = a
+	}
+}
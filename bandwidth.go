@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"net/http"
+)
+
+// bandwidthChunk ограничивает порцию тела, передаваемую транспорту за одно ожидание
+const bandwidthChunk = 16 * 1024
+
+// WithBandwidthLimit ограничивает исходящий трафик запросов к API, включая get_facts и проверки
+// соединения, bytesPerSecond байтами в секунду с запасом burst байт (0 - одна секунда трафика),
+// независимо от ограничения частоты запросов. Учитываются строка запроса, заголовки и тело
+// после сжатия; большое тело передается порциями, чтобы канал не занимался целиком
+func WithBandwidthLimit(bytesPerSecond float64, burst int) Option {
+	return func(b *Buffer) {
+		if burst <= 0 {
+			burst = int(bytesPerSecond)
+		}
+		if b.bandwidth == nil {
+			b.bandwidth = newRateLimiter(bytesPerSecond, burst)
+			return
+		}
+		b.bandwidth.set(bytesPerSecond, burst)
+	}
+}
+
+// bandwidthTransport выдерживает паузы ограничения трафика перед передачей запроса и его тела
+type bandwidthTransport struct {
+	b    *Buffer
+	next http.RoundTripper
+}
+
+// newBandwidthTransport создает транспорт с ограничением трафика; next nil означает http.DefaultTransport
+func newBandwidthTransport(b *Buffer, next http.RoundTripper) *bandwidthTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &bandwidthTransport{b: b, next: next}
+}
+
+func (t *bandwidthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.wait(headerSize(req))
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.Clone(req.Context())
+		req.Body = &throttledBody{ReadCloser: req.Body, t: t}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// wait выдерживает паузу перед передачей n байт
+func (t *bandwidthTransport) wait(n int) {
+	t.b.clock.Sleep(t.b.bandwidth.reserveN(t.b.clock.Now(), float64(n)))
+}
+
+// headerSize оценивает размер строки запроса и заголовков в байтах
+func headerSize(req *http.Request) int {
+	n := len(req.Method) + len(req.URL.RequestURI()) + len(req.Host) + len(" HTTP/1.1\r\nHost: \r\n\r\n")
+	for key, values := range req.Header {
+		for _, value := range values {
+			n += len(key) + len(value) + len(": \r\n")
+		}
+	}
+	return n
+}
+
+// throttledBody передает тело запроса порциями, выдерживая паузы ограничения трафика
+type throttledBody struct {
+	io.ReadCloser
+	t *bandwidthTransport
+}
+
+func (r *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > bandwidthChunk {
+		p = p[:bandwidthChunk]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.t.wait(n)
+	}
+	return n, err
+}
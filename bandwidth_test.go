@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBandwidthLimitDelaysBody проверяет, что тело сверх запаса передается только после паузы,
+// равной времени передачи лишних байт с заданной скоростью по часам буфера
+func TestBandwidthLimitDelaysBody(t *testing.T) {
+	received := make(chan int, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- len(body)
+	}))
+	defer srv.Close()
+	clock := NewFakeClock(time.Unix(0, 0))
+	b := NewBuffer(srv.URL, "token", WithClock(clock), WithBandwidthLimit(100, 100))
+	defer b.Close()
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(strings.Repeat("x", 300)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Заголовки укладываются в запас, а 300 байт тела превышают остаток запаса на size-100 байт
+	size := headerSize(req) + 300
+	delay := time.Duration(size-100) * time.Second / 100
+	result := make(chan error, 1)
+	go func() {
+		resp, err := newBandwidthTransport(b, nil).RoundTrip(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		result <- err
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(delay - time.Millisecond)
+	select {
+	case n := <-received:
+		t.Fatalf("server received %d bytes before the pause elapsed", n)
+	case <-time.After(20 * time.Millisecond):
+	}
+	clock.Advance(time.Millisecond)
+	if n := <-received; n != 300 {
+		t.Errorf("server received %d bytes, want 300", n)
+	}
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+}
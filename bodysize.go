@@ -0,0 +1,42 @@
+package main
+
+import "fmt"
+
+// RequestSizeError сообщает, что тело запроса элемента больше ограничения http.max_request_size
+type RequestSizeError struct {
+	URL       string // адрес отправки элемента
+	Size      int    // размер тела до сжатия, байт
+	Limit     int    // ограничение, байт
+	Field     string // поле с самым длинным значением
+	FieldSize int    // длина его значения, байт
+}
+
+func (e *RequestSizeError) Error() string {
+	return fmt.Sprintf("request body of %d bytes exceeds the limit of %d bytes for %s (largest field %s: %d bytes)",
+		e.Size, e.Limit, e.URL, e.Field, e.FieldSize)
+}
+
+// WithMaxRequestSize отвергает при добавлении элементы, тело запроса которых до сжатия больше limit
+// байт, с ошибкой *RequestSizeError, вместо того чтобы отправлять их на сервер с ограничением размера
+func WithMaxRequestSize(limit int) Option {
+	return func(b *Buffer) {
+		b.maxBody = limit
+	}
+}
+
+// checkRequestSize проверяет размер тела запроса элемента в том виде, в каком оно будет отправлено
+func (b *Buffer) checkRequestSize(item *queuedItem) error {
+	buf := b.encodeBody(item.fields)
+	size := buf.Len()
+	buf.release()
+	if size <= b.maxBody {
+		return nil
+	}
+	err := &RequestSizeError{URL: b.target(item), Size: size, Limit: b.maxBody}
+	for i, value := range item.fields.values {
+		if n := len(value); n > err.FieldSize {
+			err.Field, err.FieldSize = item.fields.layout.names[i], n
+		}
+	}
+	return err
+}
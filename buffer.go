@@ -0,0 +1,695 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// incomingCapacity задает размер канала, через который производители передают элементы отправке
+const incomingCapacity = 1024
+
+// Buffer представляет буфер для хранения данных и их последующей отправки на API
+type Buffer struct {
+	queue       *itemQueue
+	incoming    chan *queuedItem // элементы, добавленные производителями и еще не перенесенные в queue
+	wake        chan struct{}    // будит отправку после Resume и Flush
+	stop        chan struct{}
+	fatal       chan error // первая неустранимая ошибка, которую возвращает Run
+	start       sync.Once
+	close       sync.Once
+	mu          sync.Mutex
+	cond        *sync.Cond
+	url         string
+	client      *http.Client
+	gzip        atomic.Bool // сжимать тела запросов; сбрасывается, если API не принимает сжатие
+	gzipMinSize int
+	prewarm     int           // соединений, устанавливаемых заранее
+	prewarmIdle time.Duration // простой, после которого соединения прогреваются снова
+	lastSend    atomic.Int64  // время начала последней отправки, нс
+	clock       Clock
+	tokens      TokenProvider
+	auth        Authenticator
+	signer      *Signer
+	form        *FormEncoder  // кодирование тела запроса; nil - по умолчанию
+	maxBody     int           // наибольший размер тела запроса до сжатия; 0 - без ограничения
+	timeout     time.Duration // время на запрос к API вместе с чтением ответа
+	paused      bool
+	dryRun      bool
+	synchronous bool // Add доставляет элемент сам, без горутины отправки
+	validate    func(item map[string]string) error
+	values      *ValueFormat // приведение числовых полей при добавлении
+	zones       *TimeZones   // поля периода по дате и перевод отметок времени в даты пояса отчетности при добавлении
+	dedup       *remoteDedup // проверка наличия факта в API перед отправкой
+	facts       *FactsCache  // кеш ответов get_facts
+	offline     *offlineMode // приостановка отправки, пока API недоступен
+	factsMethod string       // HTTP-метод запросов get_facts, пусто - POST
+	comment     *CommentTemplate
+	window      *DeliverySchedule // окна отправки; nil - в любое время
+	outside     bool              // окно отправки закрыто и об этом сообщено
+	flushing    int
+	nextID      atomic.Uint64
+	pending     atomic.Int64 // добавленные, но еще не обработанные элементы
+	inFlight    map[uint64]*queuedItem
+	maxInFlight int
+	shards      int // части очереди по хешу ключа порядка, 0 - без деления
+	orderKey    string
+	waiting     map[string][]*queuedItem // ключи, элемент которых отправляется, и отложенные элементы с ними
+	ready       []*queuedItem            // отложенные элементы, предыдущий элемент которых доставлен
+	parked      int                      // отложенные элементы в waiting и ready
+	audit       *AuditLog
+	latency     *LatencyHistogram
+	dlq         *DeadLetterQueue
+	breaker     *circuitBreaker
+	notifiers   []AlertHook
+	statsd      *StatsdClient
+	reporter    ErrorReporter
+	dump        *DebugDump
+	retry       atomic.Pointer[retryPolicy]
+	limiter     *rateLimiter
+	bandwidth   *rateLimiter    // ограничение исходящего трафика, байт в секунду
+	quota       *apiQuota       // квота запросов к API за час и сутки
+	direct      *http.Client    // client без квоты и ограничения трафика для проверок доступности API
+	users       *keyedLimiter   // ограничение частоты по пользователю
+	throttled   []throttledItem // элементы, отложенные до появления маркера их пользователя
+	rejected    []rejectedItem  // элементы из spool, не прошедшие проверку после Update
+	recent      recentSends
+	events      *EventLog
+	hooks       Hooks
+	attempts    atomic.Uint64
+	sent        atomic.Uint64
+	failed      atomic.Uint64
+	invalid     atomic.Uint64 // элементы, отвергнутые проверкой при добавлении
+	duplicates  atomic.Uint64 // элементы, не отправленные, потому что факт уже есть в API
+	warned      atomic.Uint64 // элементы, принятые API с предупреждениями
+	canceled    atomic.Uint64 // элементы, отмененные Cancel
+	overflowed  atomic.Uint64 // элементы, отвергнутые или сброшенные при заполнении spool
+	updated     atomic.Uint64 // изменения элементов Update
+
+	standby   atomic.Pointer[RedisQueue] // общая очередь резервной реплики, задается setStandby
+	forwarded atomic.Uint64              // элементы, переданные лидеру через общую очередь
+}
+
+// queuedItem представляет элемент очереди вместе с обработчиком результата его доставки
+type queuedItem struct {
+	id            uint64
+	fields        itemFields
+	correlationID string
+	provenance    *Provenance
+	endpoint      string // адрес отправки вместо адреса буфера, задается WithEndpoint
+	attempts      []Attempt
+	tries         int
+	duplicate     bool     // факт уже есть в API, элемент не отправлялся
+	held          bool     // последняя попытка не удалась из-за недоступности API и ждет ее возвращения
+	factID        int      // indicator_to_mo_fact_id из ответа API
+	warnings      []string // MESSAGES.warning ответа, принявшего факт
+	edit          itemEdit // изменение Update, еще не примененное к элементу из spool
+	sequence      string   // ключ порядка, под которым элемент отложен или отправляется
+	sequenced     bool     // элемент занял или ждет ключ sequence
+	done          func(err error)
+	result        func(ItemResult)
+}
+
+// ItemResult описывает итог обработки элемента. Факт может быть принят с предупреждениями API,
+// например если значение округлено или период скорректирован
+type ItemResult struct {
+	ItemID    uint64
+	FactID    int      // идентификатор сохраненного факта, 0 - неизвестен
+	Warnings  []string // предупреждения API при STATUS OK
+	Duplicate bool     // факт уже был в API, элемент не отправлялся
+	Err       error    // ошибка доставки или проверки; nil - факт принят
+}
+
+// ItemOption задает дополнительные параметры отдельного элемента при добавлении в буфер
+type ItemOption func(*queuedItem)
+
+// WithCorrelationID связывает элемент с идентификатором корреляции вызывающей стороны
+func WithCorrelationID(id string) ItemOption {
+	return func(item *queuedItem) {
+		item.correlationID = id
+	}
+}
+
+// WithResultHandler передает итог обработки элемента, включая предупреждения API, в fn.
+// fn вызывается один раз после доставки, отказа или отклонения проверкой
+func WithResultHandler(fn func(ItemResult)) ItemOption {
+	return func(item *queuedItem) {
+		item.result = fn
+	}
+}
+
+// finish сообщает итог обработки элемента его обработчикам
+func (item *queuedItem) finish(err error) {
+	if item.done != nil {
+		item.done(err)
+	}
+	if item.result != nil {
+		item.result(ItemResult{
+			ItemID:    item.id,
+			FactID:    item.factID,
+			Warnings:  item.warnings,
+			Duplicate: item.duplicate,
+			Err:       err,
+		})
+	}
+}
+
+// Option задает дополнительную настройку буфера
+type Option func(*Buffer)
+
+// WithTokenProvider задает источник токена, запрашиваемого перед каждой попыткой отправки
+func WithTokenProvider(p TokenProvider) Option {
+	return func(b *Buffer) {
+		b.tokens = p
+	}
+}
+
+// WithHTTPClient задает HTTP-клиент для запросов к API вместо клиента по умолчанию
+func WithHTTPClient(client *http.Client) Option {
+	return func(b *Buffer) {
+		b.client = client
+	}
+}
+
+// WithAuditLog включает запись каждой попытки отправки в журнал аудита
+func WithAuditLog(audit *AuditLog) Option {
+	return func(b *Buffer) {
+		b.audit = audit
+	}
+}
+
+// NewBuffer создает новый буфер с заданными URL, токеном и опциями
+func NewBuffer(apiURL, token string, opts ...Option) *Buffer {
+	b := &Buffer{
+		queue:       newItemQueue(minQueueCapacity),
+		incoming:    make(chan *queuedItem, incomingCapacity),
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		fatal:       make(chan error, 1),
+		url:         apiURL,
+		client:      newHTTPClient(),
+		timeout:     defaultRequestTimeout,
+		tokens:      StaticToken(token),
+		auth:        bearerAuth{},
+		inFlight:    make(map[uint64]*queuedItem),
+		waiting:     make(map[string][]*queuedItem),
+		maxInFlight: 1,
+		orderKey:    defaultOrderKey,
+		latency:     NewLatencyHistogram(),
+		dlq:         NewDeadLetterQueue(),
+		limiter:     newRateLimiter(0, 1),
+		clock:       systemClock,
+	}
+	b.retry.Store(&retryPolicy{})
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.shards > 0 {
+		b.maxInFlight = b.shards
+	}
+	b.direct = b.client
+	if b.bandwidth != nil {
+		client := *b.client
+		client.Transport = newBandwidthTransport(b, client.Transport)
+		b.client = &client
+	}
+	if b.quota != nil {
+		client := *b.client
+		client.Transport = newQuotaTransport(b, client.Transport)
+		b.client = &client
+	}
+	if t, ok := b.tokens.(*RotatingToken); ok {
+		t.clock = b.clock
+	}
+	if s, ok := b.auth.(*sessionAuth); ok {
+		s.clock = b.clock
+	}
+	if b.prewarm > 0 && !b.dryRun {
+		go b.keepWarm()
+	}
+	// Идентификаторы новых элементов не должны совпадать с сохраненными недоставленными
+	b.nextID.Store(b.dlq.maxID())
+	b.cond = sync.NewCond(&b.mu)
+	// Элементы, загруженные из spool прошлого запуска, ожидают доставки как добавленные
+	if spool := b.queue.spool; spool != nil {
+		b.nextID.Store(max(b.nextID.Load(), spool.maxID()))
+		if n := spool.Len(); n > 0 {
+			b.pending.Add(int64(n))
+			b.start.Do(func() { go b.dispatch() })
+		}
+	}
+	return b
+}
+
+// Add добавляет новый элемент в буфер и запускает отправку данных, если она не выполняется.
+// В синхронном режиме (WithSynchronous) Add возвращается после доставки с ее результатом,
+// иначе - сразу с nil. С WithTimeZones и WithValueFormat даты и числовые поля сначала приводятся, WithCommentTemplate
+// подставляет комментарий, а с WithValidation и WithMaxRequestSize элемент, не прошедший проверку
+// или слишком большой для одного запроса, не добавляется, и Add возвращает ошибку
+func (b *Buffer) Add(item map[string]string, opts ...ItemOption) error {
+	return b.enqueue(newQueuedItem(item, opts))
+}
+
+// newQueuedItem создает элемент очереди с примененными опциями
+func newQueuedItem(data map[string]string, opts []ItemOption) *queuedItem {
+	item := &queuedItem{fields: newItemFields(data)}
+	for _, opt := range opts {
+		opt(item)
+	}
+	return item
+}
+
+// enqueue передает элемент отправке. Производители не захватывают b.mu: элемент попадает в канал,
+// откуда его забирает горутина отправки. Сама горутина отправки элементы не добавляет и не ждет
+// сети, поэтому при заполненном канале производитель ждет недолго, а порядок элементов сохраняется.
+// В синхронном режиме элемент доставляется сразу и возвращается результат доставки
+func (b *Buffer) enqueue(item *queuedItem) error {
+	_, err := b.accept(item)
+	return err
+}
+
+// accept выполняет enqueue и дополнительно сообщает, принят ли элемент: отвергнутый при проверке,
+// переполнении spool или передаче лидеру элемент не принят, а ошибка синхронной доставки принятого
+// элемента уже учтена в очереди недоставленных
+func (b *Buffer) accept(item *queuedItem) (bool, error) {
+	item.id = b.nextID.Add(1)
+	if item.endpoint != "" {
+		endpoint, err := b.resolveEndpoint(item.endpoint)
+		if err != nil {
+			logDebugf("Item %d rejected: %v", item.id, err)
+			b.invalid.Add(1)
+			item.finish(err)
+			return false, err
+		}
+		item.endpoint = endpoint
+	}
+	original := item.fields
+	if err := b.prepare(item, true); err != nil {
+		logDebugf("Item %d rejected: %v", item.id, err)
+		b.invalid.Add(1)
+		item.finish(err)
+		return false, err
+	}
+	if queue := b.standby.Load(); queue != nil {
+		// Лидер приведет поля и подставит комментарий сам, поэтому передаются исходные
+		item.fields = original
+		err := b.forward(queue, item)
+		return err == nil, err
+	}
+	if b.queue.spoolFull(spoolFullReject) {
+		logDebugf("Item %d rejected: %v", item.id, ErrSpoolFull)
+		b.overflowed.Add(1)
+		item.finish(ErrSpoolFull)
+		return false, ErrSpoolFull
+	}
+	b.pending.Add(1)
+	b.hooks.enqueued(item.id)
+	if b.synchronous {
+		return true, b.deliverNow(item)
+	}
+	b.start.Do(func() { go b.dispatch() })
+	b.incoming <- item
+	return true, nil
+}
+
+// prepare приводит даты и числовые поля элемента, подставляет комментарий, если comment задан,
+// и проверяет элемент и размер его запроса
+func (b *Buffer) prepare(item *queuedItem, comment bool) error {
+	zones := b.zones != nil && b.zones.converts(item.fields)
+	if zones || b.values != nil || comment && b.comment != nil || b.validate != nil {
+		data := item.fields.Map()
+		now := b.clock.Now()
+		changed := zones && b.zones.apply(data, now)
+		if b.values != nil && b.values.apply(data) {
+			changed = true
+		}
+		if comment && b.comment != nil {
+			if b.zones != nil {
+				now = now.In(b.zones.Location())
+			}
+			if b.comment.apply(item, data, now) {
+				changed = true
+			}
+		}
+		if changed {
+			item.fields = newItemFields(data)
+		}
+		if b.validate != nil {
+			if err := b.validate(data); err != nil {
+				return err
+			}
+		}
+	}
+	if b.maxBody > 0 {
+		if err := b.checkRequestSize(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signal будит горутину отправки, если она ожидает новых элементов
+func (b *Buffer) signal() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// drainLocked переносит элементы из канала в очередь. Вызывается с захваченным b.mu
+func (b *Buffer) drainLocked() {
+	for !b.queue.spoolFull(spoolFullBlock) {
+		select {
+		case item := <-b.incoming:
+			b.queue.Push(item)
+		default:
+			return
+		}
+	}
+}
+
+// pausedLocked сообщает, приостановлена ли отправка вызовом Pause, автономным режимом
+// или закрытым окном отправки с учетом выполняющихся Flush
+func (b *Buffer) pausedLocked() bool {
+	return (b.paused || b.offlineLocked() || b.windowClosedLocked()) && b.flushing == 0
+}
+
+// dispatch запускает отправку элементов очереди, не превышая maxInFlight одновременных запросов,
+// и ожидает новые элементы и завершения отправок, пока не вызван Close
+func (b *Buffer) dispatch() {
+	for {
+		b.mu.Lock()
+		b.drainLocked()
+		for len(b.inFlight) < b.maxInFlight && !b.pausedLocked() {
+			item := b.nextLocked()
+			if item == nil {
+				break
+			}
+			b.inFlight[item.id] = item
+			go b.run(item)
+		}
+		lost := b.queue.takeLost()
+		dropped := b.queue.takeDropped()
+		rejected := b.rejected
+		b.rejected = nil
+		var timer Timer
+		var throttled <-chan time.Time
+		wait, ok := b.throttleWaitLocked()
+		if opens, closed := b.windowWaitLocked(); closed && (!ok || opens < wait) {
+			wait, ok = opens, true
+		}
+		if ok {
+			timer = b.clock.NewTimer(wait)
+			throttled = timer.C()
+		}
+		// Пока spool заполнен, новые элементы остаются в канале и Add ждет освобождения места
+		incoming := b.incoming
+		if b.queue.spoolFull(spoolFullBlock) {
+			incoming = nil
+		}
+		b.mu.Unlock()
+		if lost.n > 0 {
+			b.dropLost(lost)
+		}
+		if len(dropped) > 0 {
+			b.dropOverflow(dropped)
+		}
+		if len(rejected) > 0 {
+			b.dropRejected(rejected)
+		}
+
+		select {
+		case item := <-incoming:
+			b.mu.Lock()
+			b.queue.Push(item)
+			b.mu.Unlock()
+		case <-b.wake:
+		case <-throttled:
+		case <-b.stop:
+			return
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// deliver отправляет элемент с повторными попытками, сообщает результат его обработчику
+// и возвращает его. С WithRemoteDedup элемент, факт которого уже есть в API, не отправляется
+func (b *Buffer) deliver(item *queuedItem) error {
+	var err error
+	if b.dedup != nil && !b.dryRun && item.endpoint == "" && b.remoteDuplicate(item) {
+		item.duplicate = true
+		b.duplicates.Add(1)
+		item.finish(nil)
+		return nil
+	}
+	for !b.dryRun {
+		if b.offline != nil {
+			b.waitOnline()
+		}
+		b.waitForTurn()
+		item.tries++
+		b.hooks.attemptStarted(item.id, item.tries)
+		err = b.sendToAPI(item)
+		b.hooks.attemptFinished(item.id, item.tries, err)
+		if item.held {
+			// Попытка при недоступном API не расходует повторы: элемент ждет возвращения API
+			item.held = false
+			item.tries--
+			b.goOffline(err.Error())
+			continue
+		}
+		retry := b.retry.Load()
+		if err == nil || !retry.shouldRetry(item) {
+			break
+		}
+		delay := retry.delay(item.tries)
+		b.hooks.backoffEntered(item.id, item.tries, delay)
+		b.clock.Sleep(delay)
+	}
+	if b.dryRun {
+		b.logDryRun(item)
+	}
+	item.finish(err)
+	return err
+}
+
+// waitForTurn выдерживает паузу, которую требуют автоматический выключатель и ограничение частоты
+func (b *Buffer) waitForTurn() {
+	if b.breaker != nil {
+		b.clock.Sleep(b.breaker.wait(b.clock.Now()))
+	}
+	b.clock.Sleep(b.limiter.reserve(b.clock.Now()))
+}
+
+// sendToAPI выполняет отправку одного элемента данных на API
+func (b *Buffer) sendToAPI(item *queuedItem) (err error) {
+	now := b.clock.Now()
+	b.lastSend.Store(now.UnixNano())
+	requestID := newRequestID()
+	entry := AuditEntry{
+		Time:          now,
+		RequestID:     requestID,
+		CorrelationID: item.correlationID,
+		Provenance:    item.provenance,
+		URL:           b.target(item),
+		Endpoint:      item.endpoint,
+	}
+	var latency time.Duration
+	var exchange debugExchange
+	fail := func(msg string, err error) error {
+		entry.Error = err.Error()
+		if item.provenance != nil {
+			fmt.Printf("[%s] %s %v (item from %s)\n", requestID, msg, err, item.provenance)
+		} else {
+			fmt.Printf("[%s] %s %v\n", requestID, msg, err)
+		}
+		return fmt.Errorf("request %s: %w", requestID, err)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fail("Panic while sending:", fmt.Errorf("panic: %v", r))
+			b.reportError(ErrorReport{
+				Message: err.Error(),
+				Kind:    "panic",
+				Tags:    map[string]string{"request_id": requestID},
+				Extra:   item.fields.Map(),
+				Stack:   string(debug.Stack()),
+			})
+		}
+		b.recordAttempt(item, &entry, latency)
+		b.dumpExchange(&entry, &exchange)
+	}()
+
+	token, err := b.tokens.Token()
+	if err != nil {
+		return fail("Error loading token:", err)
+	}
+	req, form, err := b.newAPIRequest(item, b.gzip.Load())
+	if err != nil {
+		return fail("Error creating request:", err)
+	}
+	exchange.request, exchange.requestBody = req, form
+	exchange.token = token
+	if err := b.auth.Authorize(b.client, req, token); err != nil {
+		req.Body.Close()
+		return fail("Error authorizing request:", err)
+	}
+	req.Header.Set("X-Request-ID", requestID)
+	if item.correlationID != "" {
+		req.Header.Set("X-Correlation-ID", item.correlationID)
+	}
+	entry.Headers = sanitizeHeaders(req.Header, token)
+
+	// Без ограничения API, принявший соединение и не отвечающий, занял бы место отправки навсегда
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+	start := b.clock.Now()
+	req, resp, err := b.doAPIRequest(item, req.WithContext(ctx))
+	exchange.request = req
+	if err != nil {
+		latency = b.clock.Now().Sub(start)
+		item.held = b.holdOffline()
+		return fail("Error sending request:", err)
+	}
+	defer resp.Body.Close()
+	entry.Status = resp.StatusCode
+	exchange.response = resp
+	if resp.StatusCode == http.StatusUnauthorized {
+		if r, ok := b.tokens.(interface{ Invalidate() }); ok {
+			r.Invalidate()
+		}
+		if s, ok := b.auth.(interface{ Invalidate() }); ok {
+			s.Invalidate()
+		}
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	latency = b.clock.Now().Sub(start)
+	exchange.responseBody = body
+	if err != nil {
+		return fail("Error reading response body:", err)
+	}
+	entry.Response = string(body)
+
+	response, err := parseAPIResponse(body)
+	if err != nil {
+		return fail("Error unmarshalling JSON:", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		item.held = unreachable(resp.StatusCode) && b.holdOffline()
+		if len(response.Messages.Error) > 0 {
+			return fail("Error response from API:", fmt.Errorf("API responded with %s: %s", resp.Status, response.Messages.Error))
+		}
+		return fail("Error response from API:", fmt.Errorf("API responded with %s", resp.Status))
+	}
+	item.factID = response.Data.IndicatorToMoFactID
+	if len(response.Messages.Warning) > 0 {
+		entry.Warnings = []string(response.Messages.Warning)
+		item.warnings = entry.Warnings
+		logInfof("[%s] API warning: %s", requestID, response.Messages.Warning)
+	}
+
+	logDebugf("[%s] Data successfully sent to API %s", requestID, body)
+	return nil
+}
+
+// recordAttempt обновляет статистику по завершенной попытке отправки и сохраняет ее в журнале аудита
+func (b *Buffer) recordAttempt(item *queuedItem, entry *AuditEntry, latency time.Duration) {
+	entry.LatencyMs = latency.Milliseconds()
+	attempt := Attempt{
+		Time:      entry.Time,
+		RequestID: entry.RequestID,
+		Status:    entry.Status,
+		LatencyMs: entry.LatencyMs,
+		Error:     entry.Error,
+		Warnings:  entry.Warnings,
+	}
+	item.attempts = append(item.attempts, attempt)
+	entry.Final = entry.Error == "" || !item.held && !b.retry.Load().shouldRetry(item)
+	// Копия данных нужна только журналу аудита и очереди недоставленных, поэтому при обычной
+	// отправке она не создается
+	if b.audit != nil || entry.Error != "" && entry.Final {
+		entry.Payload = item.fields.Map()
+	}
+	indicator := item.fields.Get("indicator_to_mo_id")
+	b.recent.add(RecentSend{ItemID: item.id, Indicator: indicator, Attempt: attempt})
+	b.attempts.Add(1)
+	if entry.Error == "" {
+		b.sent.Add(1)
+		if len(entry.Warnings) > 0 {
+			b.warned.Add(1)
+		}
+		if b.facts != nil {
+			b.facts.invalidate(indicator)
+		}
+		if b.breaker != nil {
+			b.breaker.success()
+		}
+	} else {
+		b.failed.Add(1)
+	}
+	if entry.Error != "" && entry.Final {
+		now := b.clock.Now()
+		b.dlq.Add(DeadLetter{
+			ID:            item.id,
+			Item:          entry.Payload,
+			Error:         entry.Error,
+			RequestID:     entry.RequestID,
+			CorrelationID: entry.CorrelationID,
+			Provenance:    item.provenance,
+			Endpoint:      item.endpoint,
+			Attempts:      append([]Attempt(nil), item.attempts...),
+			FailedAt:      now,
+		})
+		b.notify(Alert{
+			Condition: AlertDeadLetter,
+			Message:   fmt.Sprintf("item for indicator %s moved to dead letter queue (request %s): %s", indicator, entry.RequestID, entry.Error),
+			Value:     float64(b.dlq.Len()),
+			Time:      now,
+		})
+		b.reportError(ErrorReport{
+			Message: fmt.Sprintf("delivery failed: %s", entry.Error),
+			Kind:    "delivery",
+			Tags: map[string]string{
+				"request_id": entry.RequestID,
+				"indicator":  indicator,
+				"status":     strconv.Itoa(entry.Status),
+			},
+			Extra: entry.Payload,
+		})
+	}
+	if entry.Error != "" {
+		now := b.clock.Now()
+		if b.breaker != nil && b.breaker.failure(now) {
+			b.hooks.circuitOpened(now)
+			threshold, cooldown := b.breaker.settings()
+			b.notify(Alert{
+				Condition: AlertCircuitOpen,
+				Message:   fmt.Sprintf("circuit breaker opened for %s after %d consecutive failures", cooldown, threshold),
+				Value:     float64(threshold),
+				Time:      now,
+			})
+		}
+	}
+	if latency > 0 {
+		b.latency.Observe(latency)
+	}
+	b.emitStatsd(entry, latency)
+	b.writeEvent(item, entry)
+
+	if b.audit == nil {
+		return
+	}
+	if err := b.audit.Write(*entry); err != nil {
+		fmt.Println("Error writing audit log:", err)
+	}
+}
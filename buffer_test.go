@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+// benchmarkItem представляет типичный факт, отправляемый на API
+var benchmarkItem = map[string]string{
+	"period_start":            "2024-05-01",
+	"period_end":              "2024-05-31",
+	"period_key":              "month",
+	"indicator_to_mo_id":      "227373",
+	"indicator_to_mo_fact_id": "0",
+	"value":                   "1",
+	"fact_time":               "2024-05-31",
+	"is_plan":                 "0",
+	"auth_user_id":            "40",
+	"comment":                 "buffer Last_name",
+}
+
+func BenchmarkEncodeForm(b *testing.B) {
+	b.Run("url.Values", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			form := url.Values{}
+			for key, value := range benchmarkItem {
+				form.Set(key, value)
+			}
+			_ = form.Encode()
+		}
+	})
+	b.Run("pool", func(b *testing.B) {
+		fields := newItemFields(benchmarkItem)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			encodeForm(fields).release()
+		}
+	})
+	b.Run("encoder", func(b *testing.B) {
+		enc, err := NewFormEncoder(FormConfig{Order: []string{"indicator_to_mo_id", "value"}, Charset: "windows-1251"})
+		if err != nil {
+			b.Fatal(err)
+		}
+		fields := newItemFields(benchmarkItem)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			enc.encode(fields).release()
+		}
+	})
+}
+
+func BenchmarkSend(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"MESSAGES":{"error":null,"warning":null,"info":[]},"DATA":{"indicator_to_mo_fact_id":1},"STATUS":"OK"}`))
+	}))
+	defer srv.Close()
+	buffer := NewBuffer(srv.URL, "token")
+	defer buffer.Close()
+	SetLogLevel(LevelError)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buffer.Add(benchmarkItem)
+	}
+	buffer.Flush()
+}
+
+func BenchmarkQueue(b *testing.B) {
+	item := &queuedItem{fields: newItemFields(benchmarkItem)}
+	b.Run("push-pop", func(b *testing.B) {
+		q := newItemQueue(minQueueCapacity)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			q.Push(item)
+			q.Pop()
+		}
+	})
+	b.Run("fill-drain", func(b *testing.B) {
+		q := newItemQueue(minQueueCapacity)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < 1000; j++ {
+				q.Push(item)
+			}
+			for q.Len() > 0 {
+				q.Pop()
+			}
+		}
+	})
+}
+
+func BenchmarkEnqueue(b *testing.B) {
+	SetLogLevel(LevelError)
+	buffer := NewBuffer("http://127.0.0.1:0", "token", WithDryRun())
+	buffer.Pause()
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			buffer.Add(benchmarkItem)
+		}
+	})
+	b.StopTimer()
+	buffer.Close()
+}
+
+// TestUpdateOrderKeyOfParkedItem проверяет, что изменение поля ключа у отложенного элемента не
+// оставляет прежний ключ занятым
+func TestUpdateOrderKeyOfParkedItem(t *testing.T) {
+	SetLogLevel(LevelError)
+	gate := make(chan struct{})
+	started := make(chan struct{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-gate
+		w.Write([]byte(`{"DATA":{"indicator_to_mo_fact_id":1},"STATUS":"OK"}`))
+	}))
+	defer srv.Close()
+	// Close не откладывается: при ошибке он завис бы вместе с Flush
+	buffer := NewBuffer(srv.URL, "token", WithOrderKey("indicator_to_mo_id"), WithMaxInFlight(4))
+
+	item := func(key string) map[string]string {
+		return map[string]string{"indicator_to_mo_id": key, "value": "1"}
+	}
+	if err := buffer.Add(item("1")); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	parked, err := buffer.Submit(item("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rekey := func(fields map[string]string) map[string]string {
+		fields["indicator_to_mo_id"] = "2"
+		return fields
+	}
+	// Горутина отправки может еще переносить элемент из канала в очередь
+	for err = buffer.Update(parked, rekey); errors.Is(err, ErrNotQueued); err = buffer.Update(parked, rekey) {
+		time.Sleep(time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(gate)
+	if err := buffer.Add(item("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	flushed := make(chan struct{})
+	go func() {
+		buffer.Flush()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Flush hung with %d pending items", buffer.Pending())
+	}
+	buffer.Close()
+}
+
+// TestTLSPolicy проверяет, что политика TLS применяется к транспортам буфера, не меняя
+// http.DefaultTransport, а пустое пересечение наборов шифров дает ошибку
+func TestTLSPolicy(t *testing.T) {
+	defaultConfig := http.DefaultTransport.(*http.Transport).TLSClientConfig
+	SetTLSPolicy(tls.VersionTLS13, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384})
+	defer SetTLSPolicy(0, nil)
+
+	if http.DefaultTransport.(*http.Transport).TLSClientConfig != defaultConfig {
+		t.Error("SetTLSPolicy changed http.DefaultTransport")
+	}
+	transport, err := newTransport(TransportOptions{}, systemClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config := transport.TLSClientConfig; config.MinVersion != tls.VersionTLS13 || len(config.CipherSuites) != 1 {
+		t.Errorf("transport TLS config = %+v", config)
+	}
+	opts := TransportOptions{CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}}
+	if transport, err = newTransport(opts, systemClock); err != nil || !slices.Equal(transport.TLSClientConfig.CipherSuites, opts.CipherSuites[:1]) {
+		t.Errorf("intersected cipher suites: %v, %v", transport, err)
+	}
+	opts.CipherSuites = []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+	if _, err := newTransport(opts, systemClock); err == nil {
+		t.Error("transport without allowed cipher suites created")
+	}
+}
+
+// TestRequestTimeout проверяет, что запрос к API, который не отвечает, завершается ошибкой
+// по истечении http.request_timeout и не занимает место отправки
+func TestRequestTimeout(t *testing.T) {
+	SetLogLevel(LevelError)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	b := NewBuffer(srv.URL, "token", WithSynchronous(), WithRetry(1, 0, 0), WithRequestTimeout(50*time.Millisecond))
+	defer b.Close()
+
+	start := time.Now()
+	err := b.Add(benchmarkItem)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Add to a hanging API returned %v, want a deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("request timed out after %s", elapsed)
+	}
+	if stats := b.Stats(); stats.Failed != 1 || b.Pending() != 0 {
+		t.Errorf("failed %d, %d pending; want 1 and 0", stats.Failed, b.Pending())
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrCanceled сообщается обработчикам результата элемента, отмененного Cancel
+	ErrCanceled = errors.New("item canceled before delivery")
+	// ErrNotQueued означает, что элемента нет в очереди: он уже доставлен, отвергнут или не добавлялся
+	ErrNotQueued = errors.New("item is not queued")
+	// ErrInFlight означает, что элемент уже отправляется и изменить его нельзя
+	ErrInFlight = errors.New("item is already being sent")
+)
+
+// itemEdit изменяет данные элемента, как функция Update
+type itemEdit func(map[string]string) map[string]string
+
+// chainEdits возвращает изменение, применяющее сначала first, затем next
+func chainEdits(first, next itemEdit) itemEdit {
+	if first == nil {
+		return next
+	}
+	return func(data map[string]string) map[string]string {
+		return next(first(data))
+	}
+}
+
+// rejectedItem описывает элемент из spool, данные которого после Update не прошли проверку
+type rejectedItem struct {
+	item *queuedItem
+	err  error
+}
+
+// Submit добавляет элемент как Add и возвращает его идентификатор, по которому элемент можно
+// отменить или исправить до отправки
+func (b *Buffer) Submit(item map[string]string, opts ...ItemOption) (uint64, error) {
+	queued := newQueuedItem(item, opts)
+	err := b.enqueue(queued)
+	return queued.id, err
+}
+
+// Cancel убирает из очереди элемент, который еще не начал отправляться, и сообщает его
+// обработчикам результата ErrCanceled. Для элемента, который уже отправляется, возвращается
+// ErrInFlight, для доставленного, отвергнутого или неизвестного - ErrNotQueued
+func (b *Buffer) Cancel(id uint64) error {
+	b.mu.Lock()
+	b.drainLocked()
+	item, err := b.withdrawLocked(id)
+	if err != nil {
+		b.mu.Unlock()
+		return err
+	}
+	if b.pending.Add(-1) == 0 {
+		b.cond.Broadcast()
+	}
+	b.mu.Unlock()
+
+	b.canceled.Add(1)
+	logDebugf("Item %d canceled", id)
+	item.finish(ErrCanceled)
+	b.signal()
+	return nil
+}
+
+// withdrawLocked извлекает ожидающий элемент из очереди, отложенных элементов или spool.
+// Вызывается с захваченным b.mu
+func (b *Buffer) withdrawLocked(id uint64) (*queuedItem, error) {
+	if _, ok := b.inFlight[id]; ok {
+		return nil, ErrInFlight
+	}
+	for i, item := range b.ready {
+		if item.id == id {
+			b.ready = append(b.ready[:i], b.ready[i+1:]...)
+			b.parked--
+			// Готовый элемент занимает свой ключ порядка, поэтому ключ передается следующему
+			b.releaseSequenceLocked(item)
+			return item, nil
+		}
+	}
+	for key, waiting := range b.waiting {
+		for i, item := range waiting {
+			if item.id == id {
+				b.waiting[key] = append(waiting[:i], waiting[i+1:]...)
+				b.parked--
+				item.sequenced = false
+				return item, nil
+			}
+		}
+	}
+	for i, t := range b.throttled {
+		if t.item.id == id {
+			b.throttled = append(b.throttled[:i], b.throttled[i+1:]...)
+			b.parked--
+			// Элемент, отложенный ограничением частоты, тоже занимает свой ключ
+			b.releaseSequenceLocked(t.item)
+			return t.item, nil
+		}
+	}
+	for i := 0; i < b.queue.Resident(); i++ {
+		if b.queue.At(i).id == id {
+			return b.queue.remove(i), nil
+		}
+	}
+	if b.queue.spool != nil {
+		if handlers, ok := b.queue.spool.cancel(id); ok {
+			return &queuedItem{id: id, done: handlers.done, result: handlers.result}, nil
+		}
+	}
+	return nil, ErrNotQueued
+}
+
+// Update изменяет данные элемента, который еще не начал отправляться, функцией mutate. Даты
+// и числовые поля измененных данных приводятся, а проверка и ограничение размера запроса
+// применяются, как при добавлении; если измененный элемент их не проходит, он остается прежним
+// и Update возвращает ошибку. Шаблон комментария повторно не применяется. Элемент, вытесненный
+// на диск, изменяется и проверяется при загрузке в память; не прошедший проверку элемент не
+// отправляется, а его обработчикам сообщается ошибка. Ошибки ErrInFlight и ErrNotQueued - как у Cancel.
+// mutate вызывается с захваченной блокировкой буфера и не должна обращаться к нему
+func (b *Buffer) Update(id uint64, mutate func(item map[string]string) map[string]string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drainLocked()
+	if _, ok := b.inFlight[id]; ok {
+		return ErrInFlight
+	}
+	item, resident := b.queuedLocked(id)
+	if item == nil {
+		if b.queue.spool != nil && b.queue.spool.update(id, mutate) {
+			b.updated.Add(1)
+			return nil
+		}
+		return ErrNotQueued
+	}
+	if item.edit != nil {
+		// Предыдущее изменение элемента из spool еще не применено и будет проверено вместе с этим
+		item.edit = chainEdits(item.edit, mutate)
+		b.updated.Add(1)
+		return nil
+	}
+	edited := *item
+	edited.fields = newItemFields(mutate(item.fields.Map()))
+	if err := b.prepare(&edited, false); err != nil {
+		return fmt.Errorf("updated item %d is rejected: %w", id, err)
+	}
+	if resident {
+		b.queue.setFields(item, edited.fields)
+	} else {
+		item.fields = edited.fields
+	}
+	b.updated.Add(1)
+	logDebugf("Item %d updated", id)
+	return nil
+}
+
+// queuedLocked ищет ожидающий элемент в памяти; resident задан, если элемент в очереди, а не
+// среди отложенных. Вызывается с захваченным b.mu
+func (b *Buffer) queuedLocked(id uint64) (item *queuedItem, resident bool) {
+	for _, item := range b.ready {
+		if item.id == id {
+			return item, false
+		}
+	}
+	for _, waiting := range b.waiting {
+		for _, item := range waiting {
+			if item.id == id {
+				return item, false
+			}
+		}
+	}
+	for _, t := range b.throttled {
+		if t.item.id == id {
+			return t.item, false
+		}
+	}
+	for i := 0; i < b.queue.Resident(); i++ {
+		if item := b.queue.At(i); item.id == id {
+			return item, true
+		}
+	}
+	return nil, false
+}
+
+// applyEditLocked применяет к загруженному из spool элементу отложенное изменение Update
+// и проверяет результат. Вызывается с захваченным b.mu
+func (b *Buffer) applyEditLocked(item *queuedItem) error {
+	edit := item.edit
+	item.edit = nil
+	edited := *item
+	edited.fields = newItemFields(edit(item.fields.Map()))
+	if err := b.prepare(&edited, false); err != nil {
+		return fmt.Errorf("updated item %d is rejected: %w", item.id, err)
+	}
+	item.fields = edited.fields
+	return nil
+}
+
+// dropRejected сообщает ошибку элементам, не прошедшим проверку после Update, и исключает их
+// из ожидающих доставки
+func (b *Buffer) dropRejected(rejected []rejectedItem) {
+	for _, r := range rejected {
+		logDebugf("Item %d rejected: %v", r.item.id, r.err)
+		b.invalid.Add(1)
+		r.item.finish(r.err)
+	}
+	b.mu.Lock()
+	if b.pending.Add(-int64(len(rejected))) == 0 {
+		b.cond.Broadcast()
+	}
+	b.mu.Unlock()
+}
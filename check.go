@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Итоги проверок команды check
+const (
+	checkPass = "PASS"
+	checkWarn = "WARN"
+	checkFail = "FAIL"
+	checkSkip = "SKIP"
+)
+
+// certExpiryWarning задает срок действия сертификата, при котором проверка TLS предупреждает о замене
+const certExpiryWarning = 14 * 24 * time.Hour
+
+// checkResult представляет итог одной проверки
+type checkResult struct {
+	status string
+	name   string // вид проверки: dns, tls, token, storage
+	target string
+	detail string
+}
+
+// checker выполняет проверки команды check и накапливает их итоги
+type checker struct {
+	cfg     *Config
+	client  *http.Client
+	timeout time.Duration
+	results []checkResult
+}
+
+// runCheck выполняет команду check: проверяет доступность адресов API, токен и каталоги
+// хранения и выводит итог каждой проверки. Завершается с кодом 3, если хотя бы одна не прошла
+func runCheck(cfg *Config, res *Result, args []string) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: buffer check [flags]")
+		fs.PrintDefaults()
+	}
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of each network check")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("%w: unexpected arguments %q", errUsage, fs.Args())
+	}
+
+	client, err := cfg.HTTPClient()
+	if err != nil {
+		return err
+	}
+	client.Timeout = *timeout
+	c := &checker{cfg: cfg, client: client, timeout: *timeout}
+	c.endpoints()
+	c.token()
+	c.storage("queue.spool_dir", cfg.Queue.SpoolDir)
+	if cfg.DLQ.Path != "" {
+		c.storage("dlq.path", filepath.Dir(cfg.DLQ.Path))
+	}
+
+	counts := make(map[string]int)
+	for _, r := range c.results {
+		counts[r.status]++
+		fmt.Printf("%s  %-7s %s: %s\n", r.status, r.name, r.target, r.detail)
+		res.Read++
+		if r.status == checkSkip {
+			res.Skipped++
+		}
+		if r.status == checkFail {
+			res.Failed++
+			res.Failures = append(res.Failures, Failure{Error: fmt.Sprintf("%s %s: %s", r.name, r.target, r.detail)})
+		}
+	}
+	fmt.Printf("Check: %d passed, %d warnings, %d failed, %d skipped\n", counts[checkPass], counts[checkWarn], counts[checkFail], counts[checkSkip])
+	if res.Failed > 0 {
+		return fmt.Errorf("%w: %d checks failed", errItemsFailed, res.Failed)
+	}
+	return nil
+}
+
+// add сохраняет итог проверки
+func (c *checker) add(status, name, target, detail string) {
+	c.results = append(c.results, checkResult{status: status, name: name, target: target, detail: detail})
+}
+
+// endpoints проверяет разрешение имен и установку соединения для каждого настроенного адреса
+func (c *checker) endpoints() {
+	urls := []string{c.cfg.API.SaveFactURL, c.cfg.API.GetFactsURL}
+	if c.cfg.Auth.Scheme == "session" {
+		urls = append(urls, c.cfg.Auth.LoginURL)
+	}
+	for _, d := range c.cfg.Auth.Destinations {
+		if d.Scheme == "session" {
+			urls = append(urls, d.LoginURL)
+		}
+	}
+	resolver := newDNSResolver(c.cfg.HTTP.DNS, &net.Dialer{}, systemClock)
+	resolved := make(map[string]bool)
+	checked := make(map[string]bool)
+	for _, raw := range urls {
+		if raw == "" || checked[raw] {
+			continue
+		}
+		checked[raw] = true
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			c.add(checkFail, "dns", raw, "invalid URL")
+			continue
+		}
+		host := u.Hostname()
+		if !resolved[host] {
+			resolved[host] = true
+			c.dns(resolver, host)
+		}
+		c.reach(u)
+	}
+}
+
+// dns проверяет, что имя host разрешается с настройками http.dns
+func (c *checker) dns(resolver *dnsResolver, host string) {
+	if net.ParseIP(host) != nil {
+		c.add(checkSkip, "dns", host, "address literal")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	addrs, err := resolver.lookup(ctx, host)
+	if err != nil {
+		c.add(checkFail, "dns", host, err.Error())
+		return
+	}
+	c.add(checkPass, "dns", host, strings.Join(addrs, ", "))
+}
+
+// reach устанавливает соединение с адресом и для https проверяет TLS и срок действия сертификата.
+// Отправляется запрос HEAD без авторизации, поэтому любой ответ сервера означает успех
+func (c *checker) reach(u *url.URL) {
+	name := "tls"
+	if u.Scheme != "https" {
+		name = "http"
+	}
+	req, err := http.NewRequest(http.MethodHead, u.String(), nil)
+	if err != nil {
+		c.add(checkFail, name, u.Host, err.Error())
+		return
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.add(checkFail, name, u.Host, err.Error())
+		return
+	}
+	resp.Body.Close()
+	detail := fmt.Sprintf("%s responded %s", u.Path, resp.Status)
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		if u.Scheme == "https" {
+			c.add(checkFail, name, u.Host, detail+" without TLS")
+			return
+		}
+		c.add(checkWarn, name, u.Host, detail+" over plain HTTP")
+		return
+	}
+	cert := resp.TLS.PeerCertificates[0]
+	left := time.Until(cert.NotAfter)
+	detail += fmt.Sprintf(", %s, certificate expires %s", tls.VersionName(resp.TLS.Version), cert.NotAfter.Format(time.DateOnly))
+	if left < certExpiryWarning {
+		c.add(checkWarn, name, u.Host, fmt.Sprintf("%s (in %d days)", detail, int(left.Hours()/24)))
+		return
+	}
+	c.add(checkPass, name, u.Host, detail)
+}
+
+// token проверяет токен запросом get_facts за сегодняшний день по несуществующему показателю:
+// запрос ничего не меняет и почти ничего не возвращает, но требует авторизации
+func (c *checker) token() {
+	target := c.cfg.API.GetFactsURL
+	if target == "" {
+		c.add(checkSkip, "token", "api.get_facts_url", "not configured, save_fact is not called to avoid writing data")
+		return
+	}
+	if c.cfg.DryRun {
+		c.add(checkSkip, "token", target, "dry run")
+		return
+	}
+	auth := c.cfg.Auth.ForURL(target)
+	secret, err := auth.Secret()
+	if err != nil {
+		c.add(checkFail, "token", target, "loading token: "+err.Error())
+		return
+	}
+	authenticator, err := auth.Authenticator()
+	if err != nil {
+		c.add(checkFail, "token", target, err.Error())
+		return
+	}
+	today := time.Now().In(c.cfg.Time.Location()).Format(time.DateOnly)
+	result, err := queryFacts(c.client, authenticator, c.cfg.API.FactsMethod(), target, secret, map[string]string{
+		"period_start":       today,
+		"period_end":         today,
+		"period_key":         "day",
+		"indicator_to_mo_id": "0",
+	})
+	if result == nil {
+		c.add(checkFail, "token", target, err.Error())
+		return
+	}
+	switch {
+	case result.StatusCode == http.StatusUnauthorized || result.StatusCode == http.StatusForbidden:
+		c.add(checkFail, "token", target, fmt.Sprintf("rejected with %d %s", result.StatusCode, http.StatusText(result.StatusCode)))
+		return
+	case result.StatusCode != http.StatusOK:
+		c.add(checkFail, "token", target, fmt.Sprintf("API responded with %d %s", result.StatusCode, http.StatusText(result.StatusCode)))
+		return
+	}
+	response, err := parseAPIResponse(result.Body)
+	if err != nil {
+		c.add(checkFail, "token", target, "unexpected response: "+err.Error())
+		return
+	}
+	if len(response.Messages.Error) > 0 {
+		c.add(checkFail, "token", target, "API error: "+response.Messages.Error.String())
+		return
+	}
+	scheme := auth.Scheme
+	if scheme == "" {
+		scheme = "bearer"
+	}
+	c.add(checkPass, "token", target, "accepted with "+scheme+" auth")
+}
+
+// storage проверяет, что в каталог dir можно записать файл; пустой dir означает, что каталог не настроен
+func (c *checker) storage(setting, dir string) {
+	if dir == "" {
+		c.add(checkSkip, "storage", setting, "not configured")
+		return
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		c.add(checkFail, "storage", dir, err.Error())
+		return
+	}
+	f, err := os.CreateTemp(dir, ".buffer-check-*")
+	if err != nil {
+		c.add(checkFail, "storage", dir, err.Error())
+		return
+	}
+	_, err = f.WriteString("check\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	os.Remove(f.Name())
+	if err != nil {
+		c.add(checkFail, "storage", dir, err.Error())
+		return
+	}
+	c.add(checkPass, "storage", dir, setting+" is writable")
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// checkpointInterval задает период сохранения контрольной точки импорта
+const checkpointInterval = time.Second
+
+// importCheckpoint представляет сохраненное состояние импорта файла: все строки до Row включительно
+// обработаны, а SHA256 - хеш первых Offset байт файла, по которому проверяется, что файл не изменился
+type importCheckpoint struct {
+	formatHeader
+	File      string    `json:"file"`
+	Row       int       `json:"row"`
+	Offset    int64     `json:"offset"`
+	SHA256    string    `json:"sha256"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// encodeCheckpoint кодирует контрольную точку в текущей версии формата файла
+func encodeCheckpoint(cp importCheckpoint) ([]byte, error) {
+	cp.formatHeader = newFormatHeader(checkpointFormat, checkpointVersion)
+	return json.MarshalIndent(cp, "", "  ")
+}
+
+// decodeCheckpoint разбирает файл контрольной точки любой поддерживаемой версии
+func decodeCheckpoint(data []byte) (importCheckpoint, error) {
+	var cp importCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return cp, err
+	}
+	return cp, cp.check(checkpointFormat, checkpointVersion)
+}
+
+// checkpointPath возвращает файл контрольной точки по умолчанию для импортируемого файла
+func checkpointPath(path string) string {
+	return path + ".checkpoint"
+}
+
+// checkpointer отслеживает обработанные строки импорта и сохраняет контрольную точку.
+// Строки завершаются не по порядку, поэтому точка продвигается только до первой незавершенной строки
+type checkpointer struct {
+	path   string // файл контрольной точки
+	source *os.File
+	hash   hash.Hash
+	hashed int64 // количество байт файла, учтенных в hash
+
+	mu      sync.Mutex
+	current importCheckpoint
+	next    int           // первая незавершенная строка
+	ends    map[int]int64 // смещение конца прочитанных, но не завершенных строк
+	done    map[int]bool
+	saved   int
+}
+
+// openCheckpointer загружает контрольную точку импорта файла file из path.
+// Если файл изменился с момента сохранения точки, импорт начинается с начала
+func openCheckpointer(file, path string) (*checkpointer, error) {
+	source, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	c := &checkpointer{
+		path:    path,
+		source:  source,
+		hash:    sha256.New(),
+		current: importCheckpoint{File: file},
+		ends:    make(map[int]int64),
+		done:    make(map[int]bool),
+	}
+
+	var saved importCheckpoint
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		source.Close()
+		return nil, err
+	default:
+		if saved, err = decodeCheckpoint(data); err != nil {
+			source.Close()
+			return nil, fmt.Errorf("parsing checkpoint %s: %w", path, err)
+		}
+	}
+	if saved.Row > 0 {
+		if c.advanceHash(saved.Offset) == nil && hex.EncodeToString(c.hash.Sum(nil)) == saved.SHA256 {
+			c.current = saved
+			c.current.File = file
+			logInfof("Import %s: resuming after row %d", file, saved.Row)
+		} else {
+			fmt.Printf("Import %s: file changed since the checkpoint, starting from the first row\n", file)
+			c.hash.Reset()
+			c.hashed = 0
+			if _, err := source.Seek(0, io.SeekStart); err != nil {
+				source.Close()
+				return nil, err
+			}
+		}
+	}
+	c.next = c.current.Row + 1
+	c.saved = c.current.Row
+	return c, nil
+}
+
+// resumeRow возвращает номер последней строки, обработанной в прошлых запусках
+func (c *checkpointer) resumeRow() int {
+	return c.current.Row
+}
+
+// advanceHash добавляет в хеш содержимое файла до смещения offset
+func (c *checkpointer) advanceHash(offset int64) error {
+	if offset <= c.hashed {
+		return nil
+	}
+	n, err := io.CopyN(c.hash, c.source, offset-c.hashed)
+	c.hashed += n
+	return err
+}
+
+// read отмечает, что строка row прочитана и заканчивается на смещении end
+func (c *checkpointer) read(row int, end int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ends[row] = end
+}
+
+// complete отмечает строку как обработанную: доставленную, перенесенную в очередь недоставленных
+// или пропущенную из-за ошибки
+func (c *checkpointer) complete(row int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done[row] = true
+	for c.done[c.next] {
+		c.current.Row, c.current.Offset = c.next, c.ends[c.next]
+		delete(c.done, c.next)
+		delete(c.ends, c.next)
+		c.next++
+	}
+}
+
+// save записывает контрольную точку с временем now, если она продвинулась с прошлого сохранения
+func (c *checkpointer) save(now time.Time) error {
+	c.mu.Lock()
+	cp := c.current
+	c.mu.Unlock()
+	if cp.Row == c.saved {
+		return nil
+	}
+	if err := c.advanceHash(cp.Offset); err != nil {
+		return err
+	}
+	cp.SHA256 = hex.EncodeToString(c.hash.Sum(nil))
+	cp.UpdatedAt = now
+	data, err := encodeCheckpoint(cp)
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+	c.saved = cp.Row
+	return nil
+}
+
+// Close закрывает файл, по которому считается хеш
+func (c *checkpointer) Close() error {
+	return c.source.Close()
+}
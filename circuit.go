@@ -0,0 +1,92 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Состояния автоматического выключателя
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// circuitBreaker приостанавливает отправку после серии подряд идущих ошибок
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     string
+	openedAt  time.Time
+}
+
+// newCircuitBreaker создает выключатель, размыкающийся после threshold ошибок подряд на время cooldown
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, state: CircuitClosed}
+}
+
+// set меняет порог и время восстановления выключателя, не меняя его текущее состояние
+func (c *circuitBreaker) set(threshold int, cooldown time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.threshold, c.cooldown = threshold, cooldown
+}
+
+// settings возвращает порог и время восстановления выключателя
+func (c *circuitBreaker) settings() (threshold int, cooldown time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.threshold, c.cooldown
+}
+
+// wait возвращает время, которое нужно подождать после now перед следующей попыткой
+func (c *circuitBreaker) wait(now time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != CircuitOpen {
+		return 0
+	}
+	remaining := c.cooldown - now.Sub(c.openedAt)
+	if remaining <= 0 {
+		c.state = CircuitHalfOpen
+		return 0
+	}
+	return remaining
+}
+
+// success замыкает выключатель после успешной попытки
+func (c *circuitBreaker) success() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures = 0
+	c.state = CircuitClosed
+}
+
+// failure учитывает ошибку в момент now и сообщает, разомкнулся ли выключатель в результате
+func (c *circuitBreaker) failure(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures++
+	if c.state == CircuitHalfOpen || (c.state == CircuitClosed && c.failures >= c.threshold) {
+		c.state = CircuitOpen
+		c.openedAt = now
+		return true
+	}
+	return false
+}
+
+// State возвращает текущее состояние выключателя
+func (c *circuitBreaker) State() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// WithCircuitBreaker приостанавливает отправку на cooldown после threshold ошибок подряд
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(b *Buffer) {
+		b.breaker = newCircuitBreaker(threshold, cooldown)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// ClientCertConfig задает клиентский сертификат для шлюзов API, требующих взаимной проверки TLS.
+// Сертификат задается парой cert_file и key_file в PEM или файлом PKCS#12
+type ClientCertConfig struct {
+	Hosts             []string `yaml:"hosts"` // имена серверов, которым предъявляется сертификат; пусто - всем остальным
+	CertFile          string   `yaml:"cert_file"`
+	KeyFile           string   `yaml:"key_file"`
+	PKCS12File        string   `yaml:"pkcs12_file"`
+	PKCS12PasswordEnv string   `yaml:"pkcs12_password_env"` // переменная окружения с паролем файла PKCS#12
+}
+
+// ClientCert представляет загруженный клиентский сертификат и серверы, которым он предъявляется
+type ClientCert struct {
+	Hosts       []string
+	Certificate tls.Certificate
+}
+
+// validate проверяет, что сертификат задан ровно одним способом
+func (c ClientCertConfig) validate() error {
+	pem := c.CertFile != "" || c.KeyFile != ""
+	switch {
+	case pem && c.PKCS12File != "":
+		return fmt.Errorf("set either cert_file and key_file or pkcs12_file")
+	case pem && (c.CertFile == "" || c.KeyFile == ""):
+		return fmt.Errorf("cert_file and key_file must be set together")
+	case !pem && c.PKCS12File == "":
+		return fmt.Errorf("cert_file and key_file or pkcs12_file is required")
+	case c.PKCS12PasswordEnv != "" && c.PKCS12File == "":
+		return fmt.Errorf("pkcs12_password_env requires pkcs12_file")
+	}
+	return nil
+}
+
+// load читает сертификат и закрытый ключ
+func (c ClientCertConfig) load() (ClientCert, error) {
+	cert := ClientCert{Hosts: c.Hosts}
+	if c.PKCS12File == "" {
+		pair, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return cert, fmt.Errorf("loading client certificate %s: %w", c.CertFile, err)
+		}
+		cert.Certificate = pair
+		return cert, nil
+	}
+	data, err := os.ReadFile(c.PKCS12File)
+	if err != nil {
+		return cert, fmt.Errorf("reading client certificate: %w", err)
+	}
+	var password string
+	if c.PKCS12PasswordEnv != "" {
+		password = os.Getenv(c.PKCS12PasswordEnv)
+	}
+	key, leaf, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return cert, fmt.Errorf("decoding client certificate %s: %w", c.PKCS12File, err)
+	}
+	cert.Certificate = tls.Certificate{PrivateKey: key, Leaf: leaf, Certificate: [][]byte{leaf.Raw}}
+	for _, ca := range chain {
+		cert.Certificate.Certificate = append(cert.Certificate.Certificate, ca.Raw)
+	}
+	return cert, nil
+}
+
+// loadClientCerts загружает клиентские сертификаты из настроек
+func loadClientCerts(configs []ClientCertConfig) ([]ClientCert, error) {
+	certs := make([]ClientCert, 0, len(configs))
+	for _, c := range configs {
+		cert, err := c.load()
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// hostTransport выбирает транспорт по имени сервера запроса, чтобы разным получателям
+// предъявлялись разные клиентские сертификаты
+type hostTransport struct {
+	byHost   map[string]*http.Transport
+	fallback *http.Transport
+}
+
+// withClientCerts возвращает транспорт, предъявляющий сертификаты certs. Сертификат без списка
+// серверов предъявляется всем серверам, для которых не задан отдельный
+func withClientCerts(base *http.Transport, certs []ClientCert) http.RoundTripper {
+	if len(certs) == 0 {
+		return base
+	}
+	t := &hostTransport{byHost: make(map[string]*http.Transport), fallback: base}
+	for _, cert := range certs {
+		transport := base.Clone()
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert.Certificate}
+		if len(cert.Hosts) == 0 {
+			t.fallback = transport
+			continue
+		}
+		for _, host := range cert.Hosts {
+			t.byHost[strings.ToLower(host)] = transport
+		}
+	}
+	return t
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if transport, ok := t.byHost[strings.ToLower(req.URL.Hostname())]; ok {
+		return transport.RoundTrip(req)
+	}
+	return t.fallback.RoundTrip(req)
+}
+
+// CloseIdleConnections закрывает простаивающие соединения всех транспортов
+func (t *hostTransport) CloseIdleConnections() {
+	t.fallback.CloseIdleConnections()
+	for _, transport := range t.byHost {
+		transport.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock задает источник времени буфера: задержки повторов, ограничение частоты, расписания,
+// периодические сводки и сроки действия. В тестах заменяется FakeClock, чтобы сдвигать время,
+// а не ждать
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer представляет однократный таймер Clock
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// Ticker представляет периодический таймер Clock
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// systemClock использует системное время
+var systemClock Clock = realClock{}
+
+// realClock передает вызовы пакету time
+type realClock struct{}
+
+func (realClock) Now() time.Time                   { return time.Now() }
+func (realClock) Sleep(d time.Duration)            { time.Sleep(d) }
+func (realClock) NewTimer(d time.Duration) Timer   { return realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// WithClock задает источник времени буфера вместо системного. Он же используется источником
+// токена, входом по сессии, планировщиком, сводкой и оповещениями этого буфера
+func WithClock(c Clock) Option {
+	return func(b *Buffer) {
+		b.clock = c
+	}
+}
+
+// bufferClock передает вызовы часам буфера, заданным к моменту вызова. Нужен частям, которые
+// создаются опциями до WithClock, например транспорту из WithTransport
+type bufferClock struct{ b *Buffer }
+
+func (c bufferClock) Now() time.Time                   { return c.b.clock.Now() }
+func (c bufferClock) Sleep(d time.Duration)            { c.b.clock.Sleep(d) }
+func (c bufferClock) NewTimer(d time.Duration) Timer   { return c.b.clock.NewTimer(d) }
+func (c bufferClock) NewTicker(d time.Duration) Ticker { return c.b.clock.NewTicker(d) }
+
+// FakeClock представляет время, которое идет только при вызове Advance. Таймеры и Sleep
+// срабатывают, когда время доходит до их срока
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// fakeWaiter представляет таймер или тикер FakeClock
+type fakeWaiter struct {
+	clock  *FakeClock
+	at     time.Time
+	period time.Duration // период тикера, 0 - однократный таймер
+	c      chan time.Time
+}
+
+// NewFakeClock создает время, остановленное на now
+func NewFakeClock(now time.Time) *FakeClock {
+	f := &FakeClock{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// Now возвращает текущее время часов
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Sleep блокируется, пока время не сдвинется на d
+func (f *FakeClock) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	<-f.NewTimer(d).C()
+}
+
+// NewTimer создает таймер, срабатывающий через d после текущего времени часов
+func (f *FakeClock) NewTimer(d time.Duration) Timer {
+	return f.add(d, 0)
+}
+
+// NewTicker создает тикер с периодом d
+func (f *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock.NewTicker")
+	}
+	return fakeTicker{f.add(d, d)}
+}
+
+// add регистрирует таймер со сроком через d
+func (f *FakeClock) add(d, period time.Duration) *fakeWaiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &fakeWaiter{clock: f, at: f.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- f.now
+		return w
+	}
+	f.waiters = append(f.waiters, w)
+	f.cond.Broadcast()
+	return w
+}
+
+// Advance сдвигает время на d, по порядку срабатывая таймеры, срок которых наступил.
+// Как и у time.Ticker, непрочитанное срабатывание тикера не накапливается
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	target := f.now.Add(d)
+	for {
+		sort.SliceStable(f.waiters, func(i, j int) bool { return f.waiters[i].at.Before(f.waiters[j].at) })
+		if len(f.waiters) == 0 || f.waiters[0].at.After(target) {
+			break
+		}
+		w := f.waiters[0]
+		f.now = w.at
+		select {
+		case w.c <- f.now:
+		default:
+		}
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			f.waiters = f.waiters[1:]
+		}
+	}
+	f.now = target
+}
+
+// BlockUntil ждет, пока не наберется n ожидающих таймеров, тикеров и вызовов Sleep, чтобы тест
+// сдвигал время, когда проверяемая горутина уже ждет
+func (f *FakeClock) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+func (w *fakeWaiter) C() <-chan time.Time { return w.c }
+
+// Stop удаляет таймер из ожидающих и сообщает, ожидал ли он срабатывания
+func (w *fakeWaiter) Stop() bool {
+	f := w.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, other := range f.waiters {
+		if other == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTicker struct{ w *fakeWaiter }
+
+func (t fakeTicker) C() <-chan time.Time { return t.w.c }
+func (t fakeTicker) Stop()               { t.w.Stop() }
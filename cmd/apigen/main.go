@@ -0,0 +1,41 @@
+// Команда apigen создает типизированный клиент API и контрактные тесты по описанию OpenAPI.
+// Запускается через go generate ./kpiclient
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"buffer/internal/apigen"
+)
+
+func main() {
+	spec := flag.String("spec", "api/openapi.yaml", "path to the OpenAPI spec")
+	pkg := flag.String("package", "kpiclient", "package name of the generated code")
+	out := flag.String("out", ".", "output directory")
+	server := flag.String("contract-server", "buffer/mockkpi", "import path of the mock API for contract tests, empty to skip them")
+	flag.Parse()
+
+	data, err := os.ReadFile(*spec)
+	if err != nil {
+		fmt.Println("Error reading spec:", err)
+		os.Exit(1)
+	}
+	files, err := apigen.Generate(data, apigen.Options{
+		Package:        *pkg,
+		Source:         filepath.ToSlash(filepath.Clean(*spec)),
+		ContractServer: *server,
+	})
+	if err != nil {
+		fmt.Println("Error generating code:", err)
+		os.Exit(1)
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(*out, name), src, 0o644); err != nil {
+			fmt.Println("Error writing generated code:", err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,795 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// runInteractive выполняет поведение по умолчанию: отправляет элементы и источники из настроек
+// и принимает команды интерактивного режима до ввода 'exit'
+func runInteractive(cfg *Config) error {
+	buffer, closeBuffer, err := cfg.NewBuffer()
+	if err != nil {
+		return fmt.Errorf("creating buffer: %w", err)
+	}
+	defer closeBuffer()
+
+	if cfg.Logging.SummaryInterval > 0 {
+		summary := NewSummaryLogger(buffer, cfg.Logging.SummaryInterval)
+		summary.Start()
+		defer summary.Stop()
+	}
+	enqueueConfigured(cfg, buffer)
+	runREPL(cfg, buffer, os.Stdin, os.Stdout)
+
+	// Ожидание завершения всех отправок данных
+	fmt.Println("Завершение программы...")
+	buffer.Flush()
+	return nil
+}
+
+// enqueueConfigured добавляет в буфер элементы и импортирует источники из настроек
+func enqueueConfigured(cfg *Config, buffer *Buffer) {
+	for _, fields := range cfg.Items {
+		p := localProvenance("config")
+		p.File = cfg.path
+		buffer.Add(cfg.Item(fields), WithProvenance(p))
+	}
+	for _, src := range cfg.Sources {
+		if _, err := buffer.ImportFile(src.Path, src.importOptions(ImportOptions{Prepare: cfg.PrepareItem, MaxPending: cfg.Queue.ImportWindow, Source: "config"})); err != nil {
+			fmt.Println("Error importing file:", err)
+		}
+	}
+}
+
+// runSend выполняет команду send: отправляет один элемент и ожидает результата доставки
+func runSend(cfg *Config, res *Result, args []string) error {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: buffer send [flags] field=value...")
+		fs.PrintDefaults()
+	}
+	correlationID := fs.String("correlation-id", "", "correlation ID of the item")
+	source := fs.String("source", "send", "source system recorded in the item provenance")
+	endpoint := fs.String("endpoint", "", "API URL or path relative to api.save_fact_url to send the item to")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	fields, err := parseFields(fs.Args())
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return fmt.Errorf("%w: no fields given, expected field=value arguments", errUsage)
+	}
+
+	buffer, closeBuffer, err := cfg.NewBuffer()
+	if err != nil {
+		return fmt.Errorf("creating buffer: %w", err)
+	}
+	defer closeBuffer()
+
+	results := make(chan ItemResult, 1)
+	item := newQueuedItem(cfg.Item(fields), []ItemOption{WithCorrelationID(*correlationID), WithProvenance(localProvenance(*source)), WithEndpoint(*endpoint)})
+	item.result = func(result ItemResult) { results <- result }
+	buffer.enqueue(item)
+	res.Read = 1
+	result := <-results
+	if err := result.Err; err != nil {
+		res.Failed = 1
+		failure := Failure{ID: item.id, Error: err.Error()}
+		var invalid *ValidationError
+		if errors.As(err, &invalid) {
+			failure.Fields = invalid.Fields
+		}
+		res.Failures = append(res.Failures, failure)
+		return fmt.Errorf("%w: %v", errItemsFailed, err)
+	}
+	res.Sent = 1
+	if cfg.DryRun {
+		fmt.Println("Not sent: dry run")
+		return nil
+	}
+	if result.Duplicate {
+		fmt.Println("Not sent: the fact already exists in the API")
+		return nil
+	}
+	if len(result.Warnings) > 0 {
+		res.Warnings = append(res.Warnings, Warning{ID: item.id, Warnings: result.Warnings})
+		fmt.Println("Sent with API warnings:")
+		for _, warning := range result.Warnings {
+			fmt.Printf("  %s\n", warning)
+		}
+		return nil
+	}
+	fmt.Println("Sent")
+	return nil
+}
+
+// runEnqueue выполняет команду enqueue: добавляет один элемент в общую очередь queue.redis,
+// не дожидаясь доставки; элемент отправит демон, забирающий очередь
+func runEnqueue(cfg *Config, res *Result, args []string) error {
+	fs := flag.NewFlagSet("enqueue", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: buffer enqueue [flags] field=value...")
+		fs.PrintDefaults()
+	}
+	correlationID := fs.String("correlation-id", "", "correlation ID of the item")
+	source := fs.String("source", "enqueue", "source system recorded in the item provenance")
+	endpoint := fs.String("endpoint", "", "API URL or path relative to api.save_fact_url to send the item to")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	fields, err := parseFields(fs.Args())
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return fmt.Errorf("%w: no fields given, expected field=value arguments", errUsage)
+	}
+	if !cfg.Queue.Redis.enabled() {
+		return fmt.Errorf("queue.redis.url is not configured")
+	}
+
+	queue, err := cfg.RedisQueue()
+	if err != nil {
+		return fmt.Errorf("opening Redis queue: %w", err)
+	}
+	defer queue.Close()
+	res.Read = 1
+	id, err := queue.Enqueue(cfg.Item(fields), WithCorrelationID(*correlationID), WithProvenance(localProvenance(*source)), WithEndpoint(*endpoint))
+	if err != nil {
+		res.Failed = 1
+		return fmt.Errorf("adding item to %s: %w", queue.stream, err)
+	}
+	res.Sent = 1
+	fmt.Printf("Enqueued %s to %s\n", id, queue.stream)
+	return nil
+}
+
+// runImport выполняет команду import: импортирует файлы по очереди и выводит итоги
+func runImport(cfg *Config, res *Result, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: buffer import [flags] file...")
+		fs.PrintDefaults()
+	}
+	progress := fs.Duration("progress", 0, "progress report interval, 0 disables progress")
+	checkpoint := fs.Bool("checkpoint", false, "record progress in <file>.checkpoint and resume an interrupted import from it")
+	restart := fs.Bool("restart", false, "with -checkpoint, discard the saved checkpoint and import from the first row")
+	maxPending := fs.Int("max-pending", cfg.Queue.ImportWindow, "rows read ahead of delivery before reading pauses (default 10000)")
+	source := fs.String("source", "import", "source system recorded in the provenance of each row")
+	endpoint := fs.String("endpoint", "", "API URL or path relative to api.save_fact_url to send the rows to")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("%w: no files given", errUsage)
+	}
+
+	buffer, closeBuffer, err := cfg.NewBuffer()
+	if err != nil {
+		return fmt.Errorf("creating buffer: %w", err)
+	}
+	defer closeBuffer()
+
+	for _, path := range fs.Args() {
+		opts := ImportOptions{ProgressInterval: *progress, Prepare: cfg.PrepareItem, MaxPending: *maxPending, Source: *source, Endpoint: *endpoint}
+		if *checkpoint {
+			opts.Checkpoint = checkpointPath(path)
+			if *restart {
+				if err := os.Remove(opts.Checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+			}
+		}
+		summary, err := buffer.ImportFile(path, opts)
+		res.Read += summary.Read
+		res.Sent += summary.Sent
+		res.Failed += summary.Failed
+		res.Skipped += summary.Skipped
+		res.addRowErrors(path, summary.Errors)
+		res.addRowWarnings(path, summary.Warnings)
+		if err != nil {
+			return fmt.Errorf("importing %s: %w", path, err)
+		}
+		fmt.Printf("%s: read %d, sent %d, failed %d, skipped %d in %s\n",
+			summary.File, summary.Read, summary.Sent, summary.Failed, summary.Skipped, summary.Duration.Round(time.Millisecond))
+		if summary.Resumed > 0 {
+			fmt.Printf("  resumed after row %d from the checkpoint\n", summary.Resumed)
+		}
+		if summary.Duplicates > 0 {
+			fmt.Printf("  %d sent rows already existed in the API and were skipped\n", summary.Duplicates)
+		}
+		for _, rowErr := range summary.Errors {
+			fmt.Printf("  row %d: %s\n", rowErr.Row, rowErr.Error)
+		}
+		for _, rowWarn := range summary.Warnings {
+			fmt.Printf("  row %d: API warning: %s\n", rowWarn.Row, strings.Join(rowWarn.Warnings, "; "))
+		}
+	}
+	if res.Failed > 0 || res.Skipped > 0 {
+		return fmt.Errorf("%w: %d rows were not delivered", errItemsFailed, res.Failed+res.Skipped)
+	}
+	return nil
+}
+
+// runSync выполняет команду sync: импортирует из источников с sync_field только строки,
+// измененные после сохраненной отметки, и продвигает отметку
+func runSync(cfg *Config, res *Result, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: buffer sync [flags] [path...]")
+		fmt.Fprintln(fs.Output(), "Syncs sources and schedule entries with sync_field, or only the given source paths.")
+		fs.PrintDefaults()
+	}
+	progress := fs.Duration("progress", 0, "progress report interval, 0 disables progress")
+	reset := fs.Bool("reset", false, "discard the saved sync state and send every row again")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	only := make(map[string]bool, fs.NArg())
+	for _, path := range fs.Args() {
+		only[path] = true
+	}
+	var sources []SourceConfig
+	seen := make(map[string]bool)
+	for _, src := range append(append([]SourceConfig(nil), cfg.Sources...), scheduledSources(cfg.Schedule)...) {
+		if src.SyncField == "" || seen[src.Path] || len(only) > 0 && !only[src.Path] {
+			continue
+		}
+		seen[src.Path] = true
+		sources = append(sources, src)
+	}
+	for path := range only {
+		if !seen[path] {
+			return fmt.Errorf("%w: %s is not a source with sync_field", errUsage, path)
+		}
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("%w: no sources with sync_field in the config", errUsage)
+	}
+
+	buffer, closeBuffer, err := cfg.NewBuffer()
+	if err != nil {
+		return fmt.Errorf("creating buffer: %w", err)
+	}
+	defer closeBuffer()
+
+	for _, src := range sources {
+		opts := src.importOptions(ImportOptions{ProgressInterval: *progress, Prepare: cfg.PrepareItem, MaxPending: cfg.Queue.ImportWindow, Source: "sync"})
+		if *reset {
+			if err := os.Remove(syncStatePath(src.Path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		summary, err := buffer.ImportFile(src.Path, opts)
+		res.Read += summary.Read
+		res.Sent += summary.Sent
+		res.Failed += summary.Failed
+		res.Skipped += summary.Skipped
+		res.addRowErrors(src.Path, summary.Errors)
+		res.addRowWarnings(src.Path, summary.Warnings)
+		if err != nil {
+			return fmt.Errorf("syncing %s: %w", src.Path, err)
+		}
+		cursor := summary.Cursor
+		if cursor == "" {
+			cursor = "not set"
+		}
+		fmt.Printf("%s: %d changed rows, sent %d, failed %d, skipped %d, unchanged %d in %s; %s is now %s\n",
+			summary.File, summary.Read-summary.Unchanged, summary.Sent, summary.Failed, summary.Skipped, summary.Unchanged,
+			summary.Duration.Round(time.Millisecond), src.SyncField, cursor)
+		for _, rowErr := range summary.Errors {
+			fmt.Printf("  row %d: %s\n", rowErr.Row, rowErr.Error)
+		}
+	}
+	if res.Failed > 0 || res.Skipped > 0 {
+		return fmt.Errorf("%w: %d rows were not delivered", errItemsFailed, res.Failed+res.Skipped)
+	}
+	return nil
+}
+
+// scheduledSources возвращает источники заданий расписания
+func scheduledSources(schedule []ScheduleConfig) []SourceConfig {
+	sources := make([]SourceConfig, len(schedule))
+	for i, sc := range schedule {
+		sources[i] = sc.SourceConfig
+	}
+	return sources
+}
+
+// runGetFacts выполняет команду get-facts: запрашивает данные и выводит тело ответа
+func runGetFacts(cfg *Config, res *Result, args []string) error {
+	fs := flag.NewFlagSet("get-facts", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: buffer get-facts [flags] [field=value...]")
+		fs.PrintDefaults()
+	}
+	periodStart := fs.String("period-start", "", "start of the period, YYYY-MM-DD")
+	periodEnd := fs.String("period-end", "", "end of the period, YYYY-MM-DD")
+	periodKey := fs.String("period-key", "month", "period key")
+	indicator := fs.String("indicator", "", "indicator_to_mo_id")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	params, err := parseFields(fs.Args())
+	if err != nil {
+		return err
+	}
+	for key, value := range map[string]string{
+		"period_start":       *periodStart,
+		"period_end":         *periodEnd,
+		"period_key":         *periodKey,
+		"indicator_to_mo_id": *indicator,
+	} {
+		if value != "" {
+			params[key] = value
+		}
+	}
+	if cfg.API.GetFactsURL == "" {
+		return fmt.Errorf("api.get_facts_url is not configured")
+	}
+	if cfg.DryRun {
+		form := url.Values{}
+		for key, value := range params {
+			form.Set(key, value)
+		}
+		logInfof("[dry-run] %s %s %s", cfg.API.FactsMethod(), cfg.API.GetFactsURL, form.Encode())
+		return nil
+	}
+
+	auth := cfg.Auth.ForURL(cfg.API.GetFactsURL)
+	token, err := auth.Secret()
+	if err != nil {
+		return err
+	}
+	authenticator, err := auth.Authenticator()
+	if err != nil {
+		return err
+	}
+	client, err := cfg.HTTPClient()
+	if err != nil {
+		return err
+	}
+	body, err := getFacts(client, authenticator, cfg.API.FactsMethod(), cfg.API.GetFactsURL, token, params)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(body))
+	return nil
+}
+
+// runDaemon выполняет команду daemon: запускает буфер с административным API
+// и работает до получения SIGINT или SIGTERM, после чего дожидается отправки накопленных элементов.
+// По SIGHUP настройки перечитываются без потери очереди. При запуске из systemd с Type=notify
+// сообщает о готовности и остановке и поддерживает watchdog
+func runDaemon(cfg *Config, res *Result, args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	addr := fs.String("admin-addr", cfg.Admin.Addr, "admin API address, empty disables the admin API")
+	pidFile := fs.String("pidfile", cfg.Daemon.PIDFile, "write the process ID to this file")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return serveDaemon(ctx, cfg, *addr, *pidFile)
+}
+
+// serveDaemon запускает буферы с административным API на addr и работает, пока не отменен ctx
+// или один из буферов не остановится с неустранимой ошибкой, которая и возвращается.
+// Если в настройках заданы клиенты, у каждого клиента свой буфер
+func serveDaemon(ctx context.Context, cfg *Config, addr, pidFile string) error {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	configs, err := cfg.daemonConfigs()
+	if err != nil {
+		return err
+	}
+	buffers := make([]*Buffer, len(configs))
+	for i, c := range configs {
+		buffer, closeBuffer, err := c.NewBuffer()
+		if err != nil {
+			if c.Tenant() != "" {
+				return fmt.Errorf("creating buffer of tenant %s: %w", c.Tenant(), err)
+			}
+			return fmt.Errorf("creating buffer: %w", err)
+		}
+		defer closeBuffer()
+		buffers[i] = buffer
+	}
+
+	if pidFile != "" {
+		removePIDFile, err := writePIDFile(pidFile)
+		if err != nil {
+			return err
+		}
+		defer removePIDFile()
+	}
+
+	var server *http.Server
+	if addr != "" {
+		if cfg.Admin.Token == "" {
+			return fmt.Errorf("admin.token is required to serve the admin API")
+		}
+		handler, err := cfg.Admin.protect(daemonAdminHandler(configs, buffers, cfg.Admin.Token))
+		if err != nil {
+			return err
+		}
+		ln, err := cfg.Admin.listen(addr)
+		if err != nil {
+			return fmt.Errorf("starting admin API: %w", err)
+		}
+		server = &http.Server{Handler: handler}
+		go func() {
+			if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fmt.Println("Error serving admin API:", err)
+				stop()
+			}
+		}()
+		logInfof("Admin API listening on %s", ln.Addr())
+	}
+
+	queues := make([]*RedisQueue, len(configs))
+	for i, c := range configs {
+		if c.Logging.SummaryInterval > 0 {
+			summary := NewSummaryLogger(buffers[i], c.Logging.SummaryInterval)
+			summary.Start()
+			defer summary.Stop()
+		}
+		// Планировщики создаются заново при каждом избрании, а здесь проверяется расписание
+		if _, err := c.NewScheduler(buffers[i]); err != nil {
+			return err
+		}
+		go watchReload(ctx, c, buffers[i])
+		if !c.Queue.Redis.enabled() {
+			continue
+		}
+		// Элементы из Redis забираются до остановки буферов, а подтверждаются и после нее, пока
+		// отправляются накопленные, поэтому соединения закрываются последними
+		queue, err := c.RedisQueue()
+		if err != nil {
+			return fmt.Errorf("opening Redis queue: %w", err)
+		}
+		defer queue.Close()
+		queues[i] = queue
+	}
+	var elector *LeaderElector
+	if cfg.Daemon.Leader.Enabled {
+		if elector, err = NewLeaderElector(cfg.Daemon.Leader, cfg.Queue.Redis, buffers[0].clock); err != nil {
+			return fmt.Errorf("leader election: %w", err)
+		}
+		defer elector.Close()
+		for i, buffer := range buffers {
+			buffer.setStandby(queues[i])
+		}
+	}
+	// Буферы останавливаются отдельно от ctx, чтобы планировщики успели остановиться до отправки
+	// накопленных элементов
+	runCtx, stopBuffers := context.WithCancel(context.Background())
+	defer stopBuffers()
+	finished := make(chan error, len(buffers))
+	for _, buffer := range buffers {
+		go func(b *Buffer) { finished <- b.Run(runCtx) }(buffer)
+	}
+	go runWatchdog(ctx)
+	if err := sdNotify("READY=1\nSTATUS=Sending"); err != nil {
+		fmt.Println("Error:", err)
+	}
+	leadCtx, stopLeading := context.WithCancel(ctx)
+	defer stopLeading()
+	led := make(chan struct{})
+	go func() {
+		defer close(led)
+		if elector == nil {
+			lead(leadCtx, configs, buffers, queues, true, false)
+			return
+		}
+		elector.Run(leadCtx, func(ctx context.Context, first bool) {
+			lead(ctx, configs, buffers, queues, first, true)
+		})
+	}()
+
+	running := len(buffers)
+	var runErr error
+	select {
+	case <-ctx.Done():
+	case runErr = <-finished:
+		running--
+	}
+	stopLeading()
+	<-led
+	var queued int
+	for _, buffer := range buffers {
+		queued += buffer.Stats().Queued
+	}
+	logInfof("Shutting down, flushing %d queued items", queued)
+	if err := sdNotify(fmt.Sprintf("STOPPING=1\nSTATUS=Flushing %d queued items", queued)); err != nil {
+		fmt.Println("Error:", err)
+	}
+	stopBuffers()
+	for ; running > 0; running-- {
+		if err := <-finished; err != nil && runErr == nil {
+			runErr = err
+		}
+	}
+	if server != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			fmt.Println("Error stopping admin API:", err)
+		}
+	}
+	return runErr
+}
+
+// lead выполняет работу лидера, пока ctx не завершится: запускает планировщики, забирает элементы
+// из общих очередей queues и, если first, добавляет элементы из настроек. Если standby задан,
+// по завершении невзятые в отправку элементы возвращаются в очереди, а буферы переходят в резерв
+func lead(ctx context.Context, configs []*Config, buffers []*Buffer, queues []*RedisQueue, first, standby bool) {
+	var wg sync.WaitGroup
+	for i, c := range configs {
+		buffer, queue := buffers[i], queues[i]
+		buffer.setStandby(nil)
+		scheduler, _ := c.NewScheduler(buffer)
+		scheduler.Start()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if queue != nil {
+				logInfof("Consuming items from Redis stream %s", queue.stream)
+				queue.Consume(ctx, buffer)
+			} else {
+				<-ctx.Done()
+			}
+			scheduler.Stop()
+			if standby && queue != nil {
+				queue.handOff(buffer)
+				buffer.setStandby(queue)
+			}
+		}()
+		if first {
+			enqueueConfigured(c, buffer)
+		}
+	}
+	wg.Wait()
+}
+
+// daemonAdminHandler возвращает административный API буферов демона: API единственного буфера
+// или разделы /tenants/{name} буферов клиентов
+func daemonAdminHandler(configs []*Config, buffers []*Buffer, token string) http.Handler {
+	if len(configs) == 1 && configs[0].Tenant() == "" {
+		return NewAdminHandler(buffers[0], token)
+	}
+	byName := make(map[string]*Buffer, len(configs))
+	for i, c := range configs {
+		byName[c.Tenant()] = buffers[i]
+	}
+	return NewTenantAdminHandler(NewTenantBuffers(byName), token)
+}
+
+// statsReport представляет статистику запущенного буфера со скоростью отправки
+type statsReport struct {
+	Stats
+	Throughput float64 `json:"throughput"`
+	ErrorRate  float64 `json:"error_rate"`
+}
+
+// runStats выполняет команду stats: запрашивает статистику запущенного буфера через административный API.
+// Скорость отправки и доля ошибок считаются по двум запросам с промежутком -sample
+func runStats(cfg *Config, res *Result, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	addr := fs.String("addr", cfg.Admin.Addr, "admin API address")
+	token := fs.String("token", cfg.Admin.Token, "admin API token")
+	format := fs.String("format", "table", "output format: table or json")
+	sample := fs.Duration("sample", time.Second, "interval for measuring throughput, 0 skips the measurement")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("%w: unknown format %q, expected table or json", errUsage, *format)
+	}
+
+	client, err := cfg.adminClient(*addr, *token)
+	if err != nil {
+		return err
+	}
+	stats, err := client.stats()
+	if err != nil {
+		return err
+	}
+	report := statsReport{Stats: stats}
+	if *sample > 0 {
+		start := time.Now()
+		time.Sleep(*sample)
+		if report.Stats, err = client.stats(); err != nil {
+			return err
+		}
+		report.Throughput = float64(report.Sent-stats.Sent) / time.Since(start).Seconds()
+		if attempts := report.Attempts - stats.Attempts; attempts > 0 {
+			report.ErrorRate = float64(report.Failed-stats.Failed) / float64(attempts)
+		}
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Queued\t%d\n", report.Queued)
+	if report.Spilled > 0 {
+		fmt.Fprintf(w, "Spilled to disk\t%d (%d bytes)\n", report.Spilled, report.SpoolBytes)
+	}
+	if report.Overflowed > 0 {
+		fmt.Fprintf(w, "Spool overflow\t%d\n", report.Overflowed)
+	}
+	fmt.Fprintf(w, "In flight\t%d\n", report.InFlight)
+	fmt.Fprintf(w, "Paused\t%t\n", report.Paused)
+	if report.Standby || report.Forwarded > 0 {
+		fmt.Fprintf(w, "Standby\t%t (%d forwarded to the leader)\n", report.Standby, report.Forwarded)
+	}
+	fmt.Fprintf(w, "Sent\t%d\n", report.Sent)
+	fmt.Fprintf(w, "Failed attempts\t%d\n", report.Failed)
+	fmt.Fprintf(w, "Dead letters\t%d\n", report.DeadLetters)
+	if *sample > 0 {
+		fmt.Fprintf(w, "Throughput\t%.1f/s\n", report.Throughput)
+		fmt.Fprintf(w, "Error rate\t%.1f%%\n", report.ErrorRate*100)
+	}
+	fmt.Fprintf(w, "Circuit\t%s\n", report.Circuit)
+	fmt.Fprintf(w, "Latency p50/p95/p99\t%s / %s / %s\n", report.LatencyP50.Round(time.Millisecond),
+		report.LatencyP95.Round(time.Millisecond), report.LatencyP99.Round(time.Millisecond))
+	return w.Flush()
+}
+
+// controlCommand возвращает команду, выполняющую действие pause, resume или flush
+// в запущенном буфере через административный API
+func controlCommand(action string) func(cfg *Config, res *Result, args []string) error {
+	return func(cfg *Config, res *Result, args []string) error {
+		fs := flag.NewFlagSet(action, flag.ContinueOnError)
+		addr := fs.String("addr", cfg.Admin.Addr, "admin API address")
+		token := fs.String("token", cfg.Admin.Token, "admin API token")
+		timeout := fs.Duration("timeout", 30*time.Second, "how long to wait for the daemon, 0 waits indefinitely")
+		if err := parseFlags(fs, args); err != nil {
+			return err
+		}
+		client, err := cfg.adminClient(*addr, *token)
+		if err != nil {
+			return err
+		}
+		client.client.Timeout = *timeout
+		stats, err := client.control(action)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s done: queued %d, in flight %d, paused %t, dead letters %d\n",
+			action, stats.Queued, stats.InFlight, stats.Paused, stats.DeadLetters)
+		return nil
+	}
+}
+
+// runReplay выполняет команду replay: повторно отправляет данные из журнала аудита
+func runReplay(cfg *Config, res *Result, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: buffer replay [flags] audit.log")
+		fs.PrintDefaults()
+	}
+	from := fs.String("from", "", "replay attempts made at or after this time, RFC 3339 or YYYY-MM-DD")
+	to := fs.String("to", "", "replay attempts made before this time, RFC 3339 or YYYY-MM-DD")
+	indicator := fs.String("indicator", "", "replay only this indicator_to_mo_id")
+	failuresOnly := fs.Bool("failures-only", false, "replay only failed deliveries")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("%w: expected one audit log file", errUsage)
+	}
+	filter := ReplayFilter{Indicator: *indicator, FailuresOnly: *failuresOnly}
+	var err error
+	if filter.From, err = parseTimeFlag(*from); err != nil {
+		return fmt.Errorf("-from: %w", err)
+	}
+	if filter.To, err = parseTimeFlag(*to); err != nil {
+		return fmt.Errorf("-to: %w", err)
+	}
+
+	cipher, err := cfg.FileCipher()
+	if err != nil {
+		return err
+	}
+	buffer, closeBuffer, err := cfg.NewBuffer()
+	if err != nil {
+		return fmt.Errorf("creating buffer: %w", err)
+	}
+	defer closeBuffer()
+	n, replayErr := buffer.Replay(fs.Arg(0), filter, cipher)
+	if replayErr != nil && n == 0 {
+		return replayErr
+	}
+	buffer.Flush()
+
+	stats := buffer.Stats()
+	res.Read, res.Sent, res.Failed = n, int(stats.Sent), int(stats.DeadLetters)
+	res.addDeadLetters(buffer.DeadLetters().Items())
+	fmt.Printf("Replayed %d items: sent %d, failed %d\n", n, stats.Sent, stats.DeadLetters)
+	if replayErr != nil {
+		return replayErr
+	}
+	if stats.DeadLetters > 0 {
+		return fmt.Errorf("%w: %d items were not delivered", errItemsFailed, stats.DeadLetters)
+	}
+	return nil
+}
+
+// runValidate выполняет команду validate: проверяет строки файлов, ничего не отправляя
+func runValidate(cfg *Config, res *Result, args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: buffer validate file...")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("%w: no files given", errUsage)
+	}
+
+	for _, path := range fs.Args() {
+		summary, err := ValidateFile(path, cfg.checkItem)
+		res.Read += summary.Rows
+		res.Skipped += summary.Invalid
+		res.addRowErrors(path, summary.Errors)
+		for _, rowErr := range summary.Errors {
+			fmt.Printf("%s:%d: %s\n", path, rowErr.Row, rowErr.Error)
+		}
+		if err != nil {
+			return fmt.Errorf("validating %s: %w", path, err)
+		}
+		fmt.Printf("%s: %d rows, %d valid, %d invalid\n", path, summary.Rows, summary.Valid, summary.Invalid)
+	}
+	if res.Skipped > 0 {
+		return fmt.Errorf("%w: %d invalid rows", errItemsFailed, res.Skipped)
+	}
+	return nil
+}
+
+// parseFields разбирает аргументы вида field=value
+func parseFields(args []string) (map[string]string, error) {
+	fields := make(map[string]string, len(args))
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid field %q, expected field=value", arg)
+		}
+		fields[key] = value
+	}
+	return fields, nil
+}
+
+// parseTimeFlag разбирает время в формате RFC 3339 или дату YYYY-MM-DD; пустая строка - нулевое время
+func parseTimeFlag(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, value, time.Local)
+}
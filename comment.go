@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// CommentTemplate задает поле comment каждого элемента шаблоном text/template, например
+// "Imported from {{.File}} row {{.Row}} at {{.Time}}", чтобы происхождение факта попадало
+// в комментарий KPI-Drive
+type CommentTemplate struct {
+	tmpl *template.Template
+}
+
+// CommentData содержит переменные шаблона комментария
+type CommentData struct {
+	ID            uint64            // номер элемента в буфере
+	Comment       string            // комментарий элемента до подстановки
+	Source        string            // система или команда, передавшая элемент
+	File          string            // файл импорта
+	Row           int               // строка файла, начиная с 1
+	User          string            // пользователь, передавший элемент
+	Host          string            // узел, с которого передан элемент
+	CorrelationID string            // идентификатор корреляции
+	Time          CommentTime       // время добавления в буфер
+	Fields        map[string]string // все поля элемента: {{index .Fields "value"}}
+}
+
+// CommentTime выводится в шаблоне как "2006-01-02 15:04:05"; другой вид задает {{.Time.Format "..."}}
+type CommentTime struct {
+	time.Time
+}
+
+func (t CommentTime) String() string {
+	return t.Format(time.DateTime)
+}
+
+// ParseCommentTemplate разбирает шаблон комментария
+func ParseCommentTemplate(text string) (*CommentTemplate, error) {
+	tmpl, err := template.New("comment").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("comment_template: %w", err)
+	}
+	return &CommentTemplate{tmpl: tmpl}, nil
+}
+
+// WithCommentTemplate заменяет поле comment каждого элемента при добавлении результатом шаблона t.
+// Если шаблон не удалось выполнить, комментарий остается как есть
+func WithCommentTemplate(t *CommentTemplate) Option {
+	return func(b *Buffer) {
+		b.comment = t
+	}
+}
+
+// Execute возвращает комментарий по данным data
+func (t *CommentTemplate) Execute(data CommentData) (string, error) {
+	var sb strings.Builder
+	if err := t.tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// apply подставляет комментарий в поля элемента item и сообщает, изменился ли он
+func (t *CommentTemplate) apply(item *queuedItem, fields map[string]string, now time.Time) bool {
+	data := CommentData{
+		ID:            item.id,
+		Comment:       fields["comment"],
+		CorrelationID: item.correlationID,
+		Time:          CommentTime{now},
+		Fields:        fields,
+	}
+	if p := item.provenance; p != nil {
+		data.Source, data.File, data.Row, data.User, data.Host = p.Source, p.File, p.Row, p.User, p.Host
+	}
+	comment, err := t.Execute(data)
+	if err != nil {
+		logWarnf("item %d comment is left as is: %v", item.id, err)
+		return false
+	}
+	if comment == fields["comment"] {
+		return false
+	}
+	fields["comment"] = comment
+	return true
+}
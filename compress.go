@@ -0,0 +1,106 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// defaultGzipMinSize задает размер тела, начиная с которого оно сжимается, если порог не задан
+const defaultGzipMinSize = 1024
+
+// gzipWriterPool хранит кодировщики gzip, чтобы не выделять их внутренние буферы для каждого запроса
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(nil) },
+}
+
+// WithGzip включает сжатие gzip тел запросов размером не меньше minSize байт (0 - 1 КиБ).
+// Если API отвечает 415 на сжатый запрос, сжатие отключается и запрос повторяется без него
+func WithGzip(minSize int) Option {
+	return func(b *Buffer) {
+		if minSize <= 0 {
+			minSize = defaultGzipMinSize
+		}
+		b.gzip.Store(true)
+		b.gzipMinSize = minSize
+	}
+}
+
+// gzipForm сжимает тело запроса в новый буфер из пула
+func gzipForm(form *formBuffer) (*formBuffer, error) {
+	out := formBufferPool.Get().(*formBuffer)
+	out.Reset()
+	zw := gzipWriterPool.Get().(*gzip.Writer)
+	defer gzipWriterPool.Put(zw)
+	zw.Reset(out)
+	if _, err := zw.Write(form.Bytes()); err != nil {
+		out.release()
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		out.release()
+		return nil, err
+	}
+	return out, nil
+}
+
+// newAPIRequest создает запрос на сохранение элемента с телом из пула, при compress - сжатым,
+// если тело не меньше порога сжатия. form содержит несжатое тело, если включен отладочный дамп
+func (b *Buffer) newAPIRequest(item *queuedItem, compress bool) (req *http.Request, form string, err error) {
+	buf := b.encodeBody(item.fields)
+	if b.dump != nil {
+		form = buf.String()
+	}
+	compress = compress && buf.Len() >= b.gzipMinSize
+	if compress {
+		gz, err := gzipForm(buf)
+		buf.release()
+		if err != nil {
+			return nil, form, fmt.Errorf("compressing request: %w", err)
+		}
+		buf = gz
+	}
+	req, err = http.NewRequest(http.MethodPost, b.target(item), nil)
+	if err != nil {
+		buf.release()
+		return nil, form, err
+	}
+	// Транспорт закрывает тело по завершении запроса, и буфер возвращается в пул
+	req.Body, req.ContentLength = newFormBody(buf), int64(buf.Len())
+	req.Header.Set("Content-Type", b.contentType())
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if b.signer != nil {
+		b.signer.sign(req, buf.Bytes(), b.clock.Now())
+	}
+	return req, form, nil
+}
+
+// doAPIRequest выполняет запрос; если API отверг сжатое тело, отключает сжатие и повторяет запрос без него
+func (b *Buffer) doAPIRequest(item *queuedItem, req *http.Request) (*http.Request, *http.Response, error) {
+	resp, err := b.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnsupportedMediaType || req.Header.Get("Content-Encoding") == "" {
+		return req, resp, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if b.gzip.CompareAndSwap(true, false) {
+		fmt.Println("API rejected a gzip-encoded request, sending uncompressed requests from now on")
+	}
+	plain, _, err := b.newAPIRequest(item, false)
+	if err != nil {
+		return req, nil, err
+	}
+	plain = plain.WithContext(req.Context())
+	// Заголовки нового тела, в том числе подпись, сохраняются
+	for key, values := range req.Header {
+		if _, set := plain.Header[key]; !set && key != "Content-Encoding" {
+			plain.Header[key] = values
+		}
+	}
+	resp, err = b.client.Do(plain)
+	return plain, resp, err
+}
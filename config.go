@@ -0,0 +1,766 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config представляет файл настроек буфера
+type Config struct {
+	API             APIConfig            `yaml:"api"`
+	Auth            AuthConfig           `yaml:"auth"`
+	Retry           RetryConfig          `yaml:"retry"`
+	RateLimit       RateLimitConfig      `yaml:"rate_limit"`
+	HTTP            HTTPConfig           `yaml:"http"`
+	CircuitBreaker  CircuitBreakerConfig `yaml:"circuit_breaker"`
+	Offline         OfflineConfig        `yaml:"offline"`          // приостановка отправки, пока сеть или API недоступны
+	Delivery        DeliveryConfig       `yaml:"delivery"`         // окна отправки и периоды обслуживания API
+	Quota           QuotaConfig          `yaml:"quota"`            // квота запросов к API за час и сутки
+	Template        map[string]string    `yaml:"template"`         // поля, подставляемые в каждый элемент, если в нем не заданы
+	Values          ValueFormat          `yaml:"values"`           // приведение числовых полей из форматов источников
+	Time            TimeZones            `yaml:"time"`             // часовые пояса дат фактов и границ периодов
+	Dedup           DedupConfig          `yaml:"dedup"`            // пропуск фактов, уже сохраненных в API
+	FactsCache      FactsCacheConfig     `yaml:"facts_cache"`      // кеш ответов get_facts с условными запросами
+	CommentTemplate string               `yaml:"comment_template"` // шаблон поля comment, например "Imported from {{.File}} row {{.Row}}"
+	Items           []map[string]string  `yaml:"items"`            // элементы, отправляемые при запуске
+	Sources         []SourceConfig       `yaml:"sources"`
+	Schedule        []ScheduleConfig     `yaml:"schedule"` // источники, импортируемые демоном по расписанию
+	Logging         LoggingConfig        `yaml:"logging"`
+	Admin           AdminConfig          `yaml:"admin"`
+	Daemon          DaemonConfig         `yaml:"daemon"`
+	DLQ             DLQConfig            `yaml:"dlq"`
+	Queue           QueueConfig          `yaml:"queue"`
+	Encryption      EncryptionConfig     `yaml:"encryption"`      // шифрование spool, очереди недоставленных и журнала аудита
+	Profiles        map[string]yaml.Node `yaml:"profiles"`        // именованные наборы настроек, накладываемые поверх общих
+	Tenants         map[string]yaml.Node `yaml:"tenants"`         // клиенты со своими учетными данными, очередью и DLQ, поверх общих
+	DryRun          bool                 `yaml:"dry_run"`         // выводить запросы в журнал вместо отправки
+	SkipValidation  bool                 `yaml:"skip_validation"` // не проверять поля фактов при добавлении в буфер
+
+	path     string // файл настроек, из которого собраны значения, для повторного чтения
+	required bool
+	profile  string
+	tenant   string
+}
+
+// APIConfig задает адреса методов API
+type APIConfig struct {
+	SaveFactURL    string `yaml:"save_fact_url"`
+	GetFactsURL    string `yaml:"get_facts_url"`
+	GetFactsMethod string `yaml:"get_facts_method"` // POST (по умолчанию) или GET с условиями в строке запроса
+}
+
+// FactsMethod возвращает HTTP-метод запросов get_facts
+func (a APIConfig) FactsMethod() string {
+	if a.GetFactsMethod == "" {
+		return http.MethodPost
+	}
+	return strings.ToUpper(a.GetFactsMethod)
+}
+
+// AuthConfig задает источник токена для API; задается ровно один из способов
+type AuthConfig struct {
+	Token        string `yaml:"token"`         // токен в открытом виде, только для отладки
+	TokenEnv     string `yaml:"token_env"`     // имя переменной окружения с токеном
+	TokenFile    string `yaml:"token_file"`    // файл с токеном
+	TokenCommand string `yaml:"token_command"` // команда оболочки, выводящая токен
+
+	Vault             VaultConfig             `yaml:"vault"`
+	AWSSecretsManager AWSSecretsManagerConfig `yaml:"aws_secrets_manager"`
+
+	RefreshInterval time.Duration `yaml:"refresh_interval"` // период повторного запроса токена; 0 - только после отказа API
+
+	Scheme             string        `yaml:"scheme"`               // bearer, api_key, basic или session; по умолчанию bearer
+	APIKeyHeader       string        `yaml:"api_key_header"`       // заголовок ключа API, по умолчанию X-API-Key
+	Username           string        `yaml:"username"`             // имя пользователя для basic и session
+	LoginURL           string        `yaml:"login_url"`            // адрес входа для session
+	LoginUsernameField string        `yaml:"login_username_field"` // поле формы входа с именем, по умолчанию login
+	LoginPasswordField string        `yaml:"login_password_field"` // поле формы входа с паролем, по умолчанию password
+	SessionTTL         time.Duration `yaml:"session_ttl"`          // время жизни сессии, если сервер его не указал; по умолчанию 30m
+
+	Destinations []AuthDestination `yaml:"destinations"` // отдельные схема и учетные данные для серверов
+}
+
+// VaultConfig задает секрет HashiCorp Vault с токеном; используется, если задан path
+type VaultConfig struct {
+	Addr      string `yaml:"addr"`
+	Path      string `yaml:"path"`
+	Field     string `yaml:"field"`
+	Namespace string `yaml:"namespace"`
+	TokenFile string `yaml:"token_file"`
+}
+
+// AWSSecretsManagerConfig задает секрет AWS Secrets Manager с токеном; используется, если задан secret_id
+type AWSSecretsManagerConfig struct {
+	Region   string `yaml:"region"`
+	SecretID string `yaml:"secret_id"`
+	Field    string `yaml:"field"`
+	Endpoint string `yaml:"endpoint"`
+}
+
+// RetryConfig задает повторные попытки доставки
+type RetryConfig struct {
+	MaxAttempts int           `yaml:"max_attempts"`
+	BaseDelay   time.Duration `yaml:"base_delay"`
+	MaxDelay    time.Duration `yaml:"max_delay"`
+}
+
+// RateLimitConfig задает ограничение частоты запросов; 0 - без ограничения
+type RateLimitConfig struct {
+	PerSecond      float64             `yaml:"per_second"`
+	Burst          int                 `yaml:"burst"`
+	PerUser        UserRateLimitConfig `yaml:"per_user"`
+	BytesPerSecond float64             `yaml:"bytes_per_second"` // исходящий трафик запросов к API, 0 - без ограничения
+	BytesBurst     int                 `yaml:"bytes_burst"`      // запас трафика в байтах, 0 - одна секунда
+}
+
+// HTTPConfig задает параметры соединений с API; 0 - значение по умолчанию
+type HTTPConfig struct {
+	MaxIdleConnsPerHost int                `yaml:"max_idle_conns_per_host"`
+	IdleConnTimeout     time.Duration      `yaml:"idle_conn_timeout"`
+	TLSHandshakeTimeout time.Duration      `yaml:"tls_handshake_timeout"`
+	DialTimeout         time.Duration      `yaml:"dial_timeout"`
+	RequestTimeout      time.Duration      `yaml:"request_timeout"` // время на запрос вместе с чтением ответа, по умолчанию 30s
+	DisableHTTP2        bool               `yaml:"disable_http2"`
+	Gzip                bool               `yaml:"gzip"`          // сжимать тела запросов
+	GzipMinSize         int                `yaml:"gzip_min_size"` // минимальный размер сжимаемого тела, по умолчанию 1024
+	MaxInFlight         int                `yaml:"max_in_flight"` // одновременных запросов, по умолчанию 1
+	OrderKey            string             `yaml:"order_key"`     // поле, внутри значения которого сохраняется порядок доставки
+	PrewarmConns        int                `yaml:"prewarm_conns"` // соединений, устанавливаемых при запуске
+	PrewarmIdle         time.Duration      `yaml:"prewarm_idle"`  // простой, после которого соединения прогреваются снова
+	DNS                 DNSConfig          `yaml:"dns"`
+	ClientCerts         []ClientCertConfig `yaml:"client_certs"`      // сертификаты для взаимной проверки TLS
+	CAFile              string             `yaml:"ca_file"`           // корневые сертификаты PEM вместо системных
+	TLSMinVersion       string             `yaml:"tls_min_version"`   // 1.2 или 1.3 для всех исходящих соединений, по умолчанию 1.2
+	TLSCipherSuites     []string           `yaml:"tls_cipher_suites"` // разрешенные наборы шифров TLS 1.0-1.2 для всех исходящих соединений
+	InsecureSkipVerify  bool               `yaml:"insecure_skip_verify"`
+	Signing             SigningConfig      `yaml:"signing"`
+	Form                FormConfig         `yaml:"form"`               // порядок полей, кодировка и кодирование значений тела запроса
+	MaxRequestSize      int                `yaml:"max_request_size"`   // наибольший размер тела запроса до сжатия, байт; 0 - без ограничения
+	Proxy               string             `yaml:"proxy"`              // http://, https:// или socks5://; пусто - HTTPS_PROXY и HTTP_PROXY
+	ProxyPasswordEnv    string             `yaml:"proxy_password_env"` // переменная окружения с паролем прокси
+	Fixtures            FixtureConfig      `yaml:"fixtures"`           // запись обмена с API в файл и воспроизведение из него
+	Faults              FaultConfig        `yaml:"faults"`             // внесение сбоев в отправку для проверки повторов
+}
+
+// CircuitBreakerConfig задает автоматический выключатель; 0 - выключатель не используется
+type CircuitBreakerConfig struct {
+	Threshold int           `yaml:"threshold"`
+	Cooldown  time.Duration `yaml:"cooldown"`
+}
+
+// SourceConfig описывает источник данных, импортируемый при запуске
+type SourceConfig struct {
+	Type       string `yaml:"type"` // file - файл CSV или JSON Lines
+	Path       string `yaml:"path"`
+	Checkpoint bool   `yaml:"checkpoint"` // продолжать прерванный импорт с контрольной точки в файле <path>.checkpoint
+	Endpoint   string `yaml:"endpoint"`   // адрес или путь API для строк источника вместо api.save_fact_url
+	SyncField  string `yaml:"sync_field"` // поле отметки изменения строки: отправлять только строки новее отметки в <path>.sync
+}
+
+// importOptions возвращает параметры импорта источника на основе общих opts
+func (s SourceConfig) importOptions(opts ImportOptions) ImportOptions {
+	if s.Checkpoint {
+		opts.Checkpoint = checkpointPath(s.Path)
+	}
+	if s.Endpoint != "" {
+		opts.Endpoint = s.Endpoint
+	}
+	if s.SyncField != "" {
+		opts.SyncField = s.SyncField
+	}
+	return opts
+}
+
+// ScheduleConfig описывает источник, импортируемый по расписанию cron
+type ScheduleConfig struct {
+	Name         string `yaml:"name"`
+	Cron         string `yaml:"cron"` // минута час день месяц день_недели или макрос вида @daily
+	SourceConfig `yaml:",inline"`
+}
+
+// LoggingConfig задает журналирование и файлы аудита
+type LoggingConfig struct {
+	Level           string          `yaml:"level"`
+	SummaryInterval time.Duration   `yaml:"summary_interval"`
+	AuditLog        string          `yaml:"audit_log"`
+	EventLog        EventLogConfig  `yaml:"event_log"`
+	DebugDump       DebugDumpConfig `yaml:"debug_dump"`
+}
+
+// EventLogConfig задает журнал событий доставки и его ротацию
+type EventLogConfig struct {
+	Path        string        `yaml:"path"`
+	MaxSize     int64         `yaml:"max_size"`
+	RotateEvery time.Duration `yaml:"rotate_every"`
+	MaxBackups  int           `yaml:"max_backups"`
+	MaxAge      time.Duration `yaml:"max_age"`
+}
+
+// DebugDumpConfig задает отладочный дамп неуспешных попыток
+type DebugDumpConfig struct {
+	Path         string   `yaml:"path"`
+	SecretFields []string `yaml:"secret_fields"`
+}
+
+// AdminConfig задает административный HTTP API
+type AdminConfig struct {
+	Addr  string         `yaml:"addr"`
+	Token string         `yaml:"token"`
+	Allow []string       `yaml:"allow"` // адреса и подсети CIDR, которым доступен API; пусто - всем
+	TLS   AdminTLSConfig `yaml:"tls"`
+}
+
+// DaemonConfig задает работу в режиме демона
+type DaemonConfig struct {
+	PIDFile string       `yaml:"pid_file"`
+	Leader  LeaderConfig `yaml:"leader"` // выбор лидера среди реплик, отправляет только лидер
+}
+
+// DLQConfig задает хранение недоставленных элементов
+type DLQConfig struct {
+	Path string `yaml:"path"` // файл JSON, в котором очередь сохраняется между запусками; пустой - только в памяти
+}
+
+// QueueConfig задает ограничение памяти очереди; 0 - без ограничения
+type QueueConfig struct {
+	MemoryLimit   int64            `yaml:"memory_limit"`   // байт на элементы в памяти, сверх него элементы пишутся в spool_dir
+	SpoolDir      string           `yaml:"spool_dir"`      // каталог файла вытесненных элементов
+	SpoolEncoding string           `yaml:"spool_encoding"` // кодировка spool: json (по умолчанию) или msgpack
+	SpoolSegment  int64            `yaml:"spool_segment"`  // байт в файле spool, после которых начинается следующий, по умолчанию 16MB
+	SpoolMaxSize  int64            `yaml:"spool_max_size"` // байт всех файлов spool, 0 - без ограничения
+	SpoolFull     string           `yaml:"spool_full"`     // при заполнении spool_max_size: block (по умолчанию), reject или drop_oldest
+	ImportWindow  int              `yaml:"import_window"`  // строк одного импорта, ожидающих доставки, по умолчанию 10000
+	Shards        int              `yaml:"shards"`         // частей по хешу http.order_key, каждая отправляет по одному элементу
+	Redis         RedisQueueConfig `yaml:"redis"`          // общая очередь в Redis, из которой демон забирает элементы производителей
+}
+
+// DefaultConfig возвращает настройки по умолчанию
+func DefaultConfig() *Config {
+	return &Config{
+		API: APIConfig{
+			SaveFactURL: "https://development.kpi-drive.ru/_api/facts/save_fact",
+			GetFactsURL: "https://development.kpi-drive.ru/_api/indicators/get_facts",
+		},
+		Retry: RetryConfig{
+			MaxAttempts: 3,
+			BaseDelay:   time.Second,
+			MaxDelay:    30 * time.Second,
+		},
+		RateLimit: RateLimitConfig{Burst: 1},
+		CircuitBreaker: CircuitBreakerConfig{
+			Cooldown: 30 * time.Second,
+		},
+		Logging: LoggingConfig{
+			Level: "info",
+		},
+		Admin: AdminConfig{
+			Addr: "127.0.0.1:8081",
+		},
+	}
+}
+
+// LoadConfig читает файл настроек в формате YAML поверх настроек по умолчанию
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	if err := cfg.decodeFile(path); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ResolveConfig собирает настройки по возрастанию приоритета: значения по умолчанию,
+// файл path (пропускается, если required не задан и файла нет), профиль profile из этого файла,
+// переменные окружения
+func ResolveConfig(path string, required bool, profile string, environ []string) (*Config, error) {
+	cfg := DefaultConfig()
+	cfg.path, cfg.required = path, required
+	if path != "" {
+		err := cfg.decodeFile(path)
+		if err != nil && (required || !errors.Is(err, fs.ErrNotExist)) {
+			return nil, err
+		}
+	}
+	if err := cfg.selectProfile(profile); err != nil {
+		return nil, err
+	}
+	if err := cfg.ApplyEnv(environ); err != nil {
+		return nil, fmt.Errorf("environment: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	return cfg, nil
+}
+
+// decodeFile читает файл настроек поверх текущих значений, отвергая неизвестные ключи
+func (c *Config) decodeFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	return nil
+}
+
+// transportOptions возвращает параметры соединений с API, загружая клиентские сертификаты
+func (c *Config) transportOptions() (TransportOptions, error) {
+	certs, err := loadClientCerts(c.HTTP.ClientCerts)
+	if err != nil {
+		return TransportOptions{}, err
+	}
+	minVersion, err := parseTLSVersion(c.HTTP.TLSMinVersion)
+	if err != nil {
+		return TransportOptions{}, fmt.Errorf("http.tls_min_version: %w", err)
+	}
+	suites, _, err := parseCipherSuites(c.HTTP.TLSCipherSuites)
+	if err != nil {
+		return TransportOptions{}, fmt.Errorf("http.tls_cipher_suites: %w", err)
+	}
+	var roots *x509.CertPool
+	if c.HTTP.CAFile != "" {
+		if roots, err = loadCAFile(c.HTTP.CAFile); err != nil {
+			return TransportOptions{}, err
+		}
+	}
+	proxy, err := parseProxy(c.HTTP.Proxy, c.HTTP.ProxyPasswordEnv)
+	if err != nil {
+		return TransportOptions{}, fmt.Errorf("http.proxy: %w", err)
+	}
+	if c.HTTP.InsecureSkipVerify {
+		logWarnf("http.insecure_skip_verify is set, API server certificates are not verified")
+	}
+	return TransportOptions{
+		MaxIdleConnsPerHost: c.HTTP.MaxIdleConnsPerHost,
+		IdleConnTimeout:     c.HTTP.IdleConnTimeout,
+		TLSHandshakeTimeout: c.HTTP.TLSHandshakeTimeout,
+		DialTimeout:         c.HTTP.DialTimeout,
+		DisableHTTP2:        c.HTTP.DisableHTTP2,
+		DNS:                 c.HTTP.DNS,
+		ClientCerts:         certs,
+		RootCAs:             roots,
+		MinTLSVersion:       minVersion,
+		CipherSuites:        suites,
+		InsecureSkipVerify:  c.HTTP.InsecureSkipVerify,
+		Proxy:               proxy,
+	}, nil
+}
+
+// HTTPClient создает клиента для запросов к API вне буфера с теми же параметрами соединений
+func (c *Config) HTTPClient() (*http.Client, error) {
+	opts, err := c.transportOptions()
+	if err != nil {
+		return nil, err
+	}
+	transport, err := newTransport(opts, systemClock)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: withClientCerts(transport, opts.ClientCerts)}, nil
+}
+
+// ApplyTLSPolicy применяет http.tls_min_version и http.tls_cipher_suites ко всем исходящим
+// соединениям буфера, а не только к запросам API
+func (c *Config) ApplyTLSPolicy() error {
+	minVersion, err := parseTLSVersion(c.HTTP.TLSMinVersion)
+	if err != nil {
+		return fmt.Errorf("http.tls_min_version: %w", err)
+	}
+	suites, insecure, err := parseCipherSuites(c.HTTP.TLSCipherSuites)
+	if err != nil {
+		return fmt.Errorf("http.tls_cipher_suites: %w", err)
+	}
+	for _, name := range insecure {
+		logWarnf("http.tls_cipher_suites allows insecure cipher suite %s", name)
+	}
+	SetTLSPolicy(minVersion, suites)
+	return nil
+}
+
+// FileCipher возвращает шифр файлов из encryption или nil, если шифрование не настроено
+func (c *Config) FileCipher() (*FileCipher, error) {
+	if !c.Encryption.enabled() {
+		return nil, nil
+	}
+	return NewFileCipher(c.Encryption)
+}
+
+// Validate проверяет согласованность настроек
+func (c *Config) Validate() error {
+	if c.API.SaveFactURL == "" {
+		return fmt.Errorf("api.save_fact_url is required")
+	}
+	if c.Retry.MaxAttempts < 1 {
+		return fmt.Errorf("retry.max_attempts must be at least 1")
+	}
+	if _, err := c.Auth.TokenProvider(); err != nil && !errors.Is(err, errNoToken) {
+		return err
+	}
+	if err := c.Auth.validate(); err != nil {
+		return err
+	}
+	if c.RateLimit.PerSecond < 0 {
+		return fmt.Errorf("rate_limit.per_second must not be negative")
+	}
+	if c.RateLimit.BytesPerSecond < 0 || c.RateLimit.BytesBurst < 0 {
+		return fmt.Errorf("rate_limit.bytes_per_second and bytes_burst must not be negative")
+	}
+	if err := c.RateLimit.PerUser.validate(); err != nil {
+		return fmt.Errorf("rate_limit.per_user: %w", err)
+	}
+	if h := c.HTTP; h.MaxIdleConnsPerHost < 0 || h.IdleConnTimeout < 0 || h.TLSHandshakeTimeout < 0 || h.DialTimeout < 0 || h.RequestTimeout < 0 || h.GzipMinSize < 0 || h.MaxInFlight < 0 || h.PrewarmConns < 0 || h.PrewarmIdle < 0 || h.MaxRequestSize < 0 {
+		return fmt.Errorf("http settings must not be negative")
+	}
+	if c.FactsCache.TTL < 0 || c.FactsCache.MaxEntries < 0 {
+		return fmt.Errorf("facts_cache.ttl and max_entries must not be negative")
+	}
+	if o := c.Offline; o.ProbeDelay < 0 || o.ProbeMax < 0 || o.ProbeTimeout < 0 {
+		return fmt.Errorf("offline settings must not be negative")
+	}
+	if err := c.Quota.validate(); err != nil {
+		return fmt.Errorf("quota.%w", err)
+	}
+	if _, err := NewDeliverySchedule(c.Delivery); err != nil {
+		return fmt.Errorf("delivery.%w", err)
+	}
+	if err := c.HTTP.DNS.validate(); err != nil {
+		return fmt.Errorf("http.dns: %w", err)
+	}
+	if _, err := parseProxy(c.HTTP.Proxy, ""); err != nil {
+		return fmt.Errorf("http.proxy: %w", err)
+	}
+	if _, err := parseTLSVersion(c.HTTP.TLSMinVersion); err != nil {
+		return fmt.Errorf("http.tls_min_version: %w", err)
+	}
+	if _, _, err := parseCipherSuites(c.HTTP.TLSCipherSuites); err != nil {
+		return fmt.Errorf("http.tls_cipher_suites: %w", err)
+	}
+	if err := c.HTTP.Form.validate(); err != nil {
+		return fmt.Errorf("http.form: %w", err)
+	}
+	if err := c.HTTP.Signing.validate(); err != nil {
+		return fmt.Errorf("http.signing: %w", err)
+	}
+	if err := c.HTTP.Fixtures.validate(); err != nil {
+		return err
+	}
+	if err := c.HTTP.Faults.validate(); err != nil {
+		return err
+	}
+	if err := c.Values.validate(); err != nil {
+		return err
+	}
+	if err := c.Time.validate(); err != nil {
+		return err
+	}
+	if c.CommentTemplate != "" {
+		if _, err := ParseCommentTemplate(c.CommentTemplate); err != nil {
+			return err
+		}
+	}
+	if method := c.API.FactsMethod(); method != http.MethodPost && method != http.MethodGet {
+		return fmt.Errorf("api.get_facts_method must be GET or POST, got %q", c.API.GetFactsMethod)
+	}
+	if c.Dedup.Remote && c.API.GetFactsURL == "" {
+		return fmt.Errorf("dedup.remote requires api.get_facts_url")
+	}
+	for i, cert := range c.HTTP.ClientCerts {
+		if err := cert.validate(); err != nil {
+			return fmt.Errorf("http.client_certs[%d]: %w", i, err)
+		}
+	}
+	if c.Queue.MemoryLimit < 0 || c.Queue.ImportWindow < 0 || c.Queue.Shards < 0 || c.Queue.SpoolSegment < 0 || c.Queue.SpoolMaxSize < 0 {
+		return fmt.Errorf("queue settings must not be negative")
+	}
+	if c.Encryption.KeyEnv != "" && c.Encryption.KeyFile != "" {
+		return fmt.Errorf("encryption: set either key_env or key_file")
+	}
+	if c.Queue.MemoryLimit > 0 && c.Queue.SpoolDir == "" {
+		return fmt.Errorf("queue.memory_limit requires queue.spool_dir")
+	}
+	if e := c.Queue.SpoolEncoding; e != "" && e != spoolJSON && e != spoolMsgpack {
+		return fmt.Errorf("queue.spool_encoding: unknown encoding %q, expected json or msgpack", e)
+	}
+	if p := c.Queue.SpoolFull; p != "" && !validSpoolFull(p) {
+		return fmt.Errorf("queue.spool_full: unknown policy %q, expected block, reject or drop_oldest", p)
+	}
+	if err := c.Queue.Redis.validate(); err != nil {
+		return fmt.Errorf("queue.redis: %w", err)
+	}
+	if err := c.Daemon.Leader.validate(c.Queue.Redis); err != nil {
+		return fmt.Errorf("daemon.leader: %w", err)
+	}
+	if err := c.Admin.validate(); err != nil {
+		return fmt.Errorf("admin: %w", err)
+	}
+	if _, err := ParseLogLevel(c.Logging.Level); err != nil {
+		return fmt.Errorf("logging.level: %w", err)
+	}
+	for i, src := range c.Sources {
+		if err := src.validate(); err != nil {
+			return fmt.Errorf("sources[%d]: %w", i, err)
+		}
+	}
+	for i, sc := range c.Schedule {
+		if _, err := parseCron(sc.Cron); err != nil {
+			return fmt.Errorf("schedule[%d]: %w", i, err)
+		}
+		if err := sc.validate(); err != nil {
+			return fmt.Errorf("schedule[%d]: %w", i, err)
+		}
+	}
+	return c.validateTenants()
+}
+
+// validate проверяет описание источника
+func (s SourceConfig) validate() error {
+	if s.Type != "file" {
+		return fmt.Errorf("unsupported type %q", s.Type)
+	}
+	if s.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	if s.Endpoint != "" {
+		if _, err := url.Parse(s.Endpoint); err != nil {
+			return fmt.Errorf("endpoint: %w", err)
+		}
+	}
+	return nil
+}
+
+// Item возвращает копию элемента, дополненную полями шаблона, с вычисленными по дате полями периода
+// и приведенными по time и values датами и значениями
+func (c *Config) Item(fields map[string]string) map[string]string {
+	// Поля периода вычисляются по дате элемента до шаблона, чтобы период и fact_time из шаблона
+	// их не заменили; period_key может задаваться шаблоном
+	own := make(map[string]string, len(fields)+1)
+	for key, value := range fields {
+		own[key] = value
+	}
+	if _, ok := own["period_key"]; !ok && c.Template["period_key"] != "" {
+		own["period_key"] = c.Template["period_key"]
+	}
+	now := time.Now()
+	c.Time.expandDate(own, now)
+	item := make(map[string]string, len(c.Template)+len(own))
+	for key, value := range c.Template {
+		item[key] = value
+	}
+	for key, value := range own {
+		item[key] = value
+	}
+	c.Time.apply(item, now)
+	if c.Values.enabled() {
+		c.Values.apply(item)
+	}
+	return item
+}
+
+// NewBuffer создает буфер по настройкам, открывая необходимые файлы журналов;
+// возвращаемая функция закрывает их после завершения работы буфера.
+// В пробном режиме журналы не открываются, чтобы пробный запуск не попал в историю отправок
+func (c *Config) NewBuffer() (*Buffer, func(), error) {
+	if len(c.Tenants) > 0 {
+		return nil, nil, fmt.Errorf("config defines tenants %s, select one with -tenant or BUFFER_TENANT", strings.Join(c.TenantNames(), ", "))
+	}
+	prepare := []Option{WithTimeZones(c.Time), WithValueFormat(c.Values)}
+	if c.CommentTemplate != "" {
+		comment, err := ParseCommentTemplate(c.CommentTemplate)
+		if err != nil {
+			return nil, nil, err
+		}
+		prepare = append(prepare, WithCommentTemplate(comment))
+	}
+	if !c.SkipValidation {
+		prepare = append(prepare, WithValidation(ValidateFact))
+	}
+	if c.HTTP.Form.enabled() {
+		form, err := NewFormEncoder(c.HTTP.Form)
+		if err != nil {
+			return nil, nil, err
+		}
+		prepare = append(prepare, WithFormEncoder(form))
+	}
+	if c.HTTP.MaxRequestSize > 0 {
+		prepare = append(prepare, WithMaxRequestSize(c.HTTP.MaxRequestSize))
+	}
+	if c.DryRun {
+		b := NewBuffer(c.API.SaveFactURL, "", append(prepare, WithDryRun())...)
+		return b, b.Close, nil
+	}
+
+	auth := c.Auth.ForURL(c.API.SaveFactURL)
+	provider, err := auth.TokenProvider()
+	if err != nil {
+		return nil, nil, err
+	}
+	authenticator, err := auth.Authenticator()
+	if err != nil {
+		return nil, nil, err
+	}
+	tokens := NewRotatingToken(provider, auth.RefreshInterval)
+	if _, err := tokens.Token(); err != nil {
+		return nil, nil, fmt.Errorf("loading API token: %w", err)
+	}
+
+	transport, err := c.transportOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	cipher, err := c.FileCipher()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var closers []func() error
+	closeAll := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			if err := closers[i](); err != nil {
+				fmt.Println("Error closing log file:", err)
+			}
+		}
+	}
+
+	opts := []Option{
+		WithTokenProvider(tokens),
+		WithAuthenticator(authenticator),
+		WithRetry(c.Retry.MaxAttempts, c.Retry.BaseDelay, c.Retry.MaxDelay),
+		WithRateLimit(c.RateLimit.PerSecond, c.RateLimit.Burst),
+		WithTransport(transport),
+		WithRequestTimeout(c.HTTP.RequestTimeout),
+		WithMaxInFlight(c.HTTP.MaxInFlight),
+		WithOrderKey(c.HTTP.OrderKey),
+	}
+	opts = append(opts, prepare...)
+	if c.HTTP.Gzip {
+		opts = append(opts, WithGzip(c.HTTP.GzipMinSize))
+	}
+	if c.HTTP.Faults.enabled() {
+		logWarnf("http.faults is set, fault injection is enabled and some requests to API will fail")
+		opts = append(opts, WithFaultInjection(c.HTTP.Faults))
+	}
+	if fx := c.HTTP.Fixtures; fx.Mode != "" {
+		fixtures, err := OpenFixtures(fx.Path, fx.Mode, fx.SecretFields...)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, WithFixtures(fixtures))
+	}
+	if c.Dedup.Remote {
+		opts = append(opts, WithRemoteDedup(c.API.GetFactsURL, c.Dedup.Fields...), WithGetFactsMethod(c.API.GetFactsMethod))
+	}
+	if c.FactsCache.Enabled {
+		opts = append(opts, WithFactsCache(NewFactsCache(c.FactsCache.TTL, c.FactsCache.MaxEntries)))
+	}
+	if c.Queue.Shards > 0 {
+		opts = append(opts, WithShards(c.Queue.Shards))
+	}
+	if c.RateLimit.PerUser.enabled() {
+		opts = append(opts, WithUserRateLimit(c.RateLimit.PerUser))
+	}
+	if c.RateLimit.BytesPerSecond > 0 {
+		opts = append(opts, WithBandwidthLimit(c.RateLimit.BytesPerSecond, c.RateLimit.BytesBurst))
+	}
+	if c.Quota.enabled() {
+		opts = append(opts, WithQuota(c.Quota))
+	}
+	if c.HTTP.Signing.enabled() {
+		signer, err := NewSigner(c.HTTP.Signing)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, WithSigner(signer))
+	}
+	if c.HTTP.PrewarmConns > 0 {
+		opts = append(opts, WithPrewarm(c.HTTP.PrewarmConns, c.HTTP.PrewarmIdle))
+	}
+	if c.Offline.Enabled {
+		opts = append(opts, WithOfflineMode(c.Offline.ProbeDelay, c.Offline.ProbeMax, c.Offline.ProbeTimeout))
+	}
+	if c.Delivery.enabled() {
+		schedule, err := NewDeliverySchedule(c.Delivery)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, WithDeliverySchedule(schedule))
+	}
+	if c.CircuitBreaker.Threshold > 0 {
+		opts = append(opts, WithCircuitBreaker(c.CircuitBreaker.Threshold, c.CircuitBreaker.Cooldown))
+	}
+	if c.DLQ.Path != "" {
+		dlq, err := OpenDeadLetterQueue(c.DLQ.Path, cipher)
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("opening dead letter queue: %w", err)
+		}
+		opts = append(opts, WithDeadLetterQueue(dlq))
+	}
+	if c.Queue.MemoryLimit > 0 {
+		spool, err := OpenSpoolWith(c.Queue.SpoolDir, cipher, SpoolOptions{
+			Encoding:    c.Queue.SpoolEncoding,
+			SegmentSize: c.Queue.SpoolSegment,
+			MaxSize:     c.Queue.SpoolMaxSize,
+			Full:        c.Queue.SpoolFull,
+		})
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("opening spool: %w", err)
+		}
+		closers = append(closers, spool.Close)
+		opts = append(opts, WithMemoryLimit(c.Queue.MemoryLimit, spool))
+	}
+	if c.Logging.AuditLog != "" {
+		audit, err := OpenAuditLog(c.Logging.AuditLog, cipher)
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("opening audit log: %w", err)
+		}
+		closers = append(closers, audit.Close)
+		opts = append(opts, WithAuditLog(audit))
+	}
+	if ev := c.Logging.EventLog; ev.Path != "" {
+		events, err := OpenEventLog(ev.Path, RotateConfig{
+			MaxSize:     ev.MaxSize,
+			RotateEvery: ev.RotateEvery,
+			MaxBackups:  ev.MaxBackups,
+			MaxAge:      ev.MaxAge,
+		})
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("opening event log: %w", err)
+		}
+		closers = append(closers, events.Close)
+		opts = append(opts, WithEventLog(events))
+	}
+	if dd := c.Logging.DebugDump; dd.Path != "" {
+		dump, err := OpenDebugDump(dd.Path, dd.SecretFields...)
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("opening debug dump: %w", err)
+		}
+		closers = append(closers, dump.Close)
+		opts = append(opts, WithDebugDump(dump))
+	}
+	b := NewBuffer(c.API.SaveFactURL, "", opts...)
+	return b, func() {
+		b.Close()
+		closeAll()
+	}, nil
+}
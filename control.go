@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sort"
+)
+
+// ItemInfo описывает элемент очереди для просмотра без изменения состояния буфера
+type ItemInfo struct {
+	ID            uint64            `json:"id"`
+	CorrelationID string            `json:"correlation_id,omitempty"`
+	Provenance    *Provenance       `json:"provenance,omitempty"`
+	Endpoint      string            `json:"endpoint,omitempty"`
+	Data          map[string]string `json:"data"`
+	Attempts      int               `json:"attempts"`
+	Parked        bool              `json:"parked,omitempty"` // ждет предыдущего элемента с тем же ключом или маркера пользователя
+}
+
+// info возвращает описание элемента с копией его данных
+func (item *queuedItem) info() ItemInfo {
+	return ItemInfo{ID: item.id, CorrelationID: item.correlationID, Provenance: item.provenance, Endpoint: item.endpoint, Data: item.fields.Map(), Attempts: len(item.attempts)}
+}
+
+// Pause приостанавливает отправку; новые элементы продолжают накапливаться в очереди
+func (b *Buffer) Pause() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.paused = true
+}
+
+// Resume возобновляет отправку после Pause
+func (b *Buffer) Resume() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.paused = false
+	b.signal()
+}
+
+// Paused сообщает, приостановлена ли отправка
+func (b *Buffer) Paused() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.paused
+}
+
+// Flush немедленно отправляет все накопленные элементы, в том числе во время паузы,
+// и возвращает управление, когда очередь опустеет
+func (b *Buffer) Flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushing++
+	b.signal()
+	if b.offline != nil {
+		b.offline.releaseHeld()
+	}
+	for b.pending.Load() > 0 {
+		b.cond.Wait()
+	}
+	b.flushing--
+}
+
+// WaitUntilEmpty ждет, пока не останется ни ожидающих, ни отправляемых элементов, или пока ctx
+// не завершится, и тогда возвращает ошибку ctx. В отличие от Flush отправка не ускоряется: пауза,
+// окно отправки и автономный режим продолжают действовать, а элементы, добавленные во время
+// ожидания, тоже дожидаются
+func (b *Buffer) WaitUntilEmpty(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.cond.Broadcast()
+	})
+	defer stop()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.pending.Load() > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		b.cond.Wait()
+	}
+	return nil
+}
+
+// Run запускает отправку и работает, пока не отменен ctx или не произошла неустранимая ошибка,
+// например ошибка чтения spool, из-за которой элементы потеряны. Затем Run, как Close, дожидается
+// отправки накопленных элементов и останавливает буфер, возвращая неустранимую ошибку или nil
+// после отмены ctx, поэтому буфер можно запускать в errgroup.Group вместе с другими частями
+// сервиса. Без Run отправку запускает первый добавленный элемент
+func (b *Buffer) Run(ctx context.Context) error {
+	b.start.Do(func() { go b.dispatch() })
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-b.fatal:
+		fmt.Println("Error: buffer stopped:", err)
+	}
+	b.Close()
+	return err
+}
+
+// fail сообщает Run неустранимую ошибку; сохраняется только первая
+func (b *Buffer) fail(err error) {
+	select {
+	case b.fatal <- err:
+	default:
+	}
+}
+
+// Close дожидается отправки накопленных элементов и останавливает горутину отправки;
+// после Close буфер не используется
+func (b *Buffer) Close() {
+	b.Flush()
+	b.close.Do(func() { close(b.stop) })
+}
+
+// Requeue возвращает элементы из очереди недоставленных в очередь отправки и возвращает количество
+// принятых; без идентификаторов возвращаются все элементы. Элемент, который буфер отверг, остается
+// в очереди недоставленных с ошибкой отказа
+func (b *Buffer) Requeue(ids ...uint64) int {
+	return b.RequeueEdited(ids, nil)
+}
+
+// RequeueEdited возвращает элементы в очередь отправки как Requeue, предварительно изменяя
+// данные каждого элемента функцией edit
+func (b *Buffer) RequeueEdited(ids []uint64, edit func(item map[string]string) map[string]string) int {
+	var requeued int
+	var rejected []DeadLetter
+	for _, letter := range b.dlq.Take(ids...) {
+		data := letter.Item
+		if edit != nil {
+			data = edit(maps.Clone(data))
+		}
+		accepted, err := b.accept(&queuedItem{
+			fields:        newItemFields(data),
+			correlationID: letter.CorrelationID,
+			provenance:    letter.Provenance,
+			endpoint:      letter.Endpoint,
+			attempts:      letter.Attempts,
+		})
+		if !accepted {
+			// Исправление не сохраняется, чтобы элемент можно было исправить заново
+			letter.Error, letter.FailedAt = err.Error(), b.clock.Now()
+			rejected = append(rejected, letter)
+			continue
+		}
+		requeued++
+	}
+	if len(rejected) > 0 {
+		b.dlq.Add(rejected...)
+	}
+	return requeued
+}
+
+// queuedItems возвращает описания ожидающих элементов в порядке отправки, начиная с offset, не более limit.
+// Вытесненные на диск элементы входят в общее количество, но не перечисляются
+func (b *Buffer) queuedItems(offset, limit int) ([]ItemInfo, int) {
+	snapshot := b.Snapshot()
+	page := pageBounds(offset, limit, snapshot.Len())
+	items := make([]ItemInfo, 0, page.end-page.start)
+	for i := page.start; i < page.end; i++ {
+		items = append(items, snapshot.info(i))
+	}
+	return items, snapshot.Len() + snapshot.Spilled()
+}
+
+// inFlightItems возвращает описания элементов, отправка которых выполняется прямо сейчас
+func (b *Buffer) inFlightItems() []ItemInfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	items := make([]ItemInfo, 0, len(b.inFlight))
+	for _, item := range b.inFlight {
+		items = append(items, item.info())
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items
+}
+
+// QueueSnapshot перебирает ожидающие элементы в порядке отправки, не извлекая их из очереди:
+// сначала готовые к отправке и элементы очереди в памяти, затем отложенные. Состав и порядок
+// элементов фиксируются при создании, а данные копируются при переборе, поэтому видны изменения
+// Update, сделанные позже. Вытесненные на диск элементы только учитываются в Spilled
+type QueueSnapshot struct {
+	b       *Buffer
+	items   []*queuedItem
+	parked  int // отложенные элементы в конце items
+	spilled int
+	next    int
+}
+
+// Snapshot создает снимок ожидающих элементов. Буфер блокируется только на время копирования
+// списка элементов, поэтому снимок можно перебирать, не задерживая отправку
+func (b *Buffer) Snapshot() *QueueSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drainLocked()
+	s := &QueueSnapshot{b: b, spilled: b.queue.Spilled()}
+	s.items = make([]*queuedItem, 0, len(b.ready)+b.queue.Resident()+b.parked)
+	s.items = append(s.items, b.ready...)
+	for i := 0; i < b.queue.Resident(); i++ {
+		s.items = append(s.items, b.queue.At(i))
+	}
+	n := len(s.items)
+	for _, t := range b.throttled {
+		s.items = append(s.items, t.item)
+	}
+	for _, waiting := range b.waiting {
+		s.items = append(s.items, waiting...)
+	}
+	parked := s.items[n:]
+	sort.Slice(parked, func(i, j int) bool { return parked[i].id < parked[j].id })
+	s.parked = len(parked)
+	return s
+}
+
+// Next возвращает следующий элемент снимка; ok не задан, когда элементы закончились
+func (s *QueueSnapshot) Next() (info ItemInfo, ok bool) {
+	if s.next >= len(s.items) {
+		return ItemInfo{}, false
+	}
+	s.next++
+	return s.info(s.next - 1), true
+}
+
+// Len возвращает количество элементов снимка в памяти
+func (s *QueueSnapshot) Len() int {
+	return len(s.items)
+}
+
+// Spilled возвращает количество элементов, которые на момент снимка были вытеснены на диск
+func (s *QueueSnapshot) Spilled() int {
+	return s.spilled
+}
+
+// info возвращает описание i-го элемента снимка
+func (s *QueueSnapshot) info(i int) ItemInfo {
+	s.b.mu.Lock()
+	info := s.items[i].info()
+	s.b.mu.Unlock()
+	info.Parked = i >= len(s.items)-s.parked
+	return info
+}
+
+// Peek возвращает элемент, который будет отправлен следующим, не извлекая его из очереди; ok
+// не задан, если ожидающих элементов нет. Ограничение частоты по пользователю не предсказывается:
+// элемент, которому не хватит маркера, будет отложен
+func (b *Buffer) Peek() (info ItemInfo, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drainLocked()
+	item := b.peekLocked()
+	if item == nil {
+		return ItemInfo{}, false
+	}
+	return item.info(), true
+}
+
+// peekLocked возвращает элемент, который выбрал бы nextLocked, не меняя очередь. Если в памяти
+// элементов нет, они загружаются из spool, как при извлечении. Вызывается с захваченным b.mu
+func (b *Buffer) peekLocked() *queuedItem {
+	now := b.clock.Now()
+	for _, t := range b.throttled {
+		if !t.at.After(now) {
+			return t.item
+		}
+	}
+	if len(b.ready) > 0 {
+		return b.ready[0]
+	}
+	if b.queue.Resident() == 0 && b.queue.Spilled() > 0 {
+		b.queue.pageIn()
+		b.signal() // потерянные при чтении spool элементы обрабатывает горутина отправки
+	}
+	for i := 0; i < b.queue.Resident(); i++ {
+		item := b.queue.At(i)
+		if key, ok := b.sequenceKey(item); ok {
+			if _, busy := b.waiting[key]; busy {
+				continue
+			}
+		}
+		return item
+	}
+	return nil
+}
+
+// page задает границы среза при постраничном выводе
+type page struct {
+	start, end int
+}
+
+// pageBounds вычисляет границы страницы, ограничивая их размером коллекции
+func pageBounds(offset, limit, total int) page {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > total {
+		offset = total
+	}
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+	return page{start: offset, end: end}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestWaitUntilEmpty проверяет, что ожидание не ускоряет отправку: на паузе оно завершается
+// по ctx, а после возобновления - когда доставлены и отправляемые элементы
+func TestWaitUntilEmpty(t *testing.T) {
+	SetLogLevel(LevelError)
+	gate := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-gate
+		w.Write([]byte(`{"MESSAGES":{"error":null,"warning":null,"info":[]},"DATA":{"indicator_to_mo_fact_id":1},"STATUS":"OK"}`))
+	}))
+	defer srv.Close()
+	b := NewBuffer(srv.URL, "token")
+	defer b.Close()
+	b.Pause()
+	for i := 0; i < 3; i++ {
+		b.Add(benchmarkItem)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.WaitUntilEmpty(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitUntilEmpty on a paused buffer returned %v", err)
+	}
+	if n := b.Pending(); n != 3 {
+		t.Fatalf("%d pending items after the wait, want 3 not flushed", n)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- b.WaitUntilEmpty(context.Background()) }()
+	b.Resume()
+	select {
+	case err := <-done:
+		t.Fatalf("WaitUntilEmpty returned %v while items were in flight", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(gate)
+	select {
+	case err := <-done:
+		if err != nil || b.Stats().Sent != 3 {
+			t.Fatalf("WaitUntilEmpty returned %v with %d items sent, want nil and 3", err, b.Stats().Sent)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("WaitUntilEmpty hung with %d pending items", b.Pending())
+	}
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	SetLogLevel(LevelError)
+	srv := hookServer(t, http.StatusOK)
+	b := NewBuffer(srv.URL, "token")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- b.Run(ctx) }()
+	b.Add(benchmarkItem)
+	cancel()
+	select {
+	case err := <-done:
+		// Run, как Close, отправляет накопленные элементы перед остановкой
+		if err != nil || b.Stats().Sent != 1 {
+			t.Fatalf("Run returned %v with %d items sent, want nil and 1", err, b.Stats().Sent)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+}
+
+func TestRunReturnsFatalError(t *testing.T) {
+	SetLogLevel(LevelError)
+	b := NewBuffer("http://127.0.0.1:0", "token", WithDryRun())
+	fatal := errors.New("reading spool: unexpected EOF")
+	b.fail(fatal)
+	b.fail(errors.New("second failure"))
+	done := make(chan error, 1)
+	go func() { done <- b.Run(context.Background()) }()
+	select {
+	case err := <-done:
+		if err != fatal {
+			t.Fatalf("Run returned %v, want the first fatal error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after a fatal error")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// tokenCommandTimeout ограничивает время выполнения команды, выдающей токен
+const tokenCommandTimeout = 30 * time.Second
+
+// errNoToken сообщает, что источник токена не настроен; проверяется при создании буфера,
+// а не при чтении настроек, чтобы команды без обращения к API работали без токена
+var errNoToken = errors.New("no API token configured: set auth.token_env, auth.token_file, auth.token_command, auth.vault or auth.aws_secrets_manager")
+
+// TokenProvider выдает токен доступа к API
+type TokenProvider interface {
+	Token() (string, error)
+}
+
+// StaticToken выдает заранее известный токен
+type StaticToken string
+
+// Token возвращает токен
+func (t StaticToken) Token() (string, error) {
+	return string(t), nil
+}
+
+// EnvToken читает токен из переменной окружения с заданным именем
+type EnvToken string
+
+// Token возвращает значение переменной окружения
+func (name EnvToken) Token() (string, error) {
+	token, ok := os.LookupEnv(string(name))
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", string(name))
+	}
+	return strings.TrimSpace(token), nil
+}
+
+// FileToken читает токен из файла, например смонтированного секрета
+type FileToken string
+
+// Token возвращает содержимое файла без пробелов по краям
+func (path FileToken) Token() (string, error) {
+	if info, err := os.Stat(string(path)); err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		logWarnf("token file %s is accessible by other users (mode %s)", string(path), info.Mode().Perm())
+	}
+	data, err := os.ReadFile(string(path))
+	if err != nil {
+		return "", fmt.Errorf("reading token file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// CommandToken получает токен из вывода команды оболочки, например менеджера паролей
+type CommandToken string
+
+// Token выполняет команду и возвращает ее стандартный вывод без пробелов по краям
+func (command CommandToken) Token() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenCommandTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", string(command))
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", string(command))
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("running token command: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// TokenProvider возвращает источник токена по настройкам; задан должен быть ровно один источник
+func (a AuthConfig) TokenProvider() (TokenProvider, error) {
+	var providers []TokenProvider
+	if a.Token != "" {
+		providers = append(providers, StaticToken(a.Token))
+	}
+	if a.TokenEnv != "" {
+		providers = append(providers, EnvToken(a.TokenEnv))
+	}
+	if a.TokenFile != "" {
+		providers = append(providers, FileToken(a.TokenFile))
+	}
+	if a.TokenCommand != "" {
+		providers = append(providers, CommandToken(a.TokenCommand))
+	}
+	if v := a.Vault; v.Path != "" {
+		providers = append(providers, VaultToken{Addr: v.Addr, Path: v.Path, Field: v.Field, Namespace: v.Namespace, TokenFile: v.TokenFile})
+	}
+	if s := a.AWSSecretsManager; s.SecretID != "" {
+		providers = append(providers, AWSSecretToken{Region: s.Region, SecretID: s.SecretID, Field: s.Field, Endpoint: s.Endpoint})
+	}
+	switch len(providers) {
+	case 0:
+		return nil, errNoToken
+	case 1:
+		return providers[0], nil
+	default:
+		return nil, fmt.Errorf("only one of auth.token, auth.token_env, auth.token_file, auth.token_command, auth.vault and auth.aws_secrets_manager may be set")
+	}
+}
+
+// Secret получает токен, ключ или пароль из настроенного источника и проверяет, что он не пустой
+func (a AuthConfig) Secret() (string, error) {
+	provider, err := a.TokenProvider()
+	if err != nil {
+		return "", err
+	}
+	token, err := provider.Token()
+	if err != nil {
+		return "", fmt.Errorf("loading API token: %w", err)
+	}
+	if token == "" {
+		return "", fmt.Errorf("loading API token: token is empty")
+	}
+	return token, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+	"time"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// dashboardHandler отдает встроенную страницу веб-панели
+func dashboardHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardHTML)
+	})
+}
+
+// ConfigInfo описывает действующие настройки буфера без секретов
+type ConfigInfo struct {
+	URL              string        `json:"url"`
+	AuditLog         bool          `json:"audit_log"`
+	EventLog         bool          `json:"event_log"`
+	CircuitThreshold int           `json:"circuit_threshold,omitempty"`
+	CircuitCooldown  time.Duration `json:"circuit_cooldown,omitempty"`
+	Notifiers        int           `json:"notifiers"`
+	Statsd           bool          `json:"statsd"`
+}
+
+// ConfigInfo возвращает действующие настройки буфера
+func (b *Buffer) ConfigInfo() ConfigInfo {
+	info := ConfigInfo{
+		URL:       b.url,
+		AuditLog:  b.audit != nil,
+		EventLog:  b.events != nil,
+		Notifiers: len(b.notifiers),
+		Statsd:    b.statsd != nil,
+	}
+	if b.breaker != nil {
+		info.CircuitThreshold, info.CircuitCooldown = b.breaker.settings()
+	}
+	return info
+}
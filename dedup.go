@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math/big"
+	"net/http"
+
+	"buffer/kpiclient"
+)
+
+// defaultDedupFields перечисляет поля, которые должны совпасть у факта в API, кроме value
+var defaultDedupFields = []string{"indicator_to_mo_id", "period_start", "period_end", "period_key", "fact_time", "is_plan"}
+
+// DedupConfig задает проверку повторов по данным API перед отправкой
+type DedupConfig struct {
+	Remote bool     `yaml:"remote"` // запрашивать get_facts перед отправкой и пропускать уже сохраненные факты
+	Fields []string `yaml:"fields"` // сравниваемые поля кроме value, по умолчанию показатель, период, fact_time и is_plan
+}
+
+// remoteDedup запрашивает факты показателя за период перед отправкой элемента
+type remoteDedup struct {
+	url    string
+	fields []string
+}
+
+// WithRemoteDedup перед отправкой каждого элемента запрашивает факты его показателя за период
+// по адресу get_facts getFactsURL и не отправляет элемент, если в API уже есть факт с тем же
+// значением value и теми же полями fields (по умолчанию показатель, период, fact_time и is_plan).
+// Пропущенный элемент считается доставленным. Если запрос не удался, элемент отправляется
+func WithRemoteDedup(getFactsURL string, fields ...string) Option {
+	return func(b *Buffer) {
+		if len(fields) == 0 {
+			fields = defaultDedupFields
+		}
+		b.dedup = &remoteDedup{url: getFactsURL, fields: fields}
+	}
+}
+
+// remoteDuplicate сообщает, есть ли факт элемента в API. Запрос выдерживает паузы выключателя
+// и ограничения частоты, как и отправка
+func (b *Buffer) remoteDuplicate(item *queuedItem) bool {
+	fields := item.fields.Map()
+	b.waitForTurn()
+	token, err := b.tokens.Token()
+	if err != nil {
+		logWarnf("item %d not checked for duplicates: loading token: %v", item.id, err)
+		return false
+	}
+	params := make(map[string]string, 4)
+	for _, field := range []string{"indicator_to_mo_id", "period_start", "period_end", "period_key"} {
+		if value := fields[field]; value != "" {
+			params[field] = value
+		}
+	}
+	var result *kpiclient.GetFactsResult
+	if b.facts != nil {
+		result, err = b.facts.query(b.client, b.auth, b.factsMethod, b.dedup.url, token, params, b.clock.Now())
+	} else {
+		result, err = queryFacts(b.client, b.auth, b.factsMethod, b.dedup.url, token, params)
+	}
+	switch {
+	case result == nil:
+		logWarnf("item %d not checked for duplicates: %v", item.id, err)
+		return false
+	case result.StatusCode != http.StatusOK:
+		logWarnf("item %d not checked for duplicates: get_facts responded with %d %s", item.id, result.StatusCode, http.StatusText(result.StatusCode))
+		return false
+	}
+	rows, err := parseFactRows(result.Body)
+	if err != nil {
+		logWarnf("item %d not checked for duplicates: unexpected get_facts response: %v", item.id, err)
+		return false
+	}
+	for _, row := range rows {
+		if b.dedup.matches(fields, row) {
+			logInfof("Item %d skipped: fact %s with value %s already exists", item.id, row["indicator_to_mo_fact_id"], row["value"])
+			return true
+		}
+	}
+	return false
+}
+
+// matches сообщает, совпадает ли факт API row с элементом: value сравнивается как число,
+// остальные поля - как строки, если заданы и в элементе, и в факте
+func (d *remoteDedup) matches(item, row map[string]string) bool {
+	if !sameNumber(item["value"], row["value"]) {
+		return false
+	}
+	for _, field := range d.fields {
+		want, got := item[field], row[field]
+		if want != "" && got != "" && want != got {
+			return false
+		}
+	}
+	return true
+}
+
+// sameNumber сравнивает десятичные записи чисел, чтобы "5" и "5.00" считались одним значением
+func sameNumber(a, b string) bool {
+	x, okX := new(big.Rat).SetString(a)
+	y, okY := new(big.Rat).SetString(b)
+	if !okX || !okY {
+		return a == b
+	}
+	return x.Cmp(y) == 0
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeadLetter представляет элемент, который не удалось доставить на API
+type DeadLetter struct {
+	ID            uint64            `json:"id"`
+	Item          map[string]string `json:"item"`
+	Error         string            `json:"error"`
+	RequestID     string            `json:"request_id,omitempty"`
+	CorrelationID string            `json:"correlation_id,omitempty"`
+	Provenance    *Provenance       `json:"provenance,omitempty"`
+	Endpoint      string            `json:"endpoint,omitempty"` // адрес элемента вместо адреса буфера
+	Attempts      []Attempt         `json:"attempts"`
+	FailedAt      time.Time         `json:"failed_at"`
+}
+
+// Attempt описывает одну попытку доставки элемента
+type Attempt struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	Status    int       `json:"status,omitempty"`
+	LatencyMs int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	Warnings  []string  `json:"warnings,omitempty"` // предупреждения API при принятом факте
+}
+
+// dlqFile представляет файл очереди недоставленных элементов. До введения версий файл
+// содержал только массив элементов
+type dlqFile struct {
+	formatHeader
+	Items []DeadLetter `json:"items"`
+}
+
+// encodeDeadLetters кодирует элементы в текущей версии формата файла
+func encodeDeadLetters(items []DeadLetter) ([]byte, error) {
+	if items == nil {
+		items = []DeadLetter{}
+	}
+	return json.MarshalIndent(dlqFile{formatHeader: newFormatHeader(dlqFormat, dlqVersion), Items: items}, "", "  ")
+}
+
+// decodeDeadLetters разбирает файл очереди недоставленных любой поддерживаемой версии и возвращает
+// его элементы и версию
+func decodeDeadLetters(data []byte) ([]DeadLetter, int, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, 0, nil
+	}
+	if data[0] == '[' {
+		var items []DeadLetter
+		err := json.Unmarshal(data, &items)
+		return items, 0, err
+	}
+	var file dlqFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, 0, err
+	}
+	if err := file.check(dlqFormat, dlqVersion); err != nil {
+		return nil, 0, err
+	}
+	return file.Items, file.Version, nil
+}
+
+// readDeadLetterJournal читает журнал добавленных элементов. Последняя строка без перевода строки
+// не дописана при сбое и пропускается. rewrite сообщает, что дописывать журнал нельзя и его нужно
+// заменить сохранением очереди целиком: строка не дописана или записи зашифрованы не так, как сейчас
+func readDeadLetterJournal(path string, cipher *FileCipher) (letters []DeadLetter, rewrite bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	rewrite = len(lines[len(lines)-1]) > 0
+	lines = lines[:len(lines)-1]
+	for i, line := range lines {
+		rewrite = rewrite || !cipher.current(line)
+		if line, err = cipher.Open(dlqJournalFormat, line); err != nil {
+			return nil, false, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if i == 0 {
+			var header formatHeader
+			if err := json.Unmarshal(line, &header); err != nil {
+				return nil, false, fmt.Errorf("line 1: %w", err)
+			}
+			if err := header.check(dlqJournalFormat, dlqJournalVersion); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		var letter DeadLetter
+		if err := json.Unmarshal(line, &letter); err != nil {
+			return nil, false, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		letters = append(letters, letter)
+	}
+	return letters, rewrite, nil
+}
+
+// dlqCompactMin - число записей журнала, до которого очередь не сохраняется целиком, даже если
+// записей в журнале больше, чем элементов
+const dlqCompactMin = 1024
+
+// DeadLetterQueue хранит элементы, доставка которых завершилась ошибкой. Добавленные элементы
+// дописываются в журнал рядом с файлом очереди, а очередь целиком сохраняется при извлечении
+// элементов и когда журнал становится длиннее очереди, поэтому добавление не переписывает файл
+type DeadLetterQueue struct {
+	mu      sync.Mutex
+	items   []DeadLetter
+	path    string // файл, в котором сохраняется очередь; пустой - только в памяти
+	cipher  *FileCipher
+	journal int  // записей в журнале после последнего сохранения очереди целиком
+	stale   bool // файла нет, либо он прежней версии или не зашифрован, и перед журналом очередь сохраняется целиком
+}
+
+// NewDeadLetterQueue создает пустую очередь недоставленных элементов
+func NewDeadLetterQueue() *DeadLetterQueue {
+	return &DeadLetterQueue{}
+}
+
+// OpenDeadLetterQueue загружает очередь недоставленных элементов из файла JSON и его журнала
+// (файл с суффиксом .journal) и сохраняет в них последующие изменения; отсутствующий файл
+// означает пустую очередь. Если задан cipher, файлы сохраняются зашифрованными, а прежний открытый
+// файл читается, только если cipher разрешает переход, и шифруется при первом изменении. Файл прежней версии формата сохраняется
+// в текущей при первом изменении, а файл более новой версии не открывается
+func OpenDeadLetterQueue(path string, cipher *FileCipher) (*DeadLetterQueue, error) {
+	q := &DeadLetterQueue{path: path, cipher: cipher, stale: true}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		current := cipher.current(data)
+		if data, err = cipher.Open(dlqFormat, data); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		var version int
+		if q.items, version, err = decodeDeadLetters(data); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		q.stale = version < dlqVersion || !current
+	}
+	journal, rewrite, err := readDeadLetterJournal(q.journalPath(), cipher)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", q.journalPath(), err)
+	}
+	q.stale = q.stale || rewrite
+	// Журнал остается, если сбой произошел после сохранения очереди целиком, но до его удаления,
+	// поэтому уже сохраненные элементы не добавляются повторно
+	saved := make(map[uint64]bool, len(q.items))
+	for _, letter := range q.items {
+		saved[letter.ID] = true
+	}
+	for _, letter := range journal {
+		if !saved[letter.ID] {
+			q.items = append(q.items, letter)
+		}
+	}
+	q.journal = len(journal)
+	return q, nil
+}
+
+// journalPath возвращает путь журнала добавленных элементов
+func (q *DeadLetterQueue) journalPath() string {
+	return q.path + ".journal"
+}
+
+// saveLocked записывает очередь во временный файл и заменяет им прежний, чтобы сбой
+// во время записи не повредил сохраненные элементы, после чего удаляет журнал.
+// Вызывается с захваченным q.mu
+func (q *DeadLetterQueue) saveLocked() {
+	if q.path == "" {
+		return
+	}
+	if err := q.writeLocked(); err != nil {
+		fmt.Println("Error saving dead letter queue:", err)
+		return
+	}
+	q.journal, q.stale = 0, false
+	if err := os.Remove(q.journalPath()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Println("Error removing dead letter journal:", err)
+	}
+}
+
+// writeLocked записывает очередь во временный файл, сбрасывает его на диск и заменяет им прежний
+func (q *DeadLetterQueue) writeLocked() error {
+	data, err := encodeDeadLetters(q.items)
+	if err != nil {
+		return err
+	}
+	tmp := q.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(q.cipher.Seal(dlqFormat, data)); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
+
+// appendLocked дописывает элементы в журнал и сбрасывает его на диск. Вызывается с захваченным q.mu
+func (q *DeadLetterQueue) appendLocked(letters []DeadLetter) error {
+	f, err := os.OpenFile(q.journalPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	if info, err := f.Stat(); err != nil {
+		return err
+	} else if info.Size() == 0 {
+		header, _ := json.Marshal(newFormatHeader(dlqJournalFormat, dlqJournalVersion))
+		buf.Write(append(q.cipher.Seal(dlqJournalFormat, header), '\n'))
+	}
+	for _, letter := range letters {
+		line, err := json.Marshal(letter)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(q.cipher.Seal(dlqJournalFormat, line), '\n'))
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	q.journal += len(letters)
+	return nil
+}
+
+// Add помещает недоставленные элементы в очередь
+func (q *DeadLetterQueue) Add(letters ...DeadLetter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items = append(q.items, letters...)
+	if q.path == "" {
+		return
+	}
+	if q.stale || q.journal+len(letters) > max(len(q.items), dlqCompactMin) {
+		q.saveLocked()
+		return
+	}
+	if err := q.appendLocked(letters); err != nil {
+		fmt.Println("Error appending to dead letter journal:", err)
+		q.saveLocked()
+	}
+}
+
+// Len возвращает количество элементов в очереди
+func (q *DeadLetterQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// Items возвращает копию содержимого очереди
+func (q *DeadLetterQueue) Items() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := make([]DeadLetter, len(q.items))
+	copy(items, q.items)
+	return items
+}
+
+// Take извлекает из очереди элементы с указанными идентификаторами, а без идентификаторов - все элементы
+func (q *DeadLetterQueue) Take(ids ...uint64) []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(ids) == 0 {
+		taken := q.items
+		q.items = nil
+		q.saveLocked()
+		return taken
+	}
+	wanted := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	var taken []DeadLetter
+	kept := q.items[:0]
+	for _, letter := range q.items {
+		if wanted[letter.ID] {
+			taken = append(taken, letter)
+		} else {
+			kept = append(kept, letter)
+		}
+	}
+	clear(q.items[len(kept):])
+	q.items = kept
+	if len(taken) > 0 {
+		q.saveLocked()
+	}
+	return taken
+}
+
+// Get возвращает недоставленный элемент по идентификатору
+func (q *DeadLetterQueue) Get(id uint64) (DeadLetter, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, letter := range q.items {
+		if letter.ID == id {
+			return letter, true
+		}
+	}
+	return DeadLetter{}, false
+}
+
+// maxID возвращает наибольший идентификатор в очереди
+func (q *DeadLetterQueue) maxID() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var id uint64
+	for _, letter := range q.items {
+		id = max(id, letter.ID)
+	}
+	return id
+}
+
+// DeadLetterFilter отбирает недоставленные элементы; пустые поля не ограничивают выборку
+type DeadLetterFilter struct {
+	Since     time.Time // не раньше этого времени
+	Until     time.Time // раньше этого времени
+	Error     string    // подстрока текста ошибки без учета регистра
+	Indicator string    // indicator_to_mo_id
+}
+
+// match сообщает, подходит ли элемент под фильтр
+func (f DeadLetterFilter) match(letter DeadLetter) bool {
+	if !f.Since.IsZero() && letter.FailedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !letter.FailedAt.Before(f.Until) {
+		return false
+	}
+	if f.Error != "" && !strings.Contains(strings.ToLower(letter.Error), strings.ToLower(f.Error)) {
+		return false
+	}
+	return f.Indicator == "" || letter.Item["indicator_to_mo_id"] == f.Indicator
+}
+
+// Find возвращает элементы очереди, подходящие под фильтр
+func (q *DeadLetterQueue) Find(f DeadLetterFilter) []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var found []DeadLetter
+	for _, letter := range q.items {
+		if f.match(letter) {
+			found = append(found, letter)
+		}
+	}
+	return found
+}
+
+// WithDeadLetterQueue задает очередь недоставленных элементов, например сохраняемую в файл
+func WithDeadLetterQueue(q *DeadLetterQueue) Option {
+	return func(b *Buffer) {
+		b.dlq = q
+	}
+}
+
+// DeadLetters возвращает очередь недоставленных элементов буфера
+func (b *Buffer) DeadLetters() *DeadLetterQueue {
+	return b.dlq
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"buffer/kpidrivetest"
+	"buffer/mockkpi"
+)
+
+// deadLetterFailedAt - время прежней ошибки элементов, созданных deadLetters
+var deadLetterFailedAt = time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)
+
+// deadLetters создает очередь недоставленных с n копиями benchmarkItem под номерами с 1
+func deadLetters(n int) *DeadLetterQueue {
+	dlq := NewDeadLetterQueue()
+	for id := 1; id <= n; id++ {
+		dlq.Add(DeadLetter{ID: uint64(id), Item: maps.Clone(benchmarkItem), Error: "timeout", FailedAt: deadLetterFailedAt})
+	}
+	return dlq
+}
+
+// setField возвращает исправление, задающее полю key значение value
+func setField(key, value string) func(map[string]string) map[string]string {
+	return func(item map[string]string) map[string]string {
+		item[key] = value
+		return item
+	}
+}
+
+// checkRejected проверяет, что отвергнутый элемент id остался в очереди с прежними данными и ошибкой want
+func checkRejected(t *testing.T, dlq *DeadLetterQueue, id uint64, want string) {
+	t.Helper()
+	letter, ok := dlq.Get(id)
+	if !ok || !maps.Equal(letter.Item, benchmarkItem) || !strings.Contains(letter.Error, want) || !letter.FailedAt.After(deadLetterFailedAt) {
+		t.Fatalf("rejected item left in the queue as %+v, %v; want the original data and error %q", letter, ok, want)
+	}
+}
+
+func TestRequeueKeepsRejectedItems(t *testing.T) {
+	SetLogLevel(LevelError)
+	srv := kpidrivetest.NewServer(mockkpi.Options{})
+	defer srv.Close()
+	dlq := deadLetters(2)
+	b := NewBuffer(srv.SaveFactURL(), "token", WithValidation(ValidateFact), WithDeadLetterQueue(dlq))
+	defer b.Close()
+
+	// Исправление делает первый элемент неверным: он остается в очереди недоставленных без исправления
+	if n := b.RequeueEdited([]uint64{1}, setField("value", "abc")); n != 0 {
+		t.Fatalf("RequeueEdited accepted %d invalid items, want 0", n)
+	}
+	checkRejected(t, dlq, 1, "value")
+	if n := b.Requeue(2); n != 1 {
+		t.Fatalf("Requeue accepted %d items, want 1", n)
+	}
+	b.Flush()
+	if stats := b.Stats(); stats.Sent != 1 || stats.Invalid != 1 || dlq.Len() != 1 {
+		t.Errorf("sent %d, invalid %d, %d dead letters left; want 1, 1 and 1", stats.Sent, stats.Invalid, dlq.Len())
+	}
+}
+
+func TestRequeueRejectsNonDecimalValue(t *testing.T) {
+	SetLogLevel(LevelError)
+	dlq := deadLetters(1)
+	b := NewBuffer("http://127.0.0.1:0", "token", WithValidation(ValidateFact), WithDeadLetterQueue(dlq))
+	defer b.Close()
+	if n := b.RequeueEdited(nil, setField("value", "1e3")); n != 0 {
+		t.Fatalf("RequeueEdited accepted %d items with an exponent, want 0", n)
+	}
+	checkRejected(t, dlq, 1, "value")
+}
+
+func TestRequeueRejectsOversizedItem(t *testing.T) {
+	SetLogLevel(LevelError)
+	dlq := deadLetters(1)
+	b := NewBuffer("http://127.0.0.1:0", "token", WithMaxRequestSize(512), WithDeadLetterQueue(dlq))
+	defer b.Close()
+	if n := b.RequeueEdited(nil, setField("comment", strings.Repeat("x", 1024))); n != 0 {
+		t.Fatalf("RequeueEdited accepted %d oversized items, want 0", n)
+	}
+	checkRejected(t, dlq, 1, "largest field comment")
+}
+
+func TestRequeueRejectsInvalidEndpoint(t *testing.T) {
+	SetLogLevel(LevelError)
+	dlq := NewDeadLetterQueue()
+	dlq.Add(DeadLetter{ID: 1, Item: maps.Clone(benchmarkItem), Endpoint: "https:///_api/facts/save_fact", FailedAt: deadLetterFailedAt})
+	b := NewBuffer("http://127.0.0.1:0", "token", WithDeadLetterQueue(dlq))
+	defer b.Close()
+	if n := b.Requeue(); n != 0 {
+		t.Fatalf("Requeue accepted %d items with an invalid endpoint, want 0", n)
+	}
+	checkRejected(t, dlq, 1, "host is required")
+	if letter, _ := dlq.Get(1); letter.Endpoint != "https:///_api/facts/save_fact" {
+		t.Errorf("rejected item lost its endpoint: %q", letter.Endpoint)
+	}
+}
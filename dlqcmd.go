@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// deadLetterStore предоставляет команде dlq доступ к очереди недоставленных элементов
+type deadLetterStore interface {
+	find(filter DeadLetterFilter) ([]DeadLetter, error)
+	get(id uint64) (DeadLetter, error)
+	requeue(req requeueRequest) (int, error)
+	purge(req purgeRequest) (int, error)
+}
+
+// localDeadLetters работает с файлом очереди dlq.path напрямую, без запущенного демона
+type localDeadLetters struct {
+	cfg   *Config
+	queue *DeadLetterQueue
+}
+
+func (l *localDeadLetters) find(filter DeadLetterFilter) ([]DeadLetter, error) {
+	return l.queue.Find(filter), nil
+}
+
+func (l *localDeadLetters) get(id uint64) (DeadLetter, error) {
+	letter, ok := l.queue.Get(id)
+	if !ok {
+		return letter, fmt.Errorf("dead letter %d not found", id)
+	}
+	return letter, nil
+}
+
+// requeue отправляет элементы буфером, открытым на том же файле очереди, и дожидается доставки;
+// снова не доставленные элементы возвращаются в файл
+func (l *localDeadLetters) requeue(req requeueRequest) (int, error) {
+	buffer, closeBuffer, err := l.cfg.NewBuffer()
+	if err != nil {
+		return 0, fmt.Errorf("creating buffer: %w", err)
+	}
+	defer closeBuffer()
+	n := buffer.RequeueEdited(req.IDs, req.edit())
+	buffer.Flush()
+	sent := int(buffer.Stats().Sent)
+	fmt.Printf("Delivered %d, failed again %d\n", sent, n-sent)
+	return n, nil
+}
+
+func (l *localDeadLetters) purge(req purgeRequest) (int, error) {
+	if len(req.IDs) == 0 && !req.All {
+		return 0, nil
+	}
+	return len(l.queue.Take(req.IDs...)), nil
+}
+
+// runDLQ выполняет команду dlq: просматривает, возвращает в очередь и удаляет недоставленные элементы
+// запущенного буфера через административный API или, с флагом -local, в файле dlq.path
+func runDLQ(cfg *Config, res *Result, args []string) error {
+	fs := flag.NewFlagSet("dlq", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: buffer dlq [flags] list | show id | requeue [id...] | purge [id...]")
+		fs.PrintDefaults()
+	}
+	addr := fs.String("addr", cfg.Admin.Addr, "admin API address")
+	token := fs.String("token", cfg.Admin.Token, "admin API token")
+	local := fs.Bool("local", false, "work on the dlq.path file directly; the daemon must not be running")
+	offset := fs.Int("offset", 0, "first dead letter to list")
+	limit := fs.Int("limit", 100, "number of dead letters to list")
+	since := fs.String("since", "", "only items failed at or after this time, RFC 3339 or YYYY-MM-DD")
+	until := fs.String("until", "", "only items failed before this time, RFC 3339 or YYYY-MM-DD")
+	errorText := fs.String("error", "", "only items whose error contains this text")
+	indicator := fs.String("indicator", "", "only items with this indicator_to_mo_id")
+	edit := fs.Bool("edit", false, "requeue: open the item in $EDITOR before requeueing, exactly one ID")
+	all := fs.Bool("all", false, "purge: remove all dead letters")
+	set := make(map[string]string)
+	fs.Func("set", "requeue: replace a field in every item, field=value, may be repeated", func(arg string) error {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("expected field=value, got %q", arg)
+		}
+		set[key] = value
+		return nil
+	})
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	filter := DeadLetterFilter{Error: *errorText, Indicator: *indicator}
+	var err error
+	if filter.Since, err = parseTimeFlag(*since); err != nil {
+		return fmt.Errorf("%w: -since: %v", errUsage, err)
+	}
+	if filter.Until, err = parseTimeFlag(*until); err != nil {
+		return fmt.Errorf("%w: -until: %v", errUsage, err)
+	}
+	ids, err := parseIDs(fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+
+	var store deadLetterStore
+	if *local {
+		if cfg.DLQ.Path == "" {
+			return fmt.Errorf("dlq.path is not configured")
+		}
+		cipher, err := cfg.FileCipher()
+		if err != nil {
+			return err
+		}
+		queue, err := OpenDeadLetterQueue(cfg.DLQ.Path, cipher)
+		if err != nil {
+			return err
+		}
+		store = &localDeadLetters{cfg: cfg, queue: queue}
+	} else if store, err = cfg.adminClient(*addr, *token); err != nil {
+		return err
+	}
+
+	switch action := fs.Arg(0); action {
+	case "list":
+		letters, err := store.find(filter)
+		if err != nil {
+			return err
+		}
+		p := pageBounds(*offset, *limit, len(letters))
+		for _, letter := range letters[p.start:p.end] {
+			fmt.Printf("%d\t%s\t%s\t%s\n", letter.ID, letter.FailedAt.Format(time.RFC3339),
+				letter.Item["indicator_to_mo_id"], letter.Error)
+		}
+		fmt.Printf("%d of %d dead letters\n", p.end-p.start, len(letters))
+		res.Read = len(letters)
+		return nil
+	case "show":
+		if len(ids) != 1 {
+			return fmt.Errorf("%w: show expects one dead letter ID", errUsage)
+		}
+		letter, err := store.get(ids[0])
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(letter)
+	case "requeue":
+		req := requeueRequest{Set: set}
+		if *edit {
+			if len(ids) != 1 {
+				return fmt.Errorf("%w: -edit expects exactly one dead letter ID", errUsage)
+			}
+			letter, err := store.get(ids[0])
+			if err != nil {
+				return err
+			}
+			if req.Item, err = editItem(letter.Item); err != nil {
+				return err
+			}
+		}
+		var matched bool
+		if req.IDs, matched, err = selectDeadLetters(store, ids, filter); err != nil || !matched {
+			return err
+		}
+		n, err := store.requeue(req)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Requeued %d items\n", n)
+		res.Read = n
+		return nil
+	case "purge":
+		if len(ids) == 0 && filter == (DeadLetterFilter{}) && !*all {
+			return fmt.Errorf("%w: purge expects IDs, filters or -all", errUsage)
+		}
+		req := purgeRequest{All: *all && len(ids) == 0 && filter == (DeadLetterFilter{})}
+		var matched bool
+		if req.IDs, matched, err = selectDeadLetters(store, ids, filter); err != nil || !matched {
+			return err
+		}
+		n, err := store.purge(req)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Purged %d items\n", n)
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown dlq action %q, expected list, show, requeue or purge", errUsage, action)
+	}
+}
+
+// selectDeadLetters возвращает явно заданные идентификаторы либо идентификаторы элементов,
+// подходящих под фильтр; без идентификаторов и фильтра возвращает nil, что означает все элементы.
+// matched не задан, если под фильтр ничего не подошло
+func selectDeadLetters(store deadLetterStore, ids []uint64, filter DeadLetterFilter) (selected []uint64, matched bool, err error) {
+	if filter == (DeadLetterFilter{}) {
+		return ids, true, nil
+	}
+	if len(ids) > 0 {
+		return nil, false, fmt.Errorf("%w: give dead letter IDs or filters, not both", errUsage)
+	}
+	letters, err := store.find(filter)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(letters) == 0 {
+		fmt.Println("No dead letters match the filter")
+		return nil, false, nil
+	}
+	for _, letter := range letters {
+		selected = append(selected, letter.ID)
+	}
+	return selected, true, nil
+}
+
+// parseIDs разбирает идентификаторы недоставленных элементов
+func parseIDs(args []string) ([]uint64, error) {
+	var ids []uint64
+	for _, arg := range args {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid dead letter ID %q", errUsage, arg)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// editItem открывает данные элемента в редакторе $VISUAL или $EDITOR и возвращает исправленные данные
+// после проверки
+func editItem(item map[string]string) (map[string]string, error) {
+	f, err := os.CreateTemp("", "buffer-dlq-*.json")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(item); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+	args := append(strings.Fields(editor), f.Name())
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running editor: %w", err)
+	}
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	edited, err := decodeJSONItem(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing edited item: %w", err)
+	}
+	if err := ValidateFact(edited); err != nil {
+		return nil, fmt.Errorf("edited item is invalid, nothing requeued: %w", err)
+	}
+	return edited, nil
+}
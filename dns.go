@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DNSConfig задает разрешение имени API: собственные DNS-серверы, кеш ответов и закрепленные адреса.
+// Нужен установкам KPI-Drive внутри сети, где имя API разрешается только внутренним DNS
+type DNSConfig struct {
+	Servers  []string            `yaml:"servers"`   // DNS-серверы host[:port], по умолчанию системные
+	CacheTTL time.Duration       `yaml:"cache_ttl"` // сколько хранить ответ независимо от TTL записи, 0 - без кеша
+	Hosts    map[string][]string `yaml:"hosts"`     // закрепленные адреса имен, DNS для них не запрашивается
+}
+
+// enabled сообщает, отличаются ли настройки от системного разрешения имен
+func (c DNSConfig) enabled() bool {
+	return len(c.Servers) > 0 || c.CacheTTL > 0 || len(c.Hosts) > 0
+}
+
+// validate проверяет адреса серверов и закрепленных имен
+func (c DNSConfig) validate() error {
+	for _, server := range c.Servers {
+		if _, _, err := net.SplitHostPort(withDefaultPort(server, "53")); err != nil {
+			return fmt.Errorf("invalid server %q: %w", server, err)
+		}
+	}
+	if c.CacheTTL < 0 {
+		return fmt.Errorf("cache_ttl must not be negative")
+	}
+	for host, addrs := range c.Hosts {
+		if len(addrs) == 0 {
+			return fmt.Errorf("hosts.%s: no addresses", host)
+		}
+		for _, addr := range addrs {
+			if net.ParseIP(addr) == nil {
+				return fmt.Errorf("hosts.%s: %q is not an IP address", host, addr)
+			}
+		}
+	}
+	return nil
+}
+
+// withDefaultPort добавляет порт к адресу без порта
+func withDefaultPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, port)
+}
+
+// dnsResolver разрешает имена для транспорта по DNSConfig и устанавливает соединения
+type dnsResolver struct {
+	dialer   *net.Dialer
+	resolver *net.Resolver
+	clock    Clock
+	ttl      time.Duration
+	hosts    map[string][]string
+	mu       sync.Mutex
+	cache    map[string]dnsEntry
+}
+
+// dnsEntry представляет кешированный ответ DNS
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// newDNSResolver создает разрешение имен для соединений, устанавливаемых dialer; срок кеша
+// отсчитывается по clock
+func newDNSResolver(cfg DNSConfig, dialer *net.Dialer, clock Clock) *dnsResolver {
+	r := &dnsResolver{
+		dialer:   dialer,
+		resolver: net.DefaultResolver,
+		clock:    clock,
+		ttl:      cfg.CacheTTL,
+		hosts:    cfg.Hosts,
+		cache:    make(map[string]dnsEntry),
+	}
+	if len(cfg.Servers) > 0 {
+		servers := make([]string, len(cfg.Servers))
+		for i, server := range cfg.Servers {
+			servers[i] = withDefaultPort(server, "53")
+		}
+		// Каждая попытка запроса идет к следующему серверу, поэтому недоступный сервер
+		// заменяется остальными при повторе
+		var next atomic.Uint32
+		r.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				server := servers[int(next.Add(1)-1)%len(servers)]
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return r
+}
+
+// DialContext устанавливает соединение с addr, перебирая адреса имени по порядку
+func (r *dnsResolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return r.dialer.DialContext(ctx, network, addr)
+	}
+	addrs, err := r.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, ip := range addrs {
+		conn, err := r.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	// Адреса могли смениться, поэтому следующее соединение запрашивает их заново
+	r.forget(host)
+	return nil, errors.Join(errs...)
+}
+
+// lookup возвращает адреса имени: закрепленные, из кеша или от DNS
+func (r *dnsResolver) lookup(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	if r.ttl > 0 {
+		r.mu.Lock()
+		entry, ok := r.cache[host]
+		r.mu.Unlock()
+		if ok && r.clock.Now().Before(entry.expires) {
+			return entry.addrs, nil
+		}
+	}
+	addrs, err := r.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if r.ttl > 0 {
+		r.mu.Lock()
+		r.cache[host] = dnsEntry{addrs: addrs, expires: r.clock.Now().Add(r.ttl)}
+		r.mu.Unlock()
+	}
+	return addrs, nil
+}
+
+// forget удаляет адреса имени из кеша
+func (r *dnsResolver) forget(host string) {
+	r.mu.Lock()
+	delete(r.cache, host)
+	r.mu.Unlock()
+}
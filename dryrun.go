@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// WithDryRun включает пробный режим: элементы проходят очередь и все проверки, но вместо
+// отправки на API запрос выводится в журнал; токен не запрашивается и не выводится
+func WithDryRun() Option {
+	return func(b *Buffer) {
+		b.dryRun = true
+	}
+}
+
+// logDryRun выводит запрос, который был бы отправлен для элемента
+func (b *Buffer) logDryRun(item *queuedItem) {
+	var body string
+	if b.form != nil {
+		body = b.form.Encode(item.fields.Map())
+	} else {
+		formData := url.Values{}
+		for key, value := range item.fields.Map() {
+			formData.Set(key, value)
+		}
+		body = formData.Encode()
+	}
+	headers := []string{"Authorization: Bearer " + redacted, "Content-Type: " + b.contentType()}
+	if item.correlationID != "" {
+		headers = append(headers, "X-Correlation-ID: "+item.correlationID)
+	}
+	sort.Strings(headers)
+	logInfof("[dry-run] POST %s [%s] %s", b.target(item), strings.Join(headers, ", "), body)
+}
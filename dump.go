@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redacted заменяет скрытые значения в отладочном дампе
+const redacted = "***"
+
+// sensitiveHeaders перечисляет заголовки, значения которых всегда скрываются
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// debugExchange накапливает запрос и ответ одной попытки для отладочного дампа
+type debugExchange struct {
+	request      *http.Request
+	requestBody  string
+	response     *http.Response
+	responseBody []byte
+	token        string
+}
+
+// redactor скрывает значения чувствительных заголовков и секретных полей
+type redactor struct {
+	secrets map[string]bool // имена полей формы, JSON-ключей и заголовков в нижнем регистре
+}
+
+// newRedactor создает redactor для полей secretFields
+func newRedactor(secretFields []string) redactor {
+	secrets := make(map[string]bool, len(secretFields))
+	for _, field := range secretFields {
+		secrets[strings.ToLower(field)] = true
+	}
+	return redactor{secrets: secrets}
+}
+
+// DebugDump записывает полные пары запрос/ответ неуспешных попыток со скрытыми секретами
+type DebugDump struct {
+	redactor
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenDebugDump открывает файл дампа для дозаписи; secretFields перечисляет поля формы,
+// JSON-ключи и заголовки, значения которых нужно скрыть
+func OpenDebugDump(path string, secretFields ...string) (*DebugDump, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &DebugDump{redactor: newRedactor(secretFields), f: f}, nil
+}
+
+// Close закрывает файл дампа
+func (d *DebugDump) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.f.Close()
+}
+
+// WithDebugDump включает запись запросов и ответов неуспешных попыток в отладочный дамп
+func WithDebugDump(d *DebugDump) Option {
+	return func(b *Buffer) {
+		b.dump = d
+	}
+}
+
+// dumpExchange записывает неуспешную попытку в дамп, если он включен
+func (b *Buffer) dumpExchange(entry *AuditEntry, ex *debugExchange) {
+	if b.dump == nil || entry.Error == "" || ex.request == nil {
+		return
+	}
+	if err := b.dump.write(entry, ex, ex.token); err != nil {
+		fmt.Println("Error writing debug dump:", err)
+	}
+}
+
+// write форматирует пару запрос/ответ, скрывая секреты и вхождения токена
+func (d *DebugDump) write(entry *AuditEntry, ex *debugExchange, token string) error {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "=== %s request_id=%s error=%q\n", entry.Time.Format(time.RFC3339Nano), entry.RequestID, entry.Error)
+	fmt.Fprintf(&out, "--- request\n%s %s %s\n", ex.request.Method, ex.request.URL, ex.request.Proto)
+	d.writeHeaders(&out, ex.request.Header)
+	fmt.Fprintf(&out, "\n%s\n", d.redactForm(ex.requestBody))
+	if ex.response != nil {
+		fmt.Fprintf(&out, "--- response\n%s %s\n", ex.response.Proto, ex.response.Status)
+		d.writeHeaders(&out, ex.response.Header)
+		fmt.Fprintf(&out, "\n%s\n", d.redactJSON(ex.responseBody))
+	}
+	out.WriteString("\n")
+
+	dump := out.Bytes()
+	if token != "" {
+		dump = bytes.ReplaceAll(dump, []byte(token), []byte(redacted))
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err := d.f.Write(dump)
+	return err
+}
+
+// writeHeaders выводит заголовки в отсортированном порядке, скрывая чувствительные значения
+func (d *DebugDump) writeHeaders(out *bytes.Buffer, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range h[key] {
+			if d.secretHeader(key) {
+				value = redacted
+			}
+			fmt.Fprintf(out, "%s: %s\n", key, value)
+		}
+	}
+}
+
+// secretHeader сообщает, нужно ли скрыть значение заголовка key
+func (d redactor) secretHeader(key string) bool {
+	return sensitiveHeaders[http.CanonicalHeaderKey(key)] || d.secrets[strings.ToLower(key)]
+}
+
+// redactForm скрывает секретные поля в теле формы
+func (d redactor) redactForm(body string) string {
+	form, err := url.ParseQuery(body)
+	if err != nil || len(d.secrets) == 0 {
+		return body
+	}
+	for key := range form {
+		if d.secrets[strings.ToLower(key)] {
+			form.Set(key, redacted)
+		}
+	}
+	return form.Encode()
+}
+
+// redactJSON скрывает значения секретных ключей в JSON-ответе на любой глубине
+func (d redactor) redactJSON(body []byte) string {
+	var v interface{}
+	if len(d.secrets) == 0 || json.Unmarshal(body, &v) != nil {
+		return string(body)
+	}
+	out, err := json.Marshal(d.redactValue(v))
+	if err != nil {
+		return string(body)
+	}
+	return string(out)
+}
+
+func (d redactor) redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if d.secrets[strings.ToLower(key)] {
+				v[key] = redacted
+			} else {
+				v[key] = d.redactValue(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = d.redactValue(value)
+		}
+	}
+	return v
+}
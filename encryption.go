@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// encryptedPrefix отмечает запись, зашифрованную вместе с именем ее формата, а legacyEncryptedPrefix -
+// запись прежних версий, не связанную с форматом. Записи без отметки - открытый текст
+const (
+	encryptedPrefix       = "enc2:"
+	legacyEncryptedPrefix = "enc1:"
+)
+
+var (
+	// errEncrypted сообщает, что файл зашифрован, а ключ не настроен
+	errEncrypted = errors.New("data is encrypted, configure encryption.key_env or encryption.key_file")
+	// errUnsealed сообщает о незашифрованной или не связанной с форматом записи при настроенном ключе
+	errUnsealed = errors.New("record is not encrypted with the current scheme, set encryption.allow_plaintext to migrate older files")
+)
+
+// EncryptionConfig задает ключ шифрования файлов spool, очереди недоставленных и журнала аудита.
+// Ключ AES-256 (32 байта) записывается в шестнадцатеричном виде или base64 и задается ровно одним
+// из способов: key_env или key_file. AllowPlaintext на время перехода разрешает читать записи,
+// созданные до включения шифрования или прежними версиями буфера
+type EncryptionConfig struct {
+	KeyEnv         string `yaml:"key_env"`
+	KeyFile        string `yaml:"key_file"`
+	AllowPlaintext bool   `yaml:"allow_plaintext"`
+}
+
+// enabled сообщает, включено ли шифрование
+func (c EncryptionConfig) enabled() bool {
+	return c.KeyEnv != "" || c.KeyFile != ""
+}
+
+// FileCipher шифрует записи файлов AES-GCM. Каждая запись шифруется отдельно со своим nonce
+// и кодируется base64 в одну строку, поэтому файлы JSON Lines остаются построчными. Имя формата
+// записи входит в проверяемые данные, поэтому запись одного файла не читается как запись другого.
+// Методы nil-шифра оставляют записи открытыми
+type FileCipher struct {
+	aead           cipher.AEAD
+	allowPlaintext bool
+}
+
+// NewFileCipher создает шифр из настроек, читая ключ
+func NewFileCipher(c EncryptionConfig) (*FileCipher, error) {
+	if c.KeyEnv != "" && c.KeyFile != "" {
+		return nil, fmt.Errorf("encryption: set either key_env or key_file")
+	}
+	var source TokenProvider = EnvToken(c.KeyEnv)
+	if c.KeyFile != "" {
+		source = FileToken(c.KeyFile)
+	}
+	encoded, err := source.Token()
+	if err != nil {
+		return nil, fmt.Errorf("loading encryption key: %w", err)
+	}
+	key, err := decodeKey(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &FileCipher{aead: aead, allowPlaintext: c.AllowPlaintext}, nil
+}
+
+// decodeKey декодирует 32-байтовый ключ из шестнадцатеричной записи или base64
+func decodeKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 64 {
+		if key, err := hex.DecodeString(s); err == nil {
+			return key, nil
+		}
+	}
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("expected 32 bytes as 64 hex digits or base64, e.g. from openssl rand -hex 32")
+	}
+	return key, nil
+}
+
+// Seal шифрует запись формата format; результат не содержит переводов строки
+func (c *FileCipher) Seal(format string, plain []byte) []byte {
+	if c == nil {
+		return plain
+	}
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plain)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("reading random nonce: %v", err))
+	}
+	sealed := c.aead.Seal(nonce, nonce, plain, []byte(format))
+	out := make([]byte, len(encryptedPrefix)+base64.StdEncoding.EncodedLen(len(sealed)))
+	copy(out, encryptedPrefix)
+	base64.StdEncoding.Encode(out[len(encryptedPrefix):], sealed)
+	return out
+}
+
+// Open расшифровывает запись формата format, созданную Seal. Открытые записи и записи прежних
+// версий без связи с форматом принимаются только с allowPlaintext, а без ключа открытая запись
+// возвращается как есть
+func (c *FileCipher) Open(format string, data []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(data)
+	var aad []byte
+	switch {
+	case bytes.HasPrefix(trimmed, []byte(encryptedPrefix)):
+		trimmed, aad = trimmed[len(encryptedPrefix):], []byte(format)
+	case bytes.HasPrefix(trimmed, []byte(legacyEncryptedPrefix)):
+		trimmed = trimmed[len(legacyEncryptedPrefix):]
+		if c != nil && !c.allowPlaintext {
+			return nil, errUnsealed
+		}
+	case c == nil || c.allowPlaintext:
+		return data, nil
+	default:
+		return nil, errUnsealed
+	}
+	if c == nil {
+		return nil, errEncrypted
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(trimmed))
+	if err != nil {
+		return nil, fmt.Errorf("decoding encrypted record: %w", err)
+	}
+	size := c.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, fmt.Errorf("encrypted record is truncated")
+	}
+	plain, err := c.aead.Open(nil, sealed[:size], sealed[size:], aad)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting record: wrong key, another file's record or corrupted data")
+	}
+	return plain, nil
+}
+
+// current сообщает, записана ли запись так, как ее записал бы Seal сейчас; прочие записи
+// переписываются при переходе
+func (c *FileCipher) current(data []byte) bool {
+	return c == nil || bytes.HasPrefix(bytes.TrimSpace(data), []byte(encryptedPrefix))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testCipher создает шифр с ключом key из 64 шестнадцатеричных цифр
+func testCipher(t *testing.T, key string, allowPlaintext bool) *FileCipher {
+	t.Helper()
+	t.Setenv("BUFFER_TEST_KEY", key)
+	c, err := NewFileCipher(EncryptionConfig{KeyEnv: "BUFFER_TEST_KEY", AllowPlaintext: allowPlaintext})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// legacySeal шифрует запись, как прежние версии: без имени формата в проверяемых данных
+func legacySeal(c *FileCipher, plain []byte) []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	sealed := c.aead.Seal(nonce, nonce, plain, nil)
+	return []byte(legacyEncryptedPrefix + base64.StdEncoding.EncodeToString(sealed))
+}
+
+func TestFileCipher(t *testing.T) {
+	key := strings.Repeat("ab", 32)
+	c := testCipher(t, key, false)
+	plain := []byte(`{"value":"1"}`)
+	sealed := c.Seal(spoolFormat, plain)
+	if bytes.Contains(sealed, plain) || bytes.ContainsRune(sealed, '\n') {
+		t.Fatalf("sealed record %q", sealed)
+	}
+	if got, err := c.Open(spoolFormat, append(sealed, '\n')); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("round trip = %q, %v", got, err)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(string(sealed[len(encryptedPrefix):]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)-1] ^= 1
+	tampered := []byte(encryptedPrefix + base64.StdEncoding.EncodeToString(raw))
+	tests := []struct {
+		name   string
+		cipher *FileCipher
+		format string
+		data   []byte
+		want   string
+	}{
+		{"wrong key", testCipher(t, strings.Repeat("cd", 32), false), spoolFormat, sealed, "wrong key"},
+		{"tampered", c, spoolFormat, tampered, "corrupted"},
+		{"other format", c, dlqFormat, sealed, "another file's record"},
+		{"no key", nil, spoolFormat, sealed, "data is encrypted"},
+		{"plaintext", c, spoolFormat, plain, "allow_plaintext"},
+		{"legacy", c, spoolFormat, legacySeal(c, plain), "allow_plaintext"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.cipher.Open(tt.format, tt.data); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Open = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileCipherMigration(t *testing.T) {
+	key := strings.Repeat("ab", 32)
+	migrating := testCipher(t, key, true)
+	plain := []byte(`{"value":"1"}`)
+	for _, data := range [][]byte{plain, legacySeal(migrating, plain)} {
+		if got, err := migrating.Open(spoolFormat, data); err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("migration read %q as %q, %v", data, got, err)
+		}
+	}
+	if migrating.current(plain) || !migrating.current(migrating.Seal(spoolFormat, plain)) {
+		t.Error("plaintext reported as current or sealed record as outdated")
+	}
+
+	// Открытая очередь недоставленных переписывается зашифрованной и читается без флага перехода
+	path := filepath.Join(t.TempDir(), "dlq.json")
+	q, err := OpenDeadLetterQueue(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	letter := func(id uint64) DeadLetter {
+		return DeadLetter{ID: id, Item: benchmarkItem, Error: "timeout", FailedAt: deadLetterFailedAt}
+	}
+	q.Add(letter(1), letter(2))
+	if _, err := OpenDeadLetterQueue(path, testCipher(t, key, false)); err == nil {
+		t.Fatal("plaintext dead letters read without allow_plaintext")
+	}
+	if q, err = OpenDeadLetterQueue(path, migrating); err != nil {
+		t.Fatal(err)
+	}
+	q.Add(letter(3))
+	q, err = OpenDeadLetterQueue(path, testCipher(t, key, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := q.Len(); n != 3 {
+		t.Errorf("%d dead letters after migration, want 3", n)
+	}
+
+	// Открытый spool прошлого запуска переписывается зашифрованным при запуске
+	SetLogLevel(LevelError)
+	dir := t.TempDir()
+	spoolItems(t, dir, 0, 1, 2)
+	if _, err := OpenSpool(dir, testCipher(t, key, false)); err == nil {
+		t.Fatal("plaintext spool read without allow_plaintext")
+	}
+	s, err := OpenSpool(dir, migrating)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = OpenSpool(dir, testCipher(t, key, false)); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if ids := popIDs(t, s); len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("migrated spool returned %v, want [1 2]", ids)
+	}
+}
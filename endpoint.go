@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// WithEndpoint отправляет элемент по адресу endpoint вместо адреса буфера. Адрес задается целиком,
+// например https://other.kpi-drive.ru/_api/facts/save_fact, или путем относительно адреса буфера,
+// например /_api/facts/save_plan, так что одна очередь обслуживает разные методы и серверы API.
+// Токен и схема авторизации используются те же, что и для адреса буфера
+func WithEndpoint(endpoint string) ItemOption {
+	return func(item *queuedItem) {
+		item.endpoint = endpoint
+	}
+}
+
+// resolveEndpoint приводит адрес элемента к абсолютному относительно адреса буфера
+func (b *Buffer) resolveEndpoint(endpoint string) (string, error) {
+	ref, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("endpoint %q: %w", endpoint, err)
+	}
+	if ref.IsAbs() {
+		if ref.Host == "" {
+			return "", fmt.Errorf("endpoint %q: host is required", endpoint)
+		}
+		return ref.String(), nil
+	}
+	base, err := url.Parse(b.url)
+	if err != nil {
+		return "", fmt.Errorf("endpoint %q: resolving against %s: %w", endpoint, b.url, err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// target возвращает адрес, по которому отправляется элемент
+func (b *Buffer) target(item *queuedItem) string {
+	if item.endpoint != "" {
+		return item.endpoint
+	}
+	return b.url
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix задает префикс переменных окружения с настройками
+const envPrefix = "BUFFER_"
+
+// envAliases задает короткие имена для часто используемых настроек
+var envAliases = map[string]string{
+	"BUFFER_API_URL": "BUFFER_API_SAVE_FACT_URL",
+	"BUFFER_TOKEN":   "BUFFER_AUTH_TOKEN",
+}
+
+// ApplyEnv переопределяет настройки значениями переменных окружения вида KEY=VALUE.
+//
+// Имя переменной строится из пути к настройке в файле: BUFFER_ + ключи разделов и поля
+// в верхнем регистре через "_", например BUFFER_RETRY_MAX_ATTEMPTS или BUFFER_LOGGING_EVENT_LOG_PATH.
+// Поля шаблона задаются как BUFFER_TEMPLATE_<ПОЛЕ>, списки строк - через запятую.
+// Переменные окружения имеют приоритет над файлом настроек, а полное имя - над коротким.
+func (c *Config) ApplyEnv(environ []string) error {
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if ok && strings.HasPrefix(key, envPrefix) {
+			env[key] = value
+		}
+	}
+	for alias, name := range envAliases {
+		if value, ok := env[alias]; ok {
+			if _, set := env[name]; !set {
+				env[name] = value
+			}
+		}
+	}
+	return applyEnvStruct(reflect.ValueOf(c).Elem(), strings.TrimSuffix(envPrefix, "_"), env)
+}
+
+// applyEnvStruct рекурсивно применяет переменные окружения к полям структуры по их yaml-тегам
+func applyEnvStruct(v reflect.Value, prefix string, env map[string]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag)
+		fv := v.Field(i)
+
+		switch {
+		case fv.Kind() == reflect.Struct:
+			if err := applyEnvStruct(fv, name, env); err != nil {
+				return err
+			}
+		case fv.Kind() == reflect.Map && fv.Type().Key().Kind() == reflect.String && fv.Type().Elem().Kind() == reflect.String:
+			for key, value := range env {
+				if sub, ok := strings.CutPrefix(key, name+"_"); ok {
+					if fv.IsNil() {
+						fv.Set(reflect.MakeMap(fv.Type()))
+					}
+					fv.SetMapIndex(reflect.ValueOf(strings.ToLower(sub)), reflect.ValueOf(value))
+				}
+			}
+		default:
+			value, ok := env[name]
+			if !ok {
+				continue
+			}
+			if err := setFromString(fv, value); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// setFromString присваивает полю значение, разобранное из строки
+func setFromString(fv reflect.Value, value string) error {
+	if fv.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("cannot be set from the environment")
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		fv.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("cannot be set from the environment")
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// События доставки в журнале событий
+const (
+	EventSent   = "sent"
+	EventFailed = "failed"
+)
+
+// DeliveryEvent представляет запись журнала событий доставки
+type DeliveryEvent struct {
+	Time          time.Time   `json:"time"`
+	Event         string      `json:"event"`
+	ItemID        uint64      `json:"item_id"`
+	RequestID     string      `json:"request_id"`
+	CorrelationID string      `json:"correlation_id,omitempty"`
+	Provenance    *Provenance `json:"provenance,omitempty"`
+	Indicator     string      `json:"indicator,omitempty"`
+	Status        int         `json:"status,omitempty"`
+	LatencyMs     int64       `json:"latency_ms"`
+	Error         string      `json:"error,omitempty"`
+	Warnings      []string    `json:"warnings,omitempty"`
+}
+
+// EventLog записывает события доставки в файл NDJSON с ротацией
+type EventLog struct {
+	file *RotatingFile
+}
+
+// OpenEventLog открывает журнал событий по пути path с заданными правилами ротации и хранения
+func OpenEventLog(path string, cfg RotateConfig) (*EventLog, error) {
+	file, err := OpenRotatingFile(path, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &EventLog{file: file}, nil
+}
+
+// Write добавляет событие в журнал одной строкой
+func (l *EventLog) Write(event DeliveryEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = l.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close закрывает журнал событий
+func (l *EventLog) Close() error {
+	return l.file.Close()
+}
+
+// WithEventLog включает запись событий доставки в журнал NDJSON. Время событий и ротация
+// журнала отсчитываются по часам буфера
+func WithEventLog(l *EventLog) Option {
+	return func(b *Buffer) {
+		b.events = l
+		l.file.setClock(bufferClock{b})
+	}
+}
+
+// writeEvent записывает событие о завершенной попытке, если журнал событий включен
+func (b *Buffer) writeEvent(item *queuedItem, entry *AuditEntry) {
+	if b.events == nil {
+		return
+	}
+	event := DeliveryEvent{
+		Time:          b.clock.Now(),
+		Event:         EventSent,
+		ItemID:        item.id,
+		RequestID:     entry.RequestID,
+		CorrelationID: entry.CorrelationID,
+		Provenance:    item.provenance,
+		Indicator:     item.fields.Get("indicator_to_mo_id"),
+		Status:        entry.Status,
+		LatencyMs:     entry.LatencyMs,
+		Error:         entry.Error,
+		Warnings:      entry.Warnings,
+	}
+	if entry.Error != "" {
+		event.Event = EventFailed
+	}
+	if err := b.events.Write(event); err != nil {
+		fmt.Println("Error writing event log:", err)
+	}
+}
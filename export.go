@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportFailures выгружает недоставленные элементы с ошибками и историей попыток в формате csv или json
+func (q *DeadLetterQueue) ExportFailures(w io.Writer, format string) error {
+	letters := q.Items()
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if letters == nil {
+			letters = []DeadLetter{}
+		}
+		return enc.Encode(letters)
+	case "csv":
+		return writeFailuresCSV(w, letters)
+	default:
+		return fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+// ExportFailuresFile выгружает недоставленные элементы в файл, определяя формат по расширению
+func (q *DeadLetterQueue) ExportFailuresFile(path string) error {
+	format := "json"
+	if strings.HasSuffix(strings.ToLower(path), ".csv") {
+		format = "csv"
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := q.ExportFailures(f, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeFailuresCSV записывает по строке на элемент: служебные столбцы, затем поля исходных данных
+func writeFailuresCSV(w io.Writer, letters []DeadLetter) error {
+	fieldSet := make(map[string]struct{})
+	for _, letter := range letters {
+		for key := range letter.Item {
+			fieldSet[key] = struct{}{}
+		}
+	}
+	fields := make([]string, 0, len(fieldSet))
+	for key := range fieldSet {
+		fields = append(fields, key)
+	}
+	sort.Strings(fields)
+
+	cw := csv.NewWriter(w)
+	header := append([]string{"failed_at", "error", "request_id", "correlation_id", "provenance", "attempts", "attempt_history"}, fields...)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, letter := range letters {
+		history := make([]string, 0, len(letter.Attempts))
+		for _, a := range letter.Attempts {
+			history = append(history, fmt.Sprintf("%s %s status=%d latency=%dms %s",
+				a.Time.Format(time.RFC3339), a.RequestID, a.Status, a.LatencyMs, a.Error))
+		}
+		record := []string{
+			letter.FailedAt.Format(time.RFC3339),
+			letter.Error,
+			letter.RequestID,
+			letter.CorrelationID,
+			letter.Provenance.String(),
+			strconv.Itoa(len(letter.Attempts)),
+			strings.Join(history, "; "),
+		}
+		for _, key := range fields {
+			record = append(record, letter.Item[key])
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"buffer/kpiclient"
+)
+
+// WithGetFactsMethod задает HTTP-метод запросов get_facts, которые выполняет буфер, например
+// для WithRemoteDedup: GET передает условия строкой запроса. По умолчанию POST
+func WithGetFactsMethod(method string) Option {
+	return func(b *Buffer) {
+		b.factsMethod = method
+	}
+}
+
+// getFacts отправляет запрос на получение данных с сервера методом method и возвращает тело ответа
+func getFacts(client *http.Client, auth Authenticator, method, apiURL, token string, params map[string]string) ([]byte, error) {
+	// Тело возвращается как есть, поэтому ошибка разбора ответа, при которой result задан, не мешает
+	result, err := queryFacts(client, auth, method, apiURL, token, params)
+	if result == nil {
+		return nil, fmt.Errorf("sending request: %w", err)
+	}
+	if result.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API responded with %d %s", result.StatusCode, http.StatusText(result.StatusCode))
+	}
+	return result.Body, nil
+}
+
+// queryFacts отправляет запрос get_facts через клиент kpiclient с авторизацией auth. С методом GET
+// условия передаются строкой запроса, пустой method - метод из описания API; editors дополняют запрос
+func queryFacts(client *http.Client, auth Authenticator, method, apiURL, token string, params map[string]string, editors ...kpiclient.RequestEditorFn) (*kpiclient.GetFactsResult, error) {
+	var body kpiclient.GetFactsRequest
+	for key, value := range params {
+		body.SetField(key, value)
+	}
+	opts := []kpiclient.ClientOption{
+		kpiclient.WithHTTPClient(client),
+		kpiclient.WithEndpointURL(kpiclient.GetFactsPath, apiURL),
+		kpiclient.WithRequestEditorFn(func(ctx context.Context, req *http.Request) error {
+			return auth.Authorize(client, req, token)
+		}),
+	}
+	for _, edit := range editors {
+		opts = append(opts, kpiclient.WithRequestEditorFn(edit))
+	}
+	if method != "" {
+		opts = append(opts, kpiclient.WithEndpointMethod(kpiclient.GetFactsPath, method))
+	}
+	api := kpiclient.NewClient("", opts...)
+	return api.GetFacts(context.Background(), body)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"buffer/kpiclient"
+)
+
+// FactsCacheConfig задает кеширование ответов get_facts по условиям отбора. Ответ с ETag или
+// Last-Modified запрашивается повторно условным запросом и при ответе 304 берется из кеша,
+// ответ без них используется без запроса в течение ttl
+type FactsCacheConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	TTL        time.Duration `yaml:"ttl"`         // срок ответа без ETag и Last-Modified, по умолчанию 1m
+	MaxEntries int           `yaml:"max_entries"` // число хранимых ответов, по умолчанию 1000
+}
+
+// CacheStats описывает работу кеша get_facts
+type CacheStats struct {
+	Entries     int    `json:"entries"`
+	Hits        uint64 `json:"hits"`        // ответы из кеша без запроса
+	Revalidated uint64 `json:"revalidated"` // ответы 304 на условный запрос
+	Misses      uint64 `json:"misses"`      // ответы, загруженные целиком
+}
+
+// cachedFacts хранит ответ get_facts; после сохранения в кеше не меняется
+type cachedFacts struct {
+	indicator    string // indicator_to_mo_id условий отбора
+	body         []byte
+	header       http.Header
+	etag         string
+	lastModified string
+	fetched      time.Time
+}
+
+// FactsCache хранит ответы get_facts, чтобы повторные запросы с теми же условиями не загружали
+// неизменившиеся данные заново
+type FactsCache struct {
+	ttl         time.Duration
+	maxEntries  int
+	mu          sync.Mutex
+	entries     map[string]*cachedFacts
+	hits        atomic.Uint64
+	revalidated atomic.Uint64
+	misses      atomic.Uint64
+}
+
+// NewFactsCache создает кеш ответов get_facts; ttl 0 - 1m, maxEntries 0 - 1000
+func NewFactsCache(ttl time.Duration, maxEntries int) *FactsCache {
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	if maxEntries <= 0 {
+		maxEntries = 1000
+	}
+	return &FactsCache{ttl: ttl, maxEntries: maxEntries, entries: make(map[string]*cachedFacts)}
+}
+
+// WithFactsCache кеширует ответы get_facts, которые запрашивает буфер, например для WithRemoteDedup.
+// После доставки элемента ответы с его показателем удаляются из кеша, чтобы проверка повторов
+// видела только что сохраненные факты
+func WithFactsCache(c *FactsCache) Option {
+	return func(b *Buffer) {
+		b.facts = c
+	}
+}
+
+// factsCacheKey составляет ключ ответа из метода, адреса и условий отбора
+func factsCacheKey(method, apiURL string, params map[string]string) string {
+	values := make(url.Values, len(params))
+	for key, value := range params {
+		values.Set(key, value)
+	}
+	return method + " " + apiURL + "?" + values.Encode()
+}
+
+// query выполняет запрос get_facts как queryFacts, отвечая из кеша, пока ответ не изменился
+func (c *FactsCache) query(client *http.Client, auth Authenticator, method, apiURL, token string, params map[string]string, now time.Time) (*kpiclient.GetFactsResult, error) {
+	key := factsCacheKey(method, apiURL, params)
+	c.mu.Lock()
+	entry := c.entries[key]
+	c.mu.Unlock()
+
+	if entry != nil && entry.etag == "" && entry.lastModified == "" && now.Sub(entry.fetched) < c.ttl {
+		c.hits.Add(1)
+		return entry.result(), nil
+	}
+	var editors []kpiclient.RequestEditorFn
+	if entry != nil {
+		editors = append(editors, func(ctx context.Context, req *http.Request) error {
+			if entry.etag != "" {
+				req.Header.Set("If-None-Match", entry.etag)
+			}
+			if entry.lastModified != "" {
+				req.Header.Set("If-Modified-Since", entry.lastModified)
+			}
+			return nil
+		})
+	}
+	result, err := queryFacts(client, auth, method, apiURL, token, params, editors...)
+	switch {
+	case result == nil:
+		return result, err
+	case result.StatusCode == http.StatusNotModified && entry != nil:
+		c.revalidated.Add(1)
+		fresh := *entry
+		fresh.fetched = now
+		c.store(key, &fresh)
+		return entry.result(), nil
+	case result.StatusCode != http.StatusOK || err != nil:
+		return result, err
+	}
+	c.misses.Add(1)
+	if strings.Contains(result.Header.Get("Cache-Control"), "no-store") {
+		return result, nil
+	}
+	c.store(key, &cachedFacts{
+		indicator:    params["indicator_to_mo_id"],
+		body:         result.Body,
+		header:       result.Header,
+		etag:         result.Header.Get("ETag"),
+		lastModified: result.Header.Get("Last-Modified"),
+		fetched:      now,
+	})
+	return result, nil
+}
+
+// result возвращает сохраненный ответ как ответ 200
+func (e *cachedFacts) result() *kpiclient.GetFactsResult {
+	result := &kpiclient.GetFactsResult{StatusCode: http.StatusOK, Header: e.header, Body: e.body}
+	var decoded kpiclient.GetFactsResponse
+	if err := json.Unmarshal(e.body, &decoded); err == nil {
+		result.JSON = &decoded
+	}
+	return result
+}
+
+// store сохраняет ответ, вытесняя самый давно загруженный, если кеш заполнен
+func (c *FactsCache) store(key string, entry *cachedFacts) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		var oldest string
+		for k, e := range c.entries {
+			if oldest == "" || e.fetched.Before(c.entries[oldest].fetched) {
+				oldest = k
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[key] = entry
+}
+
+// invalidate удаляет ответы, в которые мог попасть сохраненный факт показателя indicator. Ответы
+// с ETag остаются: условный запрос и так вернет их изменения
+func (c *FactsCache) invalidate(indicator string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if entry.etag == "" && (entry.indicator == "" || entry.indicator == indicator) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Stats возвращает статистику кеша
+func (c *FactsCache) Stats() CacheStats {
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+	return CacheStats{Entries: entries, Hits: c.hits.Load(), Revalidated: c.revalidated.Load(), Misses: c.misses.Load()}
+}
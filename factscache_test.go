@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"buffer/kpidrivetest"
+	"buffer/mockkpi"
+)
+
+// factsParams задает условия отбора фактов показателя indicator за май 2024
+func factsParams(indicator string) map[string]string {
+	return map[string]string{"indicator_to_mo_id": indicator, "period_start": "2024-05-01", "period_end": "2024-05-31", "period_key": "month"}
+}
+
+func TestFactsCacheRevalidates(t *testing.T) {
+	srv := kpidrivetest.NewServer(mockkpi.Options{})
+	defer srv.Close()
+	api := srv.Client()
+	if _, err := api.SaveFact(context.Background(), kpidrivetest.Fact("227373")); err != nil {
+		t.Fatal(err)
+	}
+	c := NewFactsCache(time.Minute, 0)
+	now := time.Unix(0, 0)
+	query := func() int {
+		t.Helper()
+		result, err := c.query(&http.Client{}, bearerAuth{}, "", srv.GetFactsURL(), "token", factsParams("227373"), now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.StatusCode != http.StatusOK || result.JSON == nil {
+			t.Fatalf("cached get_facts returned %d %s", result.StatusCode, result.Body)
+		}
+		return result.JSON.Data.RowsCount
+	}
+
+	// Ответ с ETag запрашивается повторно условным запросом, а после сохранения загружается заново
+	if rows := query(); rows != 1 {
+		t.Fatalf("first query returned %d rows", rows)
+	}
+	if rows := query(); rows != 1 {
+		t.Fatalf("revalidated query returned %d rows", rows)
+	}
+	requests := srv.Requests()
+	if etag := requests[len(requests)-1].Header.Get("If-None-Match"); etag == "" {
+		t.Error("second query was not conditional")
+	}
+	next := kpidrivetest.Fact("227373")
+	next.FactTime, next.PeriodStart = "2024-05-30", "2024-05-02"
+	if _, err := api.SaveFact(context.Background(), next); err != nil {
+		t.Fatal(err)
+	}
+	if rows := query(); rows != 2 {
+		t.Errorf("query after a save returned %d rows, want 2", rows)
+	}
+	if stats := c.Stats(); stats != (CacheStats{Entries: 1, Revalidated: 1, Misses: 2}) {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestFactsCacheTTL(t *testing.T) {
+	var requests atomic.Int32
+	noStore := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if noStore {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		w.Write([]byte(`{"MESSAGES":{"error":null,"warning":null,"info":[]},"DATA":{"rows":[],"rows_count":0},"STATUS":"OK"}`))
+	}))
+	defer srv.Close()
+	c := NewFactsCache(time.Minute, 1)
+	start := time.Unix(0, 0)
+	query := func(indicator string, now time.Time) {
+		t.Helper()
+		if _, err := c.query(&http.Client{}, bearerAuth{}, "", srv.URL, "token", factsParams(indicator), now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	steps := []struct {
+		name     string
+		run      func()
+		requests int32
+	}{
+		{"first query", func() { query("1", start) }, 1},
+		{"within ttl", func() { query("1", start.Add(59*time.Second)) }, 1},
+		{"ttl elapsed", func() { query("1", start.Add(time.Minute)) }, 2},
+		{"after delivery", func() { c.invalidate("1"); query("1", start.Add(time.Minute)) }, 3},
+		{"other indicator evicts", func() { query("2", start.Add(time.Minute)) }, 4},
+		{"evicted entry", func() { query("1", start.Add(time.Minute)) }, 5},
+		{"no-store", func() { noStore = true; query("3", start.Add(time.Minute)); query("3", start.Add(time.Minute)) }, 7},
+	}
+	for _, step := range steps {
+		step.run()
+		if n := requests.Load(); n != step.requests {
+			t.Fatalf("%s: %d requests, want %d", step.name, n, step.requests)
+		}
+	}
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 7 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
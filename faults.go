@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultFaultSlowDelay задает задержку медленных ответов и тайм-аутов, если она не задана
+const defaultFaultSlowDelay = 5 * time.Second
+
+// FaultConfig задает внесение сбоев в отправку, чтобы проверить повторы, выключатель и очередь
+// недоставленных до работы с настоящими данными. Для каждого запроса выбирается не больше одного
+// сбоя, поэтому сумма долей не должна превышать 1
+type FaultConfig struct {
+	ErrorRate     float64       `yaml:"error_rate"`     // доля запросов, получающих ответ error_status без отправки в API
+	ErrorStatus   int           `yaml:"error_status"`   // код ответа, по умолчанию 503
+	TimeoutRate   float64       `yaml:"timeout_rate"`   // доля запросов, завершающихся тайм-аутом через slow_delay без отправки
+	SlowRate      float64       `yaml:"slow_rate"`      // доля запросов, отправляемых с задержкой slow_delay
+	SlowDelay     time.Duration `yaml:"slow_delay"`     // по умолчанию 5s
+	MalformedRate float64       `yaml:"malformed_rate"` // доля запросов, получающих ответ 200 с испорченным телом без отправки
+	Seed          int64         `yaml:"seed"`           // начальное значение генератора для повторяемых сбоев, 0 - случайное
+}
+
+// enabled сообщает, задан ли хотя бы один вид сбоев
+func (c FaultConfig) enabled() bool {
+	return c.ErrorRate > 0 || c.TimeoutRate > 0 || c.SlowRate > 0 || c.MalformedRate > 0
+}
+
+// validate проверяет доли и код ответа
+func (c FaultConfig) validate() error {
+	for name, rate := range map[string]float64{
+		"error_rate":     c.ErrorRate,
+		"timeout_rate":   c.TimeoutRate,
+		"slow_rate":      c.SlowRate,
+		"malformed_rate": c.MalformedRate,
+	} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("http.faults.%s must be between 0 and 1", name)
+		}
+	}
+	if c.ErrorRate+c.TimeoutRate+c.SlowRate+c.MalformedRate > 1 {
+		return fmt.Errorf("http.faults: sum of rates must not exceed 1")
+	}
+	if c.ErrorStatus != 0 && (c.ErrorStatus < 400 || c.ErrorStatus > 599) {
+		return fmt.Errorf("http.faults.error_status must be between 400 and 599")
+	}
+	if c.SlowDelay < 0 {
+		return fmt.Errorf("http.faults.slow_delay must not be negative")
+	}
+	return nil
+}
+
+// faultTimeoutError представляет внесенный тайм-аут; для повторов это сетевая ошибка
+type faultTimeoutError struct{}
+
+func (faultTimeoutError) Error() string   { return "injected fault: timeout awaiting response" }
+func (faultTimeoutError) Timeout() bool   { return true }
+func (faultTimeoutError) Temporary() bool { return true }
+
+// faultTransport вносит сбои в запросы перед передачей их next
+type faultTransport struct {
+	b    *Buffer // задержки отсчитываются по часам буфера
+	cfg  FaultConfig
+	next http.RoundTripper
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// WithFaultInjection вносит сбои в запросы к API с долями из c. Применяется после WithTransport
+// и WithHTTPClient, так как оборачивает уже заданный транспорт
+func WithFaultInjection(c FaultConfig) Option {
+	return func(b *Buffer) {
+		client := *b.client
+		client.Transport = newFaultTransport(b, c, client.Transport)
+		b.client = &client
+	}
+}
+
+// newFaultTransport создает транспорт со сбоями; next nil означает http.DefaultTransport
+func newFaultTransport(b *Buffer, c FaultConfig, next http.RoundTripper) *faultTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if c.ErrorStatus == 0 {
+		c.ErrorStatus = http.StatusServiceUnavailable
+	}
+	if c.SlowDelay == 0 {
+		c.SlowDelay = defaultFaultSlowDelay
+	}
+	seed := c.Seed
+	if seed == 0 {
+		seed = b.clock.Now().UnixNano()
+	}
+	return &faultTransport{b: b, cfg: c, next: next, rnd: rand.New(rand.NewSource(seed))}
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	roll := t.rnd.Float64()
+	t.mu.Unlock()
+
+	c := t.cfg
+	switch {
+	case roll < c.ErrorRate:
+		logDebugf("Injected fault: %d response for %s", c.ErrorStatus, req.URL.Path)
+		closeRequestBody(req)
+		return faultResponse(req, c.ErrorStatus, `{"MESSAGES":{"error":["injected fault"]},"DATA":{},"STATUS":"ERROR"}`), nil
+	case roll < c.ErrorRate+c.TimeoutRate:
+		logDebugf("Injected fault: timeout for %s", req.URL.Path)
+		closeRequestBody(req)
+		if err := t.sleep(req, c.SlowDelay); err != nil {
+			return nil, err
+		}
+		return nil, faultTimeoutError{}
+	case roll < c.ErrorRate+c.TimeoutRate+c.SlowRate:
+		logDebugf("Injected fault: delaying %s by %s", req.URL.Path, c.SlowDelay)
+		if err := t.sleep(req, c.SlowDelay); err != nil {
+			closeRequestBody(req)
+			return nil, err
+		}
+	case roll < c.ErrorRate+c.TimeoutRate+c.SlowRate+c.MalformedRate:
+		logDebugf("Injected fault: malformed response for %s", req.URL.Path)
+		closeRequestBody(req)
+		return faultResponse(req, http.StatusOK, `{"MESSAGES":{"error":[],"info":["ok"]},"DATA":{"indicator_to_mo_fa`), nil
+	}
+	return t.next.RoundTrip(req)
+}
+
+// closeRequestBody закрывает тело запроса, который не передается дальше, как того требует RoundTripper
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// sleep ждет d по часам буфера или отмены запроса
+func (t *faultTransport) sleep(req *http.Request, d time.Duration) error {
+	timer := t.b.clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+// faultResponse создает ответ с кодом status и телом body, не обращаясь к API
+func faultResponse(req *http.Request, status int, body string) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// layoutCacheLimit ограничивает число запоминаемых наборов полей; элементы с редкими наборами
+// получают собственное описание, чтобы произвольные входные данные не раздували кеш
+const layoutCacheLimit = 1024
+
+// fieldLayout описывает набор имен полей элемента в порядке сортировки. Элементы с одинаковым
+// набором полей, например строки одного импорта, используют одно описание
+type fieldLayout struct {
+	names   []string
+	escaped []string // имена, закодированные для тела запроса
+}
+
+// layouts хранит описания наборов полей по ключу из имен, разделенных нулевым байтом
+var layouts = struct {
+	sync.RWMutex
+	m map[string]*fieldLayout
+}{m: make(map[string]*fieldLayout)}
+
+// itemFields хранит данные элемента очереди: общее описание полей и значения в том же порядке.
+// В отличие от map[string]string, элемент занимает одно выделение памяти под значения
+type itemFields struct {
+	layout *fieldLayout
+	values []string
+}
+
+// newItemFields переводит данные элемента в компактное представление
+func newItemFields(data map[string]string) itemFields {
+	var scratch [32]string
+	names := scratch[:0]
+	for name := range data {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	layout := internLayout(names)
+	values := make([]string, len(layout.names))
+	for i, name := range layout.names {
+		values[i] = data[name]
+	}
+	return itemFields{layout: layout, values: values}
+}
+
+// internLayout возвращает общее описание для отсортированного набора имен
+func internLayout(names []string) *fieldLayout {
+	var scratch [512]byte
+	key := scratch[:0]
+	for _, name := range names {
+		key = append(key, name...)
+		key = append(key, 0)
+	}
+	layouts.RLock()
+	layout := layouts.m[string(key)]
+	layouts.RUnlock()
+	if layout != nil {
+		return layout
+	}
+
+	layout = &fieldLayout{names: slices.Clone(names), escaped: make([]string, len(names))}
+	for i, name := range names {
+		layout.escaped[i] = url.QueryEscape(name)
+	}
+	layouts.Lock()
+	defer layouts.Unlock()
+	if cached := layouts.m[string(key)]; cached != nil {
+		return cached
+	}
+	if len(layouts.m) < layoutCacheLimit {
+		layouts.m[string(key)] = layout
+	}
+	return layout
+}
+
+// Get возвращает значение поля или пустую строку, если поля нет
+func (f itemFields) Get(name string) string {
+	if i := f.index(name); i >= 0 {
+		return f.values[i]
+	}
+	return ""
+}
+
+// index возвращает номер поля в описании или -1, если поля нет
+func (f itemFields) index(name string) int {
+	if f.layout == nil {
+		return -1
+	}
+	i := sort.SearchStrings(f.layout.names, name)
+	if i < len(f.layout.names) && f.layout.names[i] == name {
+		return i
+	}
+	return -1
+}
+
+// Map возвращает копию данных в виде map для журналов, очереди недоставленных и просмотра
+func (f itemFields) Map() map[string]string {
+	data := make(map[string]string, len(f.values))
+	for i, value := range f.values {
+		data[f.layout.names[i]] = value
+	}
+	return data
+}
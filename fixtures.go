@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Режимы работы с фикстурами HTTP
+const (
+	FixtureRecord = "record" // отправлять запросы в API и записывать обмен в файл
+	FixtureReplay = "replay" // отвечать на запросы записанными ответами без обращения к сети
+)
+
+// FixtureConfig задает запись обмена с API в файл фикстур и воспроизведение из него
+type FixtureConfig struct {
+	Mode         string   `yaml:"mode"`          // record или replay; пусто - фикстуры не используются
+	Path         string   `yaml:"path"`          // файл фикстур JSON
+	SecretFields []string `yaml:"secret_fields"` // поля формы, JSON-ключи и заголовки, скрываемые при записи
+}
+
+// validate проверяет режим и путь к файлу фикстур
+func (c FixtureConfig) validate() error {
+	switch c.Mode {
+	case "":
+		return nil
+	case FixtureRecord, FixtureReplay:
+		if c.Path == "" {
+			return fmt.Errorf("http.fixtures.path is required")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown http.fixtures.mode %q, expected record or replay", c.Mode)
+	}
+}
+
+// FixtureRequest представляет записанный запрос. Тело хранится распакованным
+type FixtureRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// FixtureResponse представляет записанный ответ
+type FixtureResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// Interaction представляет пару запрос/ответ в файле фикстур
+type Interaction struct {
+	Request  FixtureRequest  `json:"request"`
+	Response FixtureResponse `json:"response"`
+}
+
+// Fixtures записывает пары запрос/ответ со скрытыми секретами в файл и воспроизводит их.
+// При воспроизведении запрос получает первый еще не использованный ответ на запрос с тем же
+// методом, путем и телом, поэтому записанные повторы после ошибок воспроизводятся по порядку
+type Fixtures struct {
+	redactor
+	mode string
+	path string
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// OpenFixtures открывает файл фикстур path в режиме mode. При записи файл создается заново,
+// при воспроизведении - читается; secretFields перечисляет поля формы, JSON-ключи и заголовки,
+// значения которых скрываются в дополнение к Authorization и cookie
+func OpenFixtures(path, mode string, secretFields ...string) (*Fixtures, error) {
+	if mode != FixtureRecord && mode != FixtureReplay {
+		return nil, fmt.Errorf("unknown fixtures mode %q, expected record or replay", mode)
+	}
+	f := &Fixtures{redactor: newRedactor(secretFields), mode: mode, path: path}
+	if mode == FixtureRecord {
+		if err := f.saveLocked(); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading fixtures: %w", err)
+	}
+	if err := json.Unmarshal(data, &f.interactions); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	f.used = make([]bool, len(f.interactions))
+	return f, nil
+}
+
+// Interactions возвращает копию записанных или загруженных пар запрос/ответ
+func (f *Fixtures) Interactions() []Interaction {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Interaction(nil), f.interactions...)
+}
+
+// Unused возвращает загруженные пары, ответы которых еще не воспроизведены
+func (f *Fixtures) Unused() []Interaction {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var unused []Interaction
+	for i, in := range f.interactions {
+		if !f.used[i] {
+			unused = append(unused, in)
+		}
+	}
+	return unused
+}
+
+// Transport возвращает транспорт, записывающий обмен через next или воспроизводящий его;
+// next nil означает http.DefaultTransport
+func (f *Fixtures) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &fixtureTransport{fixtures: f, next: next}
+}
+
+// WithFixtures записывает запросы к API в фикстуры или отвечает из них. Применяется после
+// WithTransport и WithHTTPClient, так как оборачивает уже заданный транспорт
+func WithFixtures(f *Fixtures) Option {
+	return func(b *Buffer) {
+		client := *b.client
+		client.Transport = f.Transport(client.Transport)
+		b.client = &client
+	}
+}
+
+// fixtureTransport передает запросы в фикстуры
+type fixtureTransport struct {
+	fixtures *Fixtures
+	next     http.RoundTripper
+}
+
+func (t *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		// Тело прочитано, поэтому дальше передается копия запроса с новым телом
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	recorded := t.fixtures.redactRequest(req, body)
+	if t.fixtures.mode == FixtureReplay {
+		return t.fixtures.replay(req, recorded)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	t.fixtures.record(Interaction{Request: recorded, Response: t.fixtures.redactResponse(resp, data)})
+	return resp, nil
+}
+
+// readRequestBody читает тело запроса, не расходуя его, если запрос умеет создавать копию тела
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body := req.Body
+	if req.GetBody != nil {
+		var err error
+		if body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// redactRequest возвращает запрос для записи: с распакованным телом и скрытыми секретами
+func (f *Fixtures) redactRequest(req *http.Request, body []byte) FixtureRequest {
+	if req.Header.Get("Content-Encoding") == "gzip" && len(body) > 0 {
+		if zr, err := gzip.NewReader(bytes.NewReader(body)); err == nil {
+			if plain, err := io.ReadAll(zr); err == nil {
+				body = plain
+			}
+		}
+	}
+	u := *req.URL
+	u.User = nil
+	if u.RawQuery != "" {
+		u.RawQuery = f.redactForm(u.RawQuery)
+	}
+	recorded := FixtureRequest{Method: req.Method, URL: u.String(), Header: f.redactHeader(req.Header)}
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		recorded.Body = f.redactJSON(body)
+	} else {
+		recorded.Body = f.redactForm(string(body))
+	}
+	return recorded
+}
+
+// redactResponse возвращает ответ для записи со скрытыми секретами
+func (f *Fixtures) redactResponse(resp *http.Response, body []byte) FixtureResponse {
+	return FixtureResponse{Status: resp.StatusCode, Header: f.redactHeader(resp.Header), Body: f.redactJSON(body)}
+}
+
+// redactHeader копирует заголовки, скрывая чувствительные значения. У cookie сохраняются имена
+// и атрибуты, чтобы при воспроизведении вход по сессии получал cookie
+func (f *Fixtures) redactHeader(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for key, values := range h {
+		if !f.secretHeader(key) {
+			out[key] = append([]string(nil), values...)
+			continue
+		}
+		for _, value := range values {
+			if http.CanonicalHeaderKey(key) == "Set-Cookie" {
+				cookies := (&http.Response{Header: http.Header{"Set-Cookie": {value}}}).Cookies()
+				if len(cookies) == 1 {
+					cookies[0].Value = redacted
+					out.Add(key, cookies[0].String())
+					continue
+				}
+			}
+			out.Add(key, redacted)
+		}
+	}
+	return out
+}
+
+// record добавляет пару запрос/ответ и сохраняет файл фикстур
+func (f *Fixtures) record(in Interaction) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.interactions = append(f.interactions, in)
+	f.used = append(f.used, true)
+	if err := f.saveLocked(); err != nil {
+		fmt.Println("Error saving fixtures:", err)
+	}
+}
+
+// saveLocked записывает фикстуры во временный файл и заменяет им прежний. Вызывается с захваченным f.mu
+func (f *Fixtures) saveLocked() error {
+	interactions := f.interactions
+	if interactions == nil {
+		interactions = []Interaction{}
+	}
+	// Без экранирования HTML тела форм в файле остаются читаемыми
+	var data bytes.Buffer
+	enc := json.NewEncoder(&data)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(interactions); err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// replay возвращает записанный ответ на запрос
+func (f *Fixtures) replay(req *http.Request, recorded FixtureRequest) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, in := range f.interactions {
+		if f.used[i] || !in.Request.matches(recorded) {
+			continue
+		}
+		f.used[i] = true
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.Status, http.StatusText(in.Response.Status)),
+			StatusCode:    in.Response.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        in.Response.Header.Clone(),
+			Body:          io.NopCloser(strings.NewReader(in.Response.Body)),
+			ContentLength: int64(len(in.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("no recorded response for %s %s in fixtures %s", req.Method, req.URL.Path, f.path)
+}
+
+// matches сообщает, совпадают ли у запросов метод, путь со строкой запроса и тело. Сервер
+// не сравнивается, чтобы фикстуры, записанные на рабочем API, воспроизводились при любом адресе
+func (r FixtureRequest) matches(other FixtureRequest) bool {
+	return r.Method == other.Method && fixturePath(r.URL) == fixturePath(other.URL) && r.Body == other.Body
+}
+
+// fixturePath возвращает путь адреса запроса вместе со строкой запроса, в которой запросы GET
+// передают поля
+func fixturePath(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if u.RawQuery != "" {
+		return u.Path + "?" + u.RawQuery
+	}
+	return u.Path
+}
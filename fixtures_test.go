@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"buffer/kpiclient"
+	"buffer/kpidrivetest"
+	"buffer/mockkpi"
+)
+
+// fixtureFact возвращает общий тестовый факт с комментарием, который скрывается при записи
+func fixtureFact() kpiclient.SaveFactRequest {
+	fact := kpidrivetest.Fact("227373")
+	fact.Comment = "private note"
+	return fact
+}
+
+// fixtureClient создает клиента API, запросы которого проходят через фикстуры f
+func fixtureClient(baseURL string, f *Fixtures) *kpiclient.Client {
+	return kpiclient.NewClient(baseURL,
+		kpiclient.WithHTTPClient(&http.Client{Transport: f.Transport(nil)}),
+		kpidrivetest.WithHeader("Authorization", "Bearer secret-token"),
+	)
+}
+
+func TestFixturesRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixtures.json")
+	srv := kpidrivetest.NewServer(mockkpi.Options{Token: "secret-token"})
+	srv.FailNext(1, http.StatusServiceUnavailable)
+
+	rec, err := OpenFixtures(path, FixtureRecord, "comment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := fixtureClient(srv.URL, rec)
+	for _, want := range []int{http.StatusServiceUnavailable, http.StatusOK} {
+		result, err := client.SaveFact(context.Background(), fixtureFact())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.StatusCode != want {
+			t.Fatalf("recording got %d, want %d", result.StatusCode, want)
+		}
+	}
+	srv.Close()
+
+	// Ответы записаны как есть, а токен и скрываемые поля - нет
+	if n := len(rec.Interactions()); n != 2 {
+		t.Fatalf("%d interactions recorded, want 2", n)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"secret-token", "private"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("fixtures contain %q:\n%s", secret, data)
+		}
+	}
+	in := rec.Interactions()[1]
+	if in.Request.Header.Get("Authorization") != redacted || !strings.Contains(in.Request.Body, "comment=%2A%2A%2A&") {
+		t.Errorf("recorded request = %+v", in.Request)
+	}
+	if !strings.Contains(in.Response.Body, `"indicator_to_mo_fact_id":1`) {
+		t.Errorf("recorded response = %+v", in.Response)
+	}
+
+	// Воспроизведение не обращается к сети и отдает записанные ответы по порядку
+	replay, err := OpenFixtures(path, FixtureReplay, "comment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = fixtureClient("http://kpi.invalid", replay)
+	first, err := client.SaveFact(context.Background(), fixtureFact())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := client.SaveFact(context.Background(), fixtureFact())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.StatusCode != http.StatusServiceUnavailable || second.StatusCode != http.StatusOK || second.JSON.Data.IndicatorToMoFactID != 1 {
+		t.Fatalf("replayed %d %s, then %d %s", first.StatusCode, first.Body, second.StatusCode, second.Body)
+	}
+	if unused := replay.Unused(); len(unused) != 0 {
+		t.Errorf("%d interactions left unused", len(unused))
+	}
+	if _, err := client.SaveFact(context.Background(), fixtureFact()); err == nil || !strings.Contains(err.Error(), "no recorded response") {
+		t.Errorf("request beyond the recording: %v", err)
+	}
+	other := fixtureFact()
+	other.Value = "2"
+	if _, err := client.SaveFact(context.Background(), other); err == nil {
+		t.Error("request with another body matched the recording")
+	}
+}
+
+func TestOpenFixturesErrors(t *testing.T) {
+	dir := t.TempDir()
+	malformed := filepath.Join(dir, "malformed.json")
+	if err := os.WriteFile(malformed, []byte(`[{"request":`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, path, mode, want string
+	}{
+		{"unknown mode", filepath.Join(dir, "fixtures.json"), "playback", "unknown fixtures mode"},
+		{"missing file", filepath.Join(dir, "missing.json"), FixtureReplay, "reading fixtures"},
+		{"malformed file", malformed, FixtureReplay, "parsing " + malformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := OpenFixtures(tt.path, tt.mode); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("OpenFixtures(%s, %s) = %v, want %q", tt.path, tt.mode, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"sync"
+)
+
+// formBufferPool хранит буферы для кодирования тел запросов, чтобы не выделять их для каждого элемента
+var formBufferPool = sync.Pool{
+	New: func() interface{} { return new(formBuffer) },
+}
+
+// formBuffer содержит буфер тела запроса
+type formBuffer struct {
+	bytes.Buffer
+}
+
+// encodeForm кодирует данные элемента как application/x-www-form-urlencoded в буфер из пула,
+// с ключами в порядке сортировки, как url.Values.Encode. Имена уже отсортированы и закодированы
+// в описании полей, поэтому кодируются только значения
+func encodeForm(fields itemFields) *formBuffer {
+	buf := formBufferPool.Get().(*formBuffer)
+	buf.Reset()
+	for i, value := range fields.values {
+		if i > 0 {
+			buf.WriteByte('&')
+		}
+		buf.WriteString(fields.layout.escaped[i])
+		buf.WriteByte('=')
+		buf.WriteString(url.QueryEscape(value))
+	}
+	return buf
+}
+
+// release возвращает буфер в пул; после release буфер не используется
+func (buf *formBuffer) release() {
+	// Слишком большие буферы не сохраняются, чтобы редкий огромный элемент не удерживал память
+	if buf.Cap() > 64*1024 {
+		return
+	}
+	formBufferPool.Put(buf)
+}
+
+// formBody передает буфер транспорту как тело запроса и возвращает его в пул,
+// когда транспорт закроет тело
+type formBody struct {
+	*bytes.Reader
+	buf  *formBuffer
+	once sync.Once
+}
+
+func newFormBody(buf *formBuffer) *formBody {
+	return &formBody{Reader: bytes.NewReader(buf.Bytes()), buf: buf}
+}
+
+func (f *formBody) Close() error {
+	f.once.Do(f.buf.release)
+	return nil
+}
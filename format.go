@@ -0,0 +1,61 @@
+package main
+
+import "fmt"
+
+// Форматы файлов, которые буфер сохраняет на диске, и их текущие версии.
+//
+// Правила совместимости:
+//   - каждый файл начинается заголовком с видом format и версией version; файлы, записанные
+//     до введения версий, считаются версией 0 и читаются как прежде;
+//   - новые необязательные поля добавляются без смены версии: прежние версии буфера их
+//     пропускают, а новые читают старые файлы без них;
+//   - версия повышается, только если поле удаляется, переименовывается или меняет смысл.
+//     Буфер читает все прежние версии своего формата и при следующей записи сохраняет
+//     файл в текущей, поэтому обновление не теряет сохраненные элементы;
+//   - файл более новой версии, чем поддерживает буфер, не читается и не перезаписывается:
+//     откат на старую версию останавливается с ошибкой, а не стирает данные;
+//   - имя поля не используется повторно с другим смыслом.
+//
+// Образцы каждой версии лежат в testdata/formats, и format_test.go проверяет, что они
+// читаются и что текущая версия записывается байт в байт как образец
+const (
+	spoolFormat      = "buffer-spool"
+	dlqFormat        = "buffer-dlq"
+	dlqJournalFormat = "buffer-dlq-journal" // элементы, добавленные после сохранения очереди целиком
+	checkpointFormat = "buffer-checkpoint"
+	syncFormat       = "buffer-sync"
+	redisFormat      = "buffer-redis-item" // элемент общей очереди в Redis, а не файл
+
+	spoolVersion      = 1
+	dlqVersion        = 2 // версия 2 дополняется журналом, который версия 1 не читает
+	dlqJournalVersion = 1
+	checkpointVersion = 1
+	syncVersion       = 1
+	redisVersion      = 1
+)
+
+// formatHeader представляет заголовок сохраняемого файла
+type formatHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+}
+
+// newFormatHeader возвращает заголовок текущей версии формата
+func newFormatHeader(format string, version int) formatHeader {
+	return formatHeader{Format: format, Version: version}
+}
+
+// check проверяет, что файл имеет вид format и версию не новее supported.
+// Файл без заголовка записан до введения версий и считается версией 0
+func (h formatHeader) check(format string, supported int) error {
+	if h.Format != "" && h.Format != format {
+		return fmt.Errorf("file format is %q, expected %q", h.Format, format)
+	}
+	if h.Version > supported {
+		return fmt.Errorf("%s version %d was written by a newer buffer, this one supports up to %d", format, h.Version, supported)
+	}
+	if h.Version < 0 {
+		return fmt.Errorf("%s has invalid version %d", format, h.Version)
+	}
+	return nil
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// updateGolden перезаписывает образцы текущих версий форматов: go test -run Format -update.
+// Образцы прежних версий не перезаписываются, иначе тест перестанет проверять чтение старых файлов
+var updateGolden = flag.Bool("update", false, "rewrite golden files of the current format versions")
+
+// goldenDeadLetters соответствует образцам testdata/formats/dlq-v*.json
+var goldenDeadLetters = []DeadLetter{
+	{
+		ID:            7,
+		Item:          map[string]string{"indicator_to_mo_id": "227373", "period_start": "2024-05-01", "value": "1"},
+		Error:         "request 1f2e: API responded with 503 Service Unavailable",
+		RequestID:     "1f2e",
+		CorrelationID: "batch-42",
+		Provenance:    &Provenance{Source: "import", File: "facts.csv", Row: 12, User: "ivan", Host: "srv1"},
+		Attempts: []Attempt{
+			{Time: time.Date(2024, 5, 31, 10, 0, 0, 0, time.UTC), RequestID: "1f2d", Status: 503, LatencyMs: 120, Error: "API responded with 503 Service Unavailable"},
+			{Time: time.Date(2024, 5, 31, 10, 0, 1, 0, time.UTC), RequestID: "1f2e", Status: 503, LatencyMs: 95, Error: "API responded with 503 Service Unavailable"},
+		},
+		FailedAt: time.Date(2024, 5, 31, 10, 0, 1, 0, time.UTC),
+	},
+	{
+		ID:       8,
+		Item:     map[string]string{"indicator_to_mo_id": "315", "value": "0"},
+		Error:    "request 20aa: sending request: timeout",
+		Attempts: []Attempt{{Time: time.Date(2024, 5, 31, 11, 0, 0, 0, time.UTC), RequestID: "20aa", Error: "sending request: timeout"}},
+		FailedAt: time.Date(2024, 5, 31, 11, 0, 0, 0, time.UTC),
+	},
+}
+
+// goldenCheckpoint соответствует образцам testdata/formats/checkpoint-v*.json
+var goldenCheckpoint = importCheckpoint{
+	File:      "facts.csv",
+	Row:       120,
+	Offset:    5230,
+	SHA256:    "5f0c3a0e6d1b8f7c2e9a4d3b1c0f8e7d6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d",
+	UpdatedAt: time.Date(2024, 5, 31, 12, 30, 0, 0, time.UTC),
+}
+
+// goldenSyncState соответствует образцам testdata/formats/sync-v*.json
+var goldenSyncState = syncState{
+	File:     "facts.csv",
+	Field:    "updated_at",
+	Cursor:   "2024-05-31T12:00:00Z",
+	Rows:     42,
+	SyncedAt: time.Date(2024, 5, 31, 12, 30, 0, 0, time.UTC),
+}
+
+// goldenRedisItem соответствует образцам testdata/formats/redis-item-v*.json
+var goldenRedisItem = redisItem{
+	Data:          goldenDeadLetters[0].Item,
+	CorrelationID: "batch-42",
+	Provenance:    &Provenance{Source: "import", File: "facts.csv", Row: 12, User: "ivan", Host: "srv1"},
+	Endpoint:      "https://other.kpi-drive.ru/_api/facts/save_plan",
+}
+
+// goldenPath возвращает путь образца формата
+func goldenPath(name string) string {
+	return filepath.Join("testdata", "formats", name)
+}
+
+// checkGolden сравнивает записанный файл текущей версии с образцом или перезаписывает образец с -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := goldenPath(name)
+	if *updateGolden {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s changed, bump the format version and keep the old golden file if the change is incompatible:\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestDeadLetterFormat(t *testing.T) {
+	data, err := encodeDeadLetters(goldenDeadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "dlq-v2.json", data)
+
+	for _, name := range []string{"dlq-v0.json", "dlq-v1.json", "dlq-v2.json"} {
+		t.Run(name, func(t *testing.T) {
+			q, err := OpenDeadLetterQueue(goldenPath(name), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := q.Items(); !reflect.DeepEqual(got, goldenDeadLetters) {
+				t.Errorf("got %+v, want %+v", got, goldenDeadLetters)
+			}
+			if got := q.maxID(); got != 8 {
+				t.Errorf("maxID = %d, want 8", got)
+			}
+		})
+	}
+
+	// Прежняя версия сохраняется в текущей при первом изменении
+	path := filepath.Join(t.TempDir(), "dlq.json")
+	old, err := os.ReadFile(goldenPath("dlq-v0.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, old, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	q, err := OpenDeadLetterQueue(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Add(DeadLetter{ID: 9, Item: map[string]string{"value": "2"}, Error: "rejected", FailedAt: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)})
+	q.Take(9)
+	upgraded, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(upgraded, data) {
+		t.Errorf("upgraded file differs from the current version:\n%s", upgraded)
+	}
+}
+
+func TestDeadLetterJournalFormat(t *testing.T) {
+	// Первое добавление сохраняет новую очередь целиком, следующие дописываются в журнал
+	path := filepath.Join(t.TempDir(), "dlq.json")
+	q, err := OpenDeadLetterQueue(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Add(goldenDeadLetters[0])
+	q.Add(goldenDeadLetters[1])
+	data, err := os.ReadFile(path + ".journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "dlq-journal-v1.jsonl", data)
+
+	// Недописанная при сбое строка пропускается, а элемент, сохраненный и в очереди, не повторяется
+	if err := os.WriteFile(path+".journal", append(data, `{"id":9,"item":`...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := encodeDeadLetters(goldenDeadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, snapshot, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if q, err = OpenDeadLetterQueue(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := q.Items(); !reflect.DeepEqual(got, goldenDeadLetters) {
+		t.Errorf("got %+v, want %+v", got, goldenDeadLetters)
+	}
+	// Журнал с недописанной строкой не дописывается: очередь сохраняется целиком, а журнал удаляется
+	q.Add(DeadLetter{ID: 9, Item: map[string]string{"value": "2"}, FailedAt: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)})
+	if _, err := os.Stat(path + ".journal"); !os.IsNotExist(err) {
+		t.Errorf("journal with a torn line was kept: %v", err)
+	}
+	if q, err = OpenDeadLetterQueue(path, nil); err != nil || q.Len() != 3 {
+		t.Fatalf("reopened queue has %d items, want 3: %v", q.Len(), err)
+	}
+}
+
+func TestCheckpointFormat(t *testing.T) {
+	data, err := encodeCheckpoint(goldenCheckpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "checkpoint-v1.json", data)
+
+	for _, name := range []string{"checkpoint-v0.json", "checkpoint-v1.json"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(goldenPath(name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := decodeCheckpoint(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got.formatHeader = formatHeader{}
+			if !reflect.DeepEqual(got, goldenCheckpoint) {
+				t.Errorf("got %+v, want %+v", got, goldenCheckpoint)
+			}
+		})
+	}
+}
+
+func TestSyncStateFormat(t *testing.T) {
+	data, err := encodeSyncState(goldenSyncState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "sync-v1.json", data)
+
+	data, err = os.ReadFile(goldenPath("sync-v1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeSyncState(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.formatHeader = formatHeader{}
+	if !reflect.DeepEqual(got, goldenSyncState) {
+		t.Errorf("got %+v, want %+v", got, goldenSyncState)
+	}
+}
+
+func TestRedisItemFormat(t *testing.T) {
+	data, err := encodeRedisItem(goldenRedisItem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "redis-item-v1.json", data)
+
+	data, err = os.ReadFile(goldenPath("redis-item-v1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeRedisItem(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.formatHeader = formatHeader{}
+	if !reflect.DeepEqual(got, goldenRedisItem) {
+		t.Errorf("got %+v, want %+v", got, goldenRedisItem)
+	}
+}
+
+func TestSpoolFormat(t *testing.T) {
+	testSpoolFormat(t, spoolJSON, "spool-v1.jsonl")
+}
+
+func TestSpoolMsgpackFormat(t *testing.T) {
+	testSpoolFormat(t, spoolMsgpack, "spool-v1.msgpack")
+}
+
+// TestSpoolFormatOnRestart проверяет, что файлы spool каждой версии и кодировки, оставшиеся
+// от прошлого запуска, загружаются при открытии spool
+func TestSpoolFormatOnRestart(t *testing.T) {
+	SetLogLevel(LevelError)
+	for _, golden := range []string{"spool-v1.jsonl", "spool-v1.msgpack"} {
+		t.Run(golden, func(t *testing.T) {
+			data, err := os.ReadFile(goldenPath(golden))
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "spool-000001"+filepath.Ext(golden)), data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			s, err := OpenSpoolWith(dir, nil, SpoolOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			for _, want := range goldenDeadLetters {
+				got, err := s.pop()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.id != want.ID || !reflect.DeepEqual(got.fields.Map(), want.Item) || got.tries != len(want.Attempts) {
+					t.Errorf("loaded %+v, want %+v", got, want)
+				}
+			}
+			if s.Len() != 0 {
+				t.Errorf("%d items left after the golden ones", s.Len())
+			}
+		})
+	}
+}
+
+// testSpoolFormat записывает образцовые элементы в spool с кодировкой encoding, сравнивает файл
+// с образцом golden и проверяет, что элементы читаются обратно без изменений
+func testSpoolFormat(t *testing.T, encoding, golden string) {
+	s, err := OpenSpoolWith(t.TempDir(), nil, SpoolOptions{Encoding: encoding})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var items []*queuedItem
+	for _, letter := range goldenDeadLetters {
+		item := &queuedItem{
+			id:            letter.ID,
+			fields:        newItemFields(letter.Item),
+			correlationID: letter.CorrelationID,
+			provenance:    letter.Provenance,
+			attempts:      letter.Attempts,
+			tries:         len(letter.Attempts),
+		}
+		items = append(items, item)
+		if err := s.push(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(s.segments[0].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, golden, data)
+
+	for _, want := range items {
+		got, err := s.pop()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range got.attempts {
+			got.attempts[i].Time = got.attempts[i].Time.UTC()
+		}
+		if got.id != want.id || !reflect.DeepEqual(got.fields.Map(), want.fields.Map()) ||
+			!reflect.DeepEqual(got.provenance, want.provenance) || !reflect.DeepEqual(got.attempts, want.attempts) || got.tries != want.tries {
+			t.Errorf("popped %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestNewerFormatIsRefused(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dlq.json")
+	newer := []byte(`{"format":"buffer-dlq","version":99,"items":[]}`)
+	if err := os.WriteFile(path, newer, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenDeadLetterQueue(path, nil); err == nil || !strings.Contains(err.Error(), "newer buffer") {
+		t.Errorf("OpenDeadLetterQueue error = %v, want a newer version error", err)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, newer) {
+		t.Errorf("newer file was modified:\n%s", data)
+	}
+
+	if _, err := decodeCheckpoint([]byte(`{"format":"buffer-checkpoint","version":2,"row":1}`)); err == nil {
+		t.Error("decodeCheckpoint accepted a newer version")
+	}
+	if _, err := decodeCheckpoint([]byte(`{"format":"buffer-dlq","version":1}`)); err == nil {
+		t.Error("decodeCheckpoint accepted another format")
+	}
+	if _, err := decodeSyncState([]byte(`{"format":"buffer-sync","version":2,"cursor":"1"}`)); err == nil {
+		t.Error("decodeSyncState accepted a newer version")
+	}
+	if _, err := readSpoolHeader([]byte(`{"format":"buffer-spool","version":2}`)); err == nil {
+		t.Error("readSpoolHeader accepted a newer version")
+	}
+}
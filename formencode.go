@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// FormConfig задает кодирование тела запроса application/x-www-form-urlencoded для шлюзов,
+// требующих определенного порядка полей, кодировки или записи значений. Без настроек поля
+// кодируются в порядке сортировки имен в UTF-8, как url.Values.Encode
+type FormConfig struct {
+	Order     []string          `yaml:"order"`      // поля в начале тела в заданном порядке, остальные - по имени
+	OmitEmpty bool              `yaml:"omit_empty"` // не передавать поля с пустым значением
+	Charset   string            `yaml:"charset"`    // utf-8 или windows-1251; задает и charset в Content-Type
+	Spaces    string            `yaml:"spaces"`     // plus - пробел как "+", percent - как "%20"
+	Encoders  map[string]string `yaml:"encoders"`   // кодирование отдельных полей: query, percent или base64
+}
+
+// enabled сообщает, отличается ли кодирование от кодирования по умолчанию
+func (c FormConfig) enabled() bool {
+	return len(c.Order) > 0 || c.OmitEmpty || c.Charset != "" || c.Spaces != "" || len(c.Encoders) > 0
+}
+
+// validate проверяет кодировку и кодирование полей
+func (c FormConfig) validate() error {
+	if _, err := parseCharset(c.Charset); err != nil {
+		return err
+	}
+	if c.Spaces != "" && c.Spaces != "plus" && c.Spaces != "percent" {
+		return fmt.Errorf("spaces: unknown value %q, expected plus or percent", c.Spaces)
+	}
+	for field, name := range c.Encoders {
+		if _, ok := formEncoders[name]; !ok {
+			return fmt.Errorf("encoders.%s: unknown encoder %q, expected query, percent or base64", field, name)
+		}
+	}
+	return nil
+}
+
+// FieldEncoder кодирует значение поля для тела запроса; результат вставляется в тело как есть,
+// поэтому символы &, = и + в нем должны быть закодированы
+type FieldEncoder func(value string) string
+
+// formEncoders сопоставляет имена из encoders кодированию значений с кодировкой charset
+var formEncoders = map[string]func(charset func(string) string) FieldEncoder{
+	"query": func(charset func(string) string) FieldEncoder {
+		return func(value string) string { return url.QueryEscape(charset(value)) }
+	},
+	"percent": func(charset func(string) string) FieldEncoder {
+		return func(value string) string { return strings.ReplaceAll(url.QueryEscape(charset(value)), "+", "%20") }
+	},
+	"base64": func(charset func(string) string) FieldEncoder {
+		return func(value string) string {
+			return url.QueryEscape(base64.StdEncoding.EncodeToString([]byte(charset(value))))
+		}
+	},
+}
+
+// FormEncoder кодирует данные элемента в тело запроса по настройкам FormConfig
+type FormEncoder struct {
+	order       []string
+	omitEmpty   bool
+	contentType string
+	value       FieldEncoder
+	fields      map[string]FieldEncoder
+}
+
+// NewFormEncoder создает кодирование тела по настройкам
+func NewFormEncoder(c FormConfig) (*FormEncoder, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	charset, _ := parseCharset(c.Charset)
+	e := &FormEncoder{
+		order:       c.Order,
+		omitEmpty:   c.OmitEmpty,
+		contentType: "application/x-www-form-urlencoded",
+		fields:      make(map[string]FieldEncoder, len(c.Encoders)),
+	}
+	if c.Charset != "" {
+		e.contentType += "; charset=" + strings.ToLower(c.Charset)
+	}
+	name := "query"
+	if c.Spaces == "percent" {
+		name = "percent"
+	}
+	e.value = formEncoders[name](charset)
+	for field, name := range c.Encoders {
+		e.fields[field] = formEncoders[name](charset)
+	}
+	return e, nil
+}
+
+// SetFieldEncoder задает собственное кодирование значения поля name вместо настроек
+func (e *FormEncoder) SetFieldEncoder(name string, enc FieldEncoder) {
+	e.fields[name] = enc
+}
+
+// WithFormEncoder кодирует тела запросов на сохранение кодированием e вместо кодирования по умолчанию
+func WithFormEncoder(e *FormEncoder) Option {
+	return func(b *Buffer) {
+		b.form = e
+	}
+}
+
+// Encode возвращает тело запроса для данных элемента
+func (e *FormEncoder) Encode(data map[string]string) string {
+	buf := e.encode(newItemFields(data))
+	defer buf.release()
+	return buf.String()
+}
+
+// encode кодирует поля элемента в буфер из пула: сначала поля из order в заданном порядке,
+// затем остальные в порядке сортировки имен
+func (e *FormEncoder) encode(fields itemFields) *formBuffer {
+	buf := formBufferPool.Get().(*formBuffer)
+	buf.Reset()
+	written := make([]bool, len(fields.values))
+	write := func(i int) {
+		written[i] = true
+		value := fields.values[i]
+		if value == "" && e.omitEmpty {
+			return
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('&')
+		}
+		buf.WriteString(fields.layout.escaped[i])
+		buf.WriteByte('=')
+		if enc, ok := e.fields[fields.layout.names[i]]; ok {
+			buf.WriteString(enc(value))
+		} else {
+			buf.WriteString(e.value(value))
+		}
+	}
+	for _, name := range e.order {
+		if i := fields.index(name); i >= 0 && !written[i] {
+			write(i)
+		}
+	}
+	for i := range fields.values {
+		if !written[i] {
+			write(i)
+		}
+	}
+	return buf
+}
+
+// encodeBody кодирует поля элемента в тело запроса на сохранение в буфер из пула
+func (b *Buffer) encodeBody(fields itemFields) *formBuffer {
+	if b.form != nil {
+		return b.form.encode(fields)
+	}
+	return encodeForm(fields)
+}
+
+// contentType возвращает заголовок Content-Type тела запроса на сохранение
+func (b *Buffer) contentType() string {
+	if b.form != nil {
+		return b.form.contentType
+	}
+	return "application/x-www-form-urlencoded"
+}
+
+// parseCharset возвращает перекодирование значений из UTF-8 в кодировку charset
+func parseCharset(charset string) (func(string) string, error) {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8":
+		return func(s string) string { return s }, nil
+	case "windows-1251", "cp1251":
+		return encodeWindows1251, nil
+	}
+	return nil, fmt.Errorf("charset: unsupported charset %q, expected utf-8 or windows-1251", charset)
+}
+
+// windows1251 сопоставляет символам вне ASCII и основной кириллицы их коды в windows-1251
+var windows1251 = map[rune]byte{
+	'Ђ': 0x80, 'Ѓ': 0x81, '‚': 0x82, 'ѓ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'€': 0x88, '‰': 0x89, 'Љ': 0x8A, '‹': 0x8B, 'Њ': 0x8C, 'Ќ': 0x8D, 'Ћ': 0x8E, 'Џ': 0x8F,
+	'ђ': 0x90, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'™': 0x99, 'љ': 0x9A, '›': 0x9B, 'њ': 0x9C, 'ќ': 0x9D, 'ћ': 0x9E, 'џ': 0x9F,
+	'\u00a0': 0xA0, 'Ў': 0xA1, 'ў': 0xA2, 'Ј': 0xA3, '¤': 0xA4, 'Ґ': 0xA5, '¦': 0xA6, '§': 0xA7,
+	'Ё': 0xA8, '©': 0xA9, 'Є': 0xAA, '«': 0xAB, '¬': 0xAC, '\u00ad': 0xAD, '®': 0xAE, 'Ї': 0xAF,
+	'°': 0xB0, '±': 0xB1, 'І': 0xB2, 'і': 0xB3, 'ґ': 0xB4, 'µ': 0xB5, '¶': 0xB6, '·': 0xB7,
+	'ё': 0xB8, '№': 0xB9, 'є': 0xBA, '»': 0xBB, 'ј': 0xBC, 'Ѕ': 0xBD, 'ѕ': 0xBE, 'ї': 0xBF,
+}
+
+// encodeWindows1251 перекодирует строку в windows-1251; символы без кода заменяются на "?"
+func encodeWindows1251(s string) string {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r < 0x80:
+			out = append(out, byte(r))
+		case r >= 'А' && r <= 'я':
+			out = append(out, byte(r-'А'+0xC0))
+		default:
+			if c, ok := windows1251[r]; ok {
+				out = append(out, c)
+			} else {
+				out = append(out, '?')
+			}
+		}
+	}
+	return string(out)
+}
@@ -1,3 +1,12 @@
 module buffer
 
 go 1.22.1
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require (
+	golang.org/x/sys v0.30.0
+	software.sslmate.com/src/go-pkcs12 v0.7.3
+)
+
+require golang.org/x/crypto v0.11.0 // indirect
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Hooks задает функции, вызываемые при переходах внутреннего состояния буфера. Нужны тестам
+// с -race: по ним можно дождаться нужного состояния, остановить отправку в заданной точке
+// и проверить порядок переходов без пауз. Функции вызываются синхронно из горутины, выполняющей
+// переход, без захваченных блокировок буфера, поэтому блокирующая функция задерживает только
+// эту отправку. Незаданные функции пропускаются, а паника в функции выводится и не прерывает отправку
+type Hooks struct {
+	Enqueued        func(itemID uint64)                               // элемент передан отправке
+	Dequeued        func(itemID uint64)                               // элемент взят из очереди и занял место в отправке
+	AttemptStarted  func(itemID uint64, try int)                      // начата попытка, паузы выключателя и ограничения частоты выдержаны
+	AttemptFinished func(itemID uint64, try int, err error)           // попытка завершена, err nil при успехе
+	BackoffEntered  func(itemID uint64, try int, delay time.Duration) // начата пауза перед повтором
+	CircuitOpened   func(at time.Time)                                // выключатель разомкнулся
+	Released        func(itemID uint64, err error)                    // обработка завершена; вызывается до освобождения места в отправке и возврата Flush
+}
+
+// WithHooks задает функции, вызываемые при переходах внутреннего состояния буфера
+func WithHooks(h Hooks) Option {
+	return func(b *Buffer) {
+		b.hooks = h
+	}
+}
+
+func (h *Hooks) enqueued(id uint64) {
+	if h.Enqueued != nil {
+		h.call("Enqueued", func() { h.Enqueued(id) })
+	}
+}
+
+func (h *Hooks) dequeued(id uint64) {
+	if h.Dequeued != nil {
+		h.call("Dequeued", func() { h.Dequeued(id) })
+	}
+}
+
+func (h *Hooks) attemptStarted(id uint64, try int) {
+	if h.AttemptStarted != nil {
+		h.call("AttemptStarted", func() { h.AttemptStarted(id, try) })
+	}
+}
+
+func (h *Hooks) attemptFinished(id uint64, try int, err error) {
+	if h.AttemptFinished != nil {
+		h.call("AttemptFinished", func() { h.AttemptFinished(id, try, err) })
+	}
+}
+
+func (h *Hooks) backoffEntered(id uint64, try int, delay time.Duration) {
+	if h.BackoffEntered != nil {
+		h.call("BackoffEntered", func() { h.BackoffEntered(id, try, delay) })
+	}
+}
+
+func (h *Hooks) circuitOpened(at time.Time) {
+	if h.CircuitOpened != nil {
+		h.call("CircuitOpened", func() { h.CircuitOpened(at) })
+	}
+}
+
+func (h *Hooks) released(id uint64, err error) {
+	if h.Released != nil {
+		h.call("Released", func() { h.Released(id, err) })
+	}
+}
+
+// call вызывает функцию перехода name, перехватывая ее панику
+func (h *Hooks) call(name string, f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("Error in %s hook: panic: %v\n", name, r)
+		}
+	}()
+	f()
+}
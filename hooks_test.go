@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// hookCounts считает вызовы каждой функции Hooks и запоминает ошибки попыток и результатов
+type hookCounts struct {
+	mu       sync.Mutex
+	calls    map[string]int
+	attempts []error
+	released []error
+}
+
+func (c *hookCounts) add(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls[name]++
+}
+
+func (c *hookCounts) hooks() Hooks {
+	c.calls = make(map[string]int)
+	return Hooks{
+		Enqueued:       func(uint64) { c.add("Enqueued") },
+		Dequeued:       func(uint64) { c.add("Dequeued") },
+		AttemptStarted: func(uint64, int) { c.add("AttemptStarted") },
+		AttemptFinished: func(_ uint64, _ int, err error) {
+			c.add("AttemptFinished")
+			c.mu.Lock()
+			c.attempts = append(c.attempts, err)
+			c.mu.Unlock()
+		},
+		BackoffEntered: func(uint64, int, time.Duration) { c.add("BackoffEntered") },
+		CircuitOpened:  func(time.Time) { c.add("CircuitOpened") },
+		Released: func(_ uint64, err error) {
+			c.add("Released")
+			c.mu.Lock()
+			c.released = append(c.released, err)
+			c.mu.Unlock()
+		},
+	}
+}
+
+func (c *hookCounts) check(t *testing.T, want map[string]int) {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range []string{"Enqueued", "Dequeued", "AttemptStarted", "AttemptFinished", "BackoffEntered", "CircuitOpened", "Released"} {
+		if c.calls[name] != want[name] {
+			t.Errorf("%s called %d times, want %d", name, c.calls[name], want[name])
+		}
+	}
+}
+
+// hookServer отвечает на запросы кодом status
+func hookServer(t *testing.T, status int) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(`{"MESSAGES":{"error":null,"warning":null,"info":[]},"DATA":{"indicator_to_mo_fact_id":1},"STATUS":"OK"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHooksOnSuccess(t *testing.T) {
+	SetLogLevel(LevelError)
+	srv := hookServer(t, http.StatusOK)
+	var counts hookCounts
+	b := NewBuffer(srv.URL, "token", WithHooks(counts.hooks()))
+	defer b.Close()
+	b.Add(benchmarkItem)
+	b.Flush()
+
+	counts.check(t, map[string]int{"Enqueued": 1, "Dequeued": 1, "AttemptStarted": 1, "AttemptFinished": 1, "Released": 1})
+	if counts.attempts[0] != nil || counts.released[0] != nil {
+		t.Errorf("successful delivery reported errors: attempt %v, released %v", counts.attempts[0], counts.released[0])
+	}
+}
+
+func TestHooksOnFailure(t *testing.T) {
+	SetLogLevel(LevelError)
+	srv := hookServer(t, http.StatusInternalServerError)
+	var counts hookCounts
+	b := NewBuffer(srv.URL, "token", WithHooks(counts.hooks()))
+	defer b.Close()
+	b.Add(benchmarkItem)
+	b.Flush()
+
+	counts.check(t, map[string]int{"Enqueued": 1, "Dequeued": 1, "AttemptStarted": 1, "AttemptFinished": 1, "Released": 1})
+	if counts.attempts[0] == nil || counts.released[0] == nil {
+		t.Errorf("failed delivery reported no error: attempt %v, released %v", counts.attempts[0], counts.released[0])
+	}
+}
+
+func TestHooksOnRetry(t *testing.T) {
+	SetLogLevel(LevelError)
+	srv := hookServer(t, http.StatusServiceUnavailable)
+	var counts hookCounts
+	b := NewBuffer(srv.URL, "token", WithHooks(counts.hooks()), WithRetry(2, time.Millisecond, time.Millisecond))
+	defer b.Close()
+	b.Add(benchmarkItem)
+	b.Flush()
+
+	// Повтор - вторая попытка того же элемента, а не новый элемент
+	counts.check(t, map[string]int{"Enqueued": 1, "Dequeued": 1, "AttemptStarted": 2, "AttemptFinished": 2, "BackoffEntered": 1, "Released": 1})
+}
+
+func TestHooksOnCircuitOpened(t *testing.T) {
+	SetLogLevel(LevelError)
+	srv := hookServer(t, http.StatusInternalServerError)
+	var counts hookCounts
+	b := NewBuffer(srv.URL, "token", WithHooks(counts.hooks()), WithCircuitBreaker(2, time.Hour))
+	defer b.Close()
+	b.Add(benchmarkItem)
+	b.Add(benchmarkItem)
+	b.Flush()
+
+	counts.check(t, map[string]int{"Enqueued": 2, "Dequeued": 2, "AttemptStarted": 2, "AttemptFinished": 2, "CircuitOpened": 1, "Released": 2})
+}
+
+func TestPanickingHooksDoNotBreakDelivery(t *testing.T) {
+	SetLogLevel(LevelError)
+	srv := hookServer(t, http.StatusOK)
+	var released atomic.Int32
+	b := NewBuffer(srv.URL, "token", WithHooks(Hooks{
+		Enqueued:        func(uint64) { panic("enqueued") },
+		Dequeued:        func(uint64) { panic("dequeued") },
+		AttemptStarted:  func(uint64, int) { panic("attempt started") },
+		AttemptFinished: func(uint64, int, error) { panic("attempt finished") },
+		Released: func(uint64, error) {
+			released.Add(1)
+			panic("released")
+		},
+	}))
+	defer b.Close()
+	var result error
+	b.Add(benchmarkItem, WithResultHandler(func(r ItemResult) { result = r.Err }))
+	b.Add(benchmarkItem)
+	b.Flush()
+
+	if n := released.Load(); n != 2 {
+		t.Fatalf("Released called %d times, want 2", n)
+	}
+	if result != nil {
+		t.Fatalf("delivery failed: %v", result)
+	}
+	if stats := b.Stats(); stats.Sent != 2 {
+		t.Fatalf("sent %d items, want 2", stats.Sent)
+	}
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxImportErrors ограничивает количество ошибок строк, сохраняемых в итоговой сводке
+const maxImportErrors = 100
+
+// defaultImportWindow задает, сколько строк одного импорта по умолчанию может ожидать доставки
+const defaultImportWindow = 10000
+
+// ImportProgress описывает ход импорта файла
+type ImportProgress struct {
+	Read     int           `json:"read"`
+	Enqueued int           `json:"enqueued"`
+	Sent     int           `json:"sent"`
+	Failed   int           `json:"failed"`
+	Elapsed  time.Duration `json:"elapsed"`
+	ETA      time.Duration `json:"eta"`
+}
+
+// RowError описывает ошибку обработки отдельной строки файла
+type RowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// RowWarning описывает предупреждения API к принятому факту строки файла
+type RowWarning struct {
+	Row      int      `json:"row"`
+	Warnings []string `json:"warnings"`
+}
+
+// ImportSummary представляет итог импорта файла
+type ImportSummary struct {
+	File       string        `json:"file"`
+	Read       int           `json:"read"`
+	Enqueued   int           `json:"enqueued"`
+	Sent       int           `json:"sent"`
+	Failed     int           `json:"failed"`
+	Skipped    int           `json:"skipped"`
+	Resumed    int           `json:"resumed"`    // строки, обработанные в прошлых запусках и пропущенные по контрольной точке
+	Unchanged  int           `json:"unchanged"`  // строки не новее отметки синхронизации, не отправлялись
+	Cursor     string        `json:"cursor"`     // отметка синхронизации после импорта
+	Duplicates int           `json:"duplicates"` // из отправленных - уже сохраненные в API и пропущенные с dedup.remote
+	Duration   time.Duration `json:"duration"`
+	Errors     []RowError    `json:"errors,omitempty"`
+	Warnings   []RowWarning  `json:"warnings,omitempty"` // строки, принятые API с предупреждениями
+}
+
+// ImportOptions задает параметры импорта файла
+type ImportOptions struct {
+	ProgressInterval time.Duration        // период отчета о ходе импорта, по умолчанию 5 секунд
+	OnProgress       func(ImportProgress) // вызывается периодически и по завершении импорта
+	// Prepare преобразует и проверяет строку перед постановкой в очередь; строки с ошибкой пропускаются
+	Prepare func(map[string]string) (map[string]string, error)
+	// Checkpoint задает файл контрольной точки: прерванный импорт продолжается после последней
+	// обработанной строки, если начало файла не изменилось
+	Checkpoint string
+	// MaxPending ограничивает количество строк, поставленных в очередь, но еще не доставленных
+	// (по умолчанию 10000): чтение файла приостанавливается, пока буфер не отправит часть строк
+	MaxPending int
+	// Source задает источник в происхождении строк (по умолчанию import); к нему добавляются
+	// файл, номер строки, пользователь и узел
+	Source string
+	// Endpoint задает адрес или путь API для всех строк файла вместо адреса буфера (см. WithEndpoint)
+	Endpoint string
+	// SyncField задает поле строки с отметкой изменения, например updated_at или id: отправляются
+	// только строки с отметкой новее сохраненной в SyncState, а после импорта отметка продвигается
+	SyncField string
+	// SyncState задает файл отметки синхронизации, по умолчанию <файл>.sync
+	SyncState string
+}
+
+// rowReader последовательно читает строки файла в виде элементов буфера
+type rowReader interface {
+	Next() (map[string]string, error)
+	Offset() int64 // смещение в байтах конца последней прочитанной строки
+}
+
+// progressReader сообщает ход чтения, если смещение в файле не отражает его, как у сжатых форматов
+type progressReader interface {
+	Progress() (done, total int64)
+}
+
+// ImportFile читает файл CSV, JSON Lines или XLSX потоково, ставит строки в очередь
+// и дожидается их доставки. Файл целиком в память не загружается
+func (b *Buffer) ImportFile(path string, opts ImportOptions) (ImportSummary, error) {
+	summary := ImportSummary{File: path}
+	f, err := os.Open(path)
+	if err != nil {
+		return summary, err
+	}
+	defer f.Close()
+
+	var size int64
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+	}
+	counter := &countingReader{r: f}
+	rows, err := newRowReader(path, f, counter)
+	if err != nil {
+		return summary, err
+	}
+	if c, ok := rows.(io.Closer); ok {
+		defer c.Close()
+	}
+	position := func() (int64, int64) {
+		if p, ok := rows.(progressReader); ok {
+			return p.Progress()
+		}
+		return counter.Count(), size
+	}
+
+	if opts.ProgressInterval <= 0 {
+		opts.ProgressInterval = 5 * time.Second
+	}
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = defaultImportWindow
+	}
+	if opts.Source == "" {
+		opts.Source = "import"
+	}
+	origin := localProvenance(opts.Source)
+	origin.File = path
+	window := make(chan struct{}, opts.MaxPending)
+	var checkpoint *checkpointer
+	if opts.Checkpoint != "" {
+		if checkpoint, err = openCheckpointer(path, opts.Checkpoint); err != nil {
+			return summary, fmt.Errorf("opening checkpoint: %w", err)
+		}
+		defer checkpoint.Close()
+	}
+	saveCheckpoint := func() {
+		if checkpoint == nil {
+			return
+		}
+		if err := checkpoint.save(b.clock.Now()); err != nil {
+			fmt.Println("Error saving import checkpoint:", err)
+		}
+	}
+	completeRow := func(row int) {
+		if checkpoint != nil {
+			checkpoint.complete(row)
+		}
+	}
+	var tracker *syncTracker
+	if opts.SyncField != "" {
+		if opts.SyncState == "" {
+			opts.SyncState = syncStatePath(path)
+		}
+		if tracker, err = openSyncTracker(path, opts.SyncState, opts.SyncField); err != nil {
+			return summary, fmt.Errorf("opening sync state: %w", err)
+		}
+		summary.Cursor = tracker.since
+	}
+
+	var (
+		read, enqueued, sent, failed, duplicates atomic.Int64
+		pending                                  sync.WaitGroup
+		errorsMu                                 sync.Mutex
+		start                                    = b.clock.Now()
+	)
+	addError := func(row int, err error) {
+		errorsMu.Lock()
+		defer errorsMu.Unlock()
+		if len(summary.Errors) < maxImportErrors {
+			summary.Errors = append(summary.Errors, RowError{Row: row, Error: err.Error()})
+		}
+	}
+	addWarnings := func(row int, warnings []string) {
+		errorsMu.Lock()
+		defer errorsMu.Unlock()
+		if len(summary.Warnings) < maxImportErrors {
+			summary.Warnings = append(summary.Warnings, RowWarning{Row: row, Warnings: warnings})
+		}
+	}
+	progress := func() ImportProgress {
+		p := ImportProgress{
+			Read:     int(read.Load()),
+			Enqueued: int(enqueued.Load()),
+			Sent:     int(sent.Load()),
+			Failed:   int(failed.Load()),
+			Elapsed:  b.clock.Now().Sub(start),
+		}
+		offset, total := position()
+		p.ETA = estimateETA(p, offset, total)
+		return p
+	}
+	report := func(p ImportProgress) {
+		logInfof("Import %s: read %d, enqueued %d, sent %d, failed %d, elapsed %s, eta %s",
+			path, p.Read, p.Enqueued, p.Sent, p.Failed, p.Elapsed.Round(time.Second), p.ETA.Round(time.Second))
+		if opts.OnProgress != nil {
+			opts.OnProgress(p)
+		}
+	}
+
+	stop := make(chan struct{})
+	reporterDone := make(chan struct{})
+	go func() {
+		defer close(reporterDone)
+		ticker := b.clock.NewTicker(opts.ProgressInterval)
+		defer ticker.Stop()
+		checkpointTicker := b.clock.NewTicker(checkpointInterval)
+		defer checkpointTicker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C():
+				report(progress())
+			case <-checkpointTicker.C():
+				saveCheckpoint()
+			}
+		}
+	}()
+
+	var readErr error
+	for row := 1; ; row++ {
+		item, err := rows.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var rowErr *rowParseError
+		if err != nil && !errors.As(err, &rowErr) {
+			readErr = err
+			break
+		}
+		if checkpoint != nil {
+			if row <= checkpoint.resumeRow() {
+				summary.Resumed++
+				continue
+			}
+			checkpoint.read(row, rows.Offset())
+		}
+		read.Add(1)
+		if err != nil {
+			summary.Skipped++
+			addError(row, err)
+			completeRow(row)
+			continue
+		}
+		var cursor string
+		if tracker != nil {
+			if cursor = item[opts.SyncField]; cursor == "" {
+				summary.Skipped++
+				addError(row, fmt.Errorf("sync field %s is empty", opts.SyncField))
+				completeRow(row)
+				continue
+			}
+			if !tracker.changed(cursor) {
+				summary.Unchanged++
+				completeRow(row)
+				continue
+			}
+		}
+		if opts.Prepare != nil {
+			if item, err = opts.Prepare(item); err != nil {
+				summary.Skipped++
+				addError(row, err)
+				completeRow(row)
+				continue
+			}
+		}
+		window <- struct{}{}
+		pending.Add(1)
+		row := row
+		provenance := origin
+		provenance.Row = row
+		queued := &queuedItem{fields: newItemFields(item), provenance: &provenance, endpoint: opts.Endpoint}
+		queued.result = func(result ItemResult) {
+			if result.Err != nil {
+				failed.Add(1)
+				addError(row, result.Err)
+			} else {
+				sent.Add(1)
+			}
+			if result.Duplicate {
+				duplicates.Add(1)
+			}
+			if len(result.Warnings) > 0 {
+				addWarnings(row, result.Warnings)
+			}
+			if tracker != nil {
+				tracker.done(cursor, result.Err == nil)
+			}
+			completeRow(row)
+			<-window
+			pending.Done()
+		}
+		b.enqueue(queued)
+		enqueued.Add(1)
+	}
+
+	pending.Wait()
+	close(stop)
+	<-reporterDone
+	saveCheckpoint()
+	if tracker != nil && readErr == nil {
+		if summary.Cursor, err = tracker.save(path, b.clock.Now()); err != nil {
+			fmt.Println("Error saving sync state:", err)
+		}
+	}
+
+	final := progress()
+	final.ETA = 0
+	report(final)
+
+	summary.Read = final.Read
+	summary.Enqueued = final.Enqueued
+	summary.Sent = final.Sent
+	summary.Failed = final.Failed
+	summary.Duplicates = int(duplicates.Load())
+	summary.Duration = final.Elapsed
+	sort.Slice(summary.Errors, func(i, j int) bool { return summary.Errors[i].Row < summary.Errors[j].Row })
+	sort.Slice(summary.Warnings, func(i, j int) bool { return summary.Warnings[i].Row < summary.Warnings[j].Row })
+	return summary, readErr
+}
+
+// estimateETA оценивает оставшееся время по доле прочитанного файла и доле доставленных строк
+func estimateETA(p ImportProgress, offset, size int64) time.Duration {
+	done := p.Sent + p.Failed
+	if done == 0 || size <= 0 || offset <= 0 || p.Read == 0 {
+		return 0
+	}
+	expectedRows := float64(p.Read) * float64(size) / float64(offset)
+	remaining := expectedRows - float64(done)
+	if remaining <= 0 {
+		return 0
+	}
+	perRow := float64(p.Elapsed) / float64(done)
+	return time.Duration(remaining * perRow)
+}
+
+// rowParseError описывает ошибку в содержимом одной строки, после которой чтение файла можно продолжить
+type rowParseError struct {
+	err error
+}
+
+func (e *rowParseError) Error() string { return e.err.Error() }
+
+func (e *rowParseError) Unwrap() error { return e.err }
+
+// newRowReader выбирает формат файла по расширению. Текстовые форматы читаются из r,
+// XLSX - из f, так как архиву нужен произвольный доступ
+func newRowReader(path string, f *os.File, r io.Reader) (rowReader, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".xlsx":
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return newXLSXRowReader(f, info.Size())
+	case ".csv":
+		return newCSVRowReader(r)
+	case ".jsonl", ".ndjson":
+		j := &jsonlRowReader{scanner: newLineScanner(r)}
+		j.scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+			advance, token, err := bufio.ScanLines(data, atEOF)
+			j.offset += int64(advance)
+			return advance, token, err
+		})
+		return j, nil
+	default:
+		return nil, fmt.Errorf("unsupported import file format: %s", path)
+	}
+}
+
+// csvRowReader читает строки CSV, используя первую строку как имена полей
+type csvRowReader struct {
+	r      *csv.Reader
+	header []string
+}
+
+func newCSVRowReader(r io.Reader) (*csvRowReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+	return &csvRowReader{r: cr, header: header}, nil
+}
+
+func (c *csvRowReader) Next() (map[string]string, error) {
+	record, err := c.r.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, &rowParseError{err: err}
+		}
+		return nil, err
+	}
+	if len(record) != len(c.header) {
+		return nil, &rowParseError{err: fmt.Errorf("expected %d fields, got %d", len(c.header), len(record))}
+	}
+	item := make(map[string]string, len(record))
+	for i, value := range record {
+		item[c.header[i]] = strings.TrimSpace(value)
+	}
+	return item, nil
+}
+
+func (c *csvRowReader) Offset() int64 {
+	return c.r.InputOffset()
+}
+
+// jsonlRowReader читает по одному JSON-объекту из каждой непустой строки
+type jsonlRowReader struct {
+	scanner *bufio.Scanner
+	offset  int64
+}
+
+func (j *jsonlRowReader) Offset() int64 {
+	return j.offset
+}
+
+func (j *jsonlRowReader) Next() (map[string]string, error) {
+	for j.scanner.Scan() {
+		line := strings.TrimSpace(j.scanner.Text())
+		if line == "" {
+			continue
+		}
+		item, err := decodeJSONItem([]byte(line))
+		if err != nil {
+			return nil, &rowParseError{err: err}
+		}
+		return item, nil
+	}
+	if err := j.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// decodeJSONItem разбирает JSON-объект, приводя скалярные значения полей к строкам
+func decodeJSONItem(data []byte) (map[string]string, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	item := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch v := value.(type) {
+		case string:
+			item[key] = v
+		case float64:
+			item[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			item[key] = strconv.FormatBool(v)
+		case nil:
+			item[key] = ""
+		default:
+			return nil, fmt.Errorf("field %q must be a scalar value", key)
+		}
+	}
+	return item, nil
+}
+
+// newLineScanner создает построчный сканер с увеличенным пределом длины строки
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return scanner
+}
+
+// countingReader подсчитывает количество прочитанных байт
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// Count возвращает количество прочитанных байт
+func (c *countingReader) Count() int64 {
+	return c.n.Load()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syncState представляет сохраненное состояние инкрементальной синхронизации источника:
+// строки с отметкой Field не новее Cursor уже отправлены
+type syncState struct {
+	formatHeader
+	File     string    `json:"file"`
+	Field    string    `json:"field"`
+	Cursor   string    `json:"cursor"`
+	Rows     int       `json:"rows"` // строки, доставленные последним запуском
+	SyncedAt time.Time `json:"synced_at"`
+}
+
+// encodeSyncState кодирует состояние синхронизации в текущей версии формата файла
+func encodeSyncState(st syncState) ([]byte, error) {
+	st.formatHeader = newFormatHeader(syncFormat, syncVersion)
+	return json.MarshalIndent(st, "", "  ")
+}
+
+// decodeSyncState разбирает файл состояния синхронизации любой поддерживаемой версии
+func decodeSyncState(data []byte) (syncState, error) {
+	var st syncState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return st, err
+	}
+	return st, st.check(syncFormat, syncVersion)
+}
+
+// syncStatePath возвращает файл состояния синхронизации по умолчанию для источника
+func syncStatePath(path string) string {
+	return path + ".sync"
+}
+
+// syncTracker отбирает строки, измененные после сохраненной отметки, и продвигает отметку
+// по доставленным строкам. Строки доставляются не по порядку, поэтому при ошибках отметка
+// продвигается только до строк, отметка которых меньше отметки первой недоставленной строки
+type syncTracker struct {
+	path  string // файл состояния
+	field string
+	since string // отметка прошлой синхронизации
+
+	mu        sync.Mutex
+	delivered []string
+	failed    string // наименьшая отметка недоставленной строки
+	hasFailed bool
+}
+
+// openSyncTracker загружает состояние синхронизации файла file из path. Если отметка
+// сохранена по другому полю, синхронизация начинается с первой строки
+func openSyncTracker(file, path, field string) (*syncTracker, error) {
+	t := &syncTracker{path: path, field: field}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	st, err := decodeSyncState(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing sync state %s: %w", path, err)
+	}
+	if st.Field != field {
+		fmt.Printf("Sync %s: field changed from %s to %s, starting from the first row\n", file, st.Field, field)
+		return t, nil
+	}
+	t.since = st.Cursor
+	return t, nil
+}
+
+// changed сообщает, новее ли отметка строки сохраненной
+func (t *syncTracker) changed(cursor string) bool {
+	return t.since == "" || compareCursor(cursor, t.since) > 0
+}
+
+// done учитывает итог доставки строки с отметкой cursor
+func (t *syncTracker) done(cursor string, delivered bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if delivered {
+		t.delivered = append(t.delivered, cursor)
+		return
+	}
+	if !t.hasFailed || compareCursor(cursor, t.failed) < 0 {
+		t.failed, t.hasFailed = cursor, true
+	}
+}
+
+// cursor возвращает новую отметку: наибольшую среди доставленных строк, которые меньше
+// отметки первой недоставленной строки, или прежнюю
+func (t *syncTracker) cursor() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cursor := t.since
+	for _, c := range t.delivered {
+		if t.hasFailed && compareCursor(c, t.failed) >= 0 {
+			continue
+		}
+		if cursor == "" || compareCursor(c, cursor) > 0 {
+			cursor = c
+		}
+	}
+	return cursor
+}
+
+// save записывает новую отметку синхронизации файла file со временем now
+func (t *syncTracker) save(file string, now time.Time) (string, error) {
+	cursor := t.cursor()
+	t.mu.Lock()
+	rows := len(t.delivered)
+	t.mu.Unlock()
+	data, err := encodeSyncState(syncState{File: file, Field: t.field, Cursor: cursor, Rows: rows, SyncedAt: now})
+	if err != nil {
+		return cursor, err
+	}
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return cursor, err
+	}
+	return cursor, os.Rename(tmp, t.path)
+}
+
+// cursorLayouts перечисляет записи отметок времени, которые сравниваются как время
+var cursorLayouts = append([]string{time.RFC3339Nano, time.DateOnly}, sourceTimeLayouts...)
+
+// compareCursor сравнивает отметки изменения: числа - по значению, отметки времени - как время,
+// остальные - как строки
+func compareCursor(a, b string) int {
+	x, okX := new(big.Rat).SetString(a)
+	y, okY := new(big.Rat).SetString(b)
+	if okX && okY {
+		return x.Cmp(y)
+	}
+	if ta, ok := parseCursorTime(a); ok {
+		if tb, ok := parseCursorTime(b); ok {
+			return ta.Compare(tb)
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// parseCursorTime разбирает отметку времени; отметки без смещения считаются UTC
+func parseCursorTime(s string) (time.Time, bool) {
+	for _, layout := range cursorLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
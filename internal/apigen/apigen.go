@@ -0,0 +1,746 @@
+// Package apigen создает типизированный клиент и контрактные тесты по описанию OpenAPI 3.
+// Поддерживается подмножество, которого достаточно для API KPI-Drive: запросы POST с телом
+// формы и GET с параметрами строки запроса, ответы JSON, схемы components/schemas со ссылками
+// $ref, массивы и словари строк
+package apigen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Имена создаваемых файлов
+const (
+	ClientFile   = "client_gen.go"
+	ContractFile = "contract_gen_test.go"
+)
+
+const (
+	refPrefix   = "#/components/schemas/"
+	formContent = "application/x-www-form-urlencoded"
+	jsonContent = "application/json"
+)
+
+// Options задает параметры создаваемого кода
+type Options struct {
+	Package        string // имя пакета клиента
+	Source         string // путь к описанию для заголовка файлов
+	ContractServer string // путь импорта пакета с имитацией API, например buffer/mockkpi; пусто - без контрактных тестов
+}
+
+// Spec представляет поддерживаемую часть описания OpenAPI
+type Spec struct {
+	OpenAPI string `yaml:"openapi"`
+	Info    struct {
+		Title   string `yaml:"title"`
+		Version string `yaml:"version"`
+	} `yaml:"info"`
+	Paths      map[string]map[string]*Operation `yaml:"paths"`
+	Components struct {
+		Schemas map[string]*Schema `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+// Operation представляет метод API
+type Operation struct {
+	OperationID string               `yaml:"operationId"`
+	Summary     string               `yaml:"summary"`
+	Description string               `yaml:"description"`
+	Parameters  []*Parameter         `yaml:"parameters"`
+	RequestBody *RequestBody         `yaml:"requestBody"`
+	Responses   map[string]*Response `yaml:"responses"`
+}
+
+// Parameter представляет параметр метода. Поддерживается параметр строки запроса со схемой
+// объекта, поля которого передаются отдельными параметрами (style: form, explode: true)
+type Parameter struct {
+	Name    string                 `yaml:"name"`
+	In      string                 `yaml:"in"`
+	Style   string                 `yaml:"style"`
+	Explode *bool                  `yaml:"explode"`
+	Schema  *Schema                `yaml:"schema"`
+	Example map[string]interface{} `yaml:"example"`
+}
+
+// RequestBody представляет тело запроса
+type RequestBody struct {
+	Required bool                  `yaml:"required"`
+	Content  map[string]*MediaType `yaml:"content"`
+}
+
+// Response представляет ответ метода
+type Response struct {
+	Description string                `yaml:"description"`
+	Content     map[string]*MediaType `yaml:"content"`
+}
+
+// MediaType представляет тело в одном формате
+type MediaType struct {
+	Schema  *Schema                `yaml:"schema"`
+	Example map[string]interface{} `yaml:"example"`
+}
+
+// Schema представляет схему данных; схема без типа принимает любое значение
+type Schema struct {
+	Ref                  string             `yaml:"$ref"`
+	Type                 string             `yaml:"type"`
+	Description          string             `yaml:"description"`
+	Properties           map[string]*Schema `yaml:"properties"`
+	Required             []string           `yaml:"required"`
+	Items                *Schema            `yaml:"items"`
+	AdditionalProperties *Schema            `yaml:"additionalProperties"`
+}
+
+// operation представляет метод вместе с путем и выбранными схемами
+type operation struct {
+	*Operation
+	name     string // имя метода клиента
+	method   string
+	path     string
+	request  string // схема тела формы или параметров строки запроса
+	response string // схема ответа JSON
+	example  map[string]interface{}
+}
+
+// generator накапливает создаваемый код
+type generator struct {
+	spec  *Spec
+	opts  Options
+	ops   []operation
+	forms map[string]bool // схемы, передаваемые телом формы или строкой запроса
+}
+
+// Generate разбирает описание и возвращает содержимое файлов ClientFile и ContractFile
+func Generate(data []byte, opts Options) (map[string][]byte, error) {
+	var spec Spec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("parsing spec: %w", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		return nil, fmt.Errorf("unsupported openapi version %q, expected 3.x", spec.OpenAPI)
+	}
+	if opts.Package == "" {
+		return nil, fmt.Errorf("package name is required")
+	}
+	g := &generator{spec: &spec, opts: opts, forms: make(map[string]bool)}
+	if err := g.collect(); err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte)
+	client, err := g.client()
+	if err != nil {
+		return nil, err
+	}
+	files[ClientFile] = client
+	if opts.ContractServer != "" {
+		contract, err := g.contract()
+		if err != nil {
+			return nil, err
+		}
+		files[ContractFile] = contract
+	}
+	return files, nil
+}
+
+// collect проверяет методы и выбирает схемы запросов и ответов
+func (g *generator) collect() error {
+	paths := make([]string, 0, len(g.spec.Paths))
+	for path := range g.spec.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		methods := make([]string, 0, len(g.spec.Paths[path]))
+		for method := range g.spec.Paths[path] {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			op := operation{Operation: g.spec.Paths[path][method], method: strings.ToUpper(method), path: path}
+			if op.method != "POST" && op.method != "GET" {
+				return fmt.Errorf("%s %s: only GET and POST operations are supported", op.method, path)
+			}
+			if op.OperationID == "" {
+				return fmt.Errorf("%s %s: operationId is required", op.method, path)
+			}
+			op.name = goName(op.OperationID)
+			if err := g.collectRequest(&op); err != nil {
+				return err
+			}
+			for _, status := range []string{"200", "default"} {
+				resp := op.Responses[status]
+				if resp == nil {
+					continue
+				}
+				media := resp.Content[jsonContent]
+				if media == nil || media.Schema == nil || media.Schema.Ref == "" {
+					return fmt.Errorf("%s: response %s must be %s with a $ref schema", op.OperationID, status, jsonContent)
+				}
+				name := refName(media.Schema.Ref)
+				if op.response != "" && op.response != name {
+					return fmt.Errorf("%s: responses 200 and default must share a schema", op.OperationID)
+				}
+				op.response = name
+			}
+			if op.response == "" {
+				return fmt.Errorf("%s: response 200 is required", op.OperationID)
+			}
+			if g.spec.Components.Schemas[op.response] == nil {
+				return fmt.Errorf("%s: unknown schema %s", op.OperationID, op.response)
+			}
+			g.ops = append(g.ops, op)
+		}
+	}
+	return nil
+}
+
+// collectRequest выбирает схему запроса: тело формы у POST или параметр строки запроса у GET
+func (g *generator) collectRequest(op *operation) error {
+	if op.method == "GET" && op.RequestBody != nil {
+		return fmt.Errorf("%s: GET operations cannot have a request body, use a query parameter", op.OperationID)
+	}
+	if op.method == "POST" && len(op.Parameters) > 0 {
+		return fmt.Errorf("%s: parameters of POST operations are not supported, use a form body", op.OperationID)
+	}
+	var schema *Schema
+	switch {
+	case op.RequestBody != nil:
+		media := op.RequestBody.Content[formContent]
+		if media == nil || media.Schema == nil || media.Schema.Ref == "" {
+			return fmt.Errorf("%s: request body must be %s with a $ref schema", op.OperationID, formContent)
+		}
+		schema, op.example = media.Schema, media.Example
+	case len(op.Parameters) > 1:
+		return fmt.Errorf("%s: only one query parameter with an object schema is supported", op.OperationID)
+	case len(op.Parameters) == 1:
+		p := op.Parameters[0]
+		if p.In != "query" || p.Style != "" && p.Style != "form" || p.Explode != nil && !*p.Explode {
+			return fmt.Errorf("%s: parameter %s must be in: query with style: form and explode: true", op.OperationID, p.Name)
+		}
+		if p.Schema == nil || p.Schema.Ref == "" {
+			return fmt.Errorf("%s: parameter %s must have a $ref schema", op.OperationID, p.Name)
+		}
+		schema, op.example = p.Schema, p.Example
+	default:
+		return nil
+	}
+	op.request = refName(schema.Ref)
+	if err := g.checkForm(op.request); err != nil {
+		return fmt.Errorf("%s: %w", op.OperationID, err)
+	}
+	g.forms[op.request] = true
+	return nil
+}
+
+// checkForm проверяет, что схему можно передать телом формы или строкой запроса
+func (g *generator) checkForm(name string) error {
+	s := g.spec.Components.Schemas[name]
+	if s == nil {
+		return fmt.Errorf("unknown schema %s", name)
+	}
+	for prop, p := range s.Properties {
+		if p.Type != "string" {
+			return fmt.Errorf("schema %s: form field %s must be a string", name, prop)
+		}
+	}
+	if ap := s.AdditionalProperties; ap != nil && ap.Type != "string" {
+		return fmt.Errorf("schema %s: additional form fields must be strings", name)
+	}
+	return nil
+}
+
+// client создает файл клиента
+func (g *generator) client() ([]byte, error) {
+	var b bytes.Buffer
+	g.header(&b)
+	fmt.Fprintf(&b, "package %s\n\n", g.opts.Package)
+	b.WriteString("import (\n\t\"context\"\n\t\"encoding/json\"\n\t\"fmt\"\n\t\"io\"\n\t\"net/http\"\n\t\"net/url\"\n\t\"strings\"\n)\n\n")
+	b.WriteString("// Пути методов API\nconst (\n")
+	for _, op := range g.ops {
+		fmt.Fprintf(&b, "\t%sPath = %q\n", op.name, op.path)
+	}
+	b.WriteString(")\n\n")
+	b.WriteString(clientCode)
+
+	names := make([]string, 0, len(g.spec.Components.Schemas))
+	for name := range g.spec.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := g.schemaType(&b, name, g.spec.Components.Schemas[name]); err != nil {
+			return nil, err
+		}
+		if g.forms[name] {
+			g.formMethods(&b, name, g.spec.Components.Schemas[name])
+		}
+	}
+	for _, op := range g.ops {
+		g.method(&b, op)
+	}
+	return formatSource(&b)
+}
+
+// header выводит заголовок создаваемого файла
+func (g *generator) header(b *bytes.Buffer) {
+	source := g.opts.Source
+	if source == "" {
+		source = "an OpenAPI spec"
+	}
+	fmt.Fprintf(b, "// Code generated by apigen from %s; DO NOT EDIT.\n\n", source)
+}
+
+// schemaType выводит тип Go для схемы components/schemas
+func (g *generator) schemaType(b *bytes.Buffer, name string, s *Schema) error {
+	comment := "представляет схему " + name
+	if s.Description != "" {
+		comment += ". " + s.Description
+	}
+	fmt.Fprintf(b, "// %s %s\n", goName(name), comment)
+	if s.Type != "object" || len(s.Properties) == 0 {
+		typ, err := g.goType(s)
+		if err != nil {
+			return fmt.Errorf("schema %s: %w", name, err)
+		}
+		fmt.Fprintf(b, "type %s %s\n\n", goName(name), typ)
+		return nil
+	}
+	required := make(map[string]bool, len(s.Required))
+	for _, prop := range s.Required {
+		required[prop] = true
+	}
+	fmt.Fprintf(b, "type %s struct {\n", goName(name))
+	for _, prop := range sortedKeys(s.Properties) {
+		p := s.Properties[prop]
+		typ, err := g.goType(p)
+		if err != nil {
+			return fmt.Errorf("schema %s, property %s: %w", name, prop, err)
+		}
+		tag := prop
+		if !required[prop] {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(b, "\t%s %s `json:%q`", goName(prop), typ, tag)
+		if p.Description != "" {
+			fmt.Fprintf(b, " // %s", p.Description)
+		}
+		b.WriteString("\n")
+	}
+	if s.AdditionalProperties != nil && g.forms[name] {
+		b.WriteString("\tExtra map[string]string `json:\"-\"` // неописанные поля\n")
+	}
+	b.WriteString("}\n\n")
+	return nil
+}
+
+// goType возвращает тип Go для схемы поля
+func (g *generator) goType(s *Schema) (string, error) {
+	if s.Ref != "" {
+		name := refName(s.Ref)
+		if g.spec.Components.Schemas[name] == nil {
+			return "", fmt.Errorf("unknown schema %s", name)
+		}
+		return goName(name), nil
+	}
+	switch s.Type {
+	case "":
+		return "json.RawMessage", nil
+	case "string":
+		return "string", nil
+	case "integer":
+		return "int", nil
+	case "number":
+		return "float64", nil
+	case "boolean":
+		return "bool", nil
+	case "array":
+		if s.Items == nil {
+			return "", fmt.Errorf("array without items")
+		}
+		item, err := g.goType(s.Items)
+		return "[]" + item, err
+	case "object":
+		if len(s.Properties) > 0 {
+			return "", fmt.Errorf("inline objects with properties are not supported, use $ref")
+		}
+		if s.AdditionalProperties == nil {
+			return "map[string]json.RawMessage", nil
+		}
+		value, err := g.goType(s.AdditionalProperties)
+		return "map[string]" + value, err
+	default:
+		return "", fmt.Errorf("unsupported type %q", s.Type)
+	}
+}
+
+// formMethods выводит методы схемы, передаваемой телом формы
+func (g *generator) formMethods(b *bytes.Buffer, name string, s *Schema) {
+	typ := goName(name)
+	props := sortedKeys(s.Properties)
+	required := make(map[string]bool, len(s.Required))
+	for _, prop := range s.Required {
+		required[prop] = true
+	}
+
+	fmt.Fprintf(b, "// Form возвращает поля запроса для тела формы; пустые необязательные поля не передаются\n")
+	fmt.Fprintf(b, "func (r %s) Form() url.Values {\n\tform := url.Values{}\n", typ)
+	if s.AdditionalProperties != nil {
+		b.WriteString("\tfor key, value := range r.Extra {\n\t\tform.Set(key, value)\n\t}\n")
+	}
+	for _, prop := range props {
+		if required[prop] {
+			fmt.Fprintf(b, "\tform.Set(%q, r.%s)\n", prop, goName(prop))
+		} else {
+			fmt.Fprintf(b, "\tif r.%s != \"\" {\n\t\tform.Set(%q, r.%s)\n\t}\n", goName(prop), prop, goName(prop))
+		}
+	}
+	b.WriteString("\treturn form\n}\n\n")
+
+	b.WriteString("// SetField задает поле запроса по имени и сообщает, описано ли оно схемой или допустимо как неописанное\n")
+	fmt.Fprintf(b, "func (r *%s) SetField(name, value string) bool {\n\tswitch name {\n", typ)
+	for _, prop := range props {
+		fmt.Fprintf(b, "\tcase %q:\n\t\tr.%s = value\n", prop, goName(prop))
+	}
+	b.WriteString("\tdefault:\n")
+	if s.AdditionalProperties != nil {
+		b.WriteString("\t\tif r.Extra == nil {\n\t\t\tr.Extra = make(map[string]string)\n\t\t}\n\t\tr.Extra[name] = value\n")
+	} else {
+		b.WriteString("\t\treturn false\n")
+	}
+	b.WriteString("\t}\n\treturn true\n}\n\n")
+
+	b.WriteString("// Validate проверяет, что заданы обязательные поля\n")
+	if len(s.Required) == 0 {
+		fmt.Fprintf(b, "func (r %s) Validate() error {\n\treturn nil\n}\n\n", typ)
+		return
+	}
+	fmt.Fprintf(b, "func (r %s) Validate() error {\n\tvar missing []string\n", typ)
+	for _, prop := range s.Required {
+		fmt.Fprintf(b, "\tif r.%s == \"\" {\n\t\tmissing = append(missing, %q)\n\t}\n", goName(prop), prop)
+	}
+	b.WriteString("\tif len(missing) > 0 {\n\t\treturn fmt.Errorf(\"required fields are empty: %s\", strings.Join(missing, \", \"))\n\t}\n\treturn nil\n}\n\n")
+}
+
+// method выводит метод клиента и тип его результата
+func (g *generator) method(b *bytes.Buffer, op operation) {
+	resp := goName(op.response)
+	fmt.Fprintf(b, "// %sResult представляет ответ %s; JSON пуст, если тело не удалось разобрать\n", op.name, op.OperationID)
+	fmt.Fprintf(b, "type %sResult struct {\n\tStatusCode int\n\tHeader http.Header\n\tBody []byte\n\tJSON *%s\n}\n\n", op.name, resp)
+
+	comment := op.Summary
+	if comment == "" {
+		comment = "вызывает " + op.OperationID
+	}
+	fmt.Fprintf(b, "// %s %s: %s %s\n", op.name, lowerFirst(comment), op.method, op.path)
+	if op.request != "" {
+		fmt.Fprintf(b, "func (c *Client) %s(ctx context.Context, body %s) (*%sResult, error) {\n", op.name, goName(op.request), op.name)
+		fmt.Fprintf(b, "\treq, err := c.newRequest(ctx, %q, %sPath, body.Form())\n", op.method, op.name)
+	} else {
+		fmt.Fprintf(b, "func (c *Client) %s(ctx context.Context) (*%sResult, error) {\n", op.name, op.name)
+		fmt.Fprintf(b, "\treq, err := c.newRequest(ctx, %q, %sPath, nil)\n", op.method, op.name)
+	}
+	b.WriteString("\tif err != nil {\n\t\treturn nil, err\n\t}\n")
+	b.WriteString("\tresp, data, err := c.do(ctx, req)\n\tif err != nil {\n\t\treturn nil, err\n\t}\n")
+	fmt.Fprintf(b, "\tresult := &%sResult{StatusCode: resp.StatusCode, Header: resp.Header, Body: data}\n", op.name)
+	fmt.Fprintf(b, "\tvar decoded %s\n", resp)
+	b.WriteString("\tif err := json.Unmarshal(data, &decoded); err == nil {\n\t\tresult.JSON = &decoded\n")
+	fmt.Fprintf(b, "\t} else if resp.StatusCode/100 == 2 {\n\t\treturn result, fmt.Errorf(\"decoding %s response: %%w\", err)\n\t}\n", op.OperationID)
+	b.WriteString("\treturn result, nil\n}\n\n")
+}
+
+// contract создает контрактные тесты: пример запроса каждого метода отправляется имитации API,
+// и ответ должен соответствовать схеме без неописанных и с обязательными полями
+func (g *generator) contract() ([]byte, error) {
+	var b bytes.Buffer
+	g.header(&b)
+	server := g.opts.ContractServer
+	fmt.Fprintf(&b, "package %s\n\n", g.opts.Package)
+	fmt.Fprintf(&b, "import (\n\t\"bytes\"\n\t\"context\"\n\t\"encoding/json\"\n\t\"net/http/httptest\"\n\t\"strings\"\n\t\"testing\"\n\n\t%q\n)\n\n", server)
+	pkg := server[strings.LastIndex(server, "/")+1:]
+	for _, op := range g.ops {
+		fmt.Fprintf(&b, "func TestContract%s(t *testing.T) {\n", op.name)
+		fmt.Fprintf(&b, "\tsrv := httptest.NewServer(%s.New(%s.Options{}))\n\tdefer srv.Close()\n\tclient := NewClient(srv.URL)\n\n", pkg, pkg)
+		if op.request != "" {
+			literal, err := g.exampleLiteral(op)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, "\tbody := %s\n", literal)
+			b.WriteString("\tif err := body.Validate(); err != nil {\n\t\tt.Fatalf(\"example request: %v\", err)\n\t}\n")
+			fmt.Fprintf(&b, "\tresult, err := client.%s(context.Background(), body)\n", op.name)
+		} else {
+			fmt.Fprintf(&b, "\tresult, err := client.%s(context.Background())\n", op.name)
+		}
+		b.WriteString("\tif err != nil {\n\t\tt.Fatal(err)\n\t}\n")
+		b.WriteString("\tif result.StatusCode/100 != 2 {\n\t\tt.Fatalf(\"status %d: %s\", result.StatusCode, result.Body)\n\t}\n")
+		fmt.Fprintf(&b, "\tcheckContract(t, result.Body, new(%s)", goName(op.response))
+		for _, path := range g.requiredPaths(op.response, "") {
+			fmt.Fprintf(&b, ", %q", path)
+		}
+		b.WriteString(")\n}\n\n")
+	}
+	b.WriteString(contractCode)
+	return formatSource(&b)
+}
+
+// exampleLiteral возвращает пример запроса метода литералом Go
+func (g *generator) exampleLiteral(op operation) (string, error) {
+	s := g.spec.Components.Schemas[op.request]
+	var fields, extra []string
+	for _, key := range sortedKeys(op.example) {
+		value, ok := op.example[key].(string)
+		if !ok {
+			return "", fmt.Errorf("%s: example field %s must be a string", op.OperationID, key)
+		}
+		if _, known := s.Properties[key]; known {
+			fields = append(fields, fmt.Sprintf("%s: %q", goName(key), value))
+			continue
+		}
+		if s.AdditionalProperties == nil {
+			return "", fmt.Errorf("%s: example field %s is not described by %s", op.OperationID, key, op.request)
+		}
+		extra = append(extra, fmt.Sprintf("%q: %q", key, value))
+	}
+	if len(extra) > 0 {
+		fields = append(fields, "Extra: map[string]string{"+strings.Join(extra, ", ")+"}")
+	}
+	return goName(op.request) + "{\n" + strings.Join(fields, ",\n") + ",\n}", nil
+}
+
+// requiredPaths возвращает пути обязательных полей схемы через точку, спускаясь по $ref
+func (g *generator) requiredPaths(name, prefix string) []string {
+	s := g.spec.Components.Schemas[name]
+	var paths []string
+	required := append([]string(nil), s.Required...)
+	sort.Strings(required)
+	for _, prop := range required {
+		path := prefix + prop
+		paths = append(paths, path)
+		if p := s.Properties[prop]; p != nil && p.Ref != "" {
+			paths = append(paths, g.requiredPaths(refName(p.Ref), path+".")...)
+		}
+	}
+	return paths
+}
+
+// formatSource форматирует созданный код как gofmt
+func formatSource(b *bytes.Buffer) ([]byte, error) {
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w\n%s", err, b.Bytes())
+	}
+	return src, nil
+}
+
+// refName возвращает имя схемы из ссылки #/components/schemas/Name
+func refName(ref string) string {
+	return strings.TrimPrefix(ref, refPrefix)
+}
+
+// initialisms перечисляет части имен, которые в Go пишутся заглавными буквами
+var initialisms = map[string]bool{"id": true, "url": true, "api": true, "http": true, "json": true}
+
+// goName преобразует имя из описания, например indicator_to_mo_fact_id или saveFact, в имя Go
+func goName(name string) string {
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-' || r == ' ' || r == '.':
+			flush()
+		case unicode.IsUpper(r) && i > 0 && unicode.IsLower(runes[i-1]):
+			flush()
+			word = append(word, r)
+		default:
+			word = append(word, r)
+		}
+	}
+	flush()
+	var out strings.Builder
+	for _, w := range words {
+		if initialisms[w] {
+			out.WriteString(strings.ToUpper(w))
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(w)
+		out.WriteRune(unicode.ToUpper(r))
+		out.WriteString(w[size:])
+	}
+	if out.Len() == 0 || unicode.IsDigit([]rune(out.String())[0]) {
+		return "X" + out.String()
+	}
+	return out.String()
+}
+
+// lowerFirst переводит первую букву описания в нижний регистр, чтобы оно продолжало имя в комментарии
+func lowerFirst(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError {
+		return s
+	}
+	return string(unicode.ToLower(r)) + s[size:]
+}
+
+// sortedKeys возвращает ключи словаря по алфавиту
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// clientCode содержит клиента, общего для всех методов
+const clientCode = `// RequestEditorFn изменяет запрос перед отправкой, например добавляет авторизацию
+type RequestEditorFn func(ctx context.Context, req *http.Request) error
+
+// ClientOption задает дополнительную настройку клиента
+type ClientOption func(*Client)
+
+// Client вызывает методы API
+type Client struct {
+	baseURL   string
+	client    *http.Client
+	endpoints map[string]string
+	methods   map[string]string
+	editors   []RequestEditorFn
+}
+
+// NewClient создает клиента API по адресу baseURL, например https://development.kpi-drive.ru
+func NewClient(baseURL string, opts ...ClientOption) *Client {
+	c := &Client{baseURL: strings.TrimSuffix(baseURL, "/"), client: http.DefaultClient, endpoints: make(map[string]string), methods: make(map[string]string)}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithHTTPClient задает HTTP-клиент вместо http.DefaultClient
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(c *Client) {
+		c.client = client
+	}
+}
+
+// WithEndpointURL задает полный адрес метода с путем path вместо baseURL и пути
+func WithEndpointURL(path, url string) ClientOption {
+	return func(c *Client) {
+		c.endpoints[path] = url
+	}
+}
+
+// WithEndpointMethod задает HTTP-метод запросов метода API с путем path вместо описанного,
+// например GET для сервера, который не принимает тело у запросов чтения. Поля запроса GET,
+// HEAD и DELETE передаются строкой запроса, остальных - телом формы
+func WithEndpointMethod(path, method string) ClientOption {
+	return func(c *Client) {
+		c.methods[path] = strings.ToUpper(method)
+	}
+}
+
+// WithRequestEditorFn добавляет изменение каждого запроса перед отправкой
+func WithRequestEditorFn(fn RequestEditorFn) ClientOption {
+	return func(c *Client) {
+		c.editors = append(c.editors, fn)
+	}
+}
+
+// endpoint возвращает адрес метода с путем path
+func (c *Client) endpoint(path string) string {
+	if u, ok := c.endpoints[path]; ok {
+		return u
+	}
+	return c.baseURL + path
+}
+
+// newRequest создает запрос метода API с путем path и полями form методом method или заданным
+// WithEndpointMethod
+func (c *Client) newRequest(ctx context.Context, method, path string, form url.Values) (*http.Request, error) {
+	if m, ok := c.methods[path]; ok {
+		method = m
+	}
+	target := c.endpoint(path)
+	switch {
+	case form == nil:
+		return http.NewRequestWithContext(ctx, method, target, nil)
+	case method == http.MethodGet || method == http.MethodHead || method == http.MethodDelete:
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		query := u.Query()
+		for key, values := range form {
+			query[key] = append(query[key], values...)
+		}
+		u.RawQuery = query.Encode()
+		return http.NewRequestWithContext(ctx, method, u.String(), nil)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "` + formContent + `")
+	return req, nil
+}
+
+// do отправляет запрос и читает тело ответа
+func (c *Client) do(ctx context.Context, req *http.Request) (*http.Response, []byte, error) {
+	for _, edit := range c.editors {
+		if err := edit(ctx, req); err != nil {
+			return nil, nil, err
+		}
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading response body: %w", err)
+	}
+	return resp, body, nil
+}
+
+`
+
+// contractCode содержит проверку ответа, общую для контрактных тестов
+const contractCode = `// checkContract проверяет, что в ответе нет полей, не описанных схемой, и есть обязательные поля
+func checkContract(t *testing.T, body []byte, schema interface{}, required ...string) {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(schema); err != nil {
+		t.Fatalf("response does not match the spec: %v\n%s", err, body)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range required {
+		var v interface{} = doc
+		for _, key := range strings.Split(path, ".") {
+			object, _ := v.(map[string]interface{})
+			var ok bool
+			if v, ok = object[key]; !ok {
+				t.Fatalf("response lacks required field %s:\n%s", path, body)
+			}
+		}
+	}
+}
+`
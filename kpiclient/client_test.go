@@ -0,0 +1,45 @@
+package kpiclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"buffer/mockkpi"
+)
+
+func TestEndpointMethodGET(t *testing.T) {
+	fake := mockkpi.New(mockkpi.Options{})
+	var method, query, contentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, query, contentType = r.Method, r.URL.RawQuery, r.Header.Get("Content-Type")
+		fake.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	fact := SaveFactRequest{
+		PeriodStart: "2024-05-01", PeriodEnd: "2024-05-31", PeriodKey: "month",
+		IndicatorToMoID: "227373", Value: "1", FactTime: "2024-05-31",
+	}
+	if _, err := NewClient(srv.URL).SaveFact(context.Background(), fact); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(srv.URL+"/", WithEndpointMethod(GetFactsPath, "get"))
+	result, err := client.GetFacts(context.Background(), GetFactsRequest{
+		IndicatorToMoID: "227373", PeriodStart: "2024-05-01", PeriodEnd: "2024-05-31", PeriodKey: "month",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodGet || contentType != "" {
+		t.Errorf("request was %s with Content-Type %q, want GET without a body", method, contentType)
+	}
+	if want := "indicator_to_mo_id=227373&period_end=2024-05-31&period_key=month&period_start=2024-05-01"; query != want {
+		t.Errorf("query = %q, want %q", query, want)
+	}
+	if result.JSON == nil || result.JSON.Data.RowsCount != 1 {
+		t.Fatalf("GetFacts returned %s, want the saved fact", result.Body)
+	}
+}
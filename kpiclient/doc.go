@@ -0,0 +1,6 @@
+// Package kpiclient содержит типизированный клиент API KPI-Drive, созданный по api/openapi.yaml.
+// Файлы *_gen.go не редактируются вручную: после изменения описания выполните
+// go generate ./kpiclient, а TestGeneratedCodeIsUpToDate проверяет, что это сделано
+package kpiclient
+
+//go:generate go run ../cmd/apigen -spec ../api/openapi.yaml -package kpiclient -out .
@@ -0,0 +1,34 @@
+package kpiclient
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"buffer/internal/apigen"
+)
+
+// TestGeneratedCodeIsUpToDate проверяет, что созданные файлы соответствуют api/openapi.yaml
+func TestGeneratedCodeIsUpToDate(t *testing.T) {
+	spec, err := os.ReadFile("../api/openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := apigen.Generate(spec, apigen.Options{
+		Package:        "kpiclient",
+		Source:         "../api/openapi.yaml",
+		ContractServer: "buffer/mockkpi",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range files {
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s is out of date with api/openapi.yaml, run go generate ./kpiclient", name)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Package kpidrivetest запускает в тесте поддельный API KPI-Drive на httptest.Server: методы
+// save_fact и get_facts ведут себя как у mockkpi, каждый полученный запрос записывается,
+// а ответы на следующие запросы можно задать заранее, чтобы проверить повторы и очередь
+// недоставленных без сети и стенда разработки.
+//
+//	srv := kpidrivetest.NewServer(mockkpi.Options{Token: "test"})
+//	defer srv.Close()
+//	srv.RespondNext(kpidrivetest.Response{Status: http.StatusServiceUnavailable})
+//	// ... отправка фактов на srv.SaveFactURL() ...
+//	facts := srv.Facts()
+package kpidrivetest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"buffer/kpiclient"
+	"buffer/mockkpi"
+)
+
+// Request представляет запрос, полученный сервером
+type Request struct {
+	Method string
+	Path   string
+	Header http.Header
+	Fields map[string]string // поля формы, тело gzip распаковывается; у GET - параметры строки запроса
+	Time   time.Time
+}
+
+// Response задает ответ вместо обработки запроса имитацией API
+type Response struct {
+	Status int           // код ответа, по умолчанию 200
+	Error  string        // сообщение в MESSAGES.error; задано - STATUS равен ERROR
+	Body   string        // тело ответа как есть вместо ответа в формате API
+	Delay  time.Duration // задержка перед ответом
+}
+
+// apiResponse представляет ответ в формате API
+type apiResponse struct {
+	Messages struct {
+		Error   []string `json:"error"`
+		Warning []string `json:"warning"`
+		Info    []string `json:"info"`
+	} `json:"MESSAGES"`
+	Data   interface{} `json:"DATA"`
+	Status string      `json:"STATUS"`
+}
+
+// rule задает ответ на запросы, подходящие под условие
+type rule struct {
+	match    func(Request) bool
+	response Response
+	times    int // сколько раз еще применить, 0 - без ограничения
+}
+
+// Server представляет поддельный API KPI-Drive, запущенный на адресе 127.0.0.1
+type Server struct {
+	URL string // адрес сервера, например http://127.0.0.1:41234
+
+	fake   *mockkpi.Server
+	server *httptest.Server
+
+	mu       sync.Mutex
+	next     []Response
+	rules    []*rule
+	received []Request
+}
+
+// NewServer запускает поддельный API с поведением opts; сервер останавливается методом Close
+func NewServer(opts mockkpi.Options) *Server {
+	s := &Server{fake: mockkpi.New(opts)}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+	return s
+}
+
+// Close останавливает сервер
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Client создает клиента API, отправляющего запросы на сервер
+func (s *Server) Client(opts ...kpiclient.ClientOption) *kpiclient.Client {
+	return kpiclient.NewClient(s.URL, opts...)
+}
+
+// Fact возвращает факт показателя indicator за май 2024 года со всеми обязательными полями;
+// тесты меняют в нем только нужные им поля
+func Fact(indicator string) kpiclient.SaveFactRequest {
+	return kpiclient.SaveFactRequest{
+		PeriodStart: "2024-05-01", PeriodEnd: "2024-05-31", PeriodKey: "month",
+		IndicatorToMoID: indicator, Value: "1", FactTime: "2024-05-31",
+	}
+}
+
+// WithHeader добавляет заголовок к запросам клиента, например Authorization с токеном
+func WithHeader(key, value string) kpiclient.ClientOption {
+	return kpiclient.WithRequestEditorFn(func(ctx context.Context, req *http.Request) error {
+		req.Header.Set(key, value)
+		return nil
+	})
+}
+
+// SaveFactURL возвращает адрес метода save_fact
+func (s *Server) SaveFactURL() string {
+	return s.URL + mockkpi.SaveFactPath
+}
+
+// GetFactsURL возвращает адрес метода get_facts
+func (s *Server) GetFactsURL() string {
+	return s.URL + mockkpi.GetFactsPath
+}
+
+// Facts возвращает сохраненные факты в порядке первого сохранения
+func (s *Server) Facts() []mockkpi.Fact {
+	return s.fake.Facts()
+}
+
+// Stats возвращает счетчики имитации API; запросы с заданными ответами в них не учитываются
+func (s *Server) Stats() mockkpi.Stats {
+	return s.fake.Stats()
+}
+
+// Requests возвращает копию полученных запросов в порядке поступления
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.received...)
+}
+
+// RespondNext задает ответы на следующие запросы по порядку; после них запросы снова обрабатывает
+// имитация API
+func (s *Server) RespondNext(responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next = append(s.next, responses...)
+}
+
+// FailNext отвечает кодом status на следующие n запросов
+func (s *Server) FailNext(n, status int) {
+	responses := make([]Response, n)
+	for i := range responses {
+		responses[i] = Response{Status: status, Error: http.StatusText(status)}
+	}
+	s.RespondNext(responses...)
+}
+
+// RespondWhen отвечает response на запросы, для которых match возвращает true, не более times раз;
+// times 0 - на все такие запросы. Ответы RespondNext проверяются раньше
+func (s *Server) RespondWhen(match func(Request) bool, response Response, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = append(s.rules, &rule{match: match, response: response, times: times})
+}
+
+// RespondForField отвечает response на запросы, в которых поле field равно value
+func (s *Server) RespondForField(field, value string, response Response, times int) {
+	s.RespondWhen(func(r Request) bool { return r.Fields[field] == value }, response, times)
+}
+
+// Reset удаляет сохраненные факты, полученные запросы и заданные ответы
+func (s *Server) Reset() {
+	s.mu.Lock()
+	s.next, s.rules, s.received = nil, nil, nil
+	s.mu.Unlock()
+	s.fake.Reset()
+}
+
+// serveHTTP записывает запрос и отвечает заданным ответом или передает запрос имитации API
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if r.Method == http.MethodGet {
+		body = []byte(r.URL.RawQuery)
+	}
+	req := Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Header: r.Header.Clone(),
+		Fields: formFields(body, r.Header.Get("Content-Encoding") == "gzip"),
+		Time:   time.Now(),
+	}
+
+	s.mu.Lock()
+	s.received = append(s.received, req)
+	response, scripted := s.scriptedLocked(req)
+	s.mu.Unlock()
+	if !scripted {
+		s.fake.ServeHTTP(w, r)
+		return
+	}
+	time.Sleep(response.Delay)
+	if response.Status == 0 {
+		response.Status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.Status)
+	if response.Body != "" {
+		io.WriteString(w, response.Body)
+		return
+	}
+	reply := apiResponse{Data: struct{}{}, Status: "OK"}
+	reply.Messages.Info = []string{}
+	if response.Error != "" {
+		reply.Messages.Error = []string{response.Error}
+		reply.Status = "ERROR"
+	}
+	json.NewEncoder(w).Encode(reply)
+}
+
+// scriptedLocked возвращает заданный ответ на запрос, если он есть. Вызывается с захваченным s.mu
+func (s *Server) scriptedLocked(req Request) (Response, bool) {
+	if len(s.next) > 0 {
+		response := s.next[0]
+		s.next = s.next[1:]
+		return response, true
+	}
+	for i, rule := range s.rules {
+		if !rule.match(req) {
+			continue
+		}
+		if rule.times > 0 {
+			if rule.times--; rule.times == 0 {
+				s.rules = append(s.rules[:i], s.rules[i+1:]...)
+			}
+		}
+		return rule.response, true
+	}
+	return Response{}, false
+}
+
+// formFields разбирает поля формы из тела запроса
+func formFields(body []byte, gzipped bool) map[string]string {
+	if gzipped {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil
+		}
+		if body, err = io.ReadAll(zr); err != nil {
+			return nil
+		}
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil
+	}
+	fields := make(map[string]string, len(form))
+	for key := range form {
+		fields[key] = form.Get(key)
+	}
+	return fields
+}
@@ -0,0 +1,159 @@
+package kpidrivetest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"buffer/kpiclient"
+	"buffer/mockkpi"
+)
+
+// start запускает сервер и клиента к нему
+func start(t *testing.T, opts mockkpi.Options, clientOpts ...kpiclient.ClientOption) (*Server, *kpiclient.Client) {
+	t.Helper()
+	srv := NewServer(opts)
+	t.Cleanup(srv.Close)
+	return srv, srv.Client(clientOpts...)
+}
+
+// save сохраняет факт и возвращает код ответа
+func save(t *testing.T, client *kpiclient.Client, fact kpiclient.SaveFactRequest) int {
+	t.Helper()
+	result, err := client.SaveFact(context.Background(), fact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.StatusCode
+}
+
+func TestRecordsRequests(t *testing.T) {
+	srv, client := start(t, mockkpi.Options{Token: "test"}, WithHeader("Authorization", "Bearer test"))
+	if status := save(t, client, Fact("227373")); status != http.StatusOK {
+		t.Fatalf("save_fact got %d", status)
+	}
+	if _, err := client.GetFacts(context.Background(), kpiclient.GetFactsRequest{IndicatorToMoID: "227373"}); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := srv.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("%d requests recorded, want 2", len(requests))
+	}
+	r := requests[0]
+	if r.Method != http.MethodPost || r.Path != mockkpi.SaveFactPath || r.Header.Get("Authorization") != "Bearer test" || r.Time.IsZero() {
+		t.Errorf("save_fact recorded as %s %s, header %v, time %s", r.Method, r.Path, r.Header, r.Time)
+	}
+	if r.Fields["indicator_to_mo_id"] != "227373" || r.Fields["value"] != "1" {
+		t.Errorf("save_fact fields = %v", r.Fields)
+	}
+	if r := requests[1]; r.Path != mockkpi.GetFactsPath || r.Fields["indicator_to_mo_id"] != "227373" {
+		t.Errorf("get_facts recorded as %s with fields %v", r.Path, r.Fields)
+	}
+	if facts := srv.Facts(); len(facts) != 1 || facts[0].Fields["indicator_to_mo_id"] != "227373" {
+		t.Errorf("facts = %+v", facts)
+	}
+}
+
+func TestRecordsGzipBody(t *testing.T) {
+	srv, client := start(t, mockkpi.Options{}, kpiclient.WithRequestEditorFn(func(ctx context.Context, req *http.Request) error {
+		plain, err := io.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		var body bytes.Buffer
+		zw := gzip.NewWriter(&body)
+		zw.Write(plain)
+		zw.Close()
+		req.Body, req.ContentLength = io.NopCloser(&body), int64(body.Len())
+		req.Header.Set("Content-Encoding", "gzip")
+		return nil
+	}))
+	if status := save(t, client, Fact("227373")); status != http.StatusOK {
+		t.Fatalf("save_fact got %d", status)
+	}
+	if fields := srv.Requests()[0].Fields; fields["indicator_to_mo_id"] != "227373" {
+		t.Errorf("gzip body recorded as %v", fields)
+	}
+}
+
+func TestRespondNext(t *testing.T) {
+	srv, client := start(t, mockkpi.Options{})
+	srv.FailNext(2, http.StatusServiceUnavailable)
+	srv.RespondNext(Response{Status: http.StatusTooManyRequests, Error: "slow down"})
+	for i, want := range []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK} {
+		if status := save(t, client, Fact("227373")); status != want {
+			t.Fatalf("request %d got %d, want %d", i+1, status, want)
+		}
+	}
+	// Запросы с заданными ответами записываются, но не доходят до имитации API
+	if n := len(srv.Requests()); n != 4 {
+		t.Errorf("%d requests recorded, want 4", n)
+	}
+	if stats := srv.Stats(); stats.Requests != 1 || stats.Saved != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestScriptedResponseBody(t *testing.T) {
+	srv, client := start(t, mockkpi.Options{})
+	srv.RespondNext(Response{Error: "indicator is closed"}, Response{Body: `{"DATA":`})
+
+	result, err := client.SaveFact(context.Background(), Fact("227373"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StatusCode != http.StatusOK || result.JSON.Status != "ERROR" || !strings.Contains(string(result.JSON.Messages.Error), "indicator is closed") {
+		t.Errorf("scripted error answered %d %s", result.StatusCode, result.Body)
+	}
+	if _, err := client.SaveFact(context.Background(), Fact("227373")); err == nil {
+		t.Error("malformed body decoded without an error")
+	}
+}
+
+func TestRespondForField(t *testing.T) {
+	srv, client := start(t, mockkpi.Options{})
+	srv.RespondForField("indicator_to_mo_id", "1", Response{Status: http.StatusBadGateway}, 1)
+	for i, step := range []struct {
+		indicator string
+		status    int
+	}{
+		{"2", http.StatusOK},
+		{"1", http.StatusBadGateway},
+		{"1", http.StatusOK}, // правило применено заданное число раз
+	} {
+		if status := save(t, client, Fact(step.indicator)); status != step.status {
+			t.Fatalf("request %d for indicator %s got %d, want %d", i+1, step.indicator, status, step.status)
+		}
+	}
+}
+
+func TestResponseDelay(t *testing.T) {
+	srv, client := start(t, mockkpi.Options{})
+	srv.RespondNext(Response{Delay: 200 * time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.SaveFact(ctx, Fact("227373")); err == nil {
+		t.Fatal("delayed response arrived before the client deadline")
+	}
+}
+
+func TestReset(t *testing.T) {
+	srv, client := start(t, mockkpi.Options{})
+	save(t, client, Fact("227373"))
+	srv.FailNext(1, http.StatusInternalServerError)
+	srv.RespondForField("indicator_to_mo_id", "227373", Response{Status: http.StatusBadGateway}, 0)
+	srv.Reset()
+
+	if len(srv.Requests()) != 0 || len(srv.Facts()) != 0 {
+		t.Fatalf("reset kept %d requests and %d facts", len(srv.Requests()), len(srv.Facts()))
+	}
+	if status := save(t, client, Fact("227373")); status != http.StatusOK {
+		t.Fatalf("scripted responses survived reset: %d", status)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// latencyBuckets содержит верхние границы корзин гистограммы задержек
+var latencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// LatencyHistogram накапливает распределение задержек попыток отправки
+type LatencyHistogram struct {
+	mu     sync.Mutex
+	counts []uint64 // последняя корзина - значения больше максимальной границы
+	total  uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+// NewLatencyHistogram создает пустую гистограмму задержек
+func NewLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{counts: make([]uint64, len(latencyBuckets)+1)}
+}
+
+// Observe добавляет значение задержки в гистограмму
+func (h *LatencyHistogram) Observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	h.counts[i]++
+	h.total++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// LatencySnapshot представляет согласованный срез состояния гистограммы
+type LatencySnapshot struct {
+	Buckets []time.Duration
+	Counts  []uint64 // по одной на корзину плюс корзина переполнения, не накопительные
+	Count   uint64
+	Sum     time.Duration
+	Max     time.Duration
+}
+
+// Snapshot возвращает копию текущего состояния гистограммы
+func (h *LatencyHistogram) Snapshot() LatencySnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	counts := make([]uint64, len(h.counts))
+	copy(counts, h.counts)
+	return LatencySnapshot{Buckets: latencyBuckets, Counts: counts, Count: h.total, Sum: h.sum, Max: h.max}
+}
+
+// Quantile оценивает квантиль q (от 0 до 1) линейной интерполяцией внутри корзины
+func (s LatencySnapshot) Quantile(q float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := q * float64(s.Count)
+	var seen uint64
+	for i, c := range s.Counts {
+		if c == 0 {
+			continue
+		}
+		if float64(seen+c) >= rank {
+			var lower, upper time.Duration
+			if i > 0 {
+				lower = s.Buckets[i-1]
+			}
+			if i < len(s.Buckets) {
+				upper = s.Buckets[i]
+			} else {
+				upper = s.Max
+			}
+			if upper > s.Max {
+				upper = s.Max
+			}
+			if upper < lower {
+				return upper
+			}
+			frac := (rank - float64(seen)) / float64(c)
+			return lower + time.Duration(frac*float64(upper-lower))
+		}
+		seen += c
+	}
+	return s.Max
+}
+
+// Mean возвращает среднюю задержку
+func (s LatencySnapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
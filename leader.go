@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// LeaderConfig задает выбор лидера среди реплик демона, работающих с общей очередью в Redis.
+// Отправляет только лидер, а при его отказе лидерство переходит к другой реплике
+type LeaderConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	URL         string        `yaml:"url"`          // Redis для блокировки, по умолчанию queue.redis.url
+	PasswordEnv string        `yaml:"password_env"` // переменная окружения с паролем, по умолчанию queue.redis.password_env
+	Key         string        `yaml:"key"`          // ключ блокировки, по умолчанию buffer:leader
+	TTL         time.Duration `yaml:"ttl"`          // срок блокировки без продления, по умолчанию 15s
+}
+
+// validate проверяет настройки выбора лидера; реплики обмениваются элементами через queue
+func (c LeaderConfig) validate(queue RedisQueueConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+	if !queue.enabled() {
+		return fmt.Errorf("requires queue.redis.url")
+	}
+	if c.URL != "" {
+		if _, err := newRedisClient(c.URL, ""); err != nil {
+			return fmt.Errorf("url: %w", err)
+		}
+	}
+	if c.TTL < 0 || c.TTL > 0 && c.TTL < time.Second {
+		return fmt.Errorf("ttl must be at least 1s")
+	}
+	return nil
+}
+
+// Сценарии продлевают и снимают блокировку, только если ее держит эта реплика
+const (
+	renewScript   = `if redis.call('get', KEYS[1]) == ARGV[1] then return redis.call('pexpire', KEYS[1], ARGV[2]) else return 0 end`
+	releaseScript = `if redis.call('get', KEYS[1]) == ARGV[1] then return redis.call('del', KEYS[1]) else return 0 end`
+)
+
+// LeaderElector выбирает лидера блокировкой в Redis: ключ с именем реплики и сроком TTL. Лидер
+// продлевает блокировку каждую треть срока, а остальные реплики пытаются ее занять. Лидерство
+// действует половину срока от последнего продления: по его истечении таймер реплики завершает
+// лидерство сам, даже если Redis не отвечает, чтобы две реплики не отправляли одновременно
+type LeaderElector struct {
+	client  *redisClient
+	clock   Clock
+	key     string
+	token   string // имя реплики в блокировке: узел, процесс и случайная часть
+	ttl     time.Duration
+	leader  atomic.Bool
+	expires atomic.Int64 // срок лидерства в наносекундах Unix
+}
+
+// NewLeaderElector подключается к Redis из c или, если адрес не задан, к Redis общей очереди
+// queue. Сроки блокировки отсчитываются по clock
+func NewLeaderElector(c LeaderConfig, queue RedisQueueConfig, clock Clock) (*LeaderElector, error) {
+	if c.URL == "" {
+		c.URL, c.PasswordEnv = queue.URL, queue.PasswordEnv
+	}
+	client, err := newRedisClient(c.URL, c.PasswordEnv)
+	if err != nil {
+		return nil, err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	e := &LeaderElector{
+		client: client,
+		clock:  clock,
+		key:    c.Key,
+		token:  host + "-" + strconv.Itoa(os.Getpid()) + "-" + newRequestID()[:8],
+		ttl:    c.TTL,
+	}
+	if e.key == "" {
+		e.key = "buffer:leader"
+	}
+	if e.ttl <= 0 {
+		e.ttl = 15 * time.Second
+	}
+	return e, nil
+}
+
+// Leader сообщает, является ли реплика лидером
+func (e *LeaderElector) Leader() bool {
+	return e.leader.Load()
+}
+
+// Run участвует в выборах, пока ctx не завершится. Став лидером, реплика вызывает lead в
+// отдельной горутине; ctx, переданный lead, завершается при потере лидерства, и Run ждет
+// возврата lead, прежде чем снова бороться за блокировку. first задан, если реплика заняла
+// блокировку при первой попытке, то есть стала лидером при запуске. При завершении ctx лидер
+// дожидается lead и снимает блокировку, чтобы другая реплика сразу ее заняла
+func (e *LeaderElector) Run(ctx context.Context, lead func(ctx context.Context, first bool)) {
+	ticker := e.clock.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	var resign context.CancelFunc
+	var done chan struct{}
+	var renewErr error
+	stepDown := func(reason string) {
+		resign()
+		<-done
+		resign, done = nil, nil
+		e.leader.Store(false)
+		fmt.Printf("Lost leadership: %s, standing by\n", reason)
+	}
+	first, failing := true, false
+	for {
+		// Срок отсчитывается от отправки команды: Redis мог продлить блокировку в любой момент до ответа
+		start := e.clock.Now()
+		if resign == nil {
+			ok, err := e.acquire()
+			switch {
+			case err != nil:
+				if !failing {
+					fmt.Printf("Error acquiring leader lock %s: %v\n", e.key, err)
+				}
+				failing = true
+			case ok:
+				failing, renewErr = false, nil
+				e.expires.Store(start.Add(e.ttl / 2).UnixNano())
+				e.leader.Store(true)
+				logInfof("Became leader, holding lock %s", e.key)
+				var leadCtx context.Context
+				leadCtx, resign = context.WithCancel(ctx)
+				done = make(chan struct{})
+				go e.guard(leadCtx, resign)
+				go func(first bool) {
+					defer close(done)
+					lead(leadCtx, first)
+				}(first)
+			case first:
+				failing = false
+				holder, _ := e.client.doWithin(e.ttl/4, "GET", e.key)
+				logInfof("Standing by, leader lock %s is held by %v", e.key, holder)
+			default:
+				failing = false
+			}
+			if err == nil {
+				first = false
+			}
+		} else {
+			ok, err := e.renew()
+			switch {
+			case err == nil && ok:
+				renewErr = nil
+				e.expires.Store(start.Add(e.ttl / 2).UnixNano())
+			case err == nil:
+				stepDown(fmt.Sprintf("lock %s is held by another replica", e.key))
+			default:
+				// Лидерство завершит guard, если блокировку не удастся продлить до срока
+				renewErr = err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if resign != nil {
+				resign()
+				<-done
+				e.release()
+				e.leader.Store(false)
+			}
+			return
+		case <-ticker.C():
+		case <-done:
+			reason := fmt.Sprintf("lock %s is not renewed in %s", e.key, e.ttl/2)
+			if renewErr != nil {
+				reason += ": " + renewErr.Error()
+			}
+			stepDown(reason)
+		}
+	}
+}
+
+// guard завершает лидерство resign, когда наступает срок e.expires, независимо от ответов Redis.
+// Продления сдвигают срок, поэтому при срабатывании таймера срок проверяется заново
+func (e *LeaderElector) guard(ctx context.Context, resign context.CancelFunc) {
+	for {
+		expires := time.Unix(0, e.expires.Load())
+		timer := e.clock.NewTimer(expires.Sub(e.clock.Now()))
+		select {
+		case <-timer.C():
+			if !e.clock.Now().Before(time.Unix(0, e.expires.Load())) {
+				resign()
+				return
+			}
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// acquire занимает свободную блокировку. Команды выборов ждут ответа не дольше четверти срока,
+// чтобы продление успевало до истечения лидерства
+func (e *LeaderElector) acquire() (bool, error) {
+	reply, err := e.client.doWithin(e.ttl/4, "SET", e.key, e.token, "NX", "PX", e.ttlMillis())
+	return reply == "OK", err
+}
+
+// renew продлевает блокировку и сообщает false, если ее держит уже не эта реплика
+func (e *LeaderElector) renew() (bool, error) {
+	reply, err := e.client.doWithin(e.ttl/4, "EVAL", renewScript, "1", e.key, e.token, e.ttlMillis())
+	return reply == int64(1), err
+}
+
+// release снимает блокировку этой реплики
+func (e *LeaderElector) release() {
+	if _, err := e.client.doWithin(e.ttl/4, "EVAL", releaseScript, "1", e.key, e.token); err != nil {
+		fmt.Printf("Error releasing leader lock %s: %v\n", e.key, err)
+	}
+}
+
+// ttlMillis возвращает срок блокировки в миллисекундах для команд Redis
+func (e *LeaderElector) ttlMillis() string {
+	return strconv.FormatInt(e.ttl.Milliseconds(), 10)
+}
+
+// Close закрывает соединение с Redis
+func (e *LeaderElector) Close() error {
+	return e.client.Close()
+}
+
+// setStandby переводит буфер в резерв, если задана общая очередь queue: новые элементы не
+// отправляются, а передаются через нее лидеру. nil возвращает буфер к отправке. Элементы,
+// добавленные раньше, буфер отправляет сам
+func (b *Buffer) setStandby(queue *RedisQueue) {
+	b.standby.Store(queue)
+}
+
+// forward передает элемент резервной реплики лидеру через общую очередь queue. Обработчикам
+// результата сообщается nil, когда элемент принят очередью, а не API
+func (b *Buffer) forward(queue *RedisQueue, item *queuedItem) error {
+	if _, err := queue.push(item); err != nil {
+		logDebugf("Item %d is not forwarded to %s: %v", item.id, queue.stream, err)
+		err = fmt.Errorf("forwarding to the leader: %w", err)
+		item.finish(err)
+		return err
+	}
+	b.forwarded.Add(1)
+	logDebugf("Item %d forwarded to %s", item.id, queue.stream)
+	item.finish(nil)
+	return nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockServer отвечает на команды блокировки лидера как Redis: SET NX, GET и сценарии EVAL.
+// Срок ключа не отслеживается, его заменяет owner
+type lockServer struct {
+	ln net.Listener
+
+	mu     sync.Mutex
+	owner  string        // значение ключа блокировки, пустое - ключа нет
+	renews int           // успешных продлений
+	gets   int           // запросов владельца блокировки
+	stall  chan struct{} // пока задан, EVAL не отвечает до его закрытия
+}
+
+func newLockServer(t *testing.T) *lockServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &lockServer{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		s.mu.Lock()
+		if s.stall != nil {
+			close(s.stall)
+			s.stall = nil
+		}
+		s.mu.Unlock()
+	})
+	return s
+}
+
+func (s *lockServer) config() LeaderConfig {
+	return LeaderConfig{Enabled: true, URL: "redis://" + s.ln.Addr().String(), TTL: 3 * time.Second}
+}
+
+func (s *lockServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if _, err := io.WriteString(conn, s.handle(args)); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand читает команду RESP как массив строк
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func (s *lockServer) handle(args []string) string {
+	s.mu.Lock()
+	if stall := s.stall; stall != nil && args[0] == "EVAL" {
+		s.mu.Unlock()
+		<-stall
+		s.mu.Lock()
+	}
+	defer s.mu.Unlock()
+	switch args[0] {
+	case "SET":
+		if s.owner != "" {
+			return "$-1\r\n"
+		}
+		s.owner = args[2]
+		return "+OK\r\n"
+	case "GET":
+		s.gets++
+		if s.owner == "" {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(s.owner), s.owner)
+	case "EVAL":
+		if s.owner != args[4] {
+			return ":0\r\n"
+		}
+		if strings.Contains(args[1], "pexpire") {
+			s.renews++
+		} else {
+			s.owner = ""
+		}
+		return ":1\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func (s *lockServer) state() (owner string, renews int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.owner, s.renews
+}
+
+// waitFor ждет выполнения условия, которое наступает после ответа сервера в другой горутине
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// leaderRun представляет запущенные выборы и сообщает о каждом избрании
+type leaderRun struct {
+	elector *LeaderElector
+	stop    context.CancelFunc
+	stopped chan struct{}
+	started chan bool // first каждого избрания
+}
+
+func startElection(t *testing.T, cfg LeaderConfig, clock Clock) *leaderRun {
+	e, err := NewLeaderElector(cfg, RedisQueueConfig{}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	run := &leaderRun{elector: e, stop: stop, stopped: make(chan struct{}), started: make(chan bool, 10)}
+	go func() {
+		defer close(run.stopped)
+		e.Run(ctx, func(ctx context.Context, first bool) {
+			run.started <- first
+			<-ctx.Done()
+		})
+	}()
+	t.Cleanup(func() {
+		stop()
+		<-run.stopped
+		e.Close()
+	})
+	return run
+}
+
+func TestLeaderAcquireAndRelease(t *testing.T) {
+	SetLogLevel(LevelError)
+	s := newLockServer(t)
+	clock := NewFakeClock(time.Unix(0, 0))
+	a := startElection(t, s.config(), clock)
+	if first := <-a.started; !first {
+		t.Error("replica elected at startup is not reported as first")
+	}
+	if owner, _ := s.state(); owner != a.elector.token || !a.elector.Leader() {
+		t.Fatalf("lock owner %q, want %q", owner, a.elector.token)
+	}
+
+	// Не заняв блокировку при запуске, реплика спрашивает, кто ее держит
+	b := startElection(t, s.config(), clock)
+	waitFor(t, "standby", func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.gets == 1
+	})
+	if b.elector.Leader() {
+		t.Fatal("second replica took a held lock")
+	}
+
+	// Остановленный лидер снимает блокировку, и резервная реплика занимает ее на следующем тике
+	a.stop()
+	<-a.stopped
+	if owner, _ := s.state(); owner != "" {
+		t.Fatalf("lock is still held by %q after shutdown", owner)
+	}
+	clock.Advance(time.Second)
+	if first := <-b.started; first {
+		t.Error("replica elected on failover is reported as first")
+	}
+	if !b.elector.Leader() {
+		t.Fatal("standby replica did not take the released lock")
+	}
+}
+
+func TestLeaderRenew(t *testing.T) {
+	SetLogLevel(LevelError)
+	s := newLockServer(t)
+	clock := NewFakeClock(time.Unix(0, 0))
+	a := startElection(t, s.config(), clock)
+	<-a.started
+	// Продления каждую секунду сдвигают срок лидерства в 1.5s дальше его начального срока
+	for i := 1; i <= 3; i++ {
+		clock.BlockUntil(2)
+		clock.Advance(time.Second)
+		want := time.Unix(0, 0).Add(time.Duration(i)*time.Second + 1500*time.Millisecond).UnixNano()
+		waitFor(t, "lease extension", func() bool { return a.elector.expires.Load() == want })
+	}
+	if _, renews := s.state(); renews != 3 || !a.elector.Leader() {
+		t.Fatalf("renews %d, leader %t", renews, a.elector.Leader())
+	}
+}
+
+func TestLeaderStepsDownWhenLockIsTaken(t *testing.T) {
+	SetLogLevel(LevelError)
+	s := newLockServer(t)
+	clock := NewFakeClock(time.Unix(0, 0))
+	a := startElection(t, s.config(), clock)
+	<-a.started
+	s.mu.Lock()
+	s.owner = "other"
+	s.mu.Unlock()
+	clock.BlockUntil(2)
+	clock.Advance(time.Second)
+	waitFor(t, "step-down", func() bool { return !a.elector.Leader() })
+}
+
+func TestLeaderStepsDownWhenRedisStalls(t *testing.T) {
+	SetLogLevel(LevelError)
+	s := newLockServer(t)
+	clock := NewFakeClock(time.Unix(0, 0))
+	e, err := NewLeaderElector(s.config(), RedisQueueConfig{}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	resigned := make(chan struct{})
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go e.Run(ctx, func(ctx context.Context, first bool) {
+		<-ctx.Done()
+		close(resigned)
+	})
+	waitFor(t, "election", e.Leader)
+
+	// Продление зависает, а срок лидерства истекает по часам реплики без ответа Redis
+	stall := make(chan struct{})
+	s.mu.Lock()
+	s.stall = stall
+	s.mu.Unlock()
+	clock.BlockUntil(2)
+	clock.Advance(time.Second)
+	clock.Advance(600 * time.Millisecond)
+	select {
+	case <-resigned:
+	case <-time.After(time.Second):
+		t.Fatal("leadership did not end when the lease expired")
+	}
+	if _, renews := s.state(); renews != 0 {
+		t.Fatalf("renew finished before step-down: %d renews", renews)
+	}
+	s.mu.Lock()
+	s.stall = nil
+	s.mu.Unlock()
+	close(stall)
+	waitFor(t, "step-down", func() bool { return !e.Leader() })
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// runLoadTest выполняет команду loadtest: отправляет синтетические факты с заданной частотой
+// и выводит достигнутую пропускную способность, задержки и долю ошибок
+func runLoadTest(cfg *Config, res *Result, args []string) error {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: buffer loadtest [flags]")
+		fmt.Fprintln(fs.Output(), "Sends synthetic facts built from the config template; point it at a mock or staging endpoint.")
+		fs.PrintDefaults()
+	}
+	rate := fs.Float64("rate", 10, "facts generated per second")
+	duration := fs.Duration("duration", 30*time.Second, "how long to generate facts")
+	apiURL := fs.String("url", cfg.API.SaveFactURL, "save_fact endpoint to load")
+	maxInFlight := fs.Int("max-in-flight", cfg.HTTP.MaxInFlight, "concurrent requests, overrides http.max_in_flight")
+	shards := fs.Int("shards", cfg.Queue.Shards, "queue shards by indicator, overrides queue.shards")
+	indicators := fs.String("indicators", "", "comma-separated indicator_to_mo_id values to spread facts over (default from the template)")
+	mock := fs.Bool("mock", false, "load an in-process mock API instead of -url")
+	mockOpts := mockFlags(fs, "mock-")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *rate <= 0 || *duration <= 0 {
+		return fmt.Errorf("%w: -rate and -duration must be positive", errUsage)
+	}
+	if *shards < 0 {
+		return fmt.Errorf("%w: -shards must not be negative", errUsage)
+	}
+	keys := []string{cfg.Template["indicator_to_mo_id"]}
+	if *indicators != "" {
+		keys = strings.Split(*indicators, ",")
+	}
+	if keys[0] == "" {
+		return fmt.Errorf("%w: set template.indicator_to_mo_id or -indicators", errUsage)
+	}
+
+	cfg.API.SaveFactURL = *apiURL
+	cfg.HTTP.MaxInFlight = *maxInFlight
+	cfg.Queue.Shards = *shards
+	if *mock {
+		server, err := startLoadTestMock(cfg, *mockOpts)
+		if err != nil {
+			return err
+		}
+		defer server.Close()
+		defer printMockStats(server)
+		*apiURL = cfg.API.SaveFactURL
+	}
+	buffer, closeBuffer, err := cfg.NewBuffer()
+	if err != nil {
+		return fmt.Errorf("creating buffer: %w", err)
+	}
+	defer closeBuffer()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Задержка измеряется от добавления элемента до результата доставки, включая ожидание в очереди
+	// и повторные попытки
+	latency := NewLatencyHistogram()
+	var sent, failed atomic.Int64
+	interval := time.Duration(float64(time.Second) / *rate)
+	logInfof("Load test: %.1f facts/s for %s against %s", *rate, *duration, *apiURL)
+
+	start := time.Now()
+	generated := 0
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+generate:
+	for at := start; at.Sub(start) < *duration; at = at.Add(interval) {
+		if wait := time.Until(at); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				fmt.Println("Interrupted, waiting for queued facts")
+				break generate
+			}
+		}
+		added := time.Now()
+		item := newQueuedItem(loadTestFact(cfg, keys[generated%len(keys)], generated+1), nil)
+		item.done = func(err error) {
+			latency.Observe(time.Since(added))
+			if err != nil {
+				failed.Add(1)
+				return
+			}
+			sent.Add(1)
+		}
+		buffer.enqueue(item)
+		generated++
+	}
+	// Последний факт добавляется на интервал раньше окончания, поэтому частота считается по всей
+	// длительности, если генерацию не прервали
+	generating := *duration
+	if ctx.Err() != nil {
+		generating = time.Since(start)
+	}
+	buffer.Flush()
+	elapsed := time.Since(start)
+
+	res.Read, res.Sent, res.Failed = generated, int(sent.Load()), int(failed.Load())
+	snapshot := latency.Snapshot()
+	requests := buffer.Stats()
+	var errorRate float64
+	if generated > 0 {
+		errorRate = float64(res.Failed) / float64(generated)
+	}
+	fmt.Printf("Generated %d facts in %s (target %.1f/s, achieved %.1f/s), last result after %s\n",
+		generated, generating.Round(time.Millisecond), *rate, float64(generated)/generating.Seconds(), elapsed.Round(time.Millisecond))
+	fmt.Printf("Delivered %d, failed %d (%.2f%% errors), throughput %.1f/s\n",
+		res.Sent, res.Failed, 100*errorRate, float64(res.Sent)/elapsed.Seconds())
+	fmt.Printf("Latency p50 %s, p95 %s, p99 %s, max %s\n",
+		snapshot.Quantile(0.50).Round(time.Millisecond), snapshot.Quantile(0.95).Round(time.Millisecond),
+		snapshot.Quantile(0.99).Round(time.Millisecond), snapshot.Max.Round(time.Millisecond))
+	fmt.Printf("Requests %d, failed attempts %d, request latency p50 %s, p99 %s\n",
+		requests.Attempts, requests.Failed, requests.LatencyP50.Round(time.Millisecond), requests.LatencyP99.Round(time.Millisecond))
+	if res.Failed > 0 {
+		return fmt.Errorf("%w: %d of %d facts failed", errItemsFailed, res.Failed, generated)
+	}
+	return nil
+}
+
+// loadTestFact строит синтетический факт: поля шаблона из настроек, а если их нет - текущий месяц
+func loadTestFact(cfg *Config, indicator string, n int) map[string]string {
+	now := time.Now().In(cfg.Time.Location())
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	fact := map[string]string{
+		"period_start": monthStart.Format("2006-01-02"),
+		"period_end":   monthStart.AddDate(0, 1, -1).Format("2006-01-02"),
+		"period_key":   "month",
+		"fact_time":    now.Format("2006-01-02"),
+		"is_plan":      "0",
+	}
+	for key, value := range cfg.Item(map[string]string{
+		"indicator_to_mo_id": indicator,
+		"value":              strconv.Itoa(n),
+		"comment":            "buffer loadtest",
+	}) {
+		fact[key] = value
+	}
+	return fact
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Уровни журналирования; сообщения об ошибках выводятся всегда
+const (
+	LevelDebug int32 = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// logLevel хранит текущий уровень журналирования
+var logLevel atomic.Int32
+
+func init() {
+	logLevel.Store(LevelInfo)
+}
+
+// SetLogLevel устанавливает уровень журналирования
+func SetLogLevel(level int32) {
+	logLevel.Store(level)
+}
+
+// ParseLogLevel разбирает название уровня журналирования: debug, info, warn или error
+func ParseLogLevel(name string) (int32, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level: %s", name)
+	}
+}
+
+// logDebugf выводит подробное сообщение, если включен уровень debug
+func logDebugf(format string, args ...interface{}) {
+	if logLevel.Load() <= LevelDebug {
+		fmt.Printf(format+"\n", args...)
+	}
+}
+
+// logInfof выводит информационное сообщение, если уровень не выше info
+func logInfof(format string, args ...interface{}) {
+	if logLevel.Load() <= LevelInfo {
+		fmt.Printf(format+"\n", args...)
+	}
+}
+
+// logWarnf выводит предупреждение, если уровень не выше warn
+func logWarnf(format string, args ...interface{}) {
+	if logLevel.Load() <= LevelWarn {
+		fmt.Printf("Warning: "+format+"\n", args...)
+	}
+}
@@ -1,222 +1,167 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
 	"os"
 	"strings"
-	"sync"
+	"time"
 )
 
-// Buffer представляет буфер для хранения данных и их последующей отправки на API
-type Buffer struct {
-	data      []map[string]string
-	mu        sync.Mutex
-	cond      *sync.Cond
-	url       string
-	token     string
-	isSending bool
-	wg        *sync.WaitGroup
-}
-
-// Messages представляет структуру сообщений в ответе API
-type Messages struct {
-	Error   interface{} `json:"error"`
-	Warning interface{} `json:"warning"`
-	Info    []string    `json:"info"`
-}
+// defaultConfigPath задает файл настроек, читаемый при запуске, если он существует
+// и другой файл не указан в -config или BUFFER_CONFIG
+const defaultConfigPath = "buffer.yaml"
 
-// Data представляет структуру данных в ответе API
-type Data struct {
-	IndicatorToMoFactID int `json:"indicator_to_mo_fact_id"`
+// command описывает подкоманду командной строки
+type command struct {
+	name        string
+	description string
+	run         func(cfg *Config, res *Result, args []string) error
 }
 
-// APIResponse представляет структуру ответа API
-type APIResponse struct {
-	Messages Messages `json:"MESSAGES"`
-	Data     Data     `json:"DATA"`
-	Status   string   `json:"STATUS"`
+// commands перечисляет подкоманды в порядке вывода в справке
+var commands = []command{
+	{"send", "send one fact given as field=value pairs", runSend},
+	{"enqueue", "add one fact to the shared Redis queue for the daemon to send", runEnqueue},
+	{"import", "import facts from CSV, JSON Lines or XLSX files", runImport},
+	{"sync", "send only the rows of configured sources changed since the last sync", runSync},
+	{"validate", "check CSV, JSON Lines or XLSX files without sending anything", runValidate},
+	{"get-facts", "query facts from the API", runGetFacts},
+	{"check", "verify DNS, TLS, token and storage before a deployment", runCheck},
+	{"daemon", "run the buffer with the admin API until interrupted", runDaemon},
+	{"service", "install and control the daemon as a Windows service", runService},
+	{"stats", "print queue depth, throughput, failures and circuit state of a running daemon", runStats},
+	{"pause", "pause delivery in a running daemon, items keep queueing", controlCommand("pause")},
+	{"resume", "resume delivery in a running daemon", controlCommand("resume")},
+	{"flush", "send everything queued in a running daemon now and wait for it", controlCommand("flush")},
+	{"dlq", "list, show, requeue or purge dead letters", runDLQ},
+	{"replay", "re-send payloads recorded in an audit log", runReplay},
+	{"top", "live dashboard of a running daemon", runTop},
+	{"mock-server", "serve an in-memory imitation of the KPI-Drive API for tests", runMockServer},
+	{"loadtest", "send synthetic facts at a target rate and report throughput and latency", runLoadTest},
 }
 
-// NewBuffer создает новый буфер с заданными URL и токеном
-func NewBuffer(apiURL, token string) *Buffer {
-	b := &Buffer{
-		data:  make([]map[string]string, 0),
-		url:   apiURL,
-		token: token,
-		wg:    &sync.WaitGroup{},
-	}
-	b.cond = sync.NewCond(&b.mu)
-	return b
+// main функция программы
+func main() {
+	os.Exit(run(os.Args[1:]))
 }
 
-// Add добавляет новый элемент в буфер и запускает отправку данных, если она не выполняется
-func (b *Buffer) Add(item map[string]string) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	b.data = append(b.data, item)
-	if !b.isSending {
-		b.isSending = true
-		b.wg.Add(1)
-		go b.sendData()
+// run разбирает общие флаги, загружает настройки и выполняет подкоманду; возвращает код завершения.
+// С флагом -json весь текстовый вывод направляется в stderr, а в stdout выводится только итог команды
+func run(args []string) int {
+	global := flag.NewFlagSet("buffer", flag.ContinueOnError)
+	configPath := global.String("config", "", "config file (default $BUFFER_CONFIG or "+defaultConfigPath+")")
+	profile := global.String("profile", os.Getenv("BUFFER_PROFILE"), "config profile to use, e.g. dev, staging or prod")
+	tenant := global.String("tenant", os.Getenv("BUFFER_TENANT"), "tenant from the config to work with, by default the daemon serves all tenants")
+	dryRun := global.Bool("dry-run", false, "log the requests that would be sent without calling the API")
+	jsonOutput := global.Bool("json", false, "print the command result as JSON to stdout, everything else to stderr")
+	global.Usage = func() { printUsage(global) }
+	if err := global.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
 	}
-}
-
-// sendData отправляет данные из буфера на API
-func (b *Buffer) sendData() {
-	defer b.wg.Done()
-	b.mu.Lock()
-	if len(b.data) == 0 {
-		b.isSending = false
-		b.mu.Unlock()
-		return
-	}
-	item := b.data[0]
-	b.data = b.data[1:]
-	b.mu.Unlock()
-
-	b.sendToAPI(item)
+	args = global.Args()
 
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	if len(b.data) == 0 {
-		b.isSending = false
-	} else {
-		b.wg.Add(1)
-		go b.sendData()
+	stdout := os.Stdout
+	if *jsonOutput {
+		os.Stdout = os.Stderr
 	}
-}
-
-// sendToAPI выполняет отправку одного элемента данных на API
-func (b *Buffer) sendToAPI(data map[string]string) {
-	client := &http.Client{}
-	formData := url.Values{}
-	for key, value := range data {
-		formData.Set(key, value)
+	result := &Result{Command: "interactive", start: time.Now()}
+	if len(args) > 0 {
+		result.Command = args[0]
 	}
-
-	req, err := http.NewRequest("POST", b.url, bytes.NewBufferString(formData.Encode()))
-	if err != nil {
-		fmt.Println("Error creating request:", err)
-		return
+	code := runCommand(global, args, result, *configPath, *profile, *tenant, *dryRun)
+	if *jsonOutput {
+		result.ExitCode, result.OK = code, code == exitOK
+		if err := result.write(stdout); err != nil {
+			fmt.Println("Error writing result:", err)
+		}
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Authorization", "Bearer "+b.token)
+	return code
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("Error sending request:", err)
-		return
+// runCommand загружает настройки и выполняет подкоманду, заполняя result
+func runCommand(global *flag.FlagSet, args []string, result *Result, configPath, profile, tenant string, dryRun bool) int {
+	cfg, err := loadConfig(configPath, profile)
+	if err == nil && tenant != "" {
+		cfg, err = cfg.ForTenant(tenant)
 	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Println("Error reading response body:", err)
-		return
+		fmt.Println("Error loading config:", err)
+		result.finish(err)
+		return exitError
 	}
-
-	var response APIResponse
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		fmt.Println("Error unmarshalling JSON:", err)
-		return
+	cfg.DryRun = cfg.DryRun || dryRun
+	level, _ := ParseLogLevel(cfg.Logging.Level)
+	SetLogLevel(level)
+	if err := cfg.ApplyTLSPolicy(); err != nil {
+		fmt.Println("Error loading config:", err)
+		result.finish(err)
+		return exitError
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println("Error response from API:", resp.Status)
-		return
+	if cfg.DryRun {
+		logInfof("Dry run: nothing will be sent to %s", cfg.API.SaveFactURL)
 	}
-
-	fmt.Println("Data successfully sent to API", string(body))
-}
-
-// getFacts отправляет запрос на получение данных с сервера
-func getFacts(apiURL, token string, params map[string]string) {
-	client := &http.Client{}
-	formData := url.Values{}
-	for key, value := range params {
-		formData.Set(key, value)
+	if cfg.Profile() != "" {
+		logInfof("Using profile %s: %s", cfg.Profile(), cfg.API.SaveFactURL)
 	}
-
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBufferString(formData.Encode()))
-	if err != nil {
-		fmt.Println("Error creating request:", err)
-		return
+	if cfg.Tenant() != "" {
+		logInfof("Using tenant %s: %s", cfg.Tenant(), cfg.API.SaveFactURL)
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Authorization", "Bearer "+token)
 
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("Error sending request:", err)
-		return
+	if len(args) == 0 {
+		err = runInteractive(cfg)
+	} else {
+		cmd, ok := findCommand(args[0])
+		if !ok {
+			fmt.Printf("Unknown command %q\n\n", args[0])
+			printUsage(global)
+			result.finish(fmt.Errorf("%w: unknown command %q", errUsage, args[0]))
+			return result.ExitCode
+		}
+		err = cmd.run(cfg, result, args[1:])
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println("Error response from API:", resp.Status)
-		return
+	result.finish(err)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Println("Error:", err)
 	}
+	return result.ExitCode
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println("Error reading response body:", err)
-		return
+// loadConfig выбирает файл настроек: явно указанный во флаге или BUFFER_CONFIG обязан существовать
+func loadConfig(path, profile string) (*Config, error) {
+	required := path != ""
+	if !required {
+		path, required = os.LookupEnv("BUFFER_CONFIG")
 	}
-
-	fmt.Println(string(body))
+	if !required {
+		path = defaultConfigPath
+	}
+	return ResolveConfig(path, required, profile, os.Environ())
 }
 
-// main функция программы
-func main() {
-	buffer := NewBuffer("https://development.kpi-drive.ru/_api/facts/save_fact", "48ab34464a5573519725deb5865cc74c")
-
-	// Добавление 10 записей в буфер
-	for i := 0; i < 10; i++ {
-		data := map[string]string{
-			"period_start":            "2024-05-01",
-			"period_end":              "2024-05-31",
-			"period_key":              "month",
-			"indicator_to_mo_id":      "227373",
-			"indicator_to_mo_fact_id": "0",
-			"value":                   fmt.Sprintf("%d", i+1),
-			"fact_time":               "2024-05-31",
-			"is_plan":                 "0",
-			"auth_user_id":            "40",
-			"comment":                 "buffer Last_name",
+// findCommand ищет подкоманду по имени
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
 		}
-		buffer.Add(data)
 	}
+	return command{}, false
+}
 
-	// Параметры для запроса получения данных
-	// params := map[string]string{
-	// 	"period_start":       "2024-05-01",
-	// 	"period_end":         "2024-05-31",
-	// 	"period_key":         "month",
-	// 	"indicator_to_mo_id": "227373",
-	// }
-
-	// Выполнение запроса получения данных
-	// getFacts("https://development.kpi-drive.ru/_api/indicators/get_facts", "48ab34464a5573519725deb5865cc74c", params)
-
-	// Ожидание завершения всех отправок данных
-	buffer.wg.Wait()
-
-	// Ожидание ввода пользователя для завершения программы
-	reader := bufio.NewReader(os.Stdin)
-	for {
-		fmt.Println("Введите 'exit' для завершения программы:")
-		input, _ := reader.ReadString('\n')
-		input = strings.TrimSpace(input)
-		if input == "exit" {
-			fmt.Println("Завершение программы...")
-			break
-		}
-	}
+// printUsage выводит общую справку по командам
+func printUsage(global *flag.FlagSet) {
+	var out strings.Builder
+	out.WriteString("Usage: buffer [-config file] [-profile name] [-tenant name] [-dry-run] [-json] <command> [flags]\n\n")
+	out.WriteString("Without a command, sends the items and sources from the config and starts an interactive shell.\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&out, "  %-10s %s\n", cmd.name, cmd.description)
+	}
+	out.WriteString("\nRun 'buffer <command> -h' for command flags.\n\nGlobal flags:\n")
+	fmt.Fprint(os.Stderr, out.String())
+	global.PrintDefaults()
 }
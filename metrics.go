@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// WriteMetrics выводит метрики буфера в текстовом формате Prometheus
+func (b *Buffer) WriteMetrics(w io.Writer) error {
+	stats := b.Stats()
+	latency := b.latency.Snapshot()
+
+	var err error
+	printf := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+
+	printf("# HELP buffer_queue_length Number of items waiting in the buffer.\n")
+	printf("# TYPE buffer_queue_length gauge\n")
+	printf("buffer_queue_length %d\n", stats.Queued)
+	printf("# HELP buffer_queue_spilled Number of waiting items spilled to disk.\n")
+	printf("# TYPE buffer_queue_spilled gauge\n")
+	printf("buffer_queue_spilled %d\n", stats.Spilled)
+	printf("# HELP buffer_spool_bytes Disk space used by the spool.\n")
+	printf("# TYPE buffer_spool_bytes gauge\n")
+	printf("buffer_spool_bytes %d\n", stats.SpoolBytes)
+	printf("# HELP buffer_spool_overflow_total Number of items rejected or dropped because the spool was full.\n")
+	printf("# TYPE buffer_spool_overflow_total counter\n")
+	printf("buffer_spool_overflow_total %d\n", stats.Overflowed)
+	printf("# HELP buffer_offline Whether delivery is paused because the API is unreachable.\n")
+	printf("# TYPE buffer_offline gauge\n")
+	printf("buffer_offline %d\n", boolGauge(stats.Offline))
+	printf("# HELP buffer_outside_window Whether delivery waits for the delivery window to open.\n")
+	printf("# TYPE buffer_outside_window gauge\n")
+	printf("buffer_outside_window %d\n", boolGauge(stats.Outside))
+	printf("# HELP buffer_standby Whether this replica is not the leader and forwards new items to it.\n")
+	printf("# TYPE buffer_standby gauge\n")
+	printf("buffer_standby %d\n", boolGauge(stats.Standby))
+	printf("# HELP buffer_forwarded_total Number of items forwarded to the leader through the shared queue.\n")
+	printf("# TYPE buffer_forwarded_total counter\n")
+	printf("buffer_forwarded_total %d\n", stats.Forwarded)
+	if len(stats.Quota) > 0 {
+		printf("# HELP buffer_quota_remaining Number of API requests left in the current quota period.\n")
+		printf("# TYPE buffer_quota_remaining gauge\n")
+		for _, q := range stats.Quota {
+			printf("buffer_quota_remaining{period=\"%s\"} %d\n", q.Period, q.Remaining)
+		}
+		printf("# HELP buffer_quota_limit Number of API requests allowed per quota period.\n")
+		printf("# TYPE buffer_quota_limit gauge\n")
+		for _, q := range stats.Quota {
+			printf("buffer_quota_limit{period=\"%s\"} %d\n", q.Period, q.Limit)
+		}
+	}
+	if c := stats.FactsCache; c != nil {
+		printf("# HELP buffer_facts_cache_requests_total Number of get_facts requests by cache result: hit, revalidated or miss.\n")
+		printf("# TYPE buffer_facts_cache_requests_total counter\n")
+		printf("buffer_facts_cache_requests_total{result=\"hit\"} %d\n", c.Hits)
+		printf("buffer_facts_cache_requests_total{result=\"revalidated\"} %d\n", c.Revalidated)
+		printf("buffer_facts_cache_requests_total{result=\"miss\"} %d\n", c.Misses)
+	}
+	printf("# HELP buffer_send_attempts_total Number of delivery attempts.\n")
+	printf("# TYPE buffer_send_attempts_total counter\n")
+	printf("buffer_send_attempts_total %d\n", stats.Attempts)
+	printf("# HELP buffer_items_sent_total Number of items delivered successfully.\n")
+	printf("# TYPE buffer_items_sent_total counter\n")
+	printf("buffer_items_sent_total %d\n", stats.Sent)
+	printf("# HELP buffer_items_failed_total Number of failed delivery attempts.\n")
+	printf("# TYPE buffer_items_failed_total counter\n")
+	printf("buffer_items_failed_total %d\n", stats.Failed)
+	printf("# HELP buffer_items_invalid_total Number of items rejected by validation before enqueue.\n")
+	printf("# TYPE buffer_items_invalid_total counter\n")
+	printf("buffer_items_invalid_total %d\n", stats.Invalid)
+	printf("# HELP buffer_items_duplicate_total Number of items skipped because the fact already exists in the API.\n")
+	printf("# TYPE buffer_items_duplicate_total counter\n")
+	printf("buffer_items_duplicate_total %d\n", stats.Duplicates)
+	printf("# HELP buffer_items_warned_total Number of items accepted by the API with warnings.\n")
+	printf("# TYPE buffer_items_warned_total counter\n")
+	printf("buffer_items_warned_total %d\n", stats.Warned)
+	printf("# HELP buffer_items_canceled_total Number of queued items withdrawn before delivery.\n")
+	printf("# TYPE buffer_items_canceled_total counter\n")
+	printf("buffer_items_canceled_total %d\n", stats.Canceled)
+	printf("# HELP buffer_items_updated_total Number of updates applied to queued items.\n")
+	printf("# TYPE buffer_items_updated_total counter\n")
+	printf("buffer_items_updated_total %d\n", stats.Updated)
+	printf("# HELP buffer_dead_letters Number of items in the dead letter queue.\n")
+	printf("# TYPE buffer_dead_letters gauge\n")
+	printf("buffer_dead_letters %d\n", stats.DeadLetters)
+
+	printf("# HELP buffer_send_latency_seconds Latency of delivery attempts.\n")
+	printf("# TYPE buffer_send_latency_seconds histogram\n")
+	var cumulative uint64
+	for i, upper := range latency.Buckets {
+		cumulative += latency.Counts[i]
+		printf("buffer_send_latency_seconds_bucket{le=\"%s\"} %d\n", formatSeconds(upper.Seconds()), cumulative)
+	}
+	printf("buffer_send_latency_seconds_bucket{le=\"+Inf\"} %d\n", latency.Count)
+	printf("buffer_send_latency_seconds_sum %s\n", formatSeconds(latency.Sum.Seconds()))
+	printf("buffer_send_latency_seconds_count %d\n", latency.Count)
+
+	printf("# HELP buffer_send_latency_quantile_seconds Estimated latency percentiles of delivery attempts.\n")
+	printf("# TYPE buffer_send_latency_quantile_seconds gauge\n")
+	printf("buffer_send_latency_quantile_seconds{quantile=\"0.5\"} %s\n", formatSeconds(stats.LatencyP50.Seconds()))
+	printf("buffer_send_latency_quantile_seconds{quantile=\"0.95\"} %s\n", formatSeconds(stats.LatencyP95.Seconds()))
+	printf("buffer_send_latency_quantile_seconds{quantile=\"0.99\"} %s\n", formatSeconds(stats.LatencyP99.Seconds()))
+	return err
+}
+
+// MetricsHandler возвращает HTTP-обработчик, отдающий метрики буфера
+func (b *Buffer) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := b.WriteMetrics(w); err != nil {
+			fmt.Println("Error writing metrics:", err)
+		}
+	})
+}
+
+// formatSeconds форматирует число секунд без лишних нулей
+func formatSeconds(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// boolGauge переводит признак в значение метрики 0 или 1
+func boolGauge(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,361 @@
+// Package mockkpi реализует HTTP-сервер, имитирующий методы save_fact и get_facts API KPI-Drive.
+// Сервер запускается в том же процессе и нужен нагрузочным и интеграционным тестам, которым
+// не требуется настоящий стенд разработки
+package mockkpi
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Пути методов, как у настоящего API
+const (
+	SaveFactPath = "/_api/facts/save_fact"
+	GetFactsPath = "/_api/indicators/get_facts"
+)
+
+// requiredFields перечисляет поля, без которых save_fact отклоняет факт
+var requiredFields = []string{"period_start", "period_end", "period_key", "indicator_to_mo_id", "value", "fact_time"}
+
+// keyFields определяют факт: повторное сохранение с теми же значениями считается дубликатом
+var keyFields = []string{"indicator_to_mo_id", "period_start", "period_end", "period_key", "fact_time", "is_plan"}
+
+// Options задает поведение сервера
+type Options struct {
+	Token            string        // если задан, запросы с другим токеном получают 401
+	Latency          time.Duration // задержка каждого ответа
+	Jitter           time.Duration // случайная добавка к задержке от 0 до Jitter
+	ErrorRate        float64       // доля запросов save_fact, на которые отвечает ErrorStatus, от 0 до 1
+	ErrorStatus      int           // код ответа для внесенных ошибок, по умолчанию 500
+	RejectDuplicates bool          // отвечать 409 на дубликат вместо обновления факта
+}
+
+// Fact представляет сохраненный факт
+type Fact struct {
+	ID       int               `json:"indicator_to_mo_fact_id"`
+	Fields   map[string]string `json:"fields"`
+	Saves    int               `json:"saves"` // сколько раз факт был сохранен, больше 1 у дубликатов
+	SavedAt  time.Time         `json:"saved_at"`
+	Requests []string          `json:"request_ids,omitempty"`
+}
+
+// Stats представляет счетчики запросов сервера
+type Stats struct {
+	Requests   int `json:"requests"`
+	Saved      int `json:"saved"`
+	Duplicates int `json:"duplicates"`
+	Rejected   int `json:"rejected"`  // отклоненные из-за токена, ошибок проверки или дубликатов
+	Injected   int `json:"injected"`  // ответы с внесенной ошибкой
+	Unchanged  int `json:"unchanged"` // ответы 304 на get_facts с If-None-Match
+}
+
+// Server имитирует API KPI-Drive; безопасен для одновременных запросов
+type Server struct {
+	URL string // адрес запущенного сервера, например http://127.0.0.1:41234
+
+	opts   Options
+	mu     sync.Mutex
+	facts  []*Fact
+	byKey  map[string]*Fact
+	stats  Stats
+	saves  int // сохранения фактов; задает ETag ответов get_facts
+	server *http.Server
+}
+
+// New создает сервер, который можно использовать как http.Handler или запустить методом Start
+func New(opts Options) *Server {
+	if opts.ErrorStatus == 0 {
+		opts.ErrorStatus = http.StatusInternalServerError
+	}
+	return &Server{opts: opts, byKey: make(map[string]*Fact)}
+}
+
+// Start создает и запускает сервер на адресе addr; пустой адрес - свободный порт на 127.0.0.1
+func Start(addr string, opts Options) (*Server, error) {
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	s := New(opts)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.URL = "http://" + listener.Addr().String()
+	s.server = &http.Server{Handler: s}
+	go s.server.Serve(listener)
+	return s, nil
+}
+
+// Close останавливает сервер, запущенный Start
+func (s *Server) Close() error {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Close()
+}
+
+// SaveFactURL возвращает адрес метода save_fact
+func (s *Server) SaveFactURL() string {
+	return s.URL + SaveFactPath
+}
+
+// GetFactsURL возвращает адрес метода get_facts
+func (s *Server) GetFactsURL() string {
+	return s.URL + GetFactsPath
+}
+
+// Facts возвращает копию сохраненных фактов в порядке первого сохранения
+func (s *Server) Facts() []Fact {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	facts := make([]Fact, len(s.facts))
+	for i, f := range s.facts {
+		facts[i] = *f
+		facts[i].Requests = append([]string(nil), f.Requests...)
+	}
+	return facts
+}
+
+// Stats возвращает текущие счетчики запросов
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// Reset удаляет сохраненные факты и обнуляет счетчики
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.facts, s.byKey, s.stats = nil, make(map[string]*Fact), Stats{}
+	s.saves++
+}
+
+// response представляет ответ в формате API
+type response struct {
+	Messages messages    `json:"MESSAGES"`
+	Data     interface{} `json:"DATA"`
+	Status   string      `json:"STATUS"`
+}
+
+// messages представляет сообщения в ответе API
+type messages struct {
+	Error   interface{} `json:"error"`
+	Warning interface{} `json:"warning"`
+	Info    []string    `json:"info"`
+}
+
+// ServeHTTP обрабатывает запросы save_fact и get_facts
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.stats.Requests++
+	s.mu.Unlock()
+	s.delay()
+
+	// get_facts принимает и GET с условиями в строке запроса
+	if r.Method != http.MethodPost && (r.Method != http.MethodGet || r.URL.Path != GetFactsPath) {
+		s.reply(w, http.StatusMethodNotAllowed, nil, "method not allowed")
+		return
+	}
+	if s.opts.Token != "" && r.Header.Get("Authorization") != "Bearer "+s.opts.Token {
+		s.reject()
+		s.reply(w, http.StatusUnauthorized, nil, "invalid token")
+		return
+	}
+	form, err := readForm(r)
+	if err != nil {
+		s.reject()
+		s.reply(w, http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	switch r.URL.Path {
+	case SaveFactPath:
+		s.saveFact(w, form, r.Header.Get("X-Request-ID"))
+	case GetFactsPath:
+		s.getFacts(w, r, form)
+	default:
+		s.reply(w, http.StatusNotFound, nil, "unknown method "+r.URL.Path)
+	}
+}
+
+// delay выдерживает настроенную задержку ответа
+func (s *Server) delay() {
+	d := s.opts.Latency
+	if s.opts.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(s.opts.Jitter)))
+	}
+	time.Sleep(d)
+}
+
+// reject учитывает отклоненный запрос
+func (s *Server) reject() {
+	s.mu.Lock()
+	s.stats.Rejected++
+	s.mu.Unlock()
+}
+
+// saveFact сохраняет факт или обновляет ранее сохраненный с теми же ключевыми полями
+func (s *Server) saveFact(w http.ResponseWriter, form url.Values, requestID string) {
+	if s.opts.ErrorRate > 0 && rand.Float64() < s.opts.ErrorRate {
+		s.mu.Lock()
+		s.stats.Injected++
+		s.mu.Unlock()
+		s.reply(w, s.opts.ErrorStatus, nil, "injected error")
+		return
+	}
+	fields := make(map[string]string, len(form))
+	for key := range form {
+		fields[key] = form.Get(key)
+	}
+	for _, field := range requiredFields {
+		if fields[field] == "" {
+			s.reject()
+			s.reply(w, http.StatusBadRequest, nil, field+" is required")
+			return
+		}
+	}
+	key := factKey(fields)
+
+	s.mu.Lock()
+	fact, duplicate := s.byKey[key]
+	switch {
+	case duplicate && s.opts.RejectDuplicates:
+		s.stats.Duplicates++
+		s.stats.Rejected++
+		s.mu.Unlock()
+		s.reply(w, http.StatusConflict, nil, fmt.Sprintf("fact already saved as %d", fact.ID))
+		return
+	case duplicate:
+		s.stats.Duplicates++
+		fact.Fields = fields
+	default:
+		fact = &Fact{ID: len(s.facts) + 1, Fields: fields}
+		s.facts = append(s.facts, fact)
+		s.byKey[key] = fact
+	}
+	s.stats.Saved++
+	s.saves++
+	fact.Saves++
+	fact.SavedAt = time.Now()
+	if requestID != "" {
+		fact.Requests = append(fact.Requests, requestID)
+	}
+	id := fact.ID
+	s.mu.Unlock()
+
+	s.reply(w, http.StatusOK, map[string]int{"indicator_to_mo_fact_id": id}, "")
+}
+
+// getFacts отвечает на get_facts. ETag меняется при каждом сохранении, и запрос с тем же
+// If-None-Match получает 304 без тела
+func (s *Server) getFacts(w http.ResponseWriter, r *http.Request, form url.Values) {
+	s.mu.Lock()
+	etag := fmt.Sprintf(`"%d"`, s.saves)
+	unchanged := r.Header.Get("If-None-Match") == etag
+	if unchanged {
+		s.stats.Unchanged++
+	}
+	s.mu.Unlock()
+	w.Header().Set("ETag", etag)
+	if unchanged {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	s.reply(w, http.StatusOK, s.find(form), "")
+}
+
+// find возвращает факты, совпадающие с заданными в запросе get_facts полями
+func (s *Server) find(form url.Values) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows := []map[string]string{}
+	for _, fact := range s.facts {
+		if matches(fact.Fields, form) {
+			row := map[string]string{"indicator_to_mo_fact_id": fmt.Sprint(fact.ID)}
+			for key, value := range fact.Fields {
+				if key != "indicator_to_mo_fact_id" {
+					row[key] = value
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+	return map[string]interface{}{"rows": rows, "rows_count": len(rows)}
+}
+
+// matches проверяет, что факт попадает в период запроса и совпадает по остальным заданным полям
+func matches(fields map[string]string, form url.Values) bool {
+	for key := range form {
+		want := form.Get(key)
+		switch key {
+		case "period_start":
+			if fields["fact_time"] < want {
+				return false
+			}
+		case "period_end":
+			if fields["fact_time"] > want {
+				return false
+			}
+		default:
+			if want != "" && fields[key] != want {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// factKey строит ключ факта по ключевым полям
+func factKey(fields map[string]string) string {
+	parts := make([]string, len(keyFields))
+	for i, field := range keyFields {
+		parts[i] = fields[field]
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// readForm разбирает тело запроса, в том числе сжатое gzip, а у GET - строку запроса
+func readForm(r *http.Request) (url.Values, error) {
+	if r.Method == http.MethodGet {
+		return r.URL.Query(), nil
+	}
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("reading gzip body: %w", err)
+		}
+		defer zr.Close()
+		body = zr
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("reading body: %w", err)
+	}
+	return url.ParseQuery(string(data))
+}
+
+// reply отправляет ответ в формате API; непустой errMsg помещается в MESSAGES.error
+func (s *Server) reply(w http.ResponseWriter, status int, data interface{}, errMsg string) {
+	resp := response{Messages: messages{Info: []string{}}, Data: data, Status: "OK"}
+	if errMsg != "" {
+		resp.Messages.Error = []string{errMsg}
+		resp.Status = "ERROR"
+	}
+	if resp.Data == nil {
+		resp.Data = map[string]interface{}{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,173 @@
+package mockkpi_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"buffer/kpiclient"
+	"buffer/kpidrivetest"
+	"buffer/mockkpi"
+)
+
+// testFact возвращает общий тестовый факт kpidrivetest.Fact со значением value
+func testFact(value string) kpiclient.SaveFactRequest {
+	fact := kpidrivetest.Fact("227373")
+	fact.Value = value
+	return fact
+}
+
+// start запускает сервер на свободном порту и клиента к нему
+func start(t *testing.T, opts mockkpi.Options, clientOpts ...kpiclient.ClientOption) (*mockkpi.Server, *kpiclient.Client) {
+	t.Helper()
+	s, err := mockkpi.Start("", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, kpiclient.NewClient(s.URL, clientOpts...)
+}
+
+func TestSaveFactAndDuplicates(t *testing.T) {
+	s, client := start(t, mockkpi.Options{}, kpidrivetest.WithHeader("X-Request-ID", "req-1"))
+	ctx := context.Background()
+	for i, value := range []string{"1", "2"} {
+		result, err := client.SaveFact(ctx, testFact(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.StatusCode != http.StatusOK || result.JSON.Status != "OK" || result.JSON.Data.IndicatorToMoFactID != 1 {
+			t.Fatalf("save %d: %d %s", i+1, result.StatusCode, result.Body)
+		}
+	}
+	// Повторное сохранение обновило первый факт, а факт за другой период сохраняется отдельно
+	next := testFact("3")
+	next.FactTime, next.PeriodEnd = "2024-06-30", "2024-06-30"
+	result, err := client.SaveFact(ctx, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := result.JSON.Data.IndicatorToMoFactID; id != 2 {
+		t.Fatalf("fact with other key fields saved as %d, want 2", id)
+	}
+
+	facts := s.Facts()
+	if len(facts) != 2 {
+		t.Fatalf("%d facts saved, want 2", len(facts))
+	}
+	if f := facts[0]; f.Saves != 2 || f.Fields["value"] != "2" || len(f.Requests) != 2 || f.Requests[0] != "req-1" {
+		t.Errorf("duplicate fact = %+v, want 2 saves of the last value with request ids", f)
+	}
+	if stats := s.Stats(); stats != (mockkpi.Stats{Requests: 3, Saved: 3, Duplicates: 1}) {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestRejectedRequests(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		opts   mockkpi.Options
+		token  string
+		fact   kpiclient.SaveFactRequest
+		status int
+	}{
+		{"token", mockkpi.Options{Token: "secret"}, "other", testFact("1"), http.StatusUnauthorized},
+		{"missing field", mockkpi.Options{}, "", kpiclient.SaveFactRequest{PeriodStart: "2024-05-01"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, client := start(t, tt.opts, kpidrivetest.WithHeader("Authorization", "Bearer "+tt.token))
+			result, err := client.SaveFact(ctx, tt.fact)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.StatusCode != tt.status || result.JSON.Status != "ERROR" {
+				t.Fatalf("%d %s, want %d with STATUS ERROR", result.StatusCode, result.Body, tt.status)
+			}
+			if stats := s.Stats(); stats.Rejected != 1 || stats.Saved != 0 || len(s.Facts()) != 0 {
+				t.Errorf("stats = %+v, facts %d", stats, len(s.Facts()))
+			}
+		})
+	}
+}
+
+func TestRejectDuplicates(t *testing.T) {
+	s, client := start(t, mockkpi.Options{RejectDuplicates: true})
+	ctx := context.Background()
+	if _, err := client.SaveFact(ctx, testFact("1")); err != nil {
+		t.Fatal(err)
+	}
+	result, err := client.SaveFact(ctx, testFact("2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StatusCode != http.StatusConflict {
+		t.Fatalf("duplicate got %d %s, want 409", result.StatusCode, result.Body)
+	}
+	if f := s.Facts()[0]; f.Saves != 1 || f.Fields["value"] != "1" {
+		t.Errorf("rejected duplicate changed the fact: %+v", f)
+	}
+	if stats := s.Stats(); stats.Duplicates != 1 || stats.Rejected != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestErrorInjection(t *testing.T) {
+	s, client := start(t, mockkpi.Options{ErrorRate: 1, ErrorStatus: http.StatusServiceUnavailable})
+	for i := 0; i < 3; i++ {
+		result, err := client.SaveFact(context.Background(), testFact("1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("request %d got %d, want injected 503", i+1, result.StatusCode)
+		}
+	}
+	if stats := s.Stats(); stats.Injected != 3 || stats.Saved != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestGetFacts(t *testing.T) {
+	var etag string
+	s, client := start(t, mockkpi.Options{}, kpiclient.WithRequestEditorFn(func(ctx context.Context, req *http.Request) error {
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		return nil
+	}))
+	ctx := context.Background()
+	if _, err := client.SaveFact(ctx, testFact("1")); err != nil {
+		t.Fatal(err)
+	}
+	query := kpiclient.GetFactsRequest{IndicatorToMoID: "227373", PeriodStart: "2024-05-01", PeriodEnd: "2024-05-31", PeriodKey: "month"}
+	result, err := client.GetFacts(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := result.JSON.Data; data.RowsCount != 1 || data.Rows[0]["indicator_to_mo_fact_id"] != "1" || data.Rows[0]["value"] != "1" {
+		t.Fatalf("get_facts returned %s", result.Body)
+	}
+
+	// Без новых сохранений тот же ETag дает 304, а после сохранения - новый ответ
+	etag = result.Header.Get("ETag")
+	if result, err = client.GetFacts(ctx, query); err != nil || result.StatusCode != http.StatusNotModified {
+		t.Fatalf("unchanged get_facts: %v, %+v", err, result)
+	}
+	if _, err := client.SaveFact(ctx, testFact("2")); err != nil {
+		t.Fatal(err)
+	}
+	if result, err = client.GetFacts(ctx, query); err != nil || result.StatusCode != http.StatusOK {
+		t.Fatalf("get_facts after save: %v, %+v", err, result)
+	}
+
+	query.PeriodStart, query.PeriodEnd = "2024-06-01", "2024-06-30"
+	etag = ""
+	if result, err = client.GetFacts(ctx, query); err != nil || result.JSON.Data.RowsCount != 0 {
+		t.Fatalf("get_facts outside the period: %v, %s", err, result.Body)
+	}
+	if stats := s.Stats(); stats.Unchanged != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"buffer/mockkpi"
+)
+
+// mockFlags регистрирует флаги поведения имитации API с префиксом prefix
+func mockFlags(fs *flag.FlagSet, prefix string) *mockkpi.Options {
+	opts := &mockkpi.Options{}
+	fs.DurationVar(&opts.Latency, prefix+"latency", 0, "mock API response latency")
+	fs.DurationVar(&opts.Jitter, prefix+"jitter", 0, "random extra mock API latency up to this value")
+	fs.Float64Var(&opts.ErrorRate, prefix+"error-rate", 0, "share of save_fact requests the mock API fails, 0 to 1")
+	fs.IntVar(&opts.ErrorStatus, prefix+"error-status", 500, "HTTP status of injected mock API errors")
+	fs.BoolVar(&opts.RejectDuplicates, prefix+"reject-duplicates", false, "answer 409 to duplicate facts instead of updating them")
+	return opts
+}
+
+// printMockStats выводит счетчики имитации API
+func printMockStats(server *mockkpi.Server) {
+	st := server.Stats()
+	fmt.Printf("Mock API: %d requests, %d saved, %d duplicates, %d rejected, %d injected errors, %d unchanged get_facts, %d distinct facts\n",
+		st.Requests, st.Saved, st.Duplicates, st.Rejected, st.Injected, st.Unchanged, len(server.Facts()))
+}
+
+// runMockServer выполняет команду mock-server: запускает имитацию API KPI-Drive до SIGINT/SIGTERM
+func runMockServer(cfg *Config, res *Result, args []string) error {
+	fs := flag.NewFlagSet("mock-server", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: buffer mock-server [flags]")
+		fmt.Fprintln(fs.Output(), "Serves save_fact and get_facts in memory for load tests and CI.")
+		fs.PrintDefaults()
+	}
+	addr := fs.String("addr", "127.0.0.1:8090", "address to listen on")
+	token := fs.String("token", "", "accept only this API token, any token if empty")
+	opts := mockFlags(fs, "")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	opts.Token = *token
+	if opts.ErrorRate < 0 || opts.ErrorRate > 1 {
+		return fmt.Errorf("%w: -error-rate must be between 0 and 1", errUsage)
+	}
+
+	server, err := mockkpi.Start(*addr, *opts)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+	fmt.Printf("Mock API listening: save_fact_url %s, get_facts_url %s\n", server.SaveFactURL(), server.GetFactsURL())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	printMockStats(server)
+	return nil
+}
+
+// startLoadTestMock запускает имитацию API для команды loadtest и направляет на нее настройки
+func startLoadTestMock(cfg *Config, opts mockkpi.Options) (*mockkpi.Server, error) {
+	server, err := mockkpi.Start("", opts)
+	if err != nil {
+		return nil, fmt.Errorf("starting mock API: %w", err)
+	}
+	cfg.API.SaveFactURL, cfg.API.GetFactsURL = server.SaveFactURL(), server.GetFactsURL()
+	// Имитации подходит любой токен, поэтому для запуска в CI его можно не настраивать
+	if _, err := cfg.Auth.TokenProvider(); err != nil {
+		cfg.Auth = AuthConfig{Token: "mock"}
+	}
+	return server, nil
+}
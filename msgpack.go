@@ -0,0 +1,656 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Кодирование элементов spool в MessagePack (https://msgpack.org). Элемент записывается
+// отображением с теми же именами полей, что и в JSON, поэтому неизвестные поля пропускаются при
+// чтении, как в JSON, а новые необязательные поля добавляются без смены версии формата.
+// Используется только нужное элементу подмножество: целые, строки, массивы, отображения, nil,
+// логические значения и метка времени (расширение -1); остальные типы только пропускаются
+
+// errMsgpackShort означает, что запись закончилась посреди значения
+var errMsgpackShort = errors.New("msgpack: unexpected end of record")
+
+// msgpackWriter дописывает значения MessagePack в буфер
+type msgpackWriter struct {
+	buf []byte
+}
+
+func (w *msgpackWriter) mapHeader(n int) {
+	switch {
+	case n < 16:
+		w.buf = append(w.buf, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		w.buf = binary.BigEndian.AppendUint16(append(w.buf, 0xde), uint16(n))
+	default:
+		w.buf = binary.BigEndian.AppendUint32(append(w.buf, 0xdf), uint32(n))
+	}
+}
+
+func (w *msgpackWriter) arrayHeader(n int) {
+	switch {
+	case n < 16:
+		w.buf = append(w.buf, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		w.buf = binary.BigEndian.AppendUint16(append(w.buf, 0xdc), uint16(n))
+	default:
+		w.buf = binary.BigEndian.AppendUint32(append(w.buf, 0xdd), uint32(n))
+	}
+}
+
+func (w *msgpackWriter) string(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		w.buf = append(w.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		w.buf = append(w.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		w.buf = binary.BigEndian.AppendUint16(append(w.buf, 0xda), uint16(n))
+	default:
+		w.buf = binary.BigEndian.AppendUint32(append(w.buf, 0xdb), uint32(n))
+	}
+	w.buf = append(w.buf, s...)
+}
+
+func (w *msgpackWriter) uint(v uint64) {
+	switch {
+	case v < 128:
+		w.buf = append(w.buf, byte(v))
+	case v <= math.MaxUint8:
+		w.buf = append(w.buf, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		w.buf = binary.BigEndian.AppendUint16(append(w.buf, 0xcd), uint16(v))
+	case v <= math.MaxUint32:
+		w.buf = binary.BigEndian.AppendUint32(append(w.buf, 0xce), uint32(v))
+	default:
+		w.buf = binary.BigEndian.AppendUint64(append(w.buf, 0xcf), v)
+	}
+}
+
+func (w *msgpackWriter) int(v int64) {
+	switch {
+	case v >= 0:
+		w.uint(uint64(v))
+	case v >= -32:
+		w.buf = append(w.buf, byte(v))
+	case v >= math.MinInt8:
+		w.buf = append(w.buf, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		w.buf = binary.BigEndian.AppendUint16(append(w.buf, 0xd1), uint16(v))
+	case v >= math.MinInt32:
+		w.buf = binary.BigEndian.AppendUint32(append(w.buf, 0xd2), uint32(v))
+	default:
+		w.buf = binary.BigEndian.AppendUint64(append(w.buf, 0xd3), uint64(v))
+	}
+}
+
+// time записывает метку времени расширением -1 в 96-битном виде, сохраняющем наносекунды
+func (w *msgpackWriter) time(t time.Time) {
+	w.buf = append(w.buf, 0xc7, 12, 0xff)
+	w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(t.Nanosecond()))
+	w.buf = binary.BigEndian.AppendUint64(w.buf, uint64(t.Unix()))
+}
+
+// msgpackReader читает значения MessagePack из одной записи
+type msgpackReader struct {
+	data []byte
+}
+
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if len(r.data) < n {
+		return nil, errMsgpackShort
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b, nil
+}
+
+func (r *msgpackReader) byte() (byte, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// length читает n-байтовую длину
+func (r *msgpackReader) length(n int) (int, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 1:
+		return int(b[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(b)), nil
+	}
+	return int(binary.BigEndian.Uint32(b)), nil
+}
+
+// nil пропускает значение nil и сообщает, было ли оно
+func (r *msgpackReader) nil() bool {
+	if len(r.data) > 0 && r.data[0] == 0xc0 {
+		r.data = r.data[1:]
+		return true
+	}
+	return false
+}
+
+func (r *msgpackReader) mapHeader() (int, error) {
+	c, err := r.byte()
+	var n int
+	switch {
+	case err != nil:
+		return 0, err
+	case c&0xf0 == 0x80:
+		n = int(c & 0x0f)
+	case c == 0xde:
+		n, err = r.length(2)
+	case c == 0xdf:
+		n, err = r.length(4)
+	default:
+		return 0, fmt.Errorf("msgpack: expected a map, got 0x%02x", c)
+	}
+	return r.count(n, 2, err)
+}
+
+func (r *msgpackReader) arrayHeader() (int, error) {
+	c, err := r.byte()
+	var n int
+	switch {
+	case err != nil:
+		return 0, err
+	case c&0xf0 == 0x90:
+		n = int(c & 0x0f)
+	case c == 0xdc:
+		n, err = r.length(2)
+	case c == 0xdd:
+		n, err = r.length(4)
+	default:
+		return 0, fmt.Errorf("msgpack: expected an array, got 0x%02x", c)
+	}
+	return r.count(n, 1, err)
+}
+
+// count проверяет число значений n, каждое из которых занимает не меньше size байт: заголовок
+// поврежденной записи может обещать больше значений, чем в ней осталось, и по нему нельзя
+// выделять память
+func (r *msgpackReader) count(n, size int, err error) (int, error) {
+	if err != nil {
+		return 0, err
+	}
+	if n > len(r.data)/size {
+		return 0, errMsgpackShort
+	}
+	return n, nil
+}
+
+func (r *msgpackReader) string() (string, error) {
+	c, err := r.byte()
+	if err != nil {
+		return "", err
+	}
+	var n int
+	switch {
+	case c&0xe0 == 0xa0:
+		n = int(c & 0x1f)
+	case c == 0xd9:
+		n, err = r.length(1)
+	case c == 0xda:
+		n, err = r.length(2)
+	case c == 0xdb:
+		n, err = r.length(4)
+	default:
+		return "", fmt.Errorf("msgpack: expected a string, got 0x%02x", c)
+	}
+	if err != nil {
+		return "", err
+	}
+	b, err := r.next(n)
+	return string(b), err
+}
+
+func (r *msgpackReader) int() (int64, error) {
+	c, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case c < 0x80:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	}
+	var n int
+	switch c {
+	case 0xcc, 0xd0:
+		n = 1
+	case 0xcd, 0xd1:
+		n = 2
+	case 0xce, 0xd2:
+		n = 4
+	case 0xcf, 0xd3:
+		n = 8
+	default:
+		return 0, fmt.Errorf("msgpack: expected an integer, got 0x%02x", c)
+	}
+	b, err := r.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, x := range b {
+		u = u<<8 | uint64(x)
+	}
+	if c >= 0xd0 {
+		// Знаковое целое: расширяем знак до 64 бит
+		shift := 64 - 8*n
+		return int64(u<<shift) >> shift, nil
+	}
+	if u > math.MaxInt64 {
+		return 0, fmt.Errorf("msgpack: integer %d overflows int64", u)
+	}
+	return int64(u), nil
+}
+
+func (r *msgpackReader) uint() (uint64, error) {
+	if len(r.data) > 0 && r.data[0] == 0xcf {
+		b, err := r.next(9)
+		if err != nil {
+			return 0, err
+		}
+		return binary.BigEndian.Uint64(b[1:]), nil
+	}
+	v, err := r.int()
+	if err == nil && v < 0 {
+		err = fmt.Errorf("msgpack: expected an unsigned integer, got %d", v)
+	}
+	return uint64(v), err
+}
+
+// time читает метку времени расширения -1 в любом из трех видов; время возвращается в местном поясе
+func (r *msgpackReader) time() (time.Time, error) {
+	c, err := r.byte()
+	if err != nil {
+		return time.Time{}, err
+	}
+	var n int
+	switch c {
+	case 0xd6:
+		n = 4
+	case 0xd7:
+		n = 8
+	case 0xc7:
+		if n, err = r.length(1); err != nil {
+			return time.Time{}, err
+		}
+	default:
+		return time.Time{}, fmt.Errorf("msgpack: expected a timestamp, got 0x%02x", c)
+	}
+	typ, err := r.byte()
+	if err != nil {
+		return time.Time{}, err
+	}
+	b, err := r.next(n)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if int8(typ) != -1 {
+		return time.Time{}, fmt.Errorf("msgpack: expected a timestamp, got extension %d", int8(typ))
+	}
+	switch n {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(b)), 0), nil
+	case 8:
+		v := binary.BigEndian.Uint64(b)
+		return time.Unix(int64(v&(1<<34-1)), int64(v>>34)), nil
+	case 12:
+		return time.Unix(int64(binary.BigEndian.Uint64(b[4:])), int64(binary.BigEndian.Uint32(b))), nil
+	}
+	return time.Time{}, fmt.Errorf("msgpack: invalid timestamp length %d", n)
+}
+
+// msgpackMaxDepth ограничивает вложенность пропускаемых значений
+const msgpackMaxDepth = 32
+
+// skip пропускает одно значение любого типа
+func (r *msgpackReader) skip() error {
+	return r.skipNested(msgpackMaxDepth)
+}
+
+// skipNested пропускает значение, вложенность которого не больше depth
+func (r *msgpackReader) skipNested(depth int) error {
+	if depth == 0 {
+		return fmt.Errorf("msgpack: values nested deeper than %d", msgpackMaxDepth)
+	}
+	c, err := r.byte()
+	if err != nil {
+		return err
+	}
+	var n, items int
+	switch {
+	case c < 0x80 || c >= 0xe0 || c == 0xc0 || c == 0xc2 || c == 0xc3:
+		return nil
+	case c&0xf0 == 0x80:
+		items = 2 * int(c&0x0f)
+	case c&0xf0 == 0x90:
+		items = int(c & 0x0f)
+	case c&0xe0 == 0xa0:
+		n = int(c & 0x1f)
+	case c == 0xcc || c == 0xd0:
+		n = 1
+	case c == 0xcd || c == 0xd1:
+		n = 2
+	case c == 0xce || c == 0xd2 || c == 0xca:
+		n = 4
+	case c == 0xcf || c == 0xd3 || c == 0xcb:
+		n = 8
+	case c == 0xd9 || c == 0xc4:
+		n, err = r.length(1)
+	case c == 0xda || c == 0xc5:
+		n, err = r.length(2)
+	case c == 0xdb || c == 0xc6:
+		n, err = r.length(4)
+	case c == 0xd4 || c == 0xd5 || c == 0xd6 || c == 0xd7 || c == 0xd8:
+		n = 1 + 1<<(c-0xd4)
+	case c == 0xc7:
+		n, err = r.length(1)
+		n++ // тип расширения
+	case c == 0xc8:
+		n, err = r.length(2)
+		n++
+	case c == 0xc9:
+		n, err = r.length(4)
+		n++
+	case c == 0xdc:
+		items, err = r.length(2)
+	case c == 0xdd:
+		items, err = r.length(4)
+	case c == 0xde:
+		items, err = r.length(2)
+		items *= 2
+	case c == 0xdf:
+		items, err = r.length(4)
+		items *= 2
+	default:
+		return fmt.Errorf("msgpack: invalid type 0x%02x", c)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := r.next(n); err != nil {
+		return err
+	}
+	if items > len(r.data) {
+		return errMsgpackShort
+	}
+	for i := 0; i < items; i++ {
+		if err := r.skipNested(depth - 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeSpooledItem кодирует элемент spool в MessagePack, пропуская пустые необязательные поля,
+// как omitempty в JSON
+func encodeSpooledItem(rec spooledItem) []byte {
+	w := &msgpackWriter{buf: make([]byte, 0, 256)}
+	fields := 2
+	for _, set := range []bool{rec.CorrelationID != "", rec.Provenance != nil, rec.Endpoint != "", len(rec.Attempts) > 0, rec.Tries != 0} {
+		if set {
+			fields++
+		}
+	}
+	w.mapHeader(fields)
+	w.string("id")
+	w.uint(rec.ID)
+	w.string("data")
+	// Поля записываются по порядку имен, чтобы запись одного элемента не зависела от обхода map
+	keys := make([]string, 0, len(rec.Data))
+	for key := range rec.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	w.mapHeader(len(keys))
+	for _, key := range keys {
+		w.string(key)
+		w.string(rec.Data[key])
+	}
+	if rec.CorrelationID != "" {
+		w.string("correlation_id")
+		w.string(rec.CorrelationID)
+	}
+	if p := rec.Provenance; p != nil {
+		w.string("provenance")
+		encodeProvenance(w, p)
+	}
+	if rec.Endpoint != "" {
+		w.string("endpoint")
+		w.string(rec.Endpoint)
+	}
+	if len(rec.Attempts) > 0 {
+		w.string("attempts")
+		w.arrayHeader(len(rec.Attempts))
+		for _, a := range rec.Attempts {
+			encodeAttempt(w, a)
+		}
+	}
+	if rec.Tries != 0 {
+		w.string("tries")
+		w.int(int64(rec.Tries))
+	}
+	return w.buf
+}
+
+// encodeProvenance кодирует происхождение элемента
+func encodeProvenance(w *msgpackWriter, p *Provenance) {
+	strs := []struct{ key, value string }{{"source", p.Source}, {"file", p.File}, {"user", p.User}, {"host", p.Host}}
+	n := 0
+	for _, s := range strs {
+		if s.value != "" {
+			n++
+		}
+	}
+	if p.Row != 0 {
+		n++
+	}
+	w.mapHeader(n)
+	for _, s := range strs {
+		if s.value != "" {
+			w.string(s.key)
+			w.string(s.value)
+		}
+	}
+	if p.Row != 0 {
+		w.string("row")
+		w.int(int64(p.Row))
+	}
+}
+
+// encodeAttempt кодирует попытку отправки
+func encodeAttempt(w *msgpackWriter, a Attempt) {
+	n := 3
+	for _, set := range []bool{a.Status != 0, a.Error != "", len(a.Warnings) > 0} {
+		if set {
+			n++
+		}
+	}
+	w.mapHeader(n)
+	w.string("time")
+	w.time(a.Time)
+	w.string("request_id")
+	w.string(a.RequestID)
+	w.string("latency_ms")
+	w.int(a.LatencyMs)
+	if a.Status != 0 {
+		w.string("status")
+		w.int(int64(a.Status))
+	}
+	if a.Error != "" {
+		w.string("error")
+		w.string(a.Error)
+	}
+	if len(a.Warnings) > 0 {
+		w.string("warnings")
+		w.arrayHeader(len(a.Warnings))
+		for _, warning := range a.Warnings {
+			w.string(warning)
+		}
+	}
+}
+
+// decodeSpooledItem разбирает элемент spool в MessagePack; неизвестные поля пропускаются
+func decodeSpooledItem(data []byte) (spooledItem, error) {
+	var rec spooledItem
+	r := &msgpackReader{data: data}
+	n, err := r.mapHeader()
+	if err != nil {
+		return rec, err
+	}
+	for i := 0; i < n; i++ {
+		key, err := r.string()
+		if err != nil {
+			return rec, err
+		}
+		if r.nil() {
+			continue
+		}
+		switch key {
+		case "id":
+			rec.ID, err = r.uint()
+		case "data":
+			rec.Data, err = decodeStringMap(r)
+		case "correlation_id":
+			rec.CorrelationID, err = r.string()
+		case "provenance":
+			rec.Provenance, err = decodeProvenance(r)
+		case "endpoint":
+			rec.Endpoint, err = r.string()
+		case "attempts":
+			var count int
+			if count, err = r.arrayHeader(); err == nil {
+				rec.Attempts = make([]Attempt, count)
+				for j := 0; j < count && err == nil; j++ {
+					rec.Attempts[j], err = decodeAttempt(r)
+				}
+			}
+		case "tries":
+			var tries int64
+			tries, err = r.int()
+			rec.Tries = int(tries)
+		default:
+			err = r.skip()
+		}
+		if err != nil {
+			return rec, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return rec, nil
+}
+
+// decodeStringMap разбирает отображение строк в строки
+func decodeStringMap(r *msgpackReader) (map[string]string, error) {
+	n, err := r.mapHeader()
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		key, err := r.string()
+		if err != nil {
+			return nil, err
+		}
+		if m[key], err = r.string(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// decodeProvenance разбирает происхождение элемента
+func decodeProvenance(r *msgpackReader) (*Provenance, error) {
+	n, err := r.mapHeader()
+	if err != nil {
+		return nil, err
+	}
+	p := &Provenance{}
+	for i := 0; i < n; i++ {
+		key, err := r.string()
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case "source":
+			p.Source, err = r.string()
+		case "file":
+			p.File, err = r.string()
+		case "user":
+			p.User, err = r.string()
+		case "host":
+			p.Host, err = r.string()
+		case "row":
+			var row int64
+			row, err = r.int()
+			p.Row = int(row)
+		default:
+			err = r.skip()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// decodeAttempt разбирает попытку отправки
+func decodeAttempt(r *msgpackReader) (Attempt, error) {
+	var a Attempt
+	n, err := r.mapHeader()
+	if err != nil {
+		return a, err
+	}
+	for i := 0; i < n; i++ {
+		key, err := r.string()
+		if err != nil {
+			return a, err
+		}
+		switch key {
+		case "time":
+			a.Time, err = r.time()
+		case "request_id":
+			a.RequestID, err = r.string()
+		case "status":
+			var status int64
+			status, err = r.int()
+			a.Status = int(status)
+		case "latency_ms":
+			a.LatencyMs, err = r.int()
+		case "error":
+			a.Error, err = r.string()
+		case "warnings":
+			var count int
+			if count, err = r.arrayHeader(); err == nil {
+				a.Warnings = make([]string, count)
+				for j := 0; j < count && err == nil; j++ {
+					a.Warnings[j], err = r.string()
+				}
+			}
+		default:
+			err = r.skip()
+		}
+		if err != nil {
+			return a, err
+		}
+	}
+	return a, nil
+}
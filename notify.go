@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// События доставки, о которых сообщают оповещатели
+const (
+	AlertDeadLetter  = "dead_letter"
+	AlertCircuitOpen = "circuit_open"
+	AlertOffline     = "offline"
+)
+
+// TelegramNotifier отправляет оповещения сообщением от Telegram-бота
+type TelegramNotifier struct {
+	Token  string
+	ChatID string
+	Client *http.Client
+}
+
+// Alert отправляет оповещение в чат Telegram
+func (n *TelegramNotifier) Alert(alert Alert) error {
+	form := url.Values{}
+	form.Set("chat_id", n.ChatID)
+	form.Set("text", formatAlert(alert))
+	resp, err := notifierClient(n.Client).PostForm("https://api.telegram.org/bot"+n.Token+"/sendMessage", form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("telegram responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// SlackNotifier отправляет оповещения через входящий вебхук Slack
+type SlackNotifier struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+// Alert отправляет оповещение в канал Slack
+func (n *SlackNotifier) Alert(alert Alert) error {
+	body, err := json.Marshal(map[string]string{"text": formatAlert(alert)})
+	if err != nil {
+		return err
+	}
+	resp, err := notifierClient(n.Client).Post(n.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// formatAlert формирует текст оповещения для мессенджеров
+func formatAlert(alert Alert) string {
+	return fmt.Sprintf("[buffer] %s: %s (%s)", alert.Condition, alert.Message, alert.Time.Format(time.RFC3339))
+}
+
+// notifierClient возвращает клиент оповещателя или клиент по умолчанию
+func notifierClient(client *http.Client) *http.Client {
+	if client != nil {
+		return client
+	}
+	return &http.Client{Timeout: 10 * time.Second, Transport: sharedTransport}
+}
+
+// WithNotifiers включает оповещения о попадании элементов в очередь недоставленных и размыкании выключателя
+func WithNotifiers(hooks ...AlertHook) Option {
+	return func(b *Buffer) {
+		b.notifiers = append(b.notifiers, hooks...)
+	}
+}
+
+// notify асинхронно передает событие всем оповещателям буфера
+func (b *Buffer) notify(alert Alert) {
+	for _, hook := range b.notifiers {
+		go func(hook AlertHook) {
+			if err := hook.Alert(alert); err != nil {
+				fmt.Println("Error sending notification:", err)
+			}
+		}(hook)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// OfflineConfig задает автономный режим: если сеть или API недоступны, отправка приостанавливается,
+// элементы копятся в очереди и spool, а доступность API проверяется с растущим интервалом
+type OfflineConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	ProbeDelay   time.Duration `yaml:"probe_delay"`   // первая проверка доступности, по умолчанию 1s
+	ProbeMax     time.Duration `yaml:"probe_max"`     // наибольший интервал проверок, по умолчанию 1m
+	ProbeTimeout time.Duration `yaml:"probe_timeout"` // время ожидания ответа на проверку, по умолчанию 10s
+}
+
+// offlineMode хранит состояние автономного режима
+type offlineMode struct {
+	delay, max, timeout time.Duration
+
+	mu     sync.Mutex
+	since  time.Time     // начало недоступности; нулевое - API доступен
+	online chan struct{} // закрывается, когда API снова доступен
+	flush  chan struct{} // закрывается при вызове Flush, чтобы ожидающие элементы отправлялись
+	probes int
+}
+
+// WithOfflineMode включает автономный режим: после ошибки соединения или ответа 502, 503 или 504
+// буфер перестает брать элементы из очереди, а отправляемые элементы ждут, не расходуя попытки.
+// Доступность API проверяется запросом HEAD через delay, затем с удвоением интервала до max;
+// при первом ответе отправка возобновляется. Flush и Close отправляют элементы и без сети,
+// как и после Pause, с обычными повторами
+func WithOfflineMode(delay, maxDelay, timeout time.Duration) Option {
+	return func(b *Buffer) {
+		if delay <= 0 {
+			delay = time.Second
+		}
+		if maxDelay < delay {
+			maxDelay = max(time.Minute, delay)
+		}
+		if timeout <= 0 {
+			timeout = 10 * time.Second
+		}
+		b.offline = &offlineMode{delay: delay, max: maxDelay, timeout: timeout, flush: make(chan struct{})}
+	}
+}
+
+// unreachable сообщает, говорит ли попытка с таким статусом о недоступности сети или API
+func unreachable(status int) bool {
+	return status == 0 || status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// Offline сообщает, находится ли буфер в автономном режиме, и с какого времени
+func (b *Buffer) Offline() (bool, time.Time) {
+	if b.offline == nil {
+		return false, time.Time{}
+	}
+	b.offline.mu.Lock()
+	defer b.offline.mu.Unlock()
+	return !b.offline.since.IsZero(), b.offline.since
+}
+
+// goOffline переводит буфер в автономный режим после ошибки err и запускает проверки доступности
+func (b *Buffer) goOffline(err string) {
+	o := b.offline
+	o.mu.Lock()
+	if !o.since.IsZero() {
+		o.mu.Unlock()
+		return
+	}
+	now := b.clock.Now()
+	o.since, o.online, o.probes = now, make(chan struct{}), 0
+	o.mu.Unlock()
+
+	fmt.Printf("API is unreachable, switching to offline mode: %s\n", err)
+	b.notify(Alert{
+		Condition: AlertOffline,
+		Message:   fmt.Sprintf("API %s is unreachable, delivery is paused until it responds: %s", b.url, err),
+		Time:      now,
+	})
+	go b.probe()
+}
+
+// waitOnline ждет, пока API снова станет доступен, Flush не потребует отправки или буфер не закроется
+func (b *Buffer) waitOnline() {
+	o := b.offline
+	o.mu.Lock()
+	online, flush := o.online, o.flush
+	offline := !o.since.IsZero()
+	o.mu.Unlock()
+	if !offline || b.flushingNow() {
+		return
+	}
+	select {
+	case <-online:
+	case <-flush:
+	case <-b.stop:
+	}
+}
+
+// releaseHeld отпускает элементы, ожидающие доступности API, при вызове Flush
+func (o *offlineMode) releaseHeld() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	close(o.flush)
+	o.flush = make(chan struct{})
+}
+
+// holdOffline сообщает, должна ли неудачная из-за недоступности API попытка ждать возвращения
+// API, не расходуя попытки элемента
+func (b *Buffer) holdOffline() bool {
+	return b.offline != nil && !b.flushingNow()
+}
+
+// flushingNow сообщает, выполняется ли Flush
+func (b *Buffer) flushingNow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.flushing > 0
+}
+
+// offlineLocked сообщает, приостановлена ли отправка автономным режимом. Вызывается с захваченным b.mu
+func (b *Buffer) offlineLocked() bool {
+	if b.offline == nil {
+		return false
+	}
+	b.offline.mu.Lock()
+	defer b.offline.mu.Unlock()
+	return !b.offline.since.IsZero()
+}
+
+// probe проверяет доступность API с удвоением интервала и возвращает буфер в обычный режим
+func (b *Buffer) probe() {
+	o := b.offline
+	delay := o.delay
+	for {
+		timer := b.clock.NewTimer(delay)
+		select {
+		case <-b.stop:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+		o.mu.Lock()
+		o.probes++
+		o.mu.Unlock()
+		err := b.probeOnce()
+		if err == nil {
+			break
+		}
+		logDebugf("API is still unreachable, next check in %s: %v", min(2*delay, o.max), err)
+		delay = min(2*delay, o.max)
+	}
+
+	o.mu.Lock()
+	since, probes := o.since, o.probes
+	o.since = time.Time{}
+	close(o.online)
+	o.mu.Unlock()
+	fmt.Printf("API is reachable again after %s and %d checks, resuming delivery\n", b.clock.Now().Sub(since).Round(time.Millisecond), probes)
+	b.signal()
+}
+
+// probeOnce выполняет запрос HEAD к API без токена; доступностью считается любой ответ,
+// кроме 502, 503 и 504
+func (b *Buffer) probeOnce() error {
+	ctx, cancel := context.WithTimeout(context.Background(), b.offline.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, b.url, nil)
+	if err != nil {
+		return err
+	}
+	// Проверка не расходует квоту запросов и не ждет ограничения трафика, предназначенных отправкам
+	resp, err := b.direct.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if unreachable(resp.StatusCode) {
+		return fmt.Errorf("API responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Коды завершения программы
+const (
+	exitOK     = 0
+	exitError  = 1
+	exitUsage  = 2
+	exitFailed = 3 // часть элементов окончательно не доставлена или не прошла проверку
+)
+
+// errUsage сообщает о неверных аргументах командной строки
+var errUsage = errors.New("invalid usage")
+
+// errItemsFailed сообщает, что команда выполнена, но часть элементов окончательно не доставлена
+// или отвергнута проверкой
+var errItemsFailed = errors.New("some items failed")
+
+// Failure описывает окончательно неуспешный элемент или строку файла
+type Failure struct {
+	File   string       `json:"file,omitempty"`
+	Row    int          `json:"row,omitempty"`
+	ID     uint64       `json:"id,omitempty"`
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"` // ошибки полей, если элемент отвергнут проверкой
+}
+
+// Warning описывает элемент или строку файла, принятые API с предупреждениями
+type Warning struct {
+	File     string   `json:"file,omitempty"`
+	Row      int      `json:"row,omitempty"`
+	ID       uint64   `json:"id,omitempty"`
+	Warnings []string `json:"warnings"`
+}
+
+// Result представляет итог выполнения команды, выводимый с флагом -json
+type Result struct {
+	Command    string    `json:"command"`
+	OK         bool      `json:"ok"`
+	ExitCode   int       `json:"exit_code"`
+	Error      string    `json:"error,omitempty"`
+	Read       int       `json:"read"`
+	Sent       int       `json:"sent"`
+	Failed     int       `json:"failed"`
+	Skipped    int       `json:"skipped"`
+	Failures   []Failure `json:"failures,omitempty"`
+	Warnings   []Warning `json:"warnings,omitempty"` // принятые API с предупреждениями
+	DurationMs int64     `json:"duration_ms"`
+
+	start time.Time
+}
+
+// addRowErrors добавляет ошибки строк файла к списку неуспешных элементов
+func (r *Result) addRowErrors(file string, errs []RowError) {
+	for _, e := range errs {
+		r.Failures = append(r.Failures, Failure{File: file, Row: e.Row, Error: e.Error})
+	}
+}
+
+// addRowWarnings добавляет предупреждения API к строкам файла
+func (r *Result) addRowWarnings(file string, warnings []RowWarning) {
+	for _, w := range warnings {
+		r.Warnings = append(r.Warnings, Warning{File: file, Row: w.Row, Warnings: w.Warnings})
+	}
+}
+
+// addDeadLetters добавляет недоставленные элементы к списку неуспешных
+func (r *Result) addDeadLetters(letters []DeadLetter) {
+	for _, letter := range letters {
+		r.Failures = append(r.Failures, Failure{ID: letter.ID, Error: letter.Error})
+	}
+}
+
+// finish фиксирует код завершения и длительность команды
+func (r *Result) finish(err error) {
+	r.ExitCode = exitCode(err)
+	r.OK = r.ExitCode == exitOK
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		r.Error = err.Error()
+	}
+	r.DurationMs = time.Since(r.start).Milliseconds()
+}
+
+// write выводит итог в формате JSON
+func (r *Result) write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// parseFlags разбирает флаги подкоманды; ошибки разбора, кроме запроса справки, считаются ошибками использования
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil && !errors.Is(err, flag.ErrHelp) {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// exitCode возвращает код завершения для результата команды
+func exitCode(err error) int {
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.Is(err, errUsage):
+		return exitUsage
+	case errors.Is(err, errItemsFailed):
+		return exitFailed
+	default:
+		return exitError
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"hash/fnv"
+	"strconv"
+	"time"
+)
+
+// defaultOrderKey задает поле, внутри значения которого элементы доставляются по порядку
+const defaultOrderKey = "indicator_to_mo_id"
+
+// parkedCapacity ограничивает число элементов, отложенных до завершения предыдущего элемента
+// с тем же ключом; дальше очередь не просматривается, пока не освободится ключ
+const parkedCapacity = 4096
+
+// WithMaxInFlight разрешает до n одновременных запросов к API (по умолчанию 1), чтобы скрыть
+// задержку сети. Элементы с одинаковым ключом порядка все равно отправляются по одному
+// и подтверждаются в порядке добавления
+func WithMaxInFlight(n int) Option {
+	return func(b *Buffer) {
+		if n < 1 {
+			n = 1
+		}
+		b.maxInFlight = n
+	}
+}
+
+// WithOrderKey задает поле элемента, определяющее порядок доставки при нескольких одновременных
+// запросах (по умолчанию indicator_to_mo_id). Элементы без этого поля отправляются без упорядочивания
+func WithOrderKey(field string) Option {
+	return func(b *Buffer) {
+		if field == "" {
+			field = defaultOrderKey
+		}
+		b.orderKey = field
+	}
+}
+
+// WithShards делит очередь на n частей по хешу ключа порядка. Каждая часть отправляет элементы
+// по одному, поэтому порядок внутри показателя сохраняется, а общая пропускная способность растет
+// с числом частей. Задает и число одновременных запросов, заменяя WithMaxInFlight
+func WithShards(n int) Option {
+	return func(b *Buffer) {
+		if n < 1 {
+			n = 1
+		}
+		b.shards = n
+	}
+}
+
+// sequenceKey возвращает ключ порядка элемента; ok не задан, если элемент можно отправлять
+// независимо от остальных. При делении на части ключом служит номер части
+func (b *Buffer) sequenceKey(item *queuedItem) (key string, ok bool) {
+	key = item.fields.Get(b.orderKey)
+	if b.shards > 0 {
+		return strconv.Itoa(b.shardOf(key, item.id)), true
+	}
+	return key, key != ""
+}
+
+// shardOf возвращает номер части очереди для ключа; элементы без ключа распределяются по идентификатору
+func (b *Buffer) shardOf(key string, id uint64) int {
+	if key == "" {
+		return int(id % uint64(b.shards))
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(b.shards))
+}
+
+// throttledItem представляет элемент, отложенный ограничением частоты его пользователя
+type throttledItem struct {
+	item *queuedItem
+	at   time.Time // время появления маркера
+}
+
+// nextLocked возвращает следующий элемент для отправки или nil. Первыми отправляются элементы,
+// маркер пользователя которых уже появился. Элемент пользователя, исчерпавшего запас, откладывается
+// вместе с ключом порядка, поэтому следующие элементы с тем же ключом ждут его.
+// Вызывается с захваченным b.mu
+func (b *Buffer) nextLocked() *queuedItem {
+	if item := b.releaseLocked(b.clock.Now()); item != nil {
+		return item
+	}
+	for {
+		item := b.orderedNextLocked()
+		if item == nil || b.users == nil {
+			return item
+		}
+		now := b.clock.Now()
+		wait := b.users.reserve(item.fields, now)
+		if wait <= 0 {
+			return item
+		}
+		b.throttled = append(b.throttled, throttledItem{item: item, at: now.Add(wait)})
+		b.parked++
+	}
+}
+
+// releaseLocked возвращает первый отложенный элемент, маркер которого появился к now, или nil.
+// Маркеры одного пользователя выдаются по порядку, поэтому его элементы отправляются в порядке
+// откладывания. Вызывается с захваченным b.mu
+func (b *Buffer) releaseLocked(now time.Time) *queuedItem {
+	for i, t := range b.throttled {
+		if !t.at.After(now) {
+			b.throttled = append(b.throttled[:i], b.throttled[i+1:]...)
+			b.parked--
+			return t.item
+		}
+	}
+	return nil
+}
+
+// throttleWaitLocked возвращает время до появления ближайшего маркера отложенного элемента;
+// ok не задан, если отложенных элементов нет. Вызывается с захваченным b.mu
+func (b *Buffer) throttleWaitLocked() (wait time.Duration, ok bool) {
+	if len(b.throttled) == 0 {
+		return 0, false
+	}
+	next := b.throttled[0].at
+	for _, t := range b.throttled[1:] {
+		if t.at.Before(next) {
+			next = t.at
+		}
+	}
+	return next.Sub(b.clock.Now()), true
+}
+
+// orderedNextLocked возвращает следующий элемент по ключам порядка или nil. Сначала отправляются
+// элементы, дождавшиеся завершения предыдущего элемента с тем же ключом, затем элементы очереди;
+// элемент, ключ которого уже отправляется, откладывается. Вызывается с захваченным b.mu
+func (b *Buffer) orderedNextLocked() *queuedItem {
+	if len(b.ready) > 0 {
+		item := b.ready[0]
+		b.ready = b.ready[1:]
+		b.parked--
+		return item
+	}
+	for b.queue.Len() > 0 && b.parked < parkedCapacity {
+		item := b.queue.Pop()
+		if item.edit != nil {
+			if err := b.applyEditLocked(item); err != nil {
+				b.rejected = append(b.rejected, rejectedItem{item: item, err: err})
+				continue
+			}
+		}
+		key, ok := b.sequenceKey(item)
+		if !ok {
+			return item
+		}
+		// Ключ запоминается, потому что Update может изменить поле ключа отложенного элемента
+		item.sequence, item.sequenced = key, true
+		if waiting, busy := b.waiting[key]; busy {
+			b.waiting[key] = append(waiting, item)
+			b.parked++
+			continue
+		}
+		b.waiting[key] = nil
+		return item
+	}
+	return nil
+}
+
+// run доставляет элемент и освобождает его место в отправке. Следующий элемент с тем же ключом
+// становится готовым только после завершения текущего, поэтому подтверждения внутри ключа
+// приходят в порядке добавления
+func (b *Buffer) run(item *queuedItem) {
+	b.hooks.dequeued(item.id)
+	err := b.deliver(item)
+	b.hooks.released(item.id, err)
+
+	b.mu.Lock()
+	delete(b.inFlight, item.id)
+	b.releaseSequenceLocked(item)
+	if b.pending.Add(-1) == 0 {
+		b.cond.Broadcast()
+	}
+	b.mu.Unlock()
+	b.signal()
+}
+
+// releaseSequenceLocked освобождает ключ порядка, занятый элементом, и делает готовым следующий
+// элемент с тем же ключом. Вызывается с захваченным b.mu
+func (b *Buffer) releaseSequenceLocked(item *queuedItem) {
+	if !item.sequenced {
+		return
+	}
+	item.sequenced = false
+	if waiting := b.waiting[item.sequence]; len(waiting) > 0 {
+		b.ready = append(b.ready, waiting[0])
+		b.waiting[item.sequence] = waiting[1:]
+	} else {
+		delete(b.waiting, item.sequence)
+	}
+}
+
+// parkedLocked сообщает, отложен ли элемент до завершения предыдущего элемента с тем же ключом
+// или до появления маркера его пользователя.
+// Вызывается с захваченным b.mu
+func (b *Buffer) parkedLocked(id uint64) bool {
+	for _, item := range b.ready {
+		if item.id == id {
+			return true
+		}
+	}
+	for _, t := range b.throttled {
+		if t.item.id == id {
+			return true
+		}
+	}
+	for _, waiting := range b.waiting {
+		for _, item := range waiting {
+			if item.id == id {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestShardsKeepOrderPerKey проверяет, что части очереди отправляются параллельно, а значения
+// одного показателя приходят в порядке добавления
+func TestShardsKeepOrderPerKey(t *testing.T) {
+	SetLogLevel(LevelError)
+	var mu sync.Mutex
+	active, peak := 0, 0
+	values := make(map[string][]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		peak = max(peak, active)
+		key := r.FormValue("indicator_to_mo_id")
+		values[key] = append(values[key], r.FormValue("value"))
+		mu.Unlock()
+		time.Sleep(2 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		w.Write([]byte(`{"MESSAGES":{"error":null,"warning":null,"info":[]},"DATA":{"indicator_to_mo_fact_id":1},"STATUS":"OK"}`))
+	}))
+	defer srv.Close()
+
+	const shards = 2
+	b := NewBuffer(srv.URL, "token", WithShards(shards))
+	keys := []string{"1", "2", "3", "4"}
+	used := make(map[int]bool)
+	for _, key := range keys {
+		used[b.shardOf(key, 0)] = true
+	}
+	var want []string
+	for i := 0; i < 10; i++ {
+		want = append(want, strconv.Itoa(i))
+		for _, key := range keys {
+			if err := b.Add(map[string]string{"indicator_to_mo_id": key, "value": strconv.Itoa(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	b.Flush()
+	b.Close()
+
+	for _, key := range keys {
+		if !slices.Equal(values[key], want) {
+			t.Errorf("indicator %s delivered as %v, want %v", key, values[key], want)
+		}
+	}
+	if peak > shards || peak < len(used) {
+		t.Errorf("%d concurrent requests, want %d for keys in %d shards", peak, len(used), len(used))
+	}
+}
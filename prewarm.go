@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// prewarmTimeout ограничивает время установки одного соединения при прогреве
+const prewarmTimeout = 10 * time.Second
+
+// WithPrewarm заранее устанавливает n соединений с API при создании буфера, чтобы первые запросы
+// не ждали TCP и TLS. При idle > 0 соединения устанавливаются заново, если отправок не было
+// дольше idle; idle стоит выбирать меньше http.idle_conn_timeout
+func WithPrewarm(n int, idle time.Duration) Option {
+	return func(b *Buffer) {
+		b.prewarm, b.prewarmIdle = n, idle
+	}
+}
+
+// keepWarm прогревает соединения при запуске и после простоя до остановки буфера
+func (b *Buffer) keepWarm() {
+	b.warm()
+	if b.prewarmIdle <= 0 {
+		return
+	}
+	ticker := b.clock.NewTicker(b.prewarmIdle)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C():
+			if b.clock.Now().Sub(time.Unix(0, b.lastSend.Load())) >= b.prewarmIdle {
+				b.warm()
+			}
+		}
+	}
+}
+
+// warm одновременно выполняет b.prewarm запросов HEAD к API, чтобы транспорт открыл соединения
+// и сохранил их для повторного использования. Токен не передается: ответ не важен, нужен только
+// установленный канал
+func (b *Buffer) warm() {
+	var (
+		wg      sync.WaitGroup
+		failed  atomic.Int32
+		lastErr atomic.Value
+	)
+	start := time.Now()
+	for i := 0; i < b.prewarm; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), prewarmTimeout)
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, b.url, nil)
+			if err == nil {
+				var resp *http.Response
+				if resp, err = b.client.Do(req); err == nil {
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+					return
+				}
+			}
+			failed.Add(1)
+			lastErr.Store(err)
+		}()
+	}
+	wg.Wait()
+	if n := failed.Load(); n > 0 {
+		fmt.Printf("Error pre-warming %d of %d connections to API: %v\n", n, b.prewarm, lastErr.Load())
+		return
+	}
+	logDebugf("Pre-warmed %d connections to API in %s", b.prewarm, time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// selectProfile накладывает настройки выбранного профиля поверх общих.
+//
+// Если в файле заданы профили, один из них обязан быть выбран явно, чтобы данные из тестового
+// окружения не ушли в рабочее по настройкам, оставшимся от предыдущего запуска
+func (c *Config) selectProfile(name string) error {
+	c.profile = name
+	if len(c.Profiles) == 0 {
+		if name != "" {
+			return fmt.Errorf("profile %q selected, but the config defines no profiles", name)
+		}
+		return nil
+	}
+	if name == "" {
+		return fmt.Errorf("config defines profiles %s, select one with -profile or BUFFER_PROFILE", c.profileNames())
+	}
+	node, ok := c.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q, available profiles: %s", name, c.profileNames())
+	}
+
+	data, err := yaml.Marshal(&node)
+	if err != nil {
+		return fmt.Errorf("profile %s: %w", name, err)
+	}
+	var overlay Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&overlay); err != nil {
+		return fmt.Errorf("profile %s: %w", name, err)
+	}
+	if len(overlay.Profiles) > 0 {
+		return fmt.Errorf("profile %s: profiles cannot be nested", name)
+	}
+	dec = yaml.NewDecoder(bytes.NewReader(data))
+	return dec.Decode(c)
+}
+
+// Profile возвращает имя выбранного профиля или пустую строку, если профили не используются
+func (c *Config) Profile() string {
+	return c.profile
+}
+
+// profileNames возвращает имена профилей через запятую в алфавитном порядке
+func (c *Config) profileNames() string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
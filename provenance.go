@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Provenance описывает происхождение элемента: откуда и кем он передан. Сохраняется в журналах
+// аудита и событий и в очереди недоставленных, чтобы ошибочное значение можно было найти в источнике
+type Provenance struct {
+	Source string `json:"source,omitempty"` // система или команда, передавшая элемент
+	File   string `json:"file,omitempty"`
+	Row    int    `json:"row,omitempty"` // номер строки файла, начиная с 1
+	User   string `json:"user,omitempty"`
+	Host   string `json:"host,omitempty"`
+}
+
+// String возвращает происхождение для журнала, например "import data.csv:12 by ivan@srv1"
+func (p *Provenance) String() string {
+	if p == nil {
+		return ""
+	}
+	var parts []string
+	if p.Source != "" {
+		parts = append(parts, p.Source)
+	}
+	if p.File != "" {
+		file := p.File
+		if p.Row > 0 {
+			file += ":" + strconv.Itoa(p.Row)
+		}
+		parts = append(parts, file)
+	}
+	switch {
+	case p.User != "" && p.Host != "":
+		parts = append(parts, "by "+p.User+"@"+p.Host)
+	case p.User != "":
+		parts = append(parts, "by "+p.User)
+	case p.Host != "":
+		parts = append(parts, "on "+p.Host)
+	}
+	return strings.Join(parts, " ")
+}
+
+// WithProvenance сохраняет происхождение элемента
+func WithProvenance(p Provenance) ItemOption {
+	return func(item *queuedItem) {
+		item.provenance = &p
+	}
+}
+
+// localOrigin хранит пользователя и имя узла текущего процесса, определяемые один раз
+var localOrigin = sync.OnceValue(func() Provenance {
+	var p Provenance
+	if u, err := user.Current(); err == nil {
+		p.User = u.Username
+	} else {
+		p.User = os.Getenv("USER")
+	}
+	p.Host, _ = os.Hostname()
+	return p
+})
+
+// localProvenance возвращает происхождение элемента, переданного этим процессом из source
+func localProvenance(source string) Provenance {
+	p := localOrigin()
+	p.Source = source
+	return p
+}
+
+// provenanceSize оценивает память, занимаемую происхождением элемента
+func provenanceSize(p *Provenance) int {
+	if p == nil {
+		return 0
+	}
+	return 64 + len(p.Source) + len(p.File) + len(p.User) + len(p.Host)
+}
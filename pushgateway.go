@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// PushgatewayConfig задает адрес Prometheus Pushgateway и метки группировки метрик
+type PushgatewayConfig struct {
+	URL      string
+	Job      string
+	Instance string
+	Client   *http.Client
+}
+
+// PushMetrics отправляет итоговые метрики буфера и длительность запуска в Pushgateway,
+// что позволяет сохранить результаты коротких запусков, завершающихся до очередного опроса
+func (b *Buffer) PushMetrics(cfg PushgatewayConfig, duration time.Duration) error {
+	if cfg.Job == "" {
+		return fmt.Errorf("pushgateway job label is required")
+	}
+	var body bytes.Buffer
+	if err := b.WriteMetrics(&body); err != nil {
+		return err
+	}
+	fmt.Fprintf(&body, "# HELP buffer_run_duration_seconds Duration of the run that pushed these metrics.\n")
+	fmt.Fprintf(&body, "# TYPE buffer_run_duration_seconds gauge\n")
+	fmt.Fprintf(&body, "buffer_run_duration_seconds %s\n", formatSeconds(duration.Seconds()))
+	fmt.Fprintf(&body, "# HELP buffer_last_push_timestamp_seconds Time of the last push.\n")
+	fmt.Fprintf(&body, "# TYPE buffer_last_push_timestamp_seconds gauge\n")
+	fmt.Fprintf(&body, "buffer_last_push_timestamp_seconds %d\n", time.Now().Unix())
+
+	target := strings.TrimRight(cfg.URL, "/") + "/metrics/job/" + url.PathEscape(cfg.Job)
+	if cfg.Instance != "" {
+		target += "/instance/" + url.PathEscape(cfg.Instance)
+	}
+	req, err := http.NewRequest(http.MethodPut, target, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := notifierClient(cfg.Client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("pushgateway responded with %s", resp.Status)
+	}
+	return nil
+}
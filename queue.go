@@ -0,0 +1,203 @@
+package main
+
+import "fmt"
+
+// minQueueCapacity задает емкость, ниже которой очередь не уменьшается
+const minQueueCapacity = 64
+
+// itemQueue представляет очередь элементов на кольцевом буфере: добавление в конец и извлечение
+// из начала выполняются за O(1) без перевыделения памяти. Емкость удваивается при заполнении
+// и уменьшается вдвое, когда занято меньше четверти, чтобы после пика нагрузки память освобождалась
+type itemQueue struct {
+	items []*queuedItem
+	head  int // индекс первого элемента
+	n     int // количество элементов в памяти
+	min   int // емкость, ниже которой очередь не уменьшается
+
+	limit int64  // ограничение памяти элементов, 0 - без ограничения
+	size  int64  // оценка памяти элементов в items
+	spool *Spool // продолжение очереди на диске
+	lost  lostItems
+}
+
+// lostItems описывает элементы, потерянные из-за ошибки чтения spool
+type lostItems struct {
+	n         int
+	err       error
+	callbacks []itemHandlers
+}
+
+// newItemQueue создает очередь с начальной емкостью capacity
+func newItemQueue(capacity int) *itemQueue {
+	capacity = max(capacity, minQueueCapacity)
+	return &itemQueue{items: make([]*queuedItem, capacity), min: capacity}
+}
+
+// Len возвращает количество элементов в очереди, включая вытесненные на диск
+func (q *itemQueue) Len() int {
+	if q.spool != nil {
+		return q.n + q.spool.Len()
+	}
+	return q.n
+}
+
+// Resident возвращает количество элементов в памяти; они идут в очереди первыми
+func (q *itemQueue) Resident() int {
+	return q.n
+}
+
+// Spilled возвращает количество элементов, вытесненных на диск
+func (q *itemQueue) Spilled() int {
+	if q.spool == nil {
+		return 0
+	}
+	return q.spool.Len()
+}
+
+// Cap возвращает текущую емкость очереди
+func (q *itemQueue) Cap() int {
+	return len(q.items)
+}
+
+// Push добавляет элемент в конец очереди. Если память элементов превысит ограничение или часть
+// очереди уже на диске, элемент записывается в spool, чтобы сохранить порядок
+func (q *itemQueue) Push(item *queuedItem) {
+	if q.spool != nil && (q.spool.Len() > 0 || q.size+itemSize(item) > q.limit) {
+		err := q.spool.push(item)
+		if err == nil {
+			return
+		}
+		fmt.Println("Error writing spool, keeping item in memory:", err)
+	}
+	q.pushResident(item)
+}
+
+// pushResident добавляет элемент в конец части очереди в памяти
+func (q *itemQueue) pushResident(item *queuedItem) {
+	if q.limit > 0 {
+		q.size += itemSize(item)
+	}
+	if q.n == len(q.items) {
+		q.resize(2 * len(q.items))
+	}
+	q.items[(q.head+q.n)%len(q.items)] = item
+	q.n++
+}
+
+// Pop извлекает первый элемент очереди; для пустой очереди возвращает nil.
+// Когда в памяти остается меньше половины ограничения, элементы загружаются из spool
+func (q *itemQueue) Pop() *queuedItem {
+	if q.spool != nil && q.size < q.limit/2 {
+		q.pageIn()
+	}
+	if q.n == 0 {
+		return nil
+	}
+	item := q.items[q.head]
+	q.items[q.head] = nil // чтобы отправленный элемент не удерживался в памяти
+	q.head = (q.head + 1) % len(q.items)
+	q.n--
+	if q.limit > 0 {
+		q.size -= itemSize(item)
+	}
+	if len(q.items) > q.min && q.n < len(q.items)/4 {
+		q.resize(max(len(q.items)/2, q.min))
+	}
+	return item
+}
+
+// pageIn загружает элементы из spool, пока они помещаются в ограничение памяти
+func (q *itemQueue) pageIn() {
+	for q.spool.Len() > 0 && (q.n == 0 || q.size < q.limit) {
+		item, err := q.spool.pop()
+		if err != nil {
+			n, callbacks := q.spool.discard()
+			fmt.Printf("Error reading spool, %d items lost: %v\n", n, err)
+			q.lost.n += n
+			q.lost.err = fmt.Errorf("item lost from spool: %w", err)
+			q.lost.callbacks = append(q.lost.callbacks, callbacks...)
+			return
+		}
+		q.pushResident(item)
+	}
+}
+
+// spoolFull сообщает, что spool заполнен, а правило заполнения - policy
+func (q *itemQueue) spoolFull(policy string) bool {
+	return q.spool != nil && q.spool.options.Full == policy && q.spool.full()
+}
+
+// SpoolSize возвращает место на диске, занятое spool
+func (q *itemQueue) SpoolSize() int64 {
+	if q.spool == nil {
+		return 0
+	}
+	return q.spool.Size()
+}
+
+// takeDropped возвращает обработчики элементов, сброшенных из заполненного spool
+func (q *itemQueue) takeDropped() []itemHandlers {
+	if q.spool == nil {
+		return nil
+	}
+	return q.spool.takeDropped()
+}
+
+// takeLost возвращает и сбрасывает сведения о потерянных элементах
+func (q *itemQueue) takeLost() lostItems {
+	lost := q.lost
+	q.lost = lostItems{}
+	return lost
+}
+
+// At возвращает i-й элемент от начала очереди; i должен быть меньше Resident
+func (q *itemQueue) At(i int) *queuedItem {
+	return q.items[(q.head+i)%len(q.items)]
+}
+
+// remove удаляет i-й элемент от начала очереди, сдвигая последующие; i должен быть меньше Resident
+func (q *itemQueue) remove(i int) *queuedItem {
+	item := q.At(i)
+	for j := i; j < q.n-1; j++ {
+		q.items[(q.head+j)%len(q.items)] = q.items[(q.head+j+1)%len(q.items)]
+	}
+	q.items[(q.head+q.n-1)%len(q.items)] = nil
+	q.n--
+	if q.limit > 0 {
+		q.size -= itemSize(item)
+	}
+	return item
+}
+
+// setFields заменяет данные элемента очереди в памяти, учитывая изменение занимаемой памяти
+func (q *itemQueue) setFields(item *queuedItem, fields itemFields) {
+	if q.limit > 0 {
+		q.size -= itemSize(item)
+	}
+	item.fields = fields
+	if q.limit > 0 {
+		q.size += itemSize(item)
+	}
+}
+
+// resize переносит элементы в массив заданной емкости, начиная с его начала
+func (q *itemQueue) resize(capacity int) {
+	items := make([]*queuedItem, capacity)
+	if q.head+q.n <= len(q.items) {
+		copy(items, q.items[q.head:q.head+q.n])
+	} else {
+		k := copy(items, q.items[q.head:])
+		copy(items[k:], q.items[:q.n-k])
+	}
+	q.items, q.head = items, 0
+}
+
+// WithQueueCapacity задает начальную емкость очереди, чтобы при известном объеме данных
+// она не увеличивалась по ходу добавления
+func WithQueueCapacity(capacity int) Option {
+	return func(b *Buffer) {
+		q := newItemQueue(capacity)
+		q.limit, q.spool = b.queue.limit, b.queue.spool
+		b.queue = q
+	}
+}
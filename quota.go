@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// QuotaConfig задает квоту запросов к API на час и на сутки, общую для ключа API. По мере
+// расходования квоты запросы растягиваются до конца периода, а исчерпанная квота ждет его начала
+type QuotaConfig struct {
+	Hourly     int     `yaml:"hourly"`      // запросов за календарный час, 0 - без ограничения
+	Daily      int     `yaml:"daily"`       // запросов за календарные сутки, 0 - без ограничения
+	SlowdownAt float64 `yaml:"slowdown_at"` // доля квоты, после которой запросы замедляются, по умолчанию 0.8
+	Timezone   string  `yaml:"timezone"`    // пояс IANA начала суток, пусто - местный
+}
+
+// enabled сообщает, ограничено ли число запросов
+func (c QuotaConfig) enabled() bool {
+	return c.Hourly > 0 || c.Daily > 0
+}
+
+// validate проверяет квоту и пояс
+func (c QuotaConfig) validate() error {
+	if c.Hourly < 0 || c.Daily < 0 {
+		return fmt.Errorf("hourly and daily must not be negative")
+	}
+	if c.SlowdownAt < 0 || c.SlowdownAt > 1 {
+		return fmt.Errorf("slowdown_at must be between 0 and 1")
+	}
+	if _, err := loadLocation(c.Timezone); err != nil {
+		return fmt.Errorf("timezone: %w", err)
+	}
+	return nil
+}
+
+// QuotaUsage описывает расход квоты за текущий период
+type QuotaUsage struct {
+	Period    string    `json:"period"` // hour или day
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	Resets    time.Time `json:"resets"` // начало следующего периода
+}
+
+// quotaPeriod считает запросы за один период квоты
+type quotaPeriod struct {
+	name     string
+	limit    int
+	used     int
+	resets   time.Time
+	last     time.Time // время последнего запроса
+	slowed   bool      // о замедлении в этом периоде сообщено
+	exceeded bool      // об исчерпании в этом периоде сообщено
+}
+
+// apiQuota ограничивает число запросов к API за час и сутки
+type apiQuota struct {
+	mu       sync.Mutex
+	loc      *time.Location
+	slowdown float64
+	periods  []*quotaPeriod
+}
+
+// WithQuota ограничивает все запросы к API, включая get_facts и проверки соединения, квотой c. После
+// доли квоты slowdown_at запросы распределяются равномерно до конца периода, чтобы немного квоты
+// оставалось другим пользователям ключа; исчерпанная квота ждет начала следующего часа или суток.
+// Flush и Close тоже ждут квоту. Счетчики ведутся в процессе и не переживают перезапуск
+func WithQuota(c QuotaConfig) Option {
+	return func(b *Buffer) {
+		if b.quota == nil {
+			b.quota = &apiQuota{}
+		}
+		b.quota.set(c)
+	}
+}
+
+// set меняет квоту, сохраняя расход текущих периодов
+func (q *apiQuota) set(c QuotaConfig) {
+	loc, err := loadLocation(c.Timezone)
+	if err != nil {
+		loc = time.Local
+	}
+	slowdown := c.SlowdownAt
+	if slowdown == 0 {
+		slowdown = 0.8
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	previous := make(map[string]*quotaPeriod, len(q.periods))
+	for _, p := range q.periods {
+		previous[p.name] = p
+	}
+	q.loc, q.slowdown, q.periods = loc, slowdown, nil
+	for _, p := range []struct {
+		name  string
+		limit int
+	}{{"hour", c.Hourly}, {"day", c.Daily}} {
+		if p.limit <= 0 {
+			continue
+		}
+		period := previous[p.name]
+		if period == nil || period.loc() != loc {
+			period = &quotaPeriod{name: p.name}
+		}
+		period.limit = p.limit
+		q.periods = append(q.periods, period)
+	}
+}
+
+// loc возвращает пояс, в котором отсчитан текущий период
+func (p *quotaPeriod) loc() *time.Location {
+	return p.resets.Location()
+}
+
+// roll начинает новый период, если текущий закончился к моменту now
+func (p *quotaPeriod) roll(now time.Time, loc *time.Location) {
+	if !p.resets.IsZero() && now.Before(p.resets) {
+		return
+	}
+	now = now.In(loc)
+	if p.name == "hour" {
+		p.resets = time.Date(now.Year(), now.Month(), now.Day(), now.Hour()+1, 0, 0, 0, loc)
+	} else {
+		p.resets = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
+	}
+	p.used, p.slowed, p.exceeded = 0, false, false
+}
+
+// take учитывает запрос в момент now, если квота позволяет, и иначе возвращает время ожидания
+func (q *apiQuota) take(now time.Time) time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var wait time.Duration
+	for _, p := range q.periods {
+		p.roll(now, q.loc)
+		if p.used >= p.limit {
+			if !p.exceeded {
+				p.exceeded = true
+				logWarnf("API quota of %d requests per %s is exhausted, waiting until %s", p.limit, p.name, p.resets.Format(time.DateTime))
+			}
+			wait = max(wait, p.resets.Sub(now))
+			continue
+		}
+		if float64(p.used) < q.slowdown*float64(p.limit) {
+			continue
+		}
+		if !p.slowed {
+			p.slowed = true
+			logWarnf("%d of %d API requests per %s used, slowing down until %s", p.used, p.limit, p.name, p.resets.Format(time.DateTime))
+		}
+		interval := p.resets.Sub(now) / time.Duration(p.limit-p.used)
+		if next := p.last.Add(interval); next.After(now) {
+			wait = max(wait, next.Sub(now))
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+	for _, p := range q.periods {
+		p.used++
+		p.last = now
+	}
+	return 0
+}
+
+// usage возвращает расход квоты к моменту now
+func (q *apiQuota) usage(now time.Time) []QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	usage := make([]QuotaUsage, 0, len(q.periods))
+	for _, p := range q.periods {
+		p.roll(now, q.loc)
+		usage = append(usage, QuotaUsage{Period: p.name, Limit: p.limit, Used: p.used, Remaining: p.limit - p.used, Resets: p.resets})
+	}
+	return usage
+}
+
+// Quota возвращает расход квоты запросов к API; nil, если квота не задана
+func (b *Buffer) Quota() []QuotaUsage {
+	if b.quota == nil {
+		return nil
+	}
+	return b.quota.usage(b.clock.Now())
+}
+
+// quotaTransport выдерживает ожидание квоты перед каждым запросом к API
+type quotaTransport struct {
+	b    *Buffer
+	next http.RoundTripper
+}
+
+// newQuotaTransport создает транспорт с квотой запросов; next nil означает http.DefaultTransport
+func newQuotaTransport(b *Buffer, next http.RoundTripper) *quotaTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &quotaTransport{b: b, next: next}
+}
+
+func (t *quotaTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for {
+		wait := t.b.quota.take(t.b.clock.Now())
+		if wait == 0 {
+			return t.next.RoundTrip(req)
+		}
+		timer := t.b.clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-t.b.stop:
+			timer.Stop()
+			return nil, fmt.Errorf("buffer is closed while waiting for API quota")
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestQuotaSlowsDownAndWaitsForReset проверяет равномерное распределение запросов после доли
+// slowdown_at и ожидание начала следующего часа после исчерпания квоты
+func TestQuotaSlowsDownAndWaitsForReset(t *testing.T) {
+	SetLogLevel(LevelError)
+	q := &apiQuota{}
+	q.set(QuotaConfig{Hourly: 10, SlowdownAt: 0.5, Timezone: "UTC"})
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	for i := 0; i < 5; i++ {
+		if wait := q.take(now); wait != 0 {
+			t.Fatalf("request %d waits %s before slowdown_at", i+1, wait)
+		}
+	}
+	// Оставшиеся 5 запросов часа распределяются по оставшемуся времени часа
+	var sent []string
+	for len(sent) < 4 {
+		wait := q.take(now)
+		if wait == 0 {
+			sent = append(sent, now.Format("15:04"))
+			continue
+		}
+		now = now.Add(wait)
+	}
+	if want := []string{"12:12", "12:24", "12:36", "12:48"}; !slices.Equal(sent, want) {
+		t.Errorf("slowed requests sent at %v, want %v", sent, want)
+	}
+	if usage := q.usage(now); len(usage) != 1 || usage[0].Used != 9 || usage[0].Remaining != 1 || !usage[0].Resets.Equal(start.Add(time.Hour)) {
+		t.Errorf("usage = %+v", usage)
+	}
+
+	// Без замедления исчерпанная квота ждет начала следующего часа
+	q.set(QuotaConfig{Hourly: 10, SlowdownAt: 1, Timezone: "UTC"})
+	if wait := q.take(now); wait != 0 {
+		t.Fatalf("last request of the hour waits %s", wait)
+	}
+	if wait := q.take(now); !now.Add(wait).Equal(start.Add(time.Hour)) {
+		t.Errorf("exhausted quota waits until %s, want 13:00", now.Add(wait).Format("15:04"))
+	}
+	now = start.Add(time.Hour)
+	if wait := q.take(now); wait != 0 {
+		t.Errorf("first request of the next hour waits %s", wait)
+	}
+	if usage := q.usage(now); usage[0].Used != 1 || usage[0].Remaining != 9 {
+		t.Errorf("usage after reset = %+v", usage)
+	}
+}
+
+func TestBufferQuota(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC))
+	b := NewBuffer("http://127.0.0.1:0", "token", WithClock(clock), WithDryRun())
+	defer b.Close()
+	if b.Quota() != nil {
+		t.Errorf("quota reported without WithQuota: %+v", b.Quota())
+	}
+	b = NewBuffer("http://127.0.0.1:0", "token", WithClock(clock), WithDryRun(), WithQuota(QuotaConfig{Hourly: 100, Daily: 1000, Timezone: "UTC"}))
+	defer b.Close()
+	usage := b.Quota()
+	if len(usage) != 2 || usage[0].Period != "hour" || usage[0].Remaining != 100 || usage[1].Period != "day" ||
+		!usage[1].Resets.Equal(time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("quota = %+v", usage)
+	}
+}
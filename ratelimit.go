@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// rateLimiter ограничивает частоту запросов по алгоритму маркерной корзины
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // маркеров в секунду
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter создает ограничитель на perSecond запросов в секунду с запасом burst;
+// perSecond 0 снимает ограничение
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	l := &rateLimiter{}
+	l.set(perSecond, burst)
+	l.tokens = l.burst
+	return l
+}
+
+// set меняет частоту и запас ограничителя, не сбрасывая накопленные маркеры сверх нового запаса
+func (l *rateLimiter) set(perSecond float64, burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate, l.burst = perSecond, float64(burst)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// reserve забирает маркер в момент now и возвращает время ожидания до его появления
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	return l.reserveN(now, 1)
+}
+
+// reserveN забирает n маркеров в момент now и возвращает время ожидания до их появления;
+// n может быть больше запаса, тогда ожидание дольше
+func (l *rateLimiter) reserveN(now time.Time, n float64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	if l.last.IsZero() {
+		l.last = now
+	}
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= n
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// WithRateLimit ограничивает отправку perSecond запросами в секунду с запасом burst
+func WithRateLimit(perSecond float64, burst int) Option {
+	return func(b *Buffer) {
+		b.limiter.set(perSecond, burst)
+	}
+}
+
+// defaultUserField задает поле элемента с пользователем, от имени которого передается факт
+const defaultUserField = "auth_user_id"
+
+// idleLimiterSweep задает, через сколько выданных маркеров удаляются ограничители простаивающих ключей
+const idleLimiterSweep = 1024
+
+// KeyRateLimit задает частоту и запас ограничителя одного ключа; per_second 0 снимает ограничение
+type KeyRateLimit struct {
+	PerSecond float64 `yaml:"per_second"`
+	Burst     int     `yaml:"burst"`
+}
+
+// UserRateLimitConfig задает ограничение частоты отправки от имени каждого пользователя отдельно,
+// чтобы большая дозагрузка одного пользователя не задерживала остальных
+type UserRateLimitConfig struct {
+	Field     string                  `yaml:"field"`      // поле с пользователем, по умолчанию auth_user_id
+	PerSecond float64                 `yaml:"per_second"` // отправок в секунду от одного пользователя, 0 - без ограничения
+	Burst     int                     `yaml:"burst"`
+	Users     map[string]KeyRateLimit `yaml:"users"` // отдельные ограничения для значений поля
+}
+
+// enabled сообщает, ограничена ли частота хотя бы для одного пользователя
+func (c UserRateLimitConfig) enabled() bool {
+	return c.PerSecond > 0 || len(c.Users) > 0
+}
+
+// validate проверяет, что частоты не отрицательны
+func (c UserRateLimitConfig) validate() error {
+	if c.PerSecond < 0 {
+		return fmt.Errorf("per_second must not be negative")
+	}
+	for user, limit := range c.Users {
+		if limit.PerSecond < 0 {
+			return fmt.Errorf("users.%s.per_second must not be negative", user)
+		}
+	}
+	return nil
+}
+
+// keyedLimiter ограничивает частоту отправки отдельно для каждого значения поля элемента
+type keyedLimiter struct {
+	mu       sync.Mutex
+	field    string
+	limit    KeyRateLimit
+	users    map[string]KeyRateLimit
+	limiters map[string]*rateLimiter
+	taken    int
+}
+
+// newKeyedLimiter создает ограничитель по настройкам
+func newKeyedLimiter(c UserRateLimitConfig) *keyedLimiter {
+	l := &keyedLimiter{limiters: make(map[string]*rateLimiter)}
+	l.set(c)
+	return l
+}
+
+// set меняет ограничения; при смене поля накопленные маркеры сбрасываются
+func (l *keyedLimiter) set(c UserRateLimitConfig) {
+	field := c.Field
+	if field == "" {
+		field = defaultUserField
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if field != l.field {
+		clear(l.limiters)
+	}
+	l.field, l.limit, l.users = field, KeyRateLimit{PerSecond: c.PerSecond, Burst: c.Burst}, c.Users
+	for key, limiter := range l.limiters {
+		limit := l.limitLocked(key)
+		limiter.set(limit.PerSecond, limit.Burst)
+	}
+}
+
+// limitLocked возвращает ограничение ключа. Вызывается с захваченным l.mu
+func (l *keyedLimiter) limitLocked(key string) KeyRateLimit {
+	if limit, ok := l.users[key]; ok {
+		return limit
+	}
+	return l.limit
+}
+
+// reserve забирает маркер пользователя элемента и возвращает время ожидания до его появления;
+// элементы без пользователя не ограничиваются
+func (l *keyedLimiter) reserve(fields itemFields, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := fields.Get(l.field)
+	if key == "" {
+		return 0
+	}
+	limiter, ok := l.limiters[key]
+	if !ok {
+		limit := l.limitLocked(key)
+		if limit.PerSecond <= 0 {
+			return 0
+		}
+		limiter = newRateLimiter(limit.PerSecond, limit.Burst)
+		l.limiters[key] = limiter
+	}
+	wait := limiter.reserve(now)
+	if l.taken++; l.taken%idleLimiterSweep == 0 {
+		// Ограничитель с полной корзиной не отличается от нового, поэтому его можно удалить
+		for key, limiter := range l.limiters {
+			if limiter.full(now) {
+				delete(l.limiters, key)
+			}
+		}
+	}
+	return wait
+}
+
+// full сообщает, накопил ли ограничитель весь запас маркеров к моменту now
+func (l *rateLimiter) full(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate <= 0 || l.tokens+now.Sub(l.last).Seconds()*l.rate >= l.burst
+}
+
+// WithUserRateLimit ограничивает частоту отправки от имени каждого пользователя отдельно.
+// Элементы пользователя, исчерпавшего запас, откладываются до появления маркера и не занимают
+// место в отправке, поэтому элементы остальных пользователей отправляются без задержки
+func WithUserRateLimit(c UserRateLimitConfig) Option {
+	return func(b *Buffer) {
+		b.users = newKeyedLimiter(c)
+	}
+}
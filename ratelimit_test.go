@@ -0,0 +1,65 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"buffer/kpidrivetest"
+	"buffer/mockkpi"
+)
+
+// TestUserRateLimitDefersOnlyThrottledUser проверяет, что элементы пользователя, исчерпавшего
+// запас, ждут маркера по часам буфера в порядке добавления, а остальные пользователи не ждут
+func TestUserRateLimitDefersOnlyThrottledUser(t *testing.T) {
+	SetLogLevel(LevelError)
+	srv := kpidrivetest.NewServer(mockkpi.Options{})
+	defer srv.Close()
+	clock := NewFakeClock(time.Unix(0, 0))
+	b := NewBuffer(srv.SaveFactURL(), "token", WithClock(clock), WithUserRateLimit(UserRateLimitConfig{
+		PerSecond: 1,
+		Burst:     1,
+		Users:     map[string]KeyRateLimit{"42": {}}, // без ограничения
+	}))
+	defer b.Close()
+
+	add := func(user, indicator, value string) {
+		item := maps.Clone(benchmarkItem)
+		item["auth_user_id"], item["indicator_to_mo_id"], item["value"] = user, indicator, value
+		if err := b.Add(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, value := range []string{"1", "2", "3"} {
+		add("40", "1", value)
+	}
+	add("41", "2", "1")
+	add("42", "3", "1")
+	add("42", "3", "2")
+
+	// Без сдвига часов уходят первый элемент пользователя 40 и все элементы остальных
+	waitFor(t, "unthrottled users", func() bool { return len(srv.Requests()) == 4 })
+	for sent := 5; sent <= 6; sent++ {
+		clock.BlockUntil(1)
+		if n := len(srv.Requests()); n != sent-1 {
+			t.Fatalf("%d requests before the token appeared, want %d", n, sent-1)
+		}
+		clock.Advance(time.Second)
+		waitFor(t, "the next token", func() bool { return len(srv.Requests()) == sent })
+	}
+	b.Flush()
+
+	var values []string
+	for _, r := range srv.Requests() {
+		if r.Fields["auth_user_id"] == "40" {
+			values = append(values, r.Fields["value"])
+		}
+	}
+	if !slices.Equal(values, []string{"1", "2", "3"}) {
+		t.Errorf("user 40 sent %v, want [1 2 3]", values)
+	}
+	if stats := b.Stats(); stats.Sent != 6 {
+		t.Errorf("sent %d items, want 6", stats.Sent)
+	}
+}
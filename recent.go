@@ -0,0 +1,48 @@
+package main
+
+import "sync"
+
+// recentSendsSize задает количество последних попыток, хранимых для просмотра
+const recentSendsSize = 50
+
+// RecentSend описывает недавнюю попытку отправки элемента
+type RecentSend struct {
+	ItemID    uint64 `json:"item_id"`
+	Indicator string `json:"indicator"`
+	Attempt
+}
+
+// recentSends хранит последние попытки отправки в кольцевом буфере фиксированного размера
+type recentSends struct {
+	mu    sync.Mutex
+	items [recentSendsSize]RecentSend
+	next  int
+	count int
+}
+
+// add запоминает попытку, вытесняя самую старую
+func (r *recentSends) add(send RecentSend) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.items[r.next] = send
+	r.next = (r.next + 1) % len(r.items)
+	if r.count < len(r.items) {
+		r.count++
+	}
+}
+
+// list возвращает попытки от новых к старым
+func (r *recentSends) list() []RecentSend {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]RecentSend, 0, r.count)
+	for i := 1; i <= r.count; i++ {
+		out = append(out, r.items[(r.next-i+len(r.items))%len(r.items)])
+	}
+	return out
+}
+
+// RecentSends возвращает последние попытки отправки, начиная с самой новой
+func (b *Buffer) RecentSends() []RecentSend {
+	return b.recent.list()
+}
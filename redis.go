@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisTimeout ограничивает ожидание ответа Redis сверх времени блокирующих команд
+const redisTimeout = 10 * time.Second
+
+// redisError представляет ответ Redis с ошибкой
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisClient выполняет команды Redis по протоколу RESP через одно соединение. Соединение
+// устанавливается при первой команде и заново после ошибки ввода-вывода
+type redisClient struct {
+	addr     string
+	tls      bool
+	username string
+	password string
+	db       int
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// newRedisClient разбирает адрес вида redis://[user:password@]host[:port][/db] или rediss://
+// для TLS. Пароль из passwordEnv заменяет пароль в адресе
+func newRedisClient(rawURL, passwordEnv string) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("unsupported scheme %q, expected redis or rediss", u.Scheme)
+	}
+	c := &redisClient{addr: u.Host, tls: u.Scheme == "rediss"}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+		if c.password == "" {
+			// redis://password@host - пароль без имени пользователя
+			c.username, c.password = "", c.username
+		}
+	}
+	if passwordEnv != "" {
+		c.password = os.Getenv(passwordEnv)
+		if c.password == "" {
+			return nil, fmt.Errorf("environment variable %s is empty", passwordEnv)
+		}
+	}
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		if c.db, err = strconv.Atoi(path); err != nil {
+			return nil, fmt.Errorf("invalid database number %q", path)
+		}
+	}
+	return c, nil
+}
+
+// connect устанавливает соединение, авторизуется и выбирает базу, ожидая каждый шаг не дольше timeout
+func (c *redisClient) connect(timeout time.Duration) error {
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if c.tls {
+		host, _, _ := net.SplitHostPort(c.addr)
+		config, policyErr := applyTLSPolicy(&tls.Config{ServerName: host})
+		if policyErr != nil {
+			return policyErr
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.addr, config)
+	} else {
+		conn, err = dialer.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return err
+	}
+	c.conn, c.r = conn, bufio.NewReader(conn)
+	if c.password != "" {
+		args := []string{"AUTH", c.password}
+		if c.username != "" {
+			args = []string{"AUTH", c.username, c.password}
+		}
+		if _, err := c.roundTrip(timeout, args); err != nil {
+			c.close()
+			return err
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.roundTrip(timeout, []string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			c.close()
+			return err
+		}
+	}
+	return nil
+}
+
+// do выполняет команду и возвращает ответ: string, int64, []any, nil или redisError в ошибке.
+// block - время, на которое команда может заблокироваться на сервере
+func (c *redisClient) do(block time.Duration, args ...string) (any, error) {
+	return c.doWithin(block+redisTimeout, args...)
+}
+
+// doWithin выполняет команду, как do, но ждет подключения и ответа не дольше timeout
+func (c *redisClient) doWithin(timeout time.Duration, args ...string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		if err := c.connect(timeout); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.roundTrip(timeout, args)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		c.close()
+	}
+	return reply, err
+}
+
+// roundTrip отправляет команду и читает ответ не дольше timeout
+func (c *redisClient) roundTrip(timeout time.Duration, args []string) (any, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+// readReply читает один ответ RESP
+func (c *redisClient) readReply() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = c.readReply(); err != nil {
+				var redisErr redisError
+				if !errors.As(err, &redisErr) {
+					return nil, err
+				}
+				items[i] = err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// close закрывает соединение; следующая команда установит новое
+func (c *redisClient) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn, c.r = nil, nil
+	}
+}
+
+// Close закрывает соединение
+func (c *redisClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.close()
+	return nil
+}
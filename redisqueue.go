@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisQueueConfig задает общую очередь в потоке Redis: процессы-производители добавляют в нее
+// элементы, а демон забирает их в буфер и подтверждает после доставки или отказа
+type RedisQueueConfig struct {
+	URL               string        `yaml:"url"`                // redis://[user:password@]host:6379/0, rediss:// - через TLS
+	PasswordEnv       string        `yaml:"password_env"`       // переменная окружения с паролем, заменяет пароль из url
+	Stream            string        `yaml:"stream"`             // ключ потока, по умолчанию buffer:items, у клиента - buffer:items:<имя>
+	Group             string        `yaml:"group"`              // группа потребителей, по умолчанию buffer
+	Consumer          string        `yaml:"consumer"`           // имя потребителя, по умолчанию имя хоста
+	VisibilityTimeout time.Duration `yaml:"visibility_timeout"` // срок, после которого неподтвержденный элемент забирает другой потребитель, по умолчанию 5m
+	MaxPending        int           `yaml:"max_pending"`        // элементов, взятых из Redis и ожидающих доставки, по умолчанию 1000
+}
+
+// enabled сообщает, задана ли очередь в Redis
+func (c RedisQueueConfig) enabled() bool {
+	return c.URL != ""
+}
+
+// validate проверяет настройки очереди
+func (c RedisQueueConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	if _, err := newRedisClient(c.URL, ""); err != nil {
+		return fmt.Errorf("url: %w", err)
+	}
+	if c.VisibilityTimeout < 0 || c.MaxPending < 0 {
+		return fmt.Errorf("settings must not be negative")
+	}
+	return nil
+}
+
+// redisBatch ограничивает число элементов, читаемых из потока одной командой
+const redisBatch = 100
+
+// redisItem представляет элемент в потоке Redis
+type redisItem struct {
+	formatHeader
+	Data          map[string]string `json:"data"`
+	CorrelationID string            `json:"correlation_id,omitempty"`
+	Provenance    *Provenance       `json:"provenance,omitempty"`
+	Endpoint      string            `json:"endpoint,omitempty"`
+}
+
+// encodeRedisItem кодирует элемент в текущей версии формата
+func encodeRedisItem(item redisItem) ([]byte, error) {
+	item.formatHeader = newFormatHeader(redisFormat, redisVersion)
+	return json.Marshal(item)
+}
+
+// decodeRedisItem разбирает элемент любой поддерживаемой версии
+func decodeRedisItem(data []byte) (redisItem, error) {
+	var item redisItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return item, err
+	}
+	return item, item.check(redisFormat, redisVersion)
+}
+
+// RedisQueue представляет общую устойчивую очередь в потоке Redis. Несколько процессов добавляют
+// в нее элементы методом Enqueue, а Consume переносит их в буфер через группу потребителей.
+// Взятый элемент остается в Redis неподтвержденным, пока буфер его не доставит или не откажется
+// от него; если потребитель не подтвердил элемент за VisibilityTimeout, например потому что процесс
+// упал, элемент забирает другой потребитель или этот же после перезапуска. Пока элемент ждет в
+// буфере, потребитель продлевает его срок, поэтому долгие повторы не приводят к повторной отправке
+type RedisQueue struct {
+	stream     string
+	group      string
+	consumer   string
+	visibility time.Duration
+	maxPending int
+	cipher     *FileCipher
+	client     *redisClient // добавление, подтверждение и продление
+	reader     *redisClient // блокирующее чтение потребителя
+
+	mu    sync.Mutex
+	taken map[string]*takenItem // элементы, переданные буферу и еще не подтвержденные
+	freed chan struct{}
+}
+
+// takenItem представляет элемент, взятый из потока в буфер
+type takenItem struct {
+	raw      string // значение поля item записи
+	bufferID uint64 // идентификатор в буфере, 0 - еще не добавлен
+	handOff  bool   // отменяется в буфере, чтобы вернуться в поток
+}
+
+// NewRedisQueue подключается к очереди с настройками c; если задан cipher, элементы хранятся
+// в Redis зашифрованными, и производителям нужен тот же ключ
+func NewRedisQueue(c RedisQueueConfig, cipher *FileCipher) (*RedisQueue, error) {
+	client, err := newRedisClient(c.URL, c.PasswordEnv)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := newRedisClient(c.URL, c.PasswordEnv)
+	if err != nil {
+		return nil, err
+	}
+	q := &RedisQueue{
+		stream:     c.Stream,
+		group:      c.Group,
+		consumer:   c.Consumer,
+		visibility: c.VisibilityTimeout,
+		maxPending: c.MaxPending,
+		cipher:     cipher,
+		client:     client,
+		reader:     reader,
+		taken:      make(map[string]*takenItem),
+		freed:      make(chan struct{}, 1),
+	}
+	if q.stream == "" {
+		q.stream = "buffer:items"
+	}
+	if q.group == "" {
+		q.group = "buffer"
+	}
+	if q.consumer == "" {
+		if q.consumer, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+	if q.visibility <= 0 {
+		q.visibility = 5 * time.Minute
+	}
+	if q.maxPending <= 0 {
+		q.maxPending = 1000
+	}
+	return q, nil
+}
+
+// RedisQueue подключается к очереди queue.redis; поток клиента по умолчанию получает его имя
+func (c *Config) RedisQueue() (*RedisQueue, error) {
+	rc := c.Queue.Redis
+	if rc.Stream == "" && c.tenant != "" {
+		rc.Stream = "buffer:items:" + c.tenant
+	}
+	cipher, err := c.FileCipher()
+	if err != nil {
+		return nil, err
+	}
+	return NewRedisQueue(rc, cipher)
+}
+
+// Enqueue добавляет элемент в очередь с параметрами opts и возвращает идентификатор записи
+// в потоке. Элемент проверяется буфером демона, когда тот забирает его из очереди
+func (q *RedisQueue) Enqueue(item map[string]string, opts ...ItemOption) (string, error) {
+	return q.push(newQueuedItem(item, opts))
+}
+
+// push добавляет элемент в поток и возвращает идентификатор записи
+func (q *RedisQueue) push(queued *queuedItem) (string, error) {
+	data, err := encodeRedisItem(redisItem{
+		Data:          queued.fields.Map(),
+		CorrelationID: queued.correlationID,
+		Provenance:    queued.provenance,
+		Endpoint:      queued.endpoint,
+	})
+	if err != nil {
+		return "", err
+	}
+	reply, err := q.client.do(0, "XADD", q.stream, "*", "item", string(q.cipher.Seal(redisFormat, data)))
+	if err != nil {
+		return "", err
+	}
+	id, _ := reply.(string)
+	return id, nil
+}
+
+// Consume переносит элементы из очереди в буфер b, пока ctx не завершится. Сначала забираются
+// неподтвержденные элементы этого потребителя от прошлого запуска, затем новые и просроченные
+// элементы других потребителей. Ошибки связи с Redis выводятся, и чтение возобновляется с паузой.
+// Паузы и сроки элементов отсчитываются по часам b
+func (q *RedisQueue) Consume(ctx context.Context, b *Buffer) {
+	stopTouch := make(chan struct{})
+	defer close(stopTouch)
+	go q.touchTaken(b.clock, stopTouch)
+
+	own := "0" // последняя прочитанная запись этого потребителя от прошлого запуска
+	var claimed time.Time
+	delay := time.Second
+	for ctx.Err() == nil {
+		if !q.waitFree(ctx) {
+			break
+		}
+		var err error
+		switch {
+		case own != "":
+			own, err = q.readOwn(b, own)
+		case b.clock.Now().Sub(claimed) >= q.visibility/2:
+			err = q.claimStale(b)
+			claimed = b.clock.Now()
+		default:
+			err = q.readNew(b)
+		}
+		if err == nil {
+			delay = time.Second
+			continue
+		}
+		var redisErr redisError
+		if errors.As(err, &redisErr) && strings.HasPrefix(string(redisErr), "NOGROUP") {
+			// Поток или группа еще не созданы либо удалены
+			if err = q.createGroup(); err == nil {
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		fmt.Printf("Error reading Redis queue %s: %v, retrying in %s\n", q.stream, err, delay)
+		timer := b.clock.NewTimer(delay)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		delay = min(2*delay, 30*time.Second)
+	}
+}
+
+// createGroup создает группу потребителей, а при необходимости и поток
+func (q *RedisQueue) createGroup() error {
+	_, err := q.client.do(0, "XGROUP", "CREATE", q.stream, q.group, "0", "MKSTREAM")
+	var redisErr redisError
+	if errors.As(err, &redisErr) && strings.HasPrefix(string(redisErr), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// readOwn забирает неподтвержденные элементы этого потребителя после записи after и возвращает
+// последнюю прочитанную запись или пустую строку, когда они закончились
+func (q *RedisQueue) readOwn(b *Buffer, after string) (string, error) {
+	reply, err := q.reader.do(0, "XREADGROUP", "GROUP", q.group, q.consumer, "COUNT", strconv.Itoa(redisBatch), "STREAMS", q.stream, after)
+	if err != nil {
+		return after, err
+	}
+	entries := streamEntries(reply)
+	if len(entries) == 0 {
+		return "", nil
+	}
+	q.add(b, entries)
+	return entries[len(entries)-1].id, nil
+}
+
+// readNew ожидает до секунды и забирает новые элементы
+func (q *RedisQueue) readNew(b *Buffer) error {
+	reply, err := q.reader.do(time.Second, "XREADGROUP", "GROUP", q.group, q.consumer, "COUNT", strconv.Itoa(q.batch()), "BLOCK", "1000", "STREAMS", q.stream, ">")
+	if err != nil {
+		return err
+	}
+	q.add(b, streamEntries(reply))
+	return nil
+}
+
+// claimStale забирает элементы других потребителей, не подтвержденные за VisibilityTimeout
+func (q *RedisQueue) claimStale(b *Buffer) error {
+	cursor := "0-0"
+	for {
+		reply, err := q.reader.do(0, "XAUTOCLAIM", q.stream, q.group, q.consumer, strconv.FormatInt(q.visibility.Milliseconds(), 10), cursor, "COUNT", strconv.Itoa(q.batch()))
+		if err != nil {
+			return err
+		}
+		parts, _ := reply.([]any)
+		if len(parts) < 2 {
+			return fmt.Errorf("unexpected XAUTOCLAIM reply")
+		}
+		entries := streamMessages(parts[1])
+		if len(entries) > 0 {
+			fmt.Printf("Claimed %d items from %s not acknowledged by other consumers in %s\n", len(entries), q.stream, q.visibility)
+		}
+		q.add(b, entries)
+		if cursor, _ = parts[0].(string); cursor == "0-0" || cursor == "" || len(entries) == 0 {
+			return nil
+		}
+	}
+}
+
+// batch возвращает, сколько элементов можно взять, не превышая MaxPending
+func (q *RedisQueue) batch() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return max(min(q.maxPending-len(q.taken), redisBatch), 1)
+}
+
+// waitFree ждет, пока буфер не подтвердит часть элементов, если их взято MaxPending; возвращает
+// false, если ctx завершился раньше
+func (q *RedisQueue) waitFree(ctx context.Context) bool {
+	for {
+		q.mu.Lock()
+		full := len(q.taken) >= q.maxPending
+		q.mu.Unlock()
+		if !full {
+			return true
+		}
+		select {
+		case <-q.freed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// streamEntry представляет запись потока
+type streamEntry struct {
+	id     string
+	fields map[string]string // nil, если запись удалена из потока
+}
+
+// streamEntries разбирает ответ XREADGROUP по одному потоку
+func streamEntries(reply any) []streamEntry {
+	streams, _ := reply.([]any)
+	if len(streams) == 0 {
+		return nil
+	}
+	stream, _ := streams[0].([]any)
+	if len(stream) < 2 {
+		return nil
+	}
+	return streamMessages(stream[1])
+}
+
+// streamMessages разбирает список записей вида [id, [поле, значение, ...]]
+func streamMessages(reply any) []streamEntry {
+	messages, _ := reply.([]any)
+	entries := make([]streamEntry, 0, len(messages))
+	for _, message := range messages {
+		parts, _ := message.([]any)
+		if len(parts) < 2 {
+			continue
+		}
+		entry := streamEntry{}
+		entry.id, _ = parts[0].(string)
+		if values, ok := parts[1].([]any); ok {
+			entry.fields = make(map[string]string, len(values)/2)
+			for i := 0; i+1 < len(values); i += 2 {
+				key, _ := values[i].(string)
+				value, _ := values[i+1].(string)
+				entry.fields[key] = value
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// add передает записи буферу. Удаленные записи подтверждаются сразу, а записи, которые не
+// разбираются, остаются неподтвержденными, чтобы их можно было разобрать вручную
+func (q *RedisQueue) add(b *Buffer, entries []streamEntry) {
+	for _, entry := range entries {
+		q.mu.Lock()
+		_, ok := q.taken[entry.id]
+		q.mu.Unlock()
+		if ok {
+			continue
+		}
+		if entry.fields == nil {
+			q.ack(entry.id)
+			continue
+		}
+		data, err := q.cipher.Open(redisFormat, []byte(entry.fields["item"]))
+		if err != nil {
+			fmt.Printf("Error decoding Redis queue item %s: %v\n", entry.id, err)
+			continue
+		}
+		item, err := decodeRedisItem(data)
+		if err != nil {
+			fmt.Printf("Error decoding Redis queue item %s: %v\n", entry.id, err)
+			continue
+		}
+		id := entry.id
+		taken := &takenItem{raw: entry.fields["item"]}
+		q.mu.Lock()
+		q.taken[id] = taken
+		q.mu.Unlock()
+		opts := []ItemOption{WithCorrelationID(item.CorrelationID), WithEndpoint(item.Endpoint), WithResultHandler(func(result ItemResult) {
+			q.mu.Lock()
+			handOff := taken.handOff
+			q.mu.Unlock()
+			switch {
+			case errors.Is(result.Err, ErrSpoolFull):
+				// Элемент остается в Redis и будет забран снова после VisibilityTimeout
+				q.release(id)
+			case handOff && errors.Is(result.Err, ErrCanceled):
+				q.requeue(id, taken.raw)
+			default:
+				q.ack(id)
+			}
+		})}
+		if item.Provenance != nil {
+			opts = append(opts, WithProvenance(*item.Provenance))
+		}
+		bufferID, err := b.Submit(item.Data, opts...)
+		if err != nil && !errors.Is(err, ErrSpoolFull) {
+			fmt.Printf("Redis queue item %s rejected: %v\n", id, err)
+		}
+		q.mu.Lock()
+		taken.bufferID = bufferID
+		q.mu.Unlock()
+	}
+}
+
+// handOff возвращает в конец потока элементы, которые буфер b взял из Redis и еще не начал
+// отправлять, чтобы новый лидер забрал их сразу, не дожидаясь VisibilityTimeout. Отправляемые
+// элементы подтверждаются, как обычно, после доставки. Вызывается после остановки Consume
+func (q *RedisQueue) handOff(b *Buffer) {
+	q.mu.Lock()
+	taken := make([]*takenItem, 0, len(q.taken))
+	for _, t := range q.taken {
+		if t.bufferID != 0 {
+			t.handOff = true
+			taken = append(taken, t)
+		}
+	}
+	q.mu.Unlock()
+	returned := 0
+	for _, t := range taken {
+		if b.Cancel(t.bufferID) == nil {
+			returned++
+			continue
+		}
+		q.mu.Lock()
+		t.handOff = false
+		q.mu.Unlock()
+	}
+	if returned > 0 {
+		logInfof("Returned %d items to Redis stream %s for the new leader", returned, q.stream)
+	}
+}
+
+// requeue добавляет элемент в поток заново и подтверждает прежнюю запись; если добавить не
+// удалось, прежняя запись остается неподтвержденной и будет забрана после VisibilityTimeout
+func (q *RedisQueue) requeue(id, raw string) {
+	if _, err := q.client.do(0, "XADD", q.stream, "*", "item", raw); err != nil {
+		fmt.Printf("Error returning Redis queue item %s: %v\n", id, err)
+		q.release(id)
+		return
+	}
+	q.ack(id)
+}
+
+// ack подтверждает и удаляет из потока обработанный элемент
+func (q *RedisQueue) ack(id string) {
+	if _, err := q.client.do(0, "XACK", q.stream, q.group, id); err != nil {
+		fmt.Printf("Error acknowledging Redis queue item %s: %v\n", id, err)
+	} else if _, err := q.client.do(0, "XDEL", q.stream, id); err != nil {
+		fmt.Printf("Error deleting Redis queue item %s: %v\n", id, err)
+	}
+	q.release(id)
+}
+
+// release забывает взятый элемент и освобождает место для следующих
+func (q *RedisQueue) release(id string) {
+	q.mu.Lock()
+	delete(q.taken, id)
+	q.mu.Unlock()
+	select {
+	case q.freed <- struct{}{}:
+	default:
+	}
+}
+
+// touchTaken продлевает срок элементов, ожидающих в буфере, чтобы их не забрали другие
+// потребители, пока не закрыт stop
+func (q *RedisQueue) touchTaken(clock Clock, stop <-chan struct{}) {
+	ticker := clock.NewTicker(q.visibility / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+		case <-stop:
+			return
+		}
+		q.mu.Lock()
+		ids := make([]string, 0, len(q.taken))
+		for id := range q.taken {
+			ids = append(ids, id)
+		}
+		q.mu.Unlock()
+		for len(ids) > 0 {
+			n := min(len(ids), redisBatch)
+			args := append([]string{"XCLAIM", q.stream, q.group, q.consumer, "0"}, ids[:n]...)
+			if _, err := q.client.do(0, append(args, "JUSTID")...); err != nil {
+				fmt.Printf("Error extending Redis queue items: %v\n", err)
+				break
+			}
+			ids = ids[n:]
+		}
+	}
+}
+
+// Close закрывает соединения с Redis. Вызывается после закрытия буфера, чтобы отправленные
+// при остановке элементы успели подтвердиться
+func (q *RedisQueue) Close() error {
+	q.reader.Close()
+	return q.client.Close()
+}
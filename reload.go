@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+)
+
+// Reload заново собирает настройки из того же файла и текущих переменных окружения для того же клиента
+func (c *Config) Reload() (*Config, error) {
+	next, err := ResolveConfig(c.path, c.required, c.profile, os.Environ())
+	if err != nil || c.tenant == "" {
+		return next, err
+	}
+	return next.ForTenant(c.tenant)
+}
+
+// ApplyRuntime применяет к работающему буферу настройки, которые меняются без перезапуска:
+// уровень журналирования, повторные попытки, ограничение частоты, квоту, параметры выключателя
+// и окна отправки. Накопленные в очереди элементы сохраняются, об остальных изменениях
+// сообщается в журнал
+func (c *Config) ApplyRuntime(b *Buffer, previous *Config) {
+	level, _ := ParseLogLevel(c.Logging.Level)
+	SetLogLevel(level)
+	WithRetry(c.Retry.MaxAttempts, c.Retry.BaseDelay, c.Retry.MaxDelay)(b)
+	WithRateLimit(c.RateLimit.PerSecond, c.RateLimit.Burst)(b)
+	if b.users != nil && c.RateLimit.PerUser.enabled() {
+		b.users.set(c.RateLimit.PerUser)
+	}
+	if b.bandwidth != nil && c.RateLimit.BytesPerSecond > 0 {
+		WithBandwidthLimit(c.RateLimit.BytesPerSecond, c.RateLimit.BytesBurst)(b)
+	}
+	if b.quota != nil && c.Quota.enabled() {
+		b.quota.set(c.Quota)
+	}
+	if b.breaker != nil && c.CircuitBreaker.Threshold > 0 {
+		b.breaker.set(c.CircuitBreaker.Threshold, c.CircuitBreaker.Cooldown)
+	}
+	if !reflect.DeepEqual(c.Delivery, previous.Delivery) {
+		var schedule *DeliverySchedule
+		if c.Delivery.enabled() {
+			schedule, _ = NewDeliverySchedule(c.Delivery)
+		}
+		b.mu.Lock()
+		b.window, b.outside = schedule, false
+		b.mu.Unlock()
+		b.signal()
+	}
+	for _, section := range c.restartRequired(previous) {
+		fmt.Printf("Config change of %s requires a restart to take effect\n", section)
+	}
+}
+
+// restartRequired перечисляет измененные разделы настроек, которые не применяются на лету
+func (c *Config) restartRequired(previous *Config) []string {
+	var changed []string
+	check := func(section string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			changed = append(changed, section)
+		}
+	}
+	check("api", c.API, previous.API)
+	check("auth", c.Auth, previous.Auth)
+	check("http", c.HTTP, previous.HTTP)
+	check("admin", c.Admin, previous.Admin)
+	check("daemon", c.Daemon, previous.Daemon)
+	check("schedule", c.Schedule, previous.Schedule)
+	check("dlq", c.DLQ, previous.DLQ)
+	check("queue", c.Queue, previous.Queue)
+	check("logging.audit_log", c.Logging.AuditLog, previous.Logging.AuditLog)
+	check("logging.event_log", c.Logging.EventLog, previous.Logging.EventLog)
+	check("logging.debug_dump", c.Logging.DebugDump, previous.Logging.DebugDump)
+	check("logging.summary_interval", c.Logging.SummaryInterval, previous.Logging.SummaryInterval)
+	check("circuit_breaker.threshold", c.CircuitBreaker.Threshold > 0, previous.CircuitBreaker.Threshold > 0)
+	check("rate_limit.per_user", c.RateLimit.PerUser.enabled(), previous.RateLimit.PerUser.enabled())
+	check("rate_limit.bytes_per_second", c.RateLimit.BytesPerSecond > 0, previous.RateLimit.BytesPerSecond > 0)
+	check("quota", c.Quota.enabled(), previous.Quota.enabled())
+	check("facts_cache", c.FactsCache, previous.FactsCache)
+	return changed
+}
+
+// watchReload перечитывает настройки по сигналу SIGHUP и применяет их к буферу, пока не отменен ctx
+func watchReload(ctx context.Context, cfg *Config, b *Buffer) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			next, err := cfg.Reload()
+			if err != nil {
+				fmt.Println("Error reloading config, keeping the current settings:", err)
+				continue
+			}
+			next.ApplyRuntime(b, cfg)
+			cfg = next
+			logInfof("Config reloaded from %s", cfg.path)
+		}
+	}
+}
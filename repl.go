@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// replHelp перечисляет команды интерактивного режима
+const replHelp = `Commands:
+  add field=value...   enqueue a fact (template fields are filled in)
+  status <id>          show where an item is: queued, sending, sent or failed
+  stats                show buffer statistics
+  queue [limit]        list queued items
+  peek                 show the item that will be sent next
+  cancel <id>          withdraw a queued item before it is sent
+  update <id> field=value...
+                       change fields of a queued item before it is sent
+  dlq                  list dead letters
+  requeue [id...]      requeue dead letters, all if no IDs are given
+  pause | resume       pause or resume sending
+  flush                send everything queued and wait
+  help                 show this help
+  exit                 wait for queued items and quit`
+
+// runREPL выполняет команды интерактивного режима, читая их из in до exit или конца ввода
+func runREPL(cfg *Config, b *Buffer, in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+	fmt.Fprintln(out, "Type 'help' for commands, 'exit' to quit.")
+	for {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		args := strings.Fields(scanner.Text())
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "exit" || args[0] == "quit" {
+			return
+		}
+		if err := replCommand(cfg, b, args, out); err != nil {
+			fmt.Fprintln(out, "Error:", err)
+		}
+	}
+}
+
+// replCommand выполняет одну команду интерактивного режима
+func replCommand(cfg *Config, b *Buffer, args []string, out io.Writer) error {
+	switch args[0] {
+	case "help":
+		fmt.Fprintln(out, replHelp)
+	case "add":
+		fields, err := parseFields(args[1:])
+		if err != nil {
+			return err
+		}
+		if len(fields) == 0 {
+			return fmt.Errorf("usage: add field=value...")
+		}
+		item := newQueuedItem(cfg.Item(fields), []ItemOption{WithProvenance(localProvenance("interactive"))})
+		if err := b.enqueue(item); err != nil {
+			return fmt.Errorf("item rejected: %w", err)
+		}
+		fmt.Fprintf(out, "Enqueued item %d\n", item.id)
+	case "status":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: status <id>")
+		}
+		id, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid item ID %q", args[1])
+		}
+		fmt.Fprintln(out, b.itemStatus(id))
+	case "stats":
+		s := b.Stats()
+		fmt.Fprintf(out, "queued %d, sending %d, paused %t, sent %d, failed %d, dead letters %d, circuit %s, latency p50 %s p99 %s\n",
+			s.Queued, s.InFlight, s.Paused, s.Sent, s.Failed, s.DeadLetters, s.Circuit,
+			s.LatencyP50.Round(time.Millisecond), s.LatencyP99.Round(time.Millisecond))
+	case "queue":
+		limit := 20
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid limit %q", args[1])
+			}
+			limit = n
+		}
+		items, total := b.queuedItems(0, limit)
+		for _, item := range items {
+			if item.Parked {
+				fmt.Fprintf(out, "%d\t%s\t(parked)\n", item.ID, formatFields(item.Data))
+				continue
+			}
+			fmt.Fprintf(out, "%d\t%s\n", item.ID, formatFields(item.Data))
+		}
+		fmt.Fprintf(out, "%d of %d queued items\n", len(items), total)
+	case "peek":
+		item, ok := b.Peek()
+		if !ok {
+			fmt.Fprintln(out, "Queue is empty")
+			return nil
+		}
+		fmt.Fprintf(out, "%d\t%s\n", item.ID, formatFields(item.Data))
+	case "cancel":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: cancel <id>")
+		}
+		id, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid item ID %q", args[1])
+		}
+		if err := b.Cancel(id); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Canceled item %d\n", id)
+	case "update":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: update <id> field=value...")
+		}
+		id, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid item ID %q", args[1])
+		}
+		fields, err := parseFields(args[2:])
+		if err != nil {
+			return err
+		}
+		err = b.Update(id, func(item map[string]string) map[string]string {
+			for key, value := range fields {
+				item[key] = value
+			}
+			return item
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Updated item %d\n", id)
+	case "dlq":
+		letters := b.dlq.Items()
+		for _, letter := range letters {
+			fmt.Fprintf(out, "%d\t%s\t%s\n", letter.ID, letter.Error, formatFields(letter.Item))
+		}
+		fmt.Fprintf(out, "%d dead letters\n", len(letters))
+	case "requeue":
+		ids := make([]uint64, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			id, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid dead letter ID %q", arg)
+			}
+			ids = append(ids, id)
+		}
+		fmt.Fprintf(out, "Requeued %d items\n", b.Requeue(ids...))
+	case "pause":
+		b.Pause()
+		fmt.Fprintln(out, "Paused")
+	case "resume":
+		b.Resume()
+		fmt.Fprintln(out, "Resumed")
+	case "flush":
+		b.Flush()
+		fmt.Fprintln(out, "Flushed")
+	default:
+		return fmt.Errorf("unknown command %q, type 'help' for commands", args[0])
+	}
+	return nil
+}
+
+// itemStatus описывает состояние элемента по его идентификатору
+func (b *Buffer) itemStatus(id uint64) string {
+	b.mu.Lock()
+	_, sending := b.inFlight[id]
+	parked := b.parkedLocked(id)
+	position := -1
+	for i := 0; i < b.queue.Resident(); i++ {
+		if b.queue.At(i).id == id {
+			position = i
+			break
+		}
+	}
+	b.mu.Unlock()
+
+	switch {
+	case sending:
+		return fmt.Sprintf("item %d: sending", id)
+	case parked:
+		return fmt.Sprintf("item %d: queued, waiting for the previous item with the same key", id)
+	case position >= 0:
+		return fmt.Sprintf("item %d: queued, position %d", id, position+1)
+	}
+	for _, letter := range b.dlq.Items() {
+		if letter.ID == id {
+			return fmt.Sprintf("item %d: failed after %d attempts: %s", id, len(letter.Attempts), letter.Error)
+		}
+	}
+	for _, send := range b.RecentSends() {
+		if send.ItemID == id && send.Error == "" {
+			return fmt.Sprintf("item %d: sent at %s", id, send.Time.Format(time.TimeOnly))
+		}
+	}
+	return fmt.Sprintf("item %d: not found (sent long ago or never enqueued)", id)
+}
+
+// formatFields выводит поля элемента в виде field=value в алфавитном порядке
+func formatFields(fields map[string]string) string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key + "=" + fields[key]
+	}
+	return strings.Join(parts, " ")
+}
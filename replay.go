@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ReplayFilter задает условия отбора записей журнала аудита для повторной отправки
+type ReplayFilter struct {
+	From         time.Time // нижняя граница времени попытки (включительно), нулевое значение - без ограничения
+	To           time.Time // верхняя граница времени попытки (не включительно), нулевое значение - без ограничения
+	Indicator    string    // indicator_to_mo_id, пустая строка - любой показатель
+	FailuresOnly bool      // отбирать только неуспешные попытки
+}
+
+// Match сообщает, подходит ли запись журнала под фильтр; учитываются только последние попытки
+// доставки элементов, чтобы повторы одного элемента не отправлялись несколько раз
+func (f ReplayFilter) Match(entry AuditEntry) bool {
+	if !entry.Final {
+		return false
+	}
+	if !f.From.IsZero() && entry.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !entry.Time.Before(f.To) {
+		return false
+	}
+	if f.Indicator != "" && entry.Payload["indicator_to_mo_id"] != f.Indicator {
+		return false
+	}
+	if f.FailuresOnly && entry.Error == "" {
+		return false
+	}
+	return true
+}
+
+// ReadAuditLog читает журнал аудита и возвращает записи, подходящие под фильтр; зашифрованные
+// записи расшифровываются cipher
+func ReadAuditLog(path string, filter ReplayFilter, cipher *FileCipher) ([]AuditEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) == 0 {
+			break
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return entries, err
+		}
+		if line, err = cipher.Open(auditFormat, line); err != nil {
+			return entries, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return entries, err
+		}
+		if filter.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// Replay повторно ставит в очередь исходные данные записей журнала аудита, подходящих под фильтр,
+// и возвращает количество принятых буфером элементов. Элементы, которые Add не принял, например
+// не прошедшие проверку, пропускаются, и Replay возвращает первую ошибку Add вместе с количеством
+func (b *Buffer) Replay(path string, filter ReplayFilter, cipher *FileCipher) (int, error) {
+	entries, err := ReadAuditLog(path, filter, cipher)
+	if err != nil {
+		return 0, fmt.Errorf("reading audit log: %w", err)
+	}
+	var added int
+	var firstErr error
+	for _, entry := range entries {
+		item := make(map[string]string, len(entry.Payload))
+		for key, value := range entry.Payload {
+			item[key] = value
+		}
+		opts := []ItemOption{WithCorrelationID(entry.CorrelationID)}
+		if entry.Provenance != nil {
+			opts = append(opts, WithProvenance(*entry.Provenance))
+		}
+		if entry.Endpoint != "" {
+			opts = append(opts, WithEndpoint(entry.Endpoint))
+		}
+		if err := b.Add(item, opts...); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("replaying request %s: %w", entry.RequestID, err)
+			}
+			continue
+		}
+		added++
+	}
+	return added, firstErr
+}
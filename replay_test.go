@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReplayCountsAcceptedItems(t *testing.T) {
+	SetLogLevel(LevelError)
+	valid := map[string]string{}
+	for key, value := range benchmarkItem {
+		valid[key] = value
+	}
+	invalid := map[string]string{"indicator_to_mo_id": "227373", "value": "NaN"}
+	var log strings.Builder
+	for i, payload := range []map[string]string{valid, invalid, valid, invalid} {
+		line, err := json.Marshal(AuditEntry{RequestID: string(rune('a' + i)), Payload: payload, Final: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		log.Write(append(line, '\n'))
+	}
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := os.WriteFile(path, []byte(log.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte(`{"MESSAGES":{"error":null,"warning":null,"info":[]},"DATA":{"indicator_to_mo_fact_id":1},"STATUS":"OK"}`))
+	}))
+	defer srv.Close()
+	b := NewBuffer(srv.URL, "token", WithValidation(ValidateFact))
+	defer b.Close()
+	n, err := b.Replay(path, ReplayFilter{}, nil)
+	b.Flush()
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) || !strings.Contains(err.Error(), "request b") {
+		t.Errorf("Replay returned %v, want the validation error of request b", err)
+	}
+	if n != 2 || requests.Load() != 2 {
+		t.Errorf("Replay accepted %d items and sent %d, want 2", n, requests.Load())
+	}
+}
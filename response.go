@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// MessageList представляет сообщения одного вида в ответе API. API присылает их строкой, массивом,
+// объектом с полями или null, и все эти формы приводятся к списку строк; сообщение поля объекта
+// получает префикс с именем поля, например "value: must be a number"
+type MessageList []string
+
+// UnmarshalJSON разбирает сообщения любой формы; ошибка возвращается только для неверного JSON
+func (m *MessageList) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	*m = appendMessages(nil, "", v)
+	return nil
+}
+
+// appendMessages добавляет к list сообщения из значения v; prefix - путь полей объекта до v
+func appendMessages(list MessageList, prefix string, v interface{}) MessageList {
+	add := func(text string) MessageList {
+		if text = strings.TrimSpace(text); text == "" {
+			return list
+		}
+		if prefix != "" {
+			text = prefix + ": " + text
+		}
+		return append(list, text)
+	}
+	switch v := v.(type) {
+	case nil:
+		return list
+	case string:
+		return add(v)
+	case json.Number:
+		return add(v.String())
+	case bool:
+		return add(strconv.FormatBool(v))
+	case []interface{}:
+		for _, item := range v {
+			list = appendMessages(list, prefix, item)
+		}
+		return list
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			path := prefix
+			// Числовые ключи - это массив, сериализованный как объект, и в префикс не попадают
+			if _, err := strconv.Atoi(key); err != nil {
+				path = joinMessagePath(prefix, key)
+			}
+			list = appendMessages(list, path, v[key])
+		}
+		return list
+	default:
+		return add(fmt.Sprint(v))
+	}
+}
+
+// joinMessagePath добавляет к пути полей prefix поле key без окружающих пробелов
+func joinMessagePath(prefix, key string) string {
+	key = strings.TrimSpace(key)
+	switch {
+	case key == "":
+		return prefix
+	case prefix == "":
+		return key
+	default:
+		return prefix + "." + key
+	}
+}
+
+// String объединяет сообщения через точку с запятой
+func (m MessageList) String() string {
+	return strings.Join(m, "; ")
+}
+
+// Messages представляет структуру сообщений в ответе API
+type Messages struct {
+	Error   MessageList `json:"error"`
+	Warning MessageList `json:"warning"`
+	Info    MessageList `json:"info"`
+}
+
+// Data представляет структуру данных в ответе API
+type Data struct {
+	IndicatorToMoFactID int `json:"indicator_to_mo_fact_id"`
+}
+
+// APIResponse представляет структуру ответа API
+type APIResponse struct {
+	Messages Messages `json:"MESSAGES"`
+	Data     Data     `json:"DATA"`
+	Status   string   `json:"STATUS"`
+}
+
+// Разделы ответа API. Разные версии API называли их по-разному, поэтому раздел находится по
+// любому из имен responseSectionNames
+const (
+	sectionMessages = "MESSAGES"
+	sectionData     = "DATA"
+	sectionStatus   = "STATUS"
+)
+
+// responseSectionNames сопоставляет приведенные normalizeKey имена с разделами ответа; первым
+// для каждого раздела выбирается нынешнее имя, затем прежние в порядке имен
+var responseSectionNames = map[string]string{
+	"messages": sectionMessages, "message": sectionMessages, "msg": sectionMessages,
+	"data": sectionData, "result": sectionData, "payload": sectionData,
+	"status": sectionStatus, "state": sectionStatus, "success": sectionStatus,
+}
+
+// factIDKeys перечисляет приведенные имена идентификатора факта в разделе DATA по убыванию приоритета
+var factIDKeys = []string{"indicatortomofactid", "factid", "id"}
+
+// normalizeKey приводит имя поля к виду без регистра и разделителей, чтобы indicator_to_mo_fact_id,
+// IndicatorToMoFactId и indicator-to-mo-fact-id совпадали
+func normalizeKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || r == ' ' {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, key)
+}
+
+// reportedFields хранит пути неизвестных полей ответа, о которых уже сообщено
+var reportedFields sync.Map
+
+// reportUnknownField сообщает на уровне debug о поле ответа, которое буфер не знает; о каждом
+// поле сообщается один раз, чтобы расхождение версий API было видно без повторов в журнале
+func reportUnknownField(path string) {
+	if _, seen := reportedFields.LoadOrStore(path, true); !seen {
+		logDebugf("API response has unknown field %s, ignoring it", path)
+	}
+}
+
+// responseSections находит разделы ответа по их нынешним и прежним именам. Неизвестные поля
+// верхнего уровня возвращаются в rest
+func responseSections(body []byte) (sections map[string]json.RawMessage, rest map[string]json.RawMessage, err error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, nil, err
+	}
+	if fields == nil {
+		return nil, nil, fmt.Errorf("response is null, expected a JSON object")
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	// Точное нынешнее имя раздела важнее прежних, остальные выбираются в порядке имен
+	sort.Slice(keys, func(i, j int) bool {
+		ci, cj := keys[i] == responseSectionNames[normalizeKey(keys[i])], keys[j] == responseSectionNames[normalizeKey(keys[j])]
+		if ci != cj {
+			return ci
+		}
+		return keys[i] < keys[j]
+	})
+	sections = make(map[string]json.RawMessage, 3)
+	rest = make(map[string]json.RawMessage)
+	for _, key := range keys {
+		section, known := responseSectionNames[normalizeKey(key)]
+		switch {
+		case !known:
+			rest[key] = fields[key]
+		case sections[section] == nil:
+			raw := fields[key]
+			if normalizeKey(key) == "success" {
+				raw = successStatus(raw)
+			}
+			sections[section] = raw
+		}
+	}
+	return sections, rest, nil
+}
+
+// successStatus приводит прежнее поле success к STATUS: true - OK, false - ERROR
+func successStatus(raw json.RawMessage) json.RawMessage {
+	switch parseScalar(raw) {
+	case "true", "1":
+		return json.RawMessage(`"OK"`)
+	case "false", "0":
+		return json.RawMessage(`"ERROR"`)
+	}
+	return raw
+}
+
+// parseAPIResponse разбирает ответ API, допуская расхождения между версиями API: имена разделов
+// в любом регистре и прежние имена (result, message, success), сообщения любой формы, DATA
+// массивом, null или отсутствующий с идентификатором факта на верхнем уровне, идентификатор
+// факта под прежними именами, строкой или числом, STATUS любым значением. Неизвестные поля
+// пропускаются с сообщением на уровне debug. Ошибка возвращается, только если тело не объект JSON
+func parseAPIResponse(body []byte) (APIResponse, error) {
+	var response APIResponse
+	sections, rest, err := responseSections(body)
+	if err != nil {
+		return response, err
+	}
+	if raw := sections[sectionMessages]; raw != nil {
+		response.Messages = parseMessages(raw)
+	}
+	if raw := sections[sectionData]; raw != nil {
+		response.Data = parseData(raw)
+	}
+	if raw := sections[sectionStatus]; raw != nil {
+		response.Status = parseScalar(raw)
+	}
+	keys := make([]string, 0, len(rest))
+	for key := range rest {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		// Ответ без DATA с идентификатором факта на верхнем уровне
+		if response.Data.IndicatorToMoFactID == 0 && normalizeKey(key) == factIDKeys[0] {
+			response.Data.IndicatorToMoFactID = parseFactID(rest[key])
+			continue
+		}
+		reportUnknownField(key)
+	}
+	return response, nil
+}
+
+// factRowKeys перечисляет приведенные имена списка фактов в разделе DATA ответа get_facts
+var factRowKeys = map[string]bool{"rows": true, "items": true, "facts": true, "list": true, "records": true}
+
+// factFieldNames сопоставляет приведенные имена полей факта с именами, которые использует буфер
+var factFieldNames = func() map[string]string {
+	names := make(map[string]string)
+	for _, field := range append(requiredFactFields, "indicator_to_mo_fact_id", "is_plan", "auth_user_id", "comment") {
+		names[normalizeKey(field)] = field
+	}
+	return names
+}()
+
+// parseFactRows разбирает факты из ответа get_facts: список DATA.rows или под прежними именами
+// (items, facts, list, records), либо DATA массивом. Имена полей фактов приводятся к тем, что
+// использует буфер, числа и логические значения - к строкам, а null и вложенные значения
+// пропускаются. Ответ без списка фактов дает пустой список
+func parseFactRows(body []byte) ([]map[string]string, error) {
+	sections, _, err := responseSections(body)
+	if err != nil {
+		return nil, err
+	}
+	raw := sections[sectionData]
+	if raw == nil {
+		return nil, nil
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) != nil {
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(raw, &fields) != nil {
+			return nil, nil
+		}
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if factRowKeys[normalizeKey(key)] && json.Unmarshal(fields[key], &list) == nil {
+				break
+			}
+		}
+	}
+	rows := make([]map[string]string, 0, len(list))
+	for _, item := range list {
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(item, &fields) != nil || fields == nil {
+			continue
+		}
+		row := make(map[string]string, len(fields))
+		for key, value := range fields {
+			name, known := factFieldNames[normalizeKey(key)]
+			if !known {
+				name = key
+			}
+			if text := parseScalar(value); text != "" {
+				row[name] = text
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseMessages разбирает раздел MESSAGES; сообщения без вида или с неизвестным видом считаются
+// информационными, а раздел строкой или массивом - ошибками
+func parseMessages(raw json.RawMessage) Messages {
+	var messages Messages
+	var kinds map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &kinds); err != nil {
+		messages.Error.UnmarshalJSON(raw)
+		return messages
+	}
+	keys := make([]string, 0, len(kinds))
+	for key := range kinds {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var list MessageList
+		if list.UnmarshalJSON(kinds[key]) != nil {
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "error", "errors":
+			messages.Error = append(messages.Error, list...)
+		case "warning", "warnings":
+			messages.Warning = append(messages.Warning, list...)
+		case "info":
+			messages.Info = append(messages.Info, list...)
+		default:
+			prefix := joinMessagePath("", key)
+			for _, text := range list {
+				if prefix != "" {
+					text = prefix + ": " + text
+				}
+				messages.Info = append(messages.Info, text)
+			}
+		}
+	}
+	return messages
+}
+
+// parseData разбирает раздел DATA; массив разбирается по первому объекту, другая форма, отличная
+// от объекта, оставляет данные пустыми
+func parseData(raw json.RawMessage) Data {
+	var data Data
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil && len(list) > 0 {
+		raw = list[0]
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(raw, &fields) != nil {
+		return data
+	}
+	rank := len(factIDKeys)
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		i := indexOf(factIDKeys, normalizeKey(key))
+		if i < 0 {
+			reportUnknownField(sectionData + "." + key)
+			continue
+		}
+		if i < rank {
+			if id := parseFactID(fields[key]); id != 0 || i == 0 {
+				data.IndicatorToMoFactID, rank = id, i
+			}
+		}
+	}
+	return data
+}
+
+// parseFactID разбирает идентификатор факта строкой или числом; другие значения - 0
+func parseFactID(raw json.RawMessage) int {
+	id, err := strconv.ParseFloat(parseScalar(raw), 64)
+	if err == nil && id == float64(int(id)) {
+		return int(id)
+	}
+	return 0
+}
+
+// indexOf возвращает индекс s в list или -1
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseScalar возвращает строку, число или логическое значение JSON строкой; другие формы - пустой строкой
+func parseScalar(raw json.RawMessage) string {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if dec.Decode(&v) != nil {
+		return ""
+	}
+	switch v := v.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return ""
+	}
+}
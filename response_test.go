@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// responseShapes перечисляет формы ответов, встречавшиеся у API
+var responseShapes = []struct {
+	name string
+	body string
+	want APIResponse
+}{
+	{
+		name: "success",
+		body: `{"MESSAGES":{"error":null,"warning":null,"info":[]},"DATA":{"indicator_to_mo_fact_id":42},"STATUS":"OK"}`,
+		want: APIResponse{Data: Data{IndicatorToMoFactID: 42}, Status: "OK"},
+	},
+	{
+		name: "error string",
+		body: `{"MESSAGES":{"error":"token expired","warning":"","info":[]},"DATA":[],"STATUS":"ERROR"}`,
+		want: APIResponse{Messages: Messages{Error: MessageList{"token expired"}}, Status: "ERROR"},
+	},
+	{
+		name: "error array",
+		body: `{"MESSAGES":{"error":["bad period","bad value"],"warning":null,"info":["saved 0"]},"DATA":{},"STATUS":"ERROR"}`,
+		want: APIResponse{Messages: Messages{Error: MessageList{"bad period", "bad value"}, Info: MessageList{"saved 0"}}, Status: "ERROR"},
+	},
+	{
+		name: "error object by field",
+		body: `{"MESSAGES":{"error":{"value":["must be a number"],"period_start":"required"}},"DATA":null,"STATUS":"ERROR"}`,
+		want: APIResponse{Messages: Messages{Error: MessageList{"period_start: required", "value: must be a number"}}, Status: "ERROR"},
+	},
+	{
+		name: "array serialized as object",
+		body: `{"MESSAGES":{"warning":{"0":"duplicate fact","1":"rounded value"}},"STATUS":"OK"}`,
+		want: APIResponse{Messages: Messages{Warning: MessageList{"duplicate fact", "rounded value"}}, Status: "OK"},
+	},
+	{
+		name: "fact id as string",
+		body: `{"MESSAGES":{"error":[],"warning":[],"info":[]},"DATA":{"indicator_to_mo_fact_id":"17"},"STATUS":"OK"}`,
+		want: APIResponse{Data: Data{IndicatorToMoFactID: 17}, Status: "OK"},
+	},
+	{
+		name: "lowercase sections and numeric status",
+		body: `{"messages":{"errors":[{"code":12,"text":"locked"}]},"status":0}`,
+		want: APIResponse{Messages: Messages{Error: MessageList{"code: 12", "text: locked"}}, Status: "0"},
+	},
+	{
+		name: "messages as string",
+		body: `{"MESSAGES":"internal error","STATUS":"ERROR"}`,
+		want: APIResponse{Messages: Messages{Error: MessageList{"internal error"}}, Status: "ERROR"},
+	},
+	{
+		name: "unknown message kind",
+		body: `{"MESSAGES":{"notice":"maintenance at 22:00"},"STATUS":"OK"}`,
+		want: APIResponse{Messages: Messages{Info: MessageList{"notice: maintenance at 22:00"}}, Status: "OK"},
+	},
+	{
+		name: "renamed sections and success flag",
+		body: `{"message":{"warning":"rounded"},"result":{"factId":"9"},"success":true}`,
+		want: APIResponse{Messages: Messages{Warning: MessageList{"rounded"}}, Data: Data{IndicatorToMoFactID: 9}, Status: "OK"},
+	},
+	{
+		name: "current section name wins over renamed",
+		body: `{"DATA":{"indicator_to_mo_fact_id":3},"result":{"indicator_to_mo_fact_id":4},"STATUS":"OK","success":false}`,
+		want: APIResponse{Data: Data{IndicatorToMoFactID: 3}, Status: "OK"},
+	},
+	{
+		name: "missing DATA with fact id at top level",
+		body: `{"MESSAGES":{},"IndicatorToMoFactId":12,"STATUS":"OK","version":"2.1"}`,
+		want: APIResponse{Data: Data{IndicatorToMoFactID: 12}, Status: "OK"},
+	},
+	{
+		name: "DATA as array with extra fields",
+		body: `{"DATA":[{"id":5,"indicator_to_mo_fact_id":"6","created_at":"2024-05-31"}],"STATUS":"OK"}`,
+		want: APIResponse{Data: Data{IndicatorToMoFactID: 6}, Status: "OK"},
+	},
+}
+
+func TestParseAPIResponse(t *testing.T) {
+	for _, tt := range responseShapes {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAPIResponse([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("parseAPIResponse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	for _, body := range []string{``, `null`, `[]`, `"OK"`, `{"MESSAGES":`} {
+		if _, err := parseAPIResponse([]byte(body)); err == nil {
+			t.Errorf("parseAPIResponse(%q) returned no error", body)
+		}
+	}
+}
+
+func TestParseFactRows(t *testing.T) {
+	want := []map[string]string{{"indicator_to_mo_fact_id": "1", "indicator_to_mo_id": "227373", "value": "5", "is_plan": "0", "unit": "pcs"}}
+	for _, body := range []string{
+		`{"DATA":{"rows":[{"indicator_to_mo_fact_id":"1","indicator_to_mo_id":"227373","value":"5","is_plan":"0","unit":"pcs"}],"rows_count":1}}`,
+		`{"data":{"items":[{"IndicatorToMoFactId":1,"indicatorToMoId":227373,"Value":5,"is_plan":0,"unit":"pcs","comment":null,"tags":["a"]}]}}`,
+		`{"result":[{"indicator_to_mo_fact_id":1,"indicator_to_mo_id":"227373","value":5,"is_plan":"0","unit":"pcs"}],"success":true}`,
+	} {
+		got, err := parseFactRows([]byte(body))
+		if err != nil {
+			t.Fatalf("parseFactRows(%s): %v", body, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("parseFactRows(%s) = %v, want %v", body, got, want)
+		}
+	}
+	if rows, err := parseFactRows([]byte(`{"DATA":[],"STATUS":"OK"}`)); err != nil || len(rows) != 0 {
+		t.Errorf("empty DATA: got %v, %v", rows, err)
+	}
+}
+
+func FuzzParseAPIResponse(f *testing.F) {
+	for _, tt := range responseShapes {
+		f.Add([]byte(tt.body))
+	}
+	f.Add([]byte(`{"MESSAGES":{"error":[[["deep"]],{"a":{"b":true}},1.5e3]},"DATA":{"indicator_to_mo_fact_id":1e400}}`))
+	f.Fuzz(func(t *testing.T, body []byte) {
+		response, err := parseAPIResponse(body)
+		var object map[string]json.RawMessage
+		if json.Unmarshal(body, &object) == nil && object != nil && err != nil {
+			t.Fatalf("JSON object rejected: %v", err)
+		}
+		if err != nil {
+			return
+		}
+		for _, list := range []MessageList{response.Messages.Error, response.Messages.Warning, response.Messages.Info} {
+			for _, text := range list {
+				if strings.TrimSpace(text) == "" {
+					t.Fatalf("empty message in %q", list)
+				}
+			}
+		}
+		// Приведенный ответ не меняется при повторном разборе
+		normalized, err := json.Marshal(response)
+		if err != nil {
+			t.Fatalf("marshalling parsed response: %v", err)
+		}
+		again, err := parseAPIResponse(normalized)
+		if err != nil {
+			t.Fatalf("parsing normalized response: %v", err)
+		}
+		if !reflect.DeepEqual(again, response) {
+			t.Fatalf("normalized response changed on reparse:\n%+v\n%+v", response, again)
+		}
+	})
+}
+
+func FuzzDecodeSpooledItem(f *testing.F) {
+	for _, letter := range goldenDeadLetters {
+		f.Add(encodeSpooledItem(spooledItem{ID: letter.ID, Data: letter.Item, CorrelationID: letter.CorrelationID, Provenance: letter.Provenance, Attempts: letter.Attempts, Tries: len(letter.Attempts)}))
+	}
+	// Заголовки, обещающие миллиарды элементов, и глубоко вложенные массивы
+	f.Add([]byte{0x81, 0xa8, 'a', 't', 't', 'e', 'm', 'p', 't', 's', 0xdd, 0x7f, 0xff, 0xff, 0xff})
+	f.Add([]byte{0x81, 0xa4, 'd', 'a', 't', 'a', 0xdf, 0x7f, 0xff, 0xff, 0xff})
+	f.Add(append([]byte{0x81, 0xa1, 'x'}, bytes.Repeat([]byte{0x91}, 10000)...))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		rec, err := decodeSpooledItem(data)
+		if err != nil {
+			return
+		}
+		// После одного кодирования запись не меняется при повторном
+		once, err := decodeSpooledItem(encodeSpooledItem(rec))
+		if err != nil {
+			t.Fatalf("decoding encoded record: %v", err)
+		}
+		twice, err := decodeSpooledItem(encodeSpooledItem(once))
+		if err != nil {
+			t.Fatalf("decoding re-encoded record: %v", err)
+		}
+		if !reflect.DeepEqual(once, twice) {
+			t.Fatalf("record changed on re-encoding:\n%+v\n%+v", once, twice)
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// retryPolicy задает повторные попытки доставки при временных ошибках
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+// WithRetry включает до maxAttempts попыток доставки элемента с экспоненциальной задержкой
+// от baseDelay до maxDelay; повторяются только сетевые ошибки, 429 и 5xx
+func WithRetry(maxAttempts int, baseDelay, maxDelay time.Duration) Option {
+	return func(b *Buffer) {
+		b.retry.Store(&retryPolicy{maxAttempts: maxAttempts, baseDelay: baseDelay, maxDelay: maxDelay})
+	}
+}
+
+// retryableStatus сообщает, может ли попытка с таким кодом ответа завершиться успешно при повторе
+func retryableStatus(status int) bool {
+	return status == 0 || status == 429 || status >= 500
+}
+
+// shouldRetry сообщает, нужно ли повторить последнюю неуспешную попытку элемента
+func (p retryPolicy) shouldRetry(item *queuedItem) bool {
+	if len(item.attempts) == 0 || item.tries >= p.maxAttempts {
+		return false
+	}
+	last := item.attempts[len(item.attempts)-1]
+	return last.Error != "" && retryableStatus(last.Status)
+}
+
+// delay возвращает задержку перед попыткой с номером tries+1 с полным случайным разбросом
+func (p retryPolicy) delay(tries int) time.Duration {
+	d := p.baseDelay
+	for i := 1; i < tries && d < p.maxDelay; i++ {
+		d *= 2
+	}
+	if p.maxDelay > 0 && d > p.maxDelay {
+		d = p.maxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoffWithFakeClock(t *testing.T) {
+	SetLogLevel(LevelError)
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"MESSAGES":{"error":null,"warning":null,"info":[]},"DATA":{"indicator_to_mo_fact_id":1},"STATUS":"OK"}`))
+	}))
+	defer srv.Close()
+
+	clock := NewFakeClock(time.Unix(0, 0))
+	delays := make(chan time.Duration, 3)
+	b := NewBuffer(srv.URL, "token",
+		WithClock(clock),
+		WithSynchronous(),
+		WithRetry(3, time.Second, 4*time.Second),
+		WithHooks(Hooks{BackoffEntered: func(itemID uint64, try int, delay time.Duration) { delays <- delay }}),
+	)
+	defer b.Close()
+
+	result := make(chan error, 1)
+	go func() { result <- b.Add(benchmarkItem) }()
+
+	// Задержка перед попыткой n+1 не больше baseDelay*2^(n-1), и повтор ждет ровно ее по часам буфера
+	for try, limit := range []time.Duration{time.Second, 2 * time.Second} {
+		delay := <-delays
+		if delay <= 0 || delay > limit {
+			t.Fatalf("delay after try %d is %s, want (0, %s]", try+1, delay, limit)
+		}
+		clock.BlockUntil(1)
+		clock.Advance(delay - time.Nanosecond)
+		if n := requests.Load(); n != int32(try+1) {
+			t.Fatalf("%d requests before the backoff elapsed, want %d", n, try+1)
+		}
+		clock.Advance(time.Nanosecond)
+	}
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("delivery failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery did not finish after the backoff elapsed")
+	}
+	if n := requests.Load(); n != 3 {
+		t.Fatalf("%d requests, want 3", n)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotateTimeFormat задает формат метки времени в именах архивных файлов
+const rotateTimeFormat = "20060102T150405.000000000"
+
+// RotateConfig задает правила ротации и хранения файла; нулевые значения отключают правило
+type RotateConfig struct {
+	MaxSize     int64         // размер файла в байтах, после которого начинается новый файл
+	RotateEvery time.Duration // максимальный возраст текущего файла
+	MaxBackups  int           // количество хранимых архивных файлов
+	MaxAge      time.Duration // максимальный возраст архивных файлов
+}
+
+// RotatingFile представляет файл только на дозапись с ротацией по размеру и времени
+type RotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	cfg      RotateConfig
+	f        *os.File
+	size     int64
+	openedAt time.Time
+	clock    Clock
+}
+
+// OpenRotatingFile открывает файл для дозаписи с заданными правилами ротации
+func OpenRotatingFile(path string, cfg RotateConfig) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, cfg: cfg, clock: systemClock}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open открывает текущий файл, продолжая запись в существующий
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f = f
+	r.size = info.Size()
+	r.openedAt = r.clock.Now()
+	return nil
+}
+
+// setClock задает источник времени ротации; возраст текущего файла отсчитывается заново
+func (r *RotatingFile) setClock(c Clock) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clock = c
+	r.openedAt = c.Now()
+}
+
+// Write записывает данные, предварительно выполняя ротацию, если текущий файл ее требует.
+// Запись не разбивается между файлами
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.needsRotation(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// needsRotation сообщает, нужно ли начать новый файл перед записью n байт
+func (r *RotatingFile) needsRotation(n int64) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.cfg.MaxSize > 0 && r.size+n > r.cfg.MaxSize {
+		return true
+	}
+	return r.cfg.RotateEvery > 0 && r.clock.Now().Sub(r.openedAt) >= r.cfg.RotateEvery
+}
+
+// rotate переименовывает текущий файл в архивный, открывает новый и удаляет устаревшие архивы
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	backup := r.path + "." + r.clock.Now().Format(rotateTimeFormat)
+	if err := os.Rename(r.path, backup); err != nil {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.prune()
+	return nil
+}
+
+// prune удаляет архивные файлы сверх MaxBackups и старше MaxAge
+func (r *RotatingFile) prune() {
+	backups, _ := filepath.Glob(r.path + ".*")
+	sort.Strings(backups)
+	var kept []string
+	for _, backup := range backups {
+		if _, err := time.Parse(rotateTimeFormat, strings.TrimPrefix(backup, r.path+".")); err != nil {
+			continue
+		}
+		if r.cfg.MaxAge > 0 {
+			if info, err := os.Stat(backup); err == nil && r.clock.Now().Sub(info.ModTime()) > r.cfg.MaxAge {
+				os.Remove(backup)
+				continue
+			}
+		}
+		kept = append(kept, backup)
+	}
+	if r.cfg.MaxBackups > 0 && len(kept) > r.cfg.MaxBackups {
+		for _, backup := range kept[:len(kept)-r.cfg.MaxBackups] {
+			os.Remove(backup)
+		}
+	}
+}
+
+// Sync сбрасывает текущий файл на диск
+func (r *RotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Sync()
+}
+
+// Close закрывает текущий файл
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Scheduler запускает импорт источников по расписаниям cron внутри работающего буфера
+type Scheduler struct {
+	buffer *Buffer
+	jobs   []scheduledJob
+	opts   ImportOptions
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// scheduledJob представляет источник с разобранным расписанием
+type scheduledJob struct {
+	name     string
+	schedule *cronSchedule
+	source   SourceConfig
+}
+
+// NewScheduler создает планировщик по настройкам расписаний; opts применяются к каждому импорту
+func NewScheduler(b *Buffer, schedules []ScheduleConfig, opts ImportOptions) (*Scheduler, error) {
+	s := &Scheduler{buffer: b, opts: opts, stop: make(chan struct{})}
+	for i, sc := range schedules {
+		schedule, err := parseCron(sc.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("schedule[%d]: %w", i, err)
+		}
+		name := sc.Name
+		if name == "" {
+			name = sc.Path
+		}
+		s.jobs = append(s.jobs, scheduledJob{name: name, schedule: schedule, source: sc.SourceConfig})
+	}
+	return s, nil
+}
+
+// NewScheduler создает планировщик буфера b по расписаниям из настроек
+func (c *Config) NewScheduler(b *Buffer) (*Scheduler, error) {
+	return NewScheduler(b, c.Schedule, ImportOptions{Prepare: c.PrepareItem, MaxPending: c.Queue.ImportWindow, Source: "schedule"})
+}
+
+// Start запускает ожидание расписаний; каждое задание выполняется в своей горутине,
+// и следующий запуск задания не начнется, пока не закончится предыдущий
+func (s *Scheduler) Start() {
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(job)
+	}
+}
+
+// Stop отменяет ожидание следующих запусков и дожидается завершения выполняющихся импортов
+func (s *Scheduler) Stop() {
+	s.once.Do(func() { close(s.stop) })
+	s.wg.Wait()
+}
+
+// loop выполняет задание в моменты, заданные расписанием
+func (s *Scheduler) loop(job scheduledJob) {
+	defer s.wg.Done()
+	for {
+		clock := s.buffer.clock
+		next := job.schedule.Next(clock.Now())
+		if next.IsZero() {
+			fmt.Printf("Schedule %s never fires, disabling it\n", job.name)
+			return
+		}
+		logDebugf("Schedule %s: next run at %s", job.name, next.Format(time.RFC3339))
+		timer := clock.NewTimer(next.Sub(clock.Now()))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+		logInfof("Schedule %s: importing %s", job.name, job.source.Path)
+		if _, err := s.buffer.ImportFile(job.source.Path, job.source.importOptions(s.opts)); err != nil {
+			fmt.Printf("Error running schedule %s: %v\n", job.name, err)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretsClient используется для запросов к хранилищам секретов
+var secretsClient = &http.Client{Timeout: 10 * time.Second, Transport: sharedTransport}
+
+// VaultToken читает токен из секрета HashiCorp Vault (KV версии 1 или 2)
+type VaultToken struct {
+	Addr      string // адрес Vault, по умолчанию VAULT_ADDR
+	Path      string // путь к секрету, например secret/data/kpi-drive
+	Field     string // поле секрета с токеном, по умолчанию token
+	Namespace string // пространство имен Vault Enterprise
+	TokenFile string // файл с токеном Vault, например от Vault Agent; по умолчанию VAULT_TOKEN
+}
+
+// Token запрашивает секрет и возвращает значение поля
+func (v VaultToken) Token() (string, error) {
+	addr := v.Addr
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return "", fmt.Errorf("vault address is not set")
+	}
+	vaultToken := os.Getenv("VAULT_TOKEN")
+	if v.TokenFile != "" {
+		data, err := os.ReadFile(v.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("reading vault token file: %w", err)
+		}
+		vaultToken = strings.TrimSpace(string(data))
+	}
+	if vaultToken == "" {
+		return "", fmt.Errorf("vault token is not set: use VAULT_TOKEN or auth.vault.token_file")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(v.Path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", vaultToken)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	resp, err := secretsClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("requesting vault secret: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault responded with %s", resp.Status)
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("decoding vault secret: %w", err)
+	}
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested // KV версии 2 вкладывает значения в data.data
+	}
+	field := v.Field
+	if field == "" {
+		field = "token"
+	}
+	token, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no string field %q", v.Path, field)
+	}
+	return token, nil
+}
+
+// AWSSecretToken читает токен из AWS Secrets Manager; учетные данные берутся из переменных
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY и AWS_SESSION_TOKEN
+type AWSSecretToken struct {
+	Region   string // регион, по умолчанию AWS_REGION
+	SecretID string // имя или ARN секрета
+	Field    string // ключ JSON-секрета с токеном; пустая строка - секрет целиком
+	Endpoint string // адрес API, по умолчанию https://secretsmanager.<region>.amazonaws.com
+}
+
+// Token запрашивает значение секрета
+func (a AWSSecretToken) Token() (string, error) {
+	region := a.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		return "", fmt.Errorf("aws region is not set")
+	}
+	creds := awsCredentials{
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.accessKey == "" || creds.secretKey == "" {
+		return "", fmt.Errorf("aws credentials are not set: use AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+
+	body, err := json.Marshal(map[string]string{"SecretId": a.SecretID})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signAWSRequest(req, body, creds, region, "secretsmanager", time.Now())
+
+	resp, err := secretsClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("requesting aws secret: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("reading aws secret: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("aws secrets manager responded with %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+
+	var secret struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(data, &secret); err != nil {
+		return "", fmt.Errorf("decoding aws secret: %w", err)
+	}
+	if a.Field == "" {
+		return strings.TrimSpace(secret.SecretString), nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(secret.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("aws secret %s is not a JSON object: %w", a.SecretID, err)
+	}
+	token, ok := fields[a.Field].(string)
+	if !ok {
+		return "", fmt.Errorf("aws secret %s has no string field %q", a.SecretID, a.Field)
+	}
+	return token, nil
+}
+
+// awsCredentials представляет ключи доступа AWS
+type awsCredentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// signAWSRequest подписывает запрос по схеме AWS Signature Version 4
+func signAWSRequest(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+	for key, values := range req.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// RotatingToken кэширует токен другого источника и запрашивает его заново по истечении refresh
+// или после того, как API отверг токен
+type RotatingToken struct {
+	source  TokenProvider
+	refresh time.Duration
+	clock   Clock
+
+	mu      sync.Mutex
+	token   string
+	fetched time.Time
+	stale   bool
+}
+
+// NewRotatingToken создает кэширующий источник; refresh 0 - обновлять только после отказа API
+func NewRotatingToken(source TokenProvider, refresh time.Duration) *RotatingToken {
+	return &RotatingToken{source: source, refresh: refresh, clock: systemClock}
+}
+
+// Token возвращает кэшированный токен, при необходимости обновляя его. Если обновить не удалось,
+// используется прежний токен, чтобы недоступность хранилища секретов не останавливала отправку
+func (r *RotatingToken) Token() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.token != "" && !r.stale && (r.refresh == 0 || r.clock.Now().Sub(r.fetched) < r.refresh) {
+		return r.token, nil
+	}
+	token, err := r.source.Token()
+	if err == nil && token == "" {
+		err = fmt.Errorf("token is empty")
+	}
+	if err != nil {
+		if r.token == "" {
+			return "", err
+		}
+		fmt.Println("Error refreshing API token, using the previous one:", err)
+		r.fetched, r.stale = r.clock.Now(), false
+		return r.token, nil
+	}
+	if r.token != "" && token != r.token {
+		logInfof("API token rotated")
+	}
+	r.token, r.fetched, r.stale = token, r.clock.Now(), false
+	return token, nil
+}
+
+// Invalidate требует запросить токен заново при следующем обращении
+func (r *RotatingToken) Invalidate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stale = true
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrorReport описывает непредвиденную ошибку вместе с контекстом элемента
+type ErrorReport struct {
+	Message string            // краткое описание ошибки
+	Kind    string            // вид ошибки: panic, delivery и т.п.
+	Tags    map[string]string // значения для группировки и поиска
+	Extra   map[string]string // дополнительный контекст, например поля элемента
+	Stack   string            // трассировка стека, если есть
+}
+
+// ErrorReporter принимает отчеты о непредвиденных ошибках для внешней системы мониторинга
+type ErrorReporter interface {
+	Report(report ErrorReport)
+}
+
+// WithErrorReporter включает отправку отчетов о паниках и окончательно недоставленных элементах
+func WithErrorReporter(r ErrorReporter) Option {
+	return func(b *Buffer) {
+		b.reporter = r
+	}
+}
+
+// reportError передает отчет обработчику, если он настроен
+func (b *Buffer) reportError(report ErrorReport) {
+	if b.reporter != nil {
+		b.reporter.Report(report)
+	}
+}
+
+// SentryReporter отправляет отчеты в Sentry или совместимый сервис по DSN
+type SentryReporter struct {
+	endpoint    string
+	key         string
+	environment string
+	client      *http.Client
+}
+
+// NewSentryReporter создает отправителя отчетов по DSN вида https://<key>@<host>/<project>
+func NewSentryReporter(dsn, environment string) (*SentryReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sentry DSN: %w", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("invalid sentry DSN: missing public key")
+	}
+	path := strings.Trim(u.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	project := path[slash+1:]
+	if project == "" {
+		return nil, fmt.Errorf("invalid sentry DSN: missing project ID")
+	}
+	prefix := ""
+	if slash >= 0 {
+		prefix = "/" + path[:slash]
+	}
+	return &SentryReporter{
+		endpoint:    fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, project),
+		key:         u.User.Username(),
+		environment: environment,
+		client:      &http.Client{Timeout: 10 * time.Second, Transport: sharedTransport},
+	}, nil
+}
+
+// Report асинхронно отправляет отчет, чтобы не задерживать доставку данных
+func (s *SentryReporter) Report(report ErrorReport) {
+	go func() {
+		if err := s.send(report); err != nil {
+			fmt.Println("Error reporting to sentry:", err)
+		}
+	}()
+}
+
+// send формирует событие Sentry и отправляет его
+func (s *SentryReporter) send(report ErrorReport) error {
+	hostname, _ := os.Hostname()
+	tags := map[string]string{"kind": report.Kind}
+	for key, value := range report.Tags {
+		tags[key] = value
+	}
+	extra := make(map[string]string, len(report.Extra)+1)
+	for key, value := range report.Extra {
+		extra[key] = value
+	}
+	if report.Stack != "" {
+		extra["stack"] = report.Stack
+	}
+	event := map[string]interface{}{
+		"event_id":    newRequestID(),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+		"level":       "error",
+		"platform":    "go",
+		"logger":      "buffer",
+		"server_name": hostname,
+		"environment": s.environment,
+		"message":     map[string]string{"formatted": report.Message},
+		"exception": map[string]interface{}{
+			"values": []map[string]string{{"type": report.Kind, "value": report.Message}},
+		},
+		"tags":  tags,
+		"extra": extra,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=buffer/1.0, sentry_key=%s", s.key))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sentry responded with %s", resp.Status)
+	}
+	return nil
+}
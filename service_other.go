@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+// runService сообщает, что службы Windows недоступны на этой платформе
+func runService(cfg *Config, res *Result, args []string) error {
+	return fmt.Errorf("the service command is only available on Windows, run the daemon command under systemd instead")
+}
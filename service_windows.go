@@ -0,0 +1,226 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// defaultServiceName задает имя службы Windows по умолчанию
+const defaultServiceName = "buffer"
+
+// serviceStopTimeout задает время на отправку накопленных элементов при остановке службы
+const serviceStopTimeout = 5 * time.Minute
+
+// runService выполняет команду service: устанавливает, удаляет, запускает и останавливает
+// службу Windows; действие run вызывается диспетчером служб
+func runService(cfg *Config, res *Result, args []string) error {
+	fs := flag.NewFlagSet("service", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: buffer [-config file] service [flags] install | uninstall | start | stop | status | run")
+		fs.PrintDefaults()
+	}
+	name := fs.String("name", defaultServiceName, "service name")
+	logPath := fs.String("log", "", "file for the service output, used by install and run")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	switch action := fs.Arg(0); action {
+	case "install":
+		return installService(cfg, *name, *logPath)
+	case "uninstall":
+		return withService(*name, func(s *mgr.Service) error { return s.Delete() })
+	case "start":
+		return withService(*name, func(s *mgr.Service) error { return s.Start() })
+	case "stop":
+		return withService(*name, stopService)
+	case "status":
+		return withService(*name, func(s *mgr.Service) error {
+			st, err := s.Query()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s: %s\n", *name, serviceStateName(st.State))
+			return nil
+		})
+	case "run":
+		return runAsService(cfg, *name, *logPath)
+	default:
+		return fmt.Errorf("unknown service action %q", action)
+	}
+}
+
+// installService регистрирует службу, запускающую эту программу с текущими настройками
+func installService(cfg *Config, name, logPath string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// Служба запускается в системном каталоге, поэтому все пути передаются абсолютными
+	var args []string
+	if cfg.path != "" {
+		path, err := filepath.Abs(cfg.path)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-config", path)
+	}
+	if cfg.Profile() != "" {
+		args = append(args, "-profile", cfg.Profile())
+	}
+	args = append(args, "service", "-name", name)
+	if logPath != "" {
+		path, err := filepath.Abs(logPath)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-log", path)
+	}
+	args = append(args, "run")
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", name)
+	}
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: "KPI facts buffer",
+		Description: "Buffers KPI facts and delivers them to the API",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("creating service: %w", err)
+	}
+	defer s.Close()
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 10 * time.Second}}, 24*60*60); err != nil {
+		fmt.Println("Error setting service recovery actions:", err)
+	}
+	fmt.Printf("Service %s installed\n", name)
+	return nil
+}
+
+// withService открывает службу по имени и выполняет над ней действие
+func withService(name string, action func(s *mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("opening service %s: %w", name, err)
+	}
+	defer s.Close()
+	return action(s)
+}
+
+// stopService останавливает службу и ждет ее остановки
+func stopService(s *mgr.Service) error {
+	st, err := s.Control(svc.Stop)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(serviceStopTimeout)
+	for st.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service did not stop within %s", serviceStopTimeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+		if st, err = s.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serviceStateName возвращает название состояния службы
+func serviceStateName(state svc.State) string {
+	switch state {
+	case svc.Stopped:
+		return "stopped"
+	case svc.StartPending:
+		return "starting"
+	case svc.StopPending:
+		return "stopping"
+	case svc.Running:
+		return "running"
+	case svc.Paused:
+		return "paused"
+	default:
+		return fmt.Sprintf("state %d", state)
+	}
+}
+
+// runAsService выполняет демон под управлением диспетчера служб; вывод направляется в logPath,
+// так как у службы нет консоли
+func runAsService(cfg *Config, name, logPath string) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		return fmt.Errorf("service run must be started by the service manager, use the daemon command instead")
+	}
+	if logPath != "" {
+		f, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		os.Stdout, os.Stderr = f, f
+	}
+	return svc.Run(name, &serviceHandler{cfg: cfg})
+}
+
+// serviceHandler обрабатывает команды диспетчера служб
+type serviceHandler struct {
+	cfg *Config
+}
+
+// Execute запускает демон и останавливает его по команде Stop или Shutdown
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- serveDaemon(ctx, h.cfg, h.cfg.Admin.Addr, h.cfg.Daemon.PIDFile)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				fmt.Println("Error:", err)
+				return true, 1
+			}
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32(serviceStopTimeout / time.Millisecond)}
+				cancel()
+			}
+		}
+	}
+}
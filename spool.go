@@ -0,0 +1,991 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// Имена файлов вытесненных элементов в каталоге spool_dir: файлы spool нумеруются по порядку,
+// а spool.jsonl и spool.msgpack оставались от версий без разбиения на файлы, которые не сохраняли
+// spool между запусками
+const (
+	spoolFileName       = "spool.jsonl"
+	spoolBinaryFileName = "spool.msgpack"
+	spoolSegmentName    = "spool-%06d"
+)
+
+// Кодировки элементов в файле spool
+const (
+	spoolJSON    = "json"
+	spoolMsgpack = "msgpack"
+)
+
+// Поведение spool при достижении SpoolOptions.MaxSize
+const (
+	spoolFullBlock      = "block"       // новые элементы ждут, пока место освободится
+	spoolFullReject     = "reject"      // новые элементы отвергаются
+	spoolFullDropOldest = "drop_oldest" // сбрасываются самые старые элементы spool
+)
+
+// defaultSpoolSegment задает размер файла spool по умолчанию
+const defaultSpoolSegment = 16 << 20
+
+// maxSpoolRecord ограничивает длину записи MessagePack, чтобы поврежденная длина не приводила
+// к выделению огромного буфера
+const maxSpoolRecord = 64 << 20
+
+// itemOverhead приблизительно оценивает память элемента очереди без учета его полей
+const itemOverhead = 256
+
+// ErrSpoolFull сообщается элементам, которые не приняты или сброшены, потому что spool занял
+// SpoolOptions.MaxSize
+var ErrSpoolFull = errors.New("spool is full")
+
+// SpoolOptions задает хранение вытесненных элементов на диске
+type SpoolOptions struct {
+	Encoding    string // json (по умолчанию) или msgpack
+	SegmentSize int64  // байт в файле, после которых запись переходит в следующий, по умолчанию 16MB
+	MaxSize     int64  // байт всех файлов, 0 - без ограничения
+	Full        string // поведение при заполнении MaxSize: block (по умолчанию), reject или drop_oldest
+}
+
+// itemHandlers хранит в памяти обработчики результата элемента, записанного на диск,
+// и изменения его данных, сделанные Update
+type itemHandlers struct {
+	id      uint64
+	done    func(err error)
+	result  func(ItemResult)
+	edit    itemEdit
+	segment *spoolSegment // файл, в котором записан элемент
+}
+
+// spooledItem представляет элемент очереди, записанный на диск
+type spooledItem struct {
+	ID            uint64            `json:"id"`
+	Data          map[string]string `json:"data"`
+	CorrelationID string            `json:"correlation_id,omitempty"`
+	Provenance    *Provenance       `json:"provenance,omitempty"`
+	Endpoint      string            `json:"endpoint,omitempty"`
+	Attempts      []Attempt         `json:"attempts,omitempty"`
+	Tries         int               `json:"tries,omitempty"`
+}
+
+// spoolSegment описывает один файл spool
+type spoolSegment struct {
+	path     string
+	size     int64               // байт в файле
+	records  int                 // записей в файле, включая прочитанные и отмененные
+	live     int                 // записей, которые еще не прочитаны и не отменены
+	canceled map[uint64]struct{} // отмененные записи, пропускаемые при чтении и удаляемые сжатием
+	removed  bool                // файл удален, например пока его сжимали
+}
+
+// Spool хранит на диске элементы, не поместившиеся в очередь в памяти, в порядке добавления.
+// Обработчики результата доставки нельзя записать в файл, поэтому они остаются в памяти
+// вместе с идентификаторами элементов, чтобы Cancel и Update находили элементы на диске.
+// Файлы переживают перезапуск: элементы, оставшиеся в них после остановки или сбоя, загружаются
+// при следующем открытии. Доставка при этом выполняется хотя бы один раз: элемент, прочитанный
+// из файла незадолго до сбоя, и элемент, отмененный Cancel, загружаются снова.
+//
+// Элементы записываются в последний файл, пока он не достигнет SegmentSize, и читаются с первого;
+// прочитанный файл удаляется целиком, поэтому место освобождается по ходу длительной дозагрузки.
+// Файл, в котором отменено больше половины записей, сжимается в фоновой горутине.
+// Первая строка каждого файла - заголовок формата, остальные - элементы, по одному в строке. В кодировке
+// MessagePack после строки заголовка идут записи, каждой из которых предшествует ее длина (uvarint)
+type Spool struct {
+	dir     string
+	ext     string
+	options SpoolOptions
+	cipher  *FileCipher
+	binary  bool // элементы записываются в MessagePack
+
+	mu         sync.Mutex
+	segments   []*spoolSegment // в порядке чтения; запись идет в последний, пока out открыт
+	seq        int             // номер последнего созданного файла
+	out        *os.File        // дозапись в конец последнего файла
+	w          *bufio.Writer
+	in         *os.File // чтение первого файла; nil, пока чтение не начато
+	r          *bufio.Reader
+	n          int
+	bytes      int64 // байт всех файлов
+	callbacks  map[uint64]itemHandlers
+	dropped    []itemHandlers // элементы, сброшенные при заполнении, еще не переданные буферу
+	overflow   bool           // о заполнении уже предупреждено
+	compact    chan struct{}
+	stop       chan struct{}
+	compactors sync.WaitGroup
+	loadedID   uint64 // наибольший идентификатор элементов, загруженных из файлов прошлого запуска
+}
+
+// spoolFileHeader представляет заголовок файла spool
+type spoolFileHeader struct {
+	formatHeader
+	Encoding string `json:"encoding,omitempty"` // кодировка элементов, пусто - json
+}
+
+// header возвращает первую строку файла в текущей версии формата
+func (s *Spool) header() []byte {
+	header := spoolFileHeader{formatHeader: newFormatHeader(spoolFormat, spoolVersion)}
+	if s.binary {
+		header.Encoding = spoolMsgpack
+	}
+	line, _ := json.Marshal(header)
+	return append(line, '\n')
+}
+
+// readSpoolHeader проверяет первую строку файла и возвращает заголовок
+func readSpoolHeader(line []byte) (spoolFileHeader, error) {
+	var header spoolFileHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return header, fmt.Errorf("reading spool header: %w", err)
+	}
+	return header, header.check(spoolFormat, spoolVersion)
+}
+
+// OpenSpool открывает файлы вытесненных элементов в каталоге dir и загружает элементы, оставшиеся
+// от прошлого запуска. Если задан cipher, элементы записываются зашифрованными
+func OpenSpool(dir string, cipher *FileCipher) (*Spool, error) {
+	return OpenSpoolWith(dir, cipher, SpoolOptions{})
+}
+
+// OpenSpoolWith открывает spool как OpenSpool с параметрами options. MessagePack занимает меньше
+// места на диске и быстрее кодируется, а JSON удобно читать при разборе сбоев. Если SegmentSize
+// больше четверти MaxSize, он уменьшается, чтобы место освобождалось до заполнения spool
+func OpenSpoolWith(dir string, cipher *FileCipher, options SpoolOptions) (*Spool, error) {
+	if options.Encoding == "" {
+		options.Encoding = spoolJSON
+	}
+	if options.Encoding != spoolJSON && options.Encoding != spoolMsgpack {
+		return nil, fmt.Errorf("unknown spool encoding %q, expected json or msgpack", options.Encoding)
+	}
+	if options.Full == "" {
+		options.Full = spoolFullBlock
+	}
+	if !validSpoolFull(options.Full) {
+		return nil, fmt.Errorf("unknown spool full policy %q, expected block, reject or drop_oldest", options.Full)
+	}
+	if options.SegmentSize <= 0 {
+		options.SegmentSize = defaultSpoolSegment
+	}
+	if options.MaxSize > 0 {
+		options.SegmentSize = max(min(options.SegmentSize, options.MaxSize/4), 1)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := removeStaleSpoolFiles(dir); err != nil {
+		return nil, err
+	}
+	s := &Spool{
+		dir:       dir,
+		ext:       filepath.Ext(spoolFileName),
+		options:   options,
+		cipher:    cipher,
+		binary:    options.Encoding == spoolMsgpack,
+		callbacks: make(map[uint64]itemHandlers),
+		compact:   make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+	}
+	if s.binary {
+		s.ext = filepath.Ext(spoolBinaryFileName)
+	}
+	if err := s.load(); err != nil {
+		return nil, fmt.Errorf("loading spool: %w", err)
+	}
+	if s.w == nil {
+		if err := s.openSegment(); err != nil {
+			return nil, err
+		}
+	}
+	s.compactors.Add(1)
+	go s.compactor()
+	return s, nil
+}
+
+// validSpoolFull сообщает, известно ли поведение при заполнении spool
+func validSpoolFull(policy string) bool {
+	return policy == spoolFullBlock || policy == spoolFullReject || policy == spoolFullDropOldest
+}
+
+// removeStaleSpoolFiles удаляет в каталоге dir файлы версий без разбиения на файлы и временные файлы
+// сжатия и выводит объем отбрасываемых элементов
+func removeStaleSpoolFiles(dir string) error {
+	var paths []string
+	for _, pattern := range []string{spoolFileName, spoolBinaryFileName, "spool-*.tmp"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return err
+		}
+		paths = append(paths, matches...)
+	}
+	var size int64
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			size += info.Size()
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if size > 0 {
+		logWarnf("Discarding %d bytes of items spilled to %s by a previous version", size, dir)
+	}
+	return nil
+}
+
+// segmentPaths возвращает файлы spool в каталоге в порядке номеров
+func (s *Spool) segmentPaths() ([]string, error) {
+	var paths []string
+	for _, ext := range []string{filepath.Ext(spoolFileName), filepath.Ext(spoolBinaryFileName)} {
+		matches, err := filepath.Glob(filepath.Join(s.dir, "spool-*"+ext))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, matches...)
+	}
+	slices.SortFunc(paths, func(a, b string) int { return cmp.Compare(segmentSeq(a), segmentSeq(b)) })
+	return paths, nil
+}
+
+// segmentSeq возвращает номер файла spool по его имени
+func segmentSeq(path string) int {
+	var seq int
+	fmt.Sscanf(filepath.Base(path), spoolSegmentName, &seq)
+	return seq
+}
+
+// load загружает элементы из файлов прошлого запуска. Файлы в текущей кодировке продолжают читаться
+// на месте, а если кодировка или шифрование изменились, элементы переписываются в новые файлы по порядку.
+// Недописанная при сбое последняя запись файла отбрасывается
+func (s *Spool) load() error {
+	paths, err := s.segmentPaths()
+	if err != nil || len(paths) == 0 {
+		return err
+	}
+	segments := make([]*spoolSegment, len(paths))
+	convert := false
+	for i, path := range paths {
+		s.seq = max(s.seq, segmentSeq(path))
+		var ids []uint64
+		seg := &spoolSegment{path: path, canceled: make(map[uint64]struct{})}
+		rewrite, err := s.scanSegment(seg, func(rec spooledItem) { ids = append(ids, rec.ID) })
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		convert = convert || rewrite
+		for _, id := range ids {
+			s.callbacks[id] = itemHandlers{id: id, segment: seg}
+			s.loadedID = max(s.loadedID, id)
+		}
+		seg.records, seg.live = len(ids), len(ids)
+		segments[i] = seg
+	}
+	if !convert {
+		for _, seg := range segments {
+			if seg.records == 0 {
+				if err := os.Remove(seg.path); err != nil {
+					return err
+				}
+				continue
+			}
+			s.segments = append(s.segments, seg)
+			s.bytes += seg.size
+			s.n += seg.records
+		}
+		if s.n > 0 {
+			logInfof("Loaded %d items spilled to %s by a previous run", s.n, s.dir)
+		}
+		return nil
+	}
+
+	// Переписанные элементы уже были приняты, поэтому ограничение размера к ним не применяется
+	limit := s.options.MaxSize
+	s.options.MaxSize = 0
+	defer func() { s.options.MaxSize = limit }()
+	clear(s.callbacks)
+	for _, seg := range segments {
+		var pushErr error
+		if _, err := s.scanSegment(seg, func(rec spooledItem) {
+			if pushErr == nil {
+				pushErr = s.push(&queuedItem{id: rec.ID, fields: newItemFields(rec.Data), correlationID: rec.CorrelationID,
+					provenance: rec.Provenance, endpoint: rec.Endpoint, attempts: rec.Attempts, tries: rec.Tries})
+			}
+		}); err != nil {
+			return fmt.Errorf("%s: %w", seg.path, err)
+		}
+		if pushErr != nil {
+			return pushErr
+		}
+	}
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	for _, seg := range segments {
+		if err := os.Remove(seg.path); err != nil {
+			return err
+		}
+	}
+	logInfof("Loaded %d items spilled to %s by a previous run in %s", s.n, s.dir, s.options.Encoding)
+	return nil
+}
+
+// scanSegment читает записи файла seg прошлого запуска, передает их f, запоминает в seg.size длину
+// файла без недописанной последней записи и обрезает ее. Возвращает, нужно ли переписать файл:
+// он в другой кодировке или его записи зашифрованы не так, как сейчас
+func (s *Spool) scanSegment(seg *spoolSegment, f func(rec spooledItem)) (bool, error) {
+	in, err := os.Open(seg.path)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+	r := bufio.NewReader(in)
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		// Файл без заголовка создан, но еще не записан
+		return false, nil
+	}
+	header, err := readSpoolHeader(line)
+	if err != nil {
+		return false, err
+	}
+	reader := &Spool{cipher: s.cipher, binary: header.Encoding == spoolMsgpack}
+	rewrite := reader.binary != s.binary
+	size := int64(len(line))
+	for {
+		rec, frame, err := reader.read(r)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			logWarnf("Discarding a torn record at the end of %s", seg.path)
+			if err := os.Truncate(seg.path, size); err != nil {
+				return false, err
+			}
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+		f(rec)
+		record := frame
+		if reader.binary {
+			_, n := binary.Uvarint(frame)
+			record = frame[n:]
+		}
+		rewrite = rewrite || !s.cipher.current(record)
+		size += int64(len(frame))
+	}
+	seg.size = size
+	return rewrite, nil
+}
+
+// openSegment начинает новый файл для записи
+func (s *Spool) openSegment() error {
+	s.seq++
+	path := filepath.Join(s.dir, fmt.Sprintf(spoolSegmentName, s.seq)+s.ext)
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	header := s.header()
+	s.out, s.w = out, bufio.NewWriter(out)
+	s.w.Write(header)
+	s.segments = append(s.segments, &spoolSegment{path: path, size: int64(len(header)), canceled: make(map[uint64]struct{})})
+	s.bytes += int64(len(header))
+	return nil
+}
+
+// writing сообщает, идет ли запись в файл seg
+func (s *Spool) writing(seg *spoolSegment) bool {
+	return s.w != nil && seg == s.segments[len(s.segments)-1]
+}
+
+// Len возвращает количество элементов в файлах
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n
+}
+
+// Size возвращает место на диске, занятое файлами
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes
+}
+
+// full сообщает, что spool занял MaxSize и по его правилу новые элементы ждут или отвергаются
+// до освобождения места
+func (s *Spool) full() bool {
+	if s.options.MaxSize <= 0 || s.options.Full == spoolFullDropOldest {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes >= s.options.MaxSize
+}
+
+// push дописывает элемент в конец последнего файла. Если запись превысит MaxSize, по правилу
+// drop_oldest сначала сбрасываются самые старые файлы, а по правилу reject элемент не записывается
+// и передается takeDropped
+func (s *Spool) push(item *queuedItem) error {
+	rec := spooledItem{
+		ID:            item.id,
+		Data:          item.fields.Map(),
+		CorrelationID: item.correlationID,
+		Provenance:    item.provenance,
+		Endpoint:      item.endpoint,
+		Attempts:      item.attempts,
+		Tries:         item.tries,
+	}
+	var frame []byte
+	if s.binary {
+		record := s.cipher.Seal(spoolFormat, encodeSpooledItem(rec))
+		frame = append(binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(record)), uint64(len(record))), record...)
+	} else {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		frame = append(s.cipher.Seal(spoolFormat, line), '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.options.MaxSize > 0 && s.bytes+int64(len(frame)) > s.options.MaxSize {
+		switch s.options.Full {
+		case spoolFullDropOldest:
+			for s.n > 0 && s.bytes+int64(len(frame)) > s.options.MaxSize {
+				if err := s.dropOldest(); err != nil {
+					return err
+				}
+			}
+		case spoolFullReject:
+			// Элемент, принятый Add до заполнения spool, но еще ожидавший в канале, тоже отвергается
+			s.warnFull("rejecting new items")
+			s.dropped = append(s.dropped, itemHandlers{id: item.id, done: item.done, result: item.result})
+			return nil
+		}
+	}
+	if s.w != nil {
+		if last := s.segments[len(s.segments)-1]; last.records > 0 && last.size >= s.options.SegmentSize {
+			if err := s.seal(); err != nil {
+				return err
+			}
+		}
+	}
+	if s.w == nil {
+		if err := s.openSegment(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.w.Write(frame); err != nil {
+		return err
+	}
+	seg := s.segments[len(s.segments)-1]
+	seg.size += int64(len(frame))
+	seg.records++
+	seg.live++
+	s.bytes += int64(len(frame))
+	s.callbacks[item.id] = itemHandlers{id: item.id, done: item.done, result: item.result, segment: seg}
+	s.n++
+	return nil
+}
+
+// seal завершает запись в последний файл; следующий элемент начнет новый
+func (s *Spool) seal() error {
+	err := s.w.Flush()
+	if closeErr := s.out.Close(); err == nil {
+		err = closeErr
+	}
+	s.out, s.w = nil, nil
+	return err
+}
+
+// pop читает первый элемент, пропуская отмененные. Полностью прочитанный файл удаляется
+func (s *Spool) pop() (*queuedItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.n == 0 {
+		return nil, nil
+	}
+	seg := s.segments[0]
+	if s.writing(seg) {
+		if err := s.w.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	if s.in == nil {
+		in, err := os.Open(seg.path)
+		if err != nil {
+			return nil, err
+		}
+		s.in, s.r = in, bufio.NewReader(in)
+		line, err := s.r.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		if _, err := readSpoolHeader(line); err != nil {
+			return nil, err
+		}
+	}
+	var rec spooledItem
+	for {
+		var err error
+		if rec, _, err = s.read(s.r); err != nil {
+			return nil, err
+		}
+		if _, ok := seg.canceled[rec.ID]; !ok {
+			break
+		}
+		delete(seg.canceled, rec.ID)
+	}
+	handlers := s.callbacks[rec.ID]
+	delete(s.callbacks, rec.ID)
+	seg.live--
+	s.n--
+	s.overflow = false
+	if seg.live == 0 {
+		if err := s.release(seg); err != nil {
+			fmt.Println("Error removing spool file:", err)
+		}
+	}
+	return &queuedItem{
+		id:            rec.ID,
+		fields:        newItemFields(rec.Data),
+		correlationID: rec.CorrelationID,
+		provenance:    rec.Provenance,
+		endpoint:      rec.Endpoint,
+		attempts:      rec.Attempts,
+		tries:         rec.Tries,
+		done:          handlers.done,
+		result:        handlers.result,
+		edit:          handlers.edit,
+	}, nil
+}
+
+// read читает следующую запись из r и возвращает ее вместе с байтами записи в файле
+func (s *Spool) read(r *bufio.Reader) (spooledItem, []byte, error) {
+	var rec spooledItem
+	if !s.binary {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return rec, nil, err
+		}
+		plain, err := s.cipher.Open(spoolFormat, line)
+		if err != nil {
+			return rec, nil, err
+		}
+		err = json.Unmarshal(plain, &rec)
+		return rec, line, err
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return rec, nil, err
+	}
+	if size > maxSpoolRecord {
+		return rec, nil, fmt.Errorf("spool record of %d bytes is too long", size)
+	}
+	frame := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+int(size)), size)
+	record := frame[len(frame) : len(frame)+int(size)]
+	if _, err := io.ReadFull(r, record); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return rec, nil, err
+	}
+	frame = frame[:len(frame)+int(size)]
+	if record, err = s.cipher.Open(spoolFormat, record); err != nil {
+		return rec, nil, err
+	}
+	rec, err = decodeSpooledItem(record)
+	return rec, frame, err
+}
+
+// release освобождает файл seg, в котором не осталось ожидающих записей: последний файл
+// очищается и продолжает принимать записи, остальные удаляются
+func (s *Spool) release(seg *spoolSegment) error {
+	first := seg == s.segments[0]
+	if first && s.in != nil {
+		s.in.Close()
+		s.in, s.r = nil, nil
+	}
+	clear(seg.canceled)
+	if s.writing(seg) {
+		header := s.header()
+		s.w.Reset(s.out)
+		if err := s.out.Truncate(0); err != nil {
+			return err
+		}
+		s.bytes += int64(len(header)) - seg.size
+		seg.size, seg.records = int64(len(header)), 0
+		_, err := s.w.Write(header)
+		return err
+	}
+	seg.removed = true
+	s.bytes -= seg.size
+	s.segments = slices.DeleteFunc(s.segments, func(other *spoolSegment) bool { return other == seg })
+	return os.Remove(seg.path)
+}
+
+// dropOldest сбрасывает первый файл, запоминая обработчики его элементов для takeDropped
+func (s *Spool) dropOldest() error {
+	seg := s.segments[0]
+	start := len(s.dropped)
+	for id, handlers := range s.callbacks {
+		if handlers.segment == seg {
+			s.dropped = append(s.dropped, handlers)
+			delete(s.callbacks, id)
+		}
+	}
+	slices.SortFunc(s.dropped[start:], func(a, b itemHandlers) int { return cmp.Compare(a.id, b.id) })
+	s.warnFull("dropping the oldest items")
+	s.n -= seg.live
+	seg.live = 0
+	return s.release(seg)
+}
+
+// warnFull предупреждает о заполнении spool один раз, пока из него не прочитан элемент
+func (s *Spool) warnFull(action string) {
+	if !s.overflow {
+		logWarnf("spool reached %d bytes, %s", s.options.MaxSize, action)
+		s.overflow = true
+	}
+}
+
+// takeDropped возвращает и сбрасывает обработчики элементов, сброшенных при заполнении spool
+func (s *Spool) takeDropped() []itemHandlers {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dropped := s.dropped
+	s.dropped = nil
+	return dropped
+}
+
+// discard отбрасывает оставшиеся элементы, если файл не читается, и возвращает их количество
+// и обработчики результата
+func (s *Spool) discard() (int, []itemHandlers) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.n
+	callbacks := make([]itemHandlers, 0, len(s.callbacks))
+	for _, handlers := range s.callbacks {
+		callbacks = append(callbacks, handlers)
+	}
+	s.n, s.callbacks = 0, make(map[uint64]itemHandlers)
+	if err := s.closeFiles(); err != nil {
+		fmt.Println("Error resetting spool:", err)
+	}
+	if err := s.openSegment(); err != nil {
+		fmt.Println("Error resetting spool:", err)
+	}
+	return n, callbacks
+}
+
+// closeFiles закрывает и удаляет все файлы
+func (s *Spool) closeFiles() error {
+	if s.in != nil {
+		s.in.Close()
+		s.in, s.r = nil, nil
+	}
+	var err error
+	if s.out != nil {
+		err = s.out.Close()
+		s.out, s.w = nil, nil
+	}
+	for _, seg := range s.segments {
+		seg.removed = true
+		if removeErr := os.Remove(seg.path); err == nil {
+			err = removeErr
+		}
+	}
+	s.segments, s.bytes = nil, 0
+	return err
+}
+
+// cancel исключает элемент из файла и возвращает его обработчики; ok не задан, если элемента
+// в файле нет. Запись элемента остается в файле, пропускается при чтении и удаляется сжатием
+func (s *Spool) cancel(id uint64) (handlers itemHandlers, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if handlers, ok = s.callbacks[id]; !ok {
+		return handlers, false
+	}
+	delete(s.callbacks, id)
+	seg := handlers.segment
+	seg.canceled[id] = struct{}{}
+	seg.live--
+	s.n--
+	switch {
+	case seg.live == 0:
+		if err := s.release(seg); err != nil {
+			fmt.Println("Error removing spool file:", err)
+		}
+	case s.compactable(seg):
+		select {
+		case s.compact <- struct{}{}:
+		default:
+		}
+	}
+	return handlers, true
+}
+
+// update запоминает изменение данных элемента в файле, которое применяется при его чтении;
+// возвращает false, если элемента в файле нет
+func (s *Spool) update(id uint64, mutate itemEdit) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	handlers, ok := s.callbacks[id]
+	if !ok {
+		return false
+	}
+	handlers.edit = chainEdits(handlers.edit, mutate)
+	s.callbacks[id] = handlers
+	return true
+}
+
+// compactable сообщает, стоит ли сжимать файл seg: запись в него закончена, чтение не начато,
+// а отменено не меньше половины записей
+func (s *Spool) compactable(seg *spoolSegment) bool {
+	if s.writing(seg) || seg == s.segments[0] && s.in != nil {
+		return false
+	}
+	return len(seg.canceled) > 0 && 2*len(seg.canceled) >= seg.records
+}
+
+// compactor сжимает файлы по сигналам cancel, пока не вызван Close
+func (s *Spool) compactor() {
+	defer s.compactors.Done()
+	for {
+		select {
+		case <-s.compact:
+		case <-s.stop:
+			return
+		}
+		for _, seg := range s.compactCandidates() {
+			if err := s.compactSegment(seg); err != nil {
+				fmt.Println("Error compacting spool:", err)
+			}
+		}
+	}
+}
+
+// compactCandidates возвращает файлы, которые стоит сжать
+func (s *Spool) compactCandidates() []*spoolSegment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var segments []*spoolSegment
+	for _, seg := range s.segments {
+		if s.compactable(seg) {
+			segments = append(segments, seg)
+		}
+	}
+	return segments
+}
+
+// compactSegment переписывает файл seg без отмененных записей. Файл читается и записывается
+// без блокировки, а заменяется, только если за это время его не начали читать и не удалили
+func (s *Spool) compactSegment(seg *spoolSegment) error {
+	s.mu.Lock()
+	path, canceled := seg.path, maps.Clone(seg.canceled)
+	s.mu.Unlock()
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	r := bufio.NewReader(in)
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	if _, err := readSpoolHeader(line); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	w := bufio.NewWriter(out)
+	header := s.header()
+	w.Write(header)
+	size, records := int64(len(header)), 0
+	for {
+		rec, frame, err := s.read(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			out.Close()
+			return err
+		}
+		if _, ok := canceled[rec.ID]; ok {
+			continue
+		}
+		w.Write(frame)
+		size += int64(len(frame))
+		records++
+	}
+	err = w.Flush()
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seg.removed || seg == s.segments[0] && s.in != nil {
+		return nil
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	logDebugf("Compacted %s from %d to %d bytes", path, seg.size, size)
+	s.bytes += size - seg.size
+	seg.size, seg.records = size, records
+	for id := range canceled {
+		delete(seg.canceled, id)
+	}
+	return nil
+}
+
+// Close останавливает сжатие и закрывает файлы. Файлы с непрочитанными элементами остаются
+// на диске и загружаются при следующем открытии, а прочитанные записи первого из них удаляются
+func (s *Spool) Close() error {
+	close(s.stop)
+	s.compactors.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if s.w != nil {
+		err = s.seal()
+	}
+	if s.in != nil {
+		if trimErr := s.trimLocked(s.segments[0]); err == nil {
+			err = trimErr
+		}
+		s.in.Close()
+		s.in, s.r = nil, nil
+	}
+	for _, seg := range s.segments {
+		if seg.live == 0 {
+			seg.removed = true
+			if removeErr := os.Remove(seg.path); err == nil {
+				err = removeErr
+			}
+		}
+	}
+	return err
+}
+
+// trimLocked переписывает читаемый файл seg без прочитанных и отмененных записей, чтобы при
+// следующем открытии они не загружались снова. Вызывается с захваченным s.mu после seal
+func (s *Spool) trimLocked(seg *spoolSegment) error {
+	if seg.live == 0 {
+		return nil
+	}
+	tmp := seg.path + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	w := bufio.NewWriter(out)
+	w.Write(s.header())
+	for {
+		rec, frame, err := s.read(s.r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			out.Close()
+			return err
+		}
+		if _, ok := seg.canceled[rec.ID]; !ok {
+			w.Write(frame)
+		}
+	}
+	err = w.Flush()
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, seg.path)
+}
+
+// maxID возвращает наибольший идентификатор элементов, загруженных из файлов прошлого запуска
+func (s *Spool) maxID() uint64 {
+	return s.loadedID
+}
+
+// dropLost сообщает результат элементам, потерянным из-за ошибки чтения spool, исключает их
+// из ожидающих доставки и останавливает Run
+func (b *Buffer) dropLost(lost lostItems) {
+	b.fail(fmt.Errorf("%d items lost: %w", lost.n, lost.err))
+	for _, handlers := range lost.callbacks {
+		item := queuedItem{id: handlers.id, done: handlers.done, result: handlers.result}
+		item.finish(lost.err)
+	}
+	b.mu.Lock()
+	if b.pending.Add(-int64(lost.n)) == 0 {
+		b.cond.Broadcast()
+	}
+	b.mu.Unlock()
+}
+
+// dropOverflow сообщает ErrSpoolFull элементам, сброшенным из заполненного spool или не принятым им, и исключает их
+// из ожидающих доставки
+func (b *Buffer) dropOverflow(dropped []itemHandlers) {
+	b.overflowed.Add(uint64(len(dropped)))
+	for _, handlers := range dropped {
+		item := queuedItem{id: handlers.id, done: handlers.done, result: handlers.result}
+		item.finish(ErrSpoolFull)
+	}
+	b.mu.Lock()
+	if b.pending.Add(-int64(len(dropped))) == 0 {
+		b.cond.Broadcast()
+	}
+	b.mu.Unlock()
+}
+
+// itemSize приблизительно оценивает память, занимаемую элементом очереди; имена полей общие
+// для элементов с одинаковым набором полей и не учитываются
+func itemSize(item *queuedItem) int64 {
+	size := int64(itemOverhead + len(item.correlationID) + provenanceSize(item.provenance))
+	for _, value := range item.fields.values {
+		size += int64(len(value) + 16)
+	}
+	return size
+}
+
+// WithMemoryLimit ограничивает память очереди limit байтами: элементы сверх ограничения
+// записываются в spool и загружаются обратно по мере отправки
+func WithMemoryLimit(limit int64, spool *Spool) Option {
+	return func(b *Buffer) {
+		b.queue.limit, b.queue.spool = limit, spool
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"buffer/kpidrivetest"
+	"buffer/mockkpi"
+)
+
+// spoolItems записывает в новый spool каталога dir элементы с идентификаторами ids,
+// читает из него read элементов и закрывает его
+func spoolItems(t *testing.T, dir string, read int, ids ...uint64) {
+	t.Helper()
+	s, err := OpenSpoolWith(dir, nil, SpoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if err := s.push(&queuedItem{id: id, fields: newItemFields(benchmarkItem)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < read; i++ {
+		if _, err := s.pop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// popIDs читает все элементы spool и возвращает их идентификаторы
+func popIDs(t *testing.T, s *Spool) []uint64 {
+	t.Helper()
+	var ids []uint64
+	for s.Len() > 0 {
+		item, err := s.pop()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, item.id)
+	}
+	return ids
+}
+
+func TestSpoolSurvivesRestart(t *testing.T) {
+	SetLogLevel(LevelError)
+	dir := t.TempDir()
+	spoolItems(t, dir, 1, 1, 2, 3)
+
+	// Прочитанный до закрытия элемент не загружается снова, а недописанная при сбое запись отбрасывается
+	paths, err := filepath.Glob(filepath.Join(dir, "spool-*.jsonl"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("spool files after Close: %v, %v", paths, err)
+	}
+	f, err := os.OpenFile(paths[0], os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":4,"data":`)
+	f.Close()
+
+	s, err := OpenSpoolWith(dir, nil, SpoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.maxID() != 3 {
+		t.Errorf("maxID = %d, want 3", s.maxID())
+	}
+	if err := s.push(&queuedItem{id: 5, fields: newItemFields(benchmarkItem)}); err != nil {
+		t.Fatal(err)
+	}
+	if ids := popIDs(t, s); len(ids) != 3 || ids[0] != 2 || ids[1] != 3 || ids[2] != 5 {
+		t.Errorf("reopened spool returned %v, want [2 3 5]", ids)
+	}
+}
+
+func TestSpoolConvertsEncodingOnRestart(t *testing.T) {
+	SetLogLevel(LevelError)
+	dir := t.TempDir()
+	spoolItems(t, dir, 0, 1, 2)
+
+	s, err := OpenSpoolWith(dir, nil, SpoolOptions{Encoding: spoolMsgpack})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if old, _ := filepath.Glob(filepath.Join(dir, "*.jsonl")); len(old) != 0 {
+		t.Errorf("JSON spool files kept after conversion: %v", old)
+	}
+	if ids := popIDs(t, s); len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("converted spool returned %v, want [1 2]", ids)
+	}
+}
+
+func TestBufferDeliversSpoolOfPreviousRun(t *testing.T) {
+	SetLogLevel(LevelError)
+	dir := t.TempDir()
+	spoolItems(t, dir, 0, 1, 2)
+	srv := kpidrivetest.NewServer(mockkpi.Options{})
+	defer srv.Close()
+
+	s, err := OpenSpoolWith(dir, nil, SpoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	b := NewBuffer(srv.SaveFactURL(), "token", WithMemoryLimit(1<<20, s))
+	defer b.Close()
+	id, err := b.Submit(benchmarkItem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Flush()
+	if id <= 2 {
+		t.Errorf("new item got id %d of a spooled item", id)
+	}
+	if n := len(srv.Requests()); n != 3 || b.Stats().Sent != 3 {
+		t.Errorf("%d requests, %d sent; want 3 with the 2 spooled items", n, b.Stats().Sent)
+	}
+}
+
+// pushIDs записывает в spool элементы с идентификаторами ids
+func pushIDs(t *testing.T, s *Spool, ids ...uint64) {
+	t.Helper()
+	for _, id := range ids {
+		if err := s.push(&queuedItem{id: id, fields: newItemFields(benchmarkItem)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// spoolFrame возвращает размер заголовка файла spool и записи элемента benchmarkItem
+func spoolFrame(t *testing.T) (header, frame int64) {
+	t.Helper()
+	s, err := OpenSpoolWith(t.TempDir(), nil, SpoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	header = s.Size()
+	pushIDs(t, s, 10)
+	return header, s.Size() - header
+}
+
+// TestSpoolSegments проверяет переход к новому файлу по SegmentSize, сжатие файла, в котором
+// отменено больше половины записей, и удаление прочитанных файлов
+func TestSpoolSegments(t *testing.T) {
+	SetLogLevel(LevelError)
+	header, frame := spoolFrame(t)
+	dir := t.TempDir()
+	s, err := OpenSpoolWith(dir, nil, SpoolOptions{SegmentSize: header + 3*frame})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	segments := func() int {
+		t.Helper()
+		paths, err := filepath.Glob(filepath.Join(dir, "spool-*.jsonl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(paths)
+	}
+	pushIDs(t, s, 10, 11, 12, 13, 14, 15, 16, 17, 18)
+	if n := segments(); n != 3 {
+		t.Fatalf("%d spool files for 9 items, want 3 of 3 items", n)
+	}
+
+	// Отмена двух записей из трех сжимает файл, а в дописываемом файле запись остается до чтения
+	size := s.Size()
+	for _, id := range []uint64{13, 14, 16} {
+		if _, ok := s.cancel(id); !ok {
+			t.Fatalf("item %d not found in the spool", id)
+		}
+	}
+	waitFor(t, "compaction", func() bool { return s.Size() == size-2*frame })
+	if s.Len() != 6 {
+		t.Errorf("Len = %d after 3 cancellations, want 6", s.Len())
+	}
+	if ids := popIDs(t, s); !slices.Equal(ids, []uint64{10, 11, 12, 15, 17, 18}) {
+		t.Errorf("spool returned %v, want [10 11 12 15 17 18]", ids)
+	}
+	if n := segments(); n != 1 || s.Size() != header {
+		t.Errorf("%d spool files of %d bytes after reading, want only the emptied last one", n, s.Size())
+	}
+}
+
+func TestSpoolFullPolicies(t *testing.T) {
+	SetLogLevel(LevelError)
+	header, frame := spoolFrame(t)
+	maxSize := 4 * (header + frame)
+	for _, policy := range []string{spoolFullReject, spoolFullDropOldest} {
+		s, err := OpenSpoolWith(t.TempDir(), nil, SpoolOptions{MaxSize: maxSize, Full: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dropped []uint64
+		for id := uint64(10); id < 20; id++ {
+			pushIDs(t, s, id)
+			for _, handlers := range s.takeDropped() {
+				dropped = append(dropped, handlers.id)
+			}
+			if s.Size() > maxSize {
+				t.Fatalf("%s: spool grew to %d bytes, MaxSize %d", policy, s.Size(), maxSize)
+			}
+		}
+		kept := popIDs(t, s)
+		s.Close()
+		if len(kept) == 0 || len(dropped) == 0 || len(kept)+len(dropped) != 10 {
+			t.Fatalf("%s: kept %v and dropped %v of 10 items", policy, kept, dropped)
+		}
+		// reject отвергает новые элементы, а drop_oldest сбрасывает самые старые
+		want := slices.Concat(kept, dropped)
+		if policy == spoolFullDropOldest {
+			want = slices.Concat(dropped, kept)
+		}
+		if !slices.IsSorted(want) {
+			t.Errorf("%s: kept %v, dropped %v", policy, kept, dropped)
+		}
+	}
+}
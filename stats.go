@@ -0,0 +1,118 @@
+package main
+
+import "time"
+
+// Stats представляет сводную статистику работы буфера
+type Stats struct {
+	Queued      int           `json:"queued"`
+	Spilled     int           `json:"spilled"`        // из них вытеснено на диск
+	SpoolBytes  int64         `json:"spool_bytes"`    // место на диске, занятое spool
+	Overflowed  uint64        `json:"spool_overflow"` // отвергнуто или сброшено при заполнении spool
+	InFlight    int           `json:"in_flight"`
+	Paused      bool          `json:"paused"`
+	Offline     bool          `json:"offline"`        // API недоступен, отправка ждет его возвращения
+	Outside     bool          `json:"outside_window"` // окно отправки закрыто, элементы ждут его открытия
+	Standby     bool          `json:"standby"`        // реплика не лидер и передает новые элементы лидеру
+	Forwarded   uint64        `json:"forwarded"`      // передано лидеру через общую очередь
+	Attempts    uint64        `json:"attempts"`
+	Sent        uint64        `json:"sent"`
+	Failed      uint64        `json:"failed"`
+	Invalid     uint64        `json:"invalid"`    // отвергнуто проверкой при добавлении
+	Duplicates  uint64        `json:"duplicates"` // не отправлено, потому что факт уже есть в API
+	Warned      uint64        `json:"warned"`     // из отправленных - принято API с предупреждениями
+	Canceled    uint64        `json:"canceled"`   // отменено Cancel до отправки
+	Updated     uint64        `json:"updated"`    // изменений элементов Update
+	DeadLetters int           `json:"dead_letters"`
+	Circuit     string        `json:"circuit"`
+	LatencyAvg  time.Duration `json:"latency_avg"`
+	LatencyP50  time.Duration `json:"latency_p50"`
+	LatencyP95  time.Duration `json:"latency_p95"`
+	LatencyP99  time.Duration `json:"latency_p99"`
+	LatencyMax  time.Duration `json:"latency_max"`
+	Quota       []QuotaUsage  `json:"quota,omitempty"`       // расход квоты запросов к API
+	FactsCache  *CacheStats   `json:"facts_cache,omitempty"` // работа кеша get_facts
+}
+
+// Len возвращает количество элементов, ожидающих отправки, включая вытесненные на диск и
+// отложенные, как Stats().Queued
+func (b *Buffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.queue.Len() + len(b.incoming) + b.parked
+}
+
+// Pending возвращает количество добавленных, но еще не обработанных элементов: ожидающих
+// и отправляемых. Flush возвращается, когда оно становится нулевым
+func (b *Buffer) Pending() int {
+	return int(b.pending.Load())
+}
+
+// InFlight возвращает количество элементов, отправка которых выполняется
+func (b *Buffer) InFlight() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.inFlight)
+}
+
+// FailedCount возвращает количество неудачных попыток отправки, как Stats().Failed
+func (b *Buffer) FailedCount() uint64 {
+	return b.failed.Load()
+}
+
+// Stats возвращает текущую статистику буфера
+func (b *Buffer) Stats() Stats {
+	b.mu.Lock()
+	queued := b.queue.Len() + len(b.incoming) + b.parked
+	spilled := b.queue.Spilled()
+	spoolBytes := b.queue.SpoolSize()
+	inFlight := len(b.inFlight)
+	paused := b.paused
+	outside := b.windowClosedLocked()
+	b.mu.Unlock()
+
+	offline, _ := b.Offline()
+	circuit := CircuitClosed
+	if b.breaker != nil {
+		circuit = b.breaker.State()
+	}
+
+	latency := b.latency.Snapshot()
+	return Stats{
+		Queued:      queued,
+		Spilled:     spilled,
+		SpoolBytes:  spoolBytes,
+		Overflowed:  b.overflowed.Load(),
+		InFlight:    inFlight,
+		Paused:      paused,
+		Offline:     offline,
+		Outside:     outside,
+		Standby:     b.standby.Load() != nil,
+		Forwarded:   b.forwarded.Load(),
+		Attempts:    b.attempts.Load(),
+		Sent:        b.sent.Load(),
+		Failed:      b.failed.Load(),
+		Invalid:     b.invalid.Load(),
+		Duplicates:  b.duplicates.Load(),
+		Warned:      b.warned.Load(),
+		Canceled:    b.canceled.Load(),
+		Updated:     b.updated.Load(),
+		DeadLetters: b.dlq.Len(),
+		Circuit:     circuit,
+		LatencyAvg:  latency.Mean(),
+		LatencyP50:  latency.Quantile(0.50),
+		LatencyP95:  latency.Quantile(0.95),
+		LatencyP99:  latency.Quantile(0.99),
+		LatencyMax:  latency.Max,
+		Quota:       b.Quota(),
+		FactsCache:  b.factsCacheStats(),
+	}
+}
+
+// factsCacheStats возвращает статистику кеша get_facts; nil, если кеш не используется
+func (b *Buffer) factsCacheStats() *CacheStats {
+	if b.facts == nil {
+		return nil
+	}
+	stats := b.facts.Stats()
+	return &stats
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatsdClient отправляет метрики по UDP в формате statsd с расширением тегов DogStatsD
+type StatsdClient struct {
+	conn   net.Conn
+	prefix string
+	tags   []string
+}
+
+// NewStatsdClient создает клиента statsd для адреса host:port с префиксом имен и общими тегами
+func NewStatsdClient(addr, prefix string, tags ...string) (*StatsdClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &StatsdClient{conn: conn, prefix: prefix, tags: tags}, nil
+}
+
+// Count увеличивает счетчик на value
+func (s *StatsdClient) Count(name string, value int64, tags ...string) {
+	s.send(name, strconv.FormatInt(value, 10), "c", tags)
+}
+
+// Gauge устанавливает текущее значение показателя
+func (s *StatsdClient) Gauge(name string, value float64, tags ...string) {
+	s.send(name, strconv.FormatFloat(value, 'f', -1, 64), "g", tags)
+}
+
+// Timing записывает длительность операции в миллисекундах
+func (s *StatsdClient) Timing(name string, d time.Duration, tags ...string) {
+	s.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64), "ms", tags)
+}
+
+// Close закрывает UDP-соединение
+func (s *StatsdClient) Close() error {
+	return s.conn.Close()
+}
+
+// send формирует и отправляет одну строку метрики; ошибки UDP игнорируются, чтобы не влиять на доставку данных
+func (s *StatsdClient) send(name, value, kind string, tags []string) {
+	var line strings.Builder
+	line.WriteString(s.prefix)
+	line.WriteString(name)
+	line.WriteByte(':')
+	line.WriteString(value)
+	line.WriteByte('|')
+	line.WriteString(kind)
+	if len(s.tags)+len(tags) > 0 {
+		line.WriteString("|#")
+		line.WriteString(strings.Join(append(append([]string{}, s.tags...), tags...), ","))
+	}
+	s.conn.Write([]byte(line.String()))
+}
+
+// WithStatsd включает отправку счетчиков и времени каждой попытки в statsd
+func WithStatsd(client *StatsdClient) Option {
+	return func(b *Buffer) {
+		b.statsd = client
+	}
+}
+
+// emitStatsd отправляет в statsd метрики завершенной попытки
+func (b *Buffer) emitStatsd(entry *AuditEntry, latency time.Duration) {
+	if b.statsd == nil {
+		return
+	}
+	result := "result:ok"
+	if entry.Error != "" {
+		result = "result:failed"
+	}
+	b.statsd.Count("send.attempts", 1, result)
+	if entry.Error == "" {
+		b.statsd.Count("send.sent", 1)
+	} else {
+		b.statsd.Count("send.failed", 1)
+	}
+	if latency > 0 {
+		b.statsd.Timing("send.latency", latency, result)
+	}
+	b.statsd.Gauge("queue.length", float64(b.Stats().Queued))
+}
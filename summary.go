@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SummaryLogger периодически выводит сводную строку о пропускной способности буфера
+type SummaryLogger struct {
+	buffer   *Buffer
+	interval time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewSummaryLogger создает периодический вывод сводки с заданным интервалом
+func NewSummaryLogger(b *Buffer, interval time.Duration) *SummaryLogger {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &SummaryLogger{
+		buffer:   b,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start запускает вывод сводки в отдельной горутине
+func (s *SummaryLogger) Start() {
+	go s.loop()
+}
+
+// Stop останавливает вывод сводки и дожидается завершения
+func (s *SummaryLogger) Stop() {
+	s.once.Do(func() { close(s.stop) })
+	<-s.done
+}
+
+// loop выводит сводку по приращениям счетчиков за каждый интервал
+func (s *SummaryLogger) loop() {
+	defer close(s.done)
+	prev := s.buffer.Stats()
+	prevLatency := s.buffer.latency.Snapshot()
+	prevAt := s.buffer.clock.Now()
+	ticker := s.buffer.clock.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C():
+			stats := s.buffer.Stats()
+			latency := s.buffer.latency.Snapshot()
+			logInfof("%s", formatSummary(stats, prev, latency, prevLatency, now.Sub(prevAt)))
+			prev, prevLatency, prevAt = stats, latency, now
+		}
+	}
+}
+
+// formatSummary формирует сводную строку за интервал между двумя срезами статистики
+func formatSummary(stats, prev Stats, latency, prevLatency LatencySnapshot, elapsed time.Duration) string {
+	attempts := stats.Attempts - prev.Attempts
+	sent := stats.Sent - prev.Sent
+	success := 100.0
+	if attempts > 0 {
+		success = float64(sent) / float64(attempts) * 100
+	}
+	var avg time.Duration
+	if n := latency.Count - prevLatency.Count; n > 0 {
+		avg = (latency.Sum - prevLatency.Sum) / time.Duration(n)
+	}
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(sent) / elapsed.Seconds()
+	}
+	return fmt.Sprintf("Summary: %.1f items/s, success %.1f%% (%d/%d), avg latency %s, queue %d, in flight %d, dead letters %d",
+		rate, success, sent, attempts, avg.Round(time.Millisecond), stats.Queued, stats.InFlight, stats.DeadLetters)
+}
@@ -0,0 +1,32 @@
+package main
+
+// WithSynchronous включает синхронный режим: Add доставляет элемент в вызывающей горутине,
+// с повторами и ограничением частоты, и возвращает результат доставки. Очередь и горутина
+// отправки не используются, Pause не задерживает отправку, а одновременных запросов столько,
+// сколько горутин одновременно вызывают Add. Недоставленный элемент, как и обычно, попадает
+// в очередь недоставленных
+func WithSynchronous() Option {
+	return func(b *Buffer) {
+		b.synchronous = true
+	}
+}
+
+// deliverNow доставляет элемент в вызывающей горутине и возвращает результат доставки
+func (b *Buffer) deliverNow(item *queuedItem) error {
+	if b.users != nil {
+		b.clock.Sleep(b.users.reserve(item.fields, b.clock.Now()))
+	}
+	var result error
+	done := item.done
+	item.done = func(err error) {
+		result = err
+		if done != nil {
+			done(err)
+		}
+	}
+	b.mu.Lock()
+	b.inFlight[item.id] = item
+	b.mu.Unlock()
+	b.run(item)
+	return result
+}
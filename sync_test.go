@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"buffer/kpidrivetest"
+	"buffer/mockkpi"
+)
+
+// TestSynchronousAdd проверяет, что в синхронном режиме Add возвращается после доставки
+// с ее результатом, без Flush и несмотря на Pause
+func TestSynchronousAdd(t *testing.T) {
+	SetLogLevel(LevelError)
+	srv := kpidrivetest.NewServer(mockkpi.Options{})
+	defer srv.Close()
+	dlq := NewDeadLetterQueue()
+	b := NewBuffer(srv.SaveFactURL(), "token", WithSynchronous(), WithDeadLetterQueue(dlq))
+	defer b.Close()
+	b.Pause()
+
+	var handled error
+	if err := b.Add(benchmarkItem, WithResultHandler(func(r ItemResult) { handled = r.Err })); err != nil {
+		t.Fatalf("delivered item returned %v", err)
+	}
+	if n := len(srv.Requests()); n != 1 || b.Pending() != 0 || handled != nil {
+		t.Fatalf("after Add: %d requests, %d pending, result %v; want 1, 0 and nil", n, b.Pending(), handled)
+	}
+
+	srv.FailNext(1, http.StatusBadRequest)
+	if err := b.Add(benchmarkItem); err == nil {
+		t.Fatal("rejected item returned no error")
+	}
+	if stats := b.Stats(); stats.Sent != 1 || stats.Failed != 1 || dlq.Len() != 1 {
+		t.Errorf("sent %d, failed %d, %d dead letters; want 1, 1 and 1", stats.Sent, stats.Failed, dlq.Len())
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify сообщает systemd состояние службы (READY=1, STOPPING=1, STATUS=...), если программа
+// запущена с Type=notify; без NOTIFY_SOCKET ничего не делает
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:] // абстрактный сокет Linux
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("connecting to systemd: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("notifying systemd: %w", err)
+	}
+	return nil
+}
+
+// sdWatchdogInterval возвращает период проверки watchdog, заданный systemd в WATCHDOG_USEC,
+// или 0, если watchdog не включен для этого процесса
+func sdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// runWatchdog отправляет WATCHDOG=1 с половиной периода watchdog, пока не отменен ctx
+func runWatchdog(ctx context.Context) {
+	interval := sdWatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				fmt.Println("Error pinging systemd watchdog:", err)
+			}
+		}
+	}
+}
+
+// writePIDFile записывает идентификатор процесса в файл; возвращаемая функция удаляет файл
+func writePIDFile(path string) (func(), error) {
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return nil, fmt.Errorf("writing pid file: %w", err)
+	}
+	return func() {
+		if err := os.Remove(path); err != nil {
+			fmt.Println("Error removing pid file:", err)
+		}
+	}, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// tenantNamePattern ограничивает имена клиентов символами, допустимыми в пути URL и имени файла
+var tenantNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ForTenant возвращает настройки клиента name: общие настройки с наложенными поверх них
+// настройками из tenants. Файлы очереди недоставленных, spool и журналов, которые клиент
+// не задал сам, получают отдельные имена с именем клиента, чтобы данные клиентов не смешивались
+func (c *Config) ForTenant(name string) (*Config, error) {
+	node, ok := c.Tenants[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tenant %q, available tenants: %s", name, strings.Join(c.TenantNames(), ", "))
+	}
+	data, err := yaml.Marshal(&node)
+	if err != nil {
+		return nil, fmt.Errorf("tenant %s: %w", name, err)
+	}
+	var overlay Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&overlay); err != nil {
+		return nil, fmt.Errorf("tenant %s: %w", name, err)
+	}
+	switch {
+	case len(overlay.Tenants) > 0 || len(overlay.Profiles) > 0:
+		return nil, fmt.Errorf("tenant %s: tenants and profiles cannot be nested", name)
+	case !reflect.DeepEqual(overlay.Admin, AdminConfig{}) || overlay.Daemon != (DaemonConfig{}):
+		return nil, fmt.Errorf("tenant %s: admin and daemon are shared by all tenants", name)
+	}
+
+	t := *c
+	t.Tenants = nil
+	// Карты при разборе дополняются, а не заменяются, поэтому клиент получает свои копии
+	t.Template = maps.Clone(c.Template)
+	t.HTTP.DNS.Hosts = maps.Clone(c.HTTP.DNS.Hosts)
+	dec = yaml.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&t); err != nil {
+		return nil, fmt.Errorf("tenant %s: %w", name, err)
+	}
+	t.tenant = name
+	isolate := func(path *string, shared string) {
+		if *path != "" && *path == shared {
+			ext := filepath.Ext(shared)
+			*path = strings.TrimSuffix(shared, ext) + "." + name + ext
+		}
+	}
+	isolate(&t.DLQ.Path, c.DLQ.Path)
+	isolate(&t.Logging.AuditLog, c.Logging.AuditLog)
+	isolate(&t.Logging.EventLog.Path, c.Logging.EventLog.Path)
+	isolate(&t.Logging.DebugDump.Path, c.Logging.DebugDump.Path)
+	if t.Queue.SpoolDir != "" && t.Queue.SpoolDir == c.Queue.SpoolDir {
+		t.Queue.SpoolDir = filepath.Join(c.Queue.SpoolDir, name)
+	}
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("tenant %s: %w", name, err)
+	}
+	return &t, nil
+}
+
+// Tenant возвращает имя клиента, для которого собраны настройки, или пустую строку
+func (c *Config) Tenant() string {
+	return c.tenant
+}
+
+// TenantNames возвращает имена клиентов в алфавитном порядке
+func (c *Config) TenantNames() []string {
+	names := make([]string, 0, len(c.Tenants))
+	for name := range c.Tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateTenants проверяет настройки каждого клиента и то, что клиенты не делят файлы
+func (c *Config) validateTenants() error {
+	if len(c.Tenants) == 0 {
+		return nil
+	}
+	if len(c.Items) > 0 || len(c.Sources) > 0 || len(c.Schedule) > 0 {
+		return fmt.Errorf("items, sources and schedule must be set per tenant when tenants are defined")
+	}
+	owners := make(map[string]string)
+	claim := func(name, kind, path string) error {
+		if path == "" {
+			return nil
+		}
+		key := kind + "\x00" + filepath.Clean(path)
+		if other, ok := owners[key]; ok {
+			return fmt.Errorf("tenants %s and %s share %s %s", other, name, kind, path)
+		}
+		owners[key] = name
+		return nil
+	}
+	for _, name := range c.TenantNames() {
+		if !tenantNamePattern.MatchString(name) {
+			return fmt.Errorf("tenant name %q may contain only letters, digits, - and _", name)
+		}
+		t, err := c.ForTenant(name)
+		if err != nil {
+			return err
+		}
+		if err := claim(name, "dlq.path", t.DLQ.Path); err != nil {
+			return err
+		}
+		if err := claim(name, "queue.spool_dir", t.Queue.SpoolDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// daemonConfigs возвращает настройки буферов, которые обслуживает демон: по одному на клиента
+// или единственный буфер, если клиенты не заданы
+func (c *Config) daemonConfigs() ([]*Config, error) {
+	if len(c.Tenants) == 0 {
+		return []*Config{c}, nil
+	}
+	configs := make([]*Config, 0, len(c.Tenants))
+	for _, name := range c.TenantNames() {
+		t, err := c.ForTenant(name)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, t)
+	}
+	return configs, nil
+}
+
+// tenantPrefix возвращает префикс пути административного API клиента
+func tenantPrefix(name string) string {
+	return "/tenants/" + url.PathEscape(name)
+}
+
+// TenantBuffers направляет элементы в буферы клиентов по имени клиента.
+// У каждого клиента свои токен, адрес API, ограничение частоты, очередь и очередь недоставленных
+type TenantBuffers struct {
+	buffers map[string]*Buffer
+	names   []string
+}
+
+// NewTenantBuffers объединяет буферы клиентов, заданные по именам
+func NewTenantBuffers(buffers map[string]*Buffer) *TenantBuffers {
+	t := &TenantBuffers{buffers: buffers, names: make([]string, 0, len(buffers))}
+	for name := range buffers {
+		t.names = append(t.names, name)
+	}
+	sort.Strings(t.names)
+	return t
+}
+
+// Add добавляет элемент в очередь клиента tenant; в синхронном режиме возвращает результат доставки
+func (t *TenantBuffers) Add(tenant string, item map[string]string, opts ...ItemOption) error {
+	b, ok := t.buffers[tenant]
+	if !ok {
+		return fmt.Errorf("unknown tenant %q", tenant)
+	}
+	return b.Add(item, opts...)
+}
+
+// Buffer возвращает буфер клиента
+func (t *TenantBuffers) Buffer(tenant string) (*Buffer, bool) {
+	b, ok := t.buffers[tenant]
+	return b, ok
+}
+
+// Names возвращает имена клиентов в алфавитном порядке
+func (t *TenantBuffers) Names() []string {
+	return t.names
+}
+
+// Stats возвращает статистику буферов всех клиентов
+func (t *TenantBuffers) Stats() map[string]Stats {
+	stats := make(map[string]Stats, len(t.buffers))
+	for name, b := range t.buffers {
+		stats[name] = b.Stats()
+	}
+	return stats
+}
+
+// NewTenantAdminHandler создает административный API нескольких клиентов. API буфера каждого
+// клиента из NewAdminHandler доступен с префиксом /tenants/{name}, например GET /tenants/acme/stats.
+//
+//	GET  /tenants        статистика буферов всех клиентов
+func NewTenantAdminHandler(tenants *TenantBuffers, token string) http.Handler {
+	root := http.NewServeMux()
+	for _, name := range tenants.Names() {
+		b, _ := tenants.Buffer(name)
+		prefix := tenantPrefix(name)
+		root.Handle(prefix+"/", http.StripPrefix(prefix, NewAdminHandler(b, token)))
+	}
+	root.Handle("GET /tenants", requireToken(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, tenants.Stats())
+	})))
+	return root
+}
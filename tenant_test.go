@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"buffer/kpidrivetest"
+	"buffer/mockkpi"
+)
+
+// TestTenantBuffersIsolation проверяет, что элементы клиента уходят с его токеном на его адрес,
+// а отказ одного клиента попадает только в его очередь недоставленных
+func TestTenantBuffersIsolation(t *testing.T) {
+	SetLogLevel(LevelError)
+	servers := map[string]*kpidrivetest.Server{
+		"acme": kpidrivetest.NewServer(mockkpi.Options{Token: "acme-token"}),
+		"beta": kpidrivetest.NewServer(mockkpi.Options{Token: "beta-token"}),
+	}
+	dlqs := make(map[string]*DeadLetterQueue)
+	buffers := make(map[string]*Buffer)
+	for name, srv := range servers {
+		defer srv.Close()
+		dlqs[name] = NewDeadLetterQueue()
+		buffers[name] = NewBuffer(srv.SaveFactURL(), name+"-token", WithDeadLetterQueue(dlqs[name]))
+		defer buffers[name].Close()
+	}
+	tenants := NewTenantBuffers(buffers)
+	servers["beta"].FailNext(1, http.StatusBadRequest)
+
+	for _, name := range []string{"acme", "beta", "acme"} {
+		if err := tenants.Add(name, benchmarkItem); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tenants.Add("gamma", benchmarkItem); err == nil || !strings.Contains(err.Error(), "unknown tenant") {
+		t.Errorf("unknown tenant accepted: %v", err)
+	}
+	for _, b := range buffers {
+		b.Flush()
+	}
+
+	if n := len(servers["acme"].Facts()); n != 1 || servers["acme"].Stats().Saved != 2 {
+		t.Errorf("acme saved %d facts in %d saves, want 2 saves of one fact", n, servers["acme"].Stats().Saved)
+	}
+	for _, r := range servers["acme"].Requests() {
+		if r.Header.Get("Authorization") != "Bearer acme-token" {
+			t.Errorf("acme request sent with %q", r.Header.Get("Authorization"))
+		}
+	}
+	if n := len(servers["beta"].Facts()); n != 0 {
+		t.Errorf("beta saved %d facts after a rejection, want 0", n)
+	}
+	if dlqs["acme"].Len() != 0 || dlqs["beta"].Len() != 1 {
+		t.Errorf("dead letters: acme %d, beta %d; want 0 and 1", dlqs["acme"].Len(), dlqs["beta"].Len())
+	}
+	if stats := tenants.Stats(); stats["acme"].Sent != 2 || stats["beta"].Sent != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestForTenantIsolatesFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "buffer.yaml")
+	config := `
+auth:
+  token: shared
+dlq:
+  path: /var/lib/buffer/dlq.json
+queue:
+  spool_dir: /var/lib/buffer/spool
+tenants:
+  acme:
+    auth:
+      token: acme-token
+  beta:
+    auth:
+      token: beta-token
+    dlq:
+      path: /var/lib/beta/dlq.json
+`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme, err := cfg.ForTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	beta, err := cfg.ForTenant("beta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acme.Auth.Token != "acme-token" || beta.Auth.Token != "beta-token" || cfg.Auth.Token != "shared" {
+		t.Errorf("tokens: acme %q, beta %q, shared %q", acme.Auth.Token, beta.Auth.Token, cfg.Auth.Token)
+	}
+	if acme.DLQ.Path != "/var/lib/buffer/dlq.acme.json" || beta.DLQ.Path != "/var/lib/beta/dlq.json" {
+		t.Errorf("dlq paths: acme %s, beta %s", acme.DLQ.Path, beta.DLQ.Path)
+	}
+	if acme.Queue.SpoolDir != filepath.Join("/var/lib/buffer/spool", "acme") || acme.Tenant() != "acme" {
+		t.Errorf("acme spool dir %s, tenant %q", acme.Queue.SpoolDir, acme.Tenant())
+	}
+	if _, err := cfg.ForTenant("gamma"); err == nil {
+		t.Error("unknown tenant resolved")
+	}
+
+	// Клиенты не могут делить очередь недоставленных
+	shared := strings.Replace(config, "/var/lib/beta/dlq.json", "/var/lib/buffer/dlq.acme.json", 1)
+	if err := os.WriteFile(path, []byte(shared), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "share dlq.path") {
+		t.Errorf("shared dead letter file: %v", err)
+	}
+}
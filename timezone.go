@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	_ "time/tzdata" // часовые пояса доступны и без базы zoneinfo в системе, например на Windows
+)
+
+// sourceTimeLayouts перечисляет записи отметок времени без смещения, которые разбирает TimeZones
+var sourceTimeLayouts = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+}
+
+// locations хранит загруженные часовые пояса, чтобы не читать базу поясов для каждого элемента
+var locations sync.Map
+
+// loadLocation возвращает часовой пояс по имени IANA; пустое имя - местный пояс
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return time.Local, nil
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// TimeZones задает часовые пояса дат фактов. Источники на серверах в разных поясах выгружают
+// fact_time и границы периодов отметками времени, например "2024-05-31T23:30:00Z" или
+// "2024-05-31 23:30", а API принимает даты: отметка переводится в пояс отчетности и заменяется
+// датой в нем. Даты без времени не меняются. Без настроек значения передаются как есть
+type TimeZones struct {
+	Timezone       string   `yaml:"timezone"`        // пояс отчетности IANA, например Europe/Moscow; по умолчанию местный
+	SourceTimezone string   `yaml:"source_timezone"` // пояс отметок времени без смещения; по умолчанию timezone
+	Fields         []string `yaml:"fields"`          // поля с отметками времени, по умолчанию fact_time, period_start и period_end
+	DerivePeriod   bool     `yaml:"derive_period"`   // вычислять period_start и period_end по fact_time и period_key
+	DateField      string   `yaml:"date_field"`      // поле с одной датой, по которой вычисляются поля периода; по умолчанию date
+}
+
+// enabled сообщает, задан ли перевод дат
+func (z TimeZones) enabled() bool {
+	return z.Timezone != "" || z.SourceTimezone != "" || z.DerivePeriod
+}
+
+// converts сообщает, может ли apply изменить элемент с полями fields: перевод задан или
+// в элементе есть поле даты. Иначе буфер не копирует поля элемента ради перевода
+func (z TimeZones) converts(fields itemFields) bool {
+	return z.enabled() || fields.Get(z.dateField()) != ""
+}
+
+// dateField возвращает имя поля с одной датой
+func (z TimeZones) dateField() string {
+	if z.DateField == "" {
+		return "date"
+	}
+	return z.DateField
+}
+
+// validate проверяет настройки часовых поясов
+func (z TimeZones) validate() error {
+	if _, err := loadLocation(z.Timezone); err != nil {
+		return fmt.Errorf("time.timezone: %w", err)
+	}
+	if _, err := loadLocation(z.SourceTimezone); err != nil {
+		return fmt.Errorf("time.source_timezone: %w", err)
+	}
+	return nil
+}
+
+// WithTimeZones переводит отметки времени в полях каждого элемента в даты пояса отчетности
+// и вычисляет поля периода по полю даты при добавлении, до WithValueFormat и WithValidation.
+// Значение, которое не удалось разобрать, остается как есть
+func WithTimeZones(z TimeZones) Option {
+	return func(b *Buffer) {
+		b.zones = &z
+	}
+}
+
+// Location возвращает пояс отчетности, в котором определяются день и период факта
+func (z TimeZones) Location() *time.Location {
+	loc, err := loadLocation(z.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// source возвращает пояс отметок времени без смещения
+func (z TimeZones) source() *time.Location {
+	if z.SourceTimezone == "" {
+		return z.Location()
+	}
+	loc, err := loadLocation(z.SourceTimezone)
+	if err != nil {
+		return z.Location()
+	}
+	return loc
+}
+
+// apply вычисляет поля периода по полю даты и переводит поля элемента на месте; сообщает,
+// изменилось ли хотя бы одно. now задает текущее время для fact_time текущего периода
+func (z TimeZones) apply(item map[string]string, now time.Time) bool {
+	changed := z.expandDate(item, now)
+	if !z.enabled() {
+		return changed
+	}
+	fields := z.Fields
+	if len(fields) == 0 {
+		fields = []string{"fact_time", "period_start", "period_end"}
+	}
+	for _, field := range fields {
+		value, ok := item[field]
+		if !ok || value == "" {
+			continue
+		}
+		date, err := z.Date(value)
+		if err != nil {
+			logDebugf("Leaving %s %q as is: %v", field, value, err)
+			continue
+		}
+		if date != value {
+			item[field] = date
+			changed = true
+		}
+	}
+	if z.DerivePeriod {
+		changed = z.derivePeriod(item) || changed
+	}
+	return changed
+}
+
+// Date переводит отметку времени в дату пояса отчетности, например "2024-05-31T22:30:00Z"
+// с timezone Europe/Moscow в "2024-06-01". Отметка без смещения считается временем
+// source_timezone, а дата без времени возвращается как есть
+func (z TimeZones) Date(value string) (string, error) {
+	if _, err := time.Parse(time.DateOnly, value); err == nil {
+		return value, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		t, err = parseSourceTime(value, z.source())
+	}
+	if err != nil {
+		return "", err
+	}
+	return t.In(z.Location()).Format(time.DateOnly), nil
+}
+
+// parseSourceTime разбирает отметку времени без смещения в поясе loc
+func parseSourceTime(value string, loc *time.Location) (time.Time, error) {
+	for _, layout := range sourceTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date or timestamp", value)
+}
+
+// expandDate заменяет поле даты полями period_start и period_end периода period_key, в который
+// попадает дата, и задает fact_time, если его нет: последний день прошедшего периода, сегодня
+// для текущего и первый день будущего. Поле даты не передается в API
+func (z TimeZones) expandDate(item map[string]string, now time.Time) bool {
+	field := z.dateField()
+	value := item[field]
+	if value == "" {
+		return false
+	}
+	date, err := z.Date(value)
+	if err != nil {
+		logDebugf("Leaving %s %q as is: %v", field, value, err)
+		return false
+	}
+	day, _ := time.Parse(time.DateOnly, date)
+	start, end, ok := periodBounds(day, item["period_key"])
+	if !ok {
+		logDebugf("Leaving %s %q as is: unknown period_key %q", field, value, item["period_key"])
+		return false
+	}
+	delete(item, field)
+	item["period_start"], item["period_end"] = start.Format(time.DateOnly), end.Format(time.DateOnly)
+	if item["fact_time"] == "" {
+		today := now.In(z.Location()).Format(time.DateOnly)
+		switch {
+		case today > item["period_end"]:
+			item["fact_time"] = item["period_end"]
+		case today < item["period_start"]:
+			item["fact_time"] = item["period_start"]
+		default:
+			item["fact_time"] = today
+		}
+	}
+	return true
+}
+
+// derivePeriod заменяет period_start и period_end границами периода period_key, в который
+// попадает fact_time. Неделя начинается с понедельника
+func (z TimeZones) derivePeriod(item map[string]string) bool {
+	day, err := time.Parse(time.DateOnly, item["fact_time"])
+	if err != nil {
+		return false
+	}
+	start, end, ok := periodBounds(day, item["period_key"])
+	if !ok {
+		return false
+	}
+	startDate, endDate := start.Format(time.DateOnly), end.Format(time.DateOnly)
+	if item["period_start"] == startDate && item["period_end"] == endDate {
+		return false
+	}
+	item["period_start"], item["period_end"] = startDate, endDate
+	return true
+}
+
+// periodBounds возвращает первый и последний день периода key, содержащего день day
+func periodBounds(day time.Time, key string) (time.Time, time.Time, bool) {
+	var start time.Time
+	switch key {
+	case "day":
+		return day, day, true
+	case "week":
+		start = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		return start, start.AddDate(0, 0, 6), true
+	case "month":
+		start = time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, -1), true
+	case "quarter":
+		start = time.Date(day.Year(), (day.Month()-1)/3*3+1, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 3, -1), true
+	case "year":
+		start = time.Date(day.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(1, 0, -1), true
+	}
+	return time.Time{}, time.Time{}, false
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+// topRecentFailures задает количество последних недоставленных элементов на экране
+const topRecentFailures = 8
+
+// runTop выполняет команду top: периодически опрашивает административный API и перерисовывает сводку
+func runTop(cfg *Config, res *Result, args []string) error {
+	fs := flag.NewFlagSet("top", flag.ContinueOnError)
+	addr := fs.String("addr", cfg.Admin.Addr, "admin API address")
+	token := fs.String("token", cfg.Admin.Token, "admin API token")
+	interval := fs.Duration("interval", 2*time.Second, "refresh interval")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client, err := cfg.adminClient(*addr, *token)
+	if err != nil {
+		return err
+	}
+	var prev *Stats
+	var prevAt time.Time
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		stats, err := client.stats()
+		now := time.Now()
+		var failures []DeadLetter
+		if err == nil && stats.DeadLetters > 0 {
+			var page ItemPage[DeadLetter]
+			page, err = client.deadLetters(DeadLetterFilter{}, max(0, stats.DeadLetters-topRecentFailures), topRecentFailures)
+			failures = page.Items
+		}
+
+		var out strings.Builder
+		out.WriteString("\033[H\033[2J")
+		fmt.Fprintf(&out, "buffer top - %s - %s (refresh %s, Ctrl+C to quit)\n\n", client.addr, now.Format("2006-01-02 15:04:05"), *interval)
+		if err != nil {
+			fmt.Fprintf(&out, "Error: %v\n", err)
+		} else {
+			renderTop(&out, stats, prev, now.Sub(prevAt), failures)
+			prev, prevAt = &stats, now
+		}
+		os.Stdout.WriteString(out.String())
+
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// renderTop выводит текущее состояние буфера; скорость и доля ошибок считаются относительно предыдущего опроса
+func renderTop(w io.Writer, stats Stats, prev *Stats, elapsed time.Duration, failures []DeadLetter) {
+	var throughput, errorRate float64
+	if prev != nil && elapsed > 0 {
+		throughput = float64(stats.Sent-prev.Sent) / elapsed.Seconds()
+		if attempts := stats.Attempts - prev.Attempts; attempts > 0 {
+			errorRate = float64(stats.Failed-prev.Failed) / float64(attempts)
+		}
+	}
+	paused := "no"
+	if stats.Paused {
+		paused = "yes"
+	}
+	if stats.Outside {
+		paused = "window"
+	}
+	if stats.Offline {
+		paused = "offline"
+	}
+
+	fmt.Fprintf(w, "Queue       %-10d In flight  %-10d Paused  %s\n", stats.Queued, stats.InFlight, paused)
+	fmt.Fprintf(w, "Sent        %-10d Failed     %-10d DLQ     %d\n", stats.Sent, stats.Failed, stats.DeadLetters)
+	fmt.Fprintf(w, "Throughput  %-10s Error rate %-10s Circuit %s\n", fmt.Sprintf("%.1f/s", throughput), fmt.Sprintf("%.1f%%", errorRate*100), stats.Circuit)
+	if len(stats.Quota) > 0 {
+		fmt.Fprintf(w, "Quota      ")
+		for _, q := range stats.Quota {
+			fmt.Fprintf(w, " %s %d/%d until %s ", q.Period, q.Used, q.Limit, q.Resets.Format("15:04"))
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "Latency     avg %s  p50 %s  p95 %s  p99 %s  max %s\n\n",
+		stats.LatencyAvg.Round(time.Millisecond), stats.LatencyP50.Round(time.Millisecond),
+		stats.LatencyP95.Round(time.Millisecond), stats.LatencyP99.Round(time.Millisecond),
+		stats.LatencyMax.Round(time.Millisecond))
+
+	fmt.Fprintf(w, "Recent failures:\n")
+	if len(failures) == 0 {
+		fmt.Fprintf(w, "  none\n")
+	}
+	for i := len(failures) - 1; i >= 0; i-- {
+		letter := failures[i]
+		fmt.Fprintf(w, "  %s  #%-6d indicator %-8s %s\n",
+			letter.FailedAt.Local().Format("15:04:05"), letter.ID, letter.Item["indicator_to_mo_id"], truncate(letter.Error, 80))
+	}
+}
+
+// truncate обрезает строку до n символов
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaultMaxIdleConnsPerHost задает количество сохраняемых соединений с API по умолчанию
+const defaultMaxIdleConnsPerHost = 16
+
+// defaultRequestTimeout ограничивает время запроса к API по умолчанию
+const defaultRequestTimeout = 30 * time.Second
+
+// TransportOptions задает параметры соединений с API; нулевые значения оставляют значения по умолчанию
+type TransportOptions struct {
+	MaxIdleConnsPerHost int           // количество простаивающих соединений, сохраняемых для повторного использования
+	IdleConnTimeout     time.Duration // время, после которого простаивающее соединение закрывается
+	TLSHandshakeTimeout time.Duration
+	DialTimeout         time.Duration // время на установку TCP-соединения
+	DisableHTTP2        bool
+	DNS                 DNSConfig
+	ClientCerts         []ClientCert   // клиентские сертификаты для взаимной проверки TLS
+	RootCAs             *x509.CertPool // корневые сертификаты вместо системных
+	MinTLSVersion       uint16
+	CipherSuites        []uint16 // разрешенные наборы шифров TLS 1.0-1.2, пусто - по умолчанию
+	InsecureSkipVerify  bool     // не проверять сертификат сервера, только для тестовых стендов
+	Proxy               *url.URL // прокси вместо HTTPS_PROXY и HTTP_PROXY
+}
+
+// newTransport создает транспорт на основе http.DefaultTransport с заданными параметрами и политикой
+// SetTLSPolicy. Срок кеша DNS отсчитывается по clock
+func newTransport(opts TransportOptions, clock Clock) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	if opts.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+		transport.MaxIdleConns = max(transport.MaxIdleConns, opts.MaxIdleConnsPerHost)
+	}
+	if opts.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = opts.IdleConnTimeout
+	}
+	if opts.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = opts.TLSHandshakeTimeout
+	}
+	if opts.DialTimeout > 0 || opts.DNS.enabled() {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		if opts.DialTimeout > 0 {
+			dialer.Timeout = opts.DialTimeout
+		}
+		transport.DialContext = dialer.DialContext
+		if opts.DNS.enabled() {
+			transport.DialContext = newDNSResolver(opts.DNS, dialer, clock).DialContext
+		}
+	}
+	if opts.RootCAs != nil || opts.MinTLSVersion != 0 || len(opts.CipherSuites) > 0 || opts.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs:            opts.RootCAs,
+			MinVersion:         opts.MinTLSVersion,
+			CipherSuites:       opts.CipherSuites,
+			InsecureSkipVerify: opts.InsecureSkipVerify,
+		}
+	}
+	tlsConfig, err := applyTLSPolicy(transport.TLSClientConfig)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+	if opts.Proxy != nil {
+		transport.Proxy = http.ProxyURL(opts.Proxy)
+	}
+	if opts.DisableHTTP2 {
+		// Непустая TLSNextProto без h2 отключает HTTP/2 для соединений TLS
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return transport, nil
+}
+
+// newHTTPClient создает клиента по умолчанию, сохраняющего соединения с API между запросами.
+// Без своих наборов шифров политика TLS применяется без ошибок
+func newHTTPClient() *http.Client {
+	transport, _ := newTransport(TransportOptions{}, systemClock)
+	return &http.Client{Transport: transport}
+}
+
+// WithTransport задает параметры соединений с API, сохраняя остальные настройки HTTP-клиента.
+// Если параметры несовместимы с политикой TLS, остается прежний транспорт
+func WithTransport(opts TransportOptions) Option {
+	return func(b *Buffer) {
+		transport, err := newTransport(opts, bufferClock{b})
+		if err != nil {
+			fmt.Println("Error configuring transport, keeping the previous one:", err)
+			return
+		}
+		client := *b.client
+		client.Transport = withClientCerts(transport, opts.ClientCerts)
+		b.client = &client
+	}
+}
+
+// WithRequestTimeout ограничивает время запроса к API вместе с чтением ответа, по умолчанию 30s.
+// Превысивший его запрос завершается ошибкой и повторяется как при сбое сети; 0 - значение по умолчанию
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(b *Buffer) {
+		if timeout > 0 {
+			b.timeout = timeout
+		}
+	}
+}
+
+// policyTransport передает запросы вне API - к хранилищам секретов, вебхукам оповещений и Sentry -
+// транспорту newTransport, созданному при первом запросе, чтобы к ним применялась политика
+// SetTLSPolicy, заданная после создания клиентов
+type policyTransport struct {
+	once      sync.Once
+	transport *http.Transport
+}
+
+// sharedTransport используется клиентами запросов вне API
+var sharedTransport http.RoundTripper = &policyTransport{}
+
+func (t *policyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.once.Do(func() { t.transport, _ = newTransport(TransportOptions{}, systemClock) })
+	return t.transport.RoundTrip(req)
+}
+
+// tlsVersions сопоставляет значения http.tls_min_version версиям TLS
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion возвращает версию TLS по строке вида 1.2; пустая строка - значение по умолчанию
+func parseTLSVersion(s string) (uint16, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q, expected 1.0, 1.1, 1.2 or 1.3", s)
+	}
+	return v, nil
+}
+
+// tlsPolicy задает ограничения TLS исходящих соединений буфера
+var tlsPolicy struct {
+	minVersion   uint16
+	cipherSuites []uint16
+}
+
+// SetTLSPolicy ограничивает минимальную версию TLS и наборы шифров исходящих соединений буфера:
+// к API, серверам секретов, оповещений и отчетов об ошибках. http.DefaultTransport и транспорты
+// других частей программы не меняются. Вызывается до создания буферов и первых запросов
+func SetTLSPolicy(minVersion uint16, cipherSuites []uint16) {
+	tlsPolicy.minVersion, tlsPolicy.cipherSuites = minVersion, cipherSuites
+}
+
+// applyTLSPolicy возвращает копию настроек TLS с ограничениями SetTLSPolicy. Минимальная версия
+// только повышается, а наборы шифров, заданные в config, сужаются до разрешенных политикой.
+// Если ни один из них не разрешен, возвращается ошибка: пустой список означал бы все наборы по умолчанию
+func applyTLSPolicy(config *tls.Config) (*tls.Config, error) {
+	if tlsPolicy.minVersion == 0 && len(tlsPolicy.cipherSuites) == 0 {
+		return config, nil
+	}
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	config.MinVersion = max(config.MinVersion, tlsPolicy.minVersion)
+	if len(tlsPolicy.cipherSuites) > 0 {
+		if len(config.CipherSuites) == 0 {
+			config.CipherSuites = tlsPolicy.cipherSuites
+		} else {
+			config.CipherSuites = slices.DeleteFunc(slices.Clone(config.CipherSuites), func(id uint16) bool {
+				return !slices.Contains(tlsPolicy.cipherSuites, id)
+			})
+			if len(config.CipherSuites) == 0 {
+				return nil, fmt.Errorf("none of the cipher suites is allowed by the TLS policy")
+			}
+		}
+	}
+	return config, nil
+}
+
+// parseCipherSuites возвращает идентификаторы наборов шифров по именам вида
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 и имена небезопасных наборов среди них.
+// Наборы TLS 1.3 в Go не настраиваются, поэтому не принимаются
+func parseCipherSuites(names []string) (ids []uint16, insecureNames []string, err error) {
+	if len(names) == 0 {
+		return nil, nil, nil
+	}
+	known := make(map[string]*tls.CipherSuite)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite
+	}
+	insecure := make(map[string]*tls.CipherSuite)
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = suite
+	}
+	ids = make([]uint16, 0, len(names))
+	for _, name := range names {
+		suite, ok := known[name]
+		if !ok {
+			if suite, ok = insecure[name]; !ok {
+				return nil, nil, fmt.Errorf("unknown cipher suite %q", name)
+			}
+			insecureNames = append(insecureNames, name)
+		}
+		if slices.Equal(suite.SupportedVersions, []uint16{tls.VersionTLS13}) {
+			return nil, nil, fmt.Errorf("cipher suite %s is TLS 1.3 only, TLS 1.3 suites are not configurable", name)
+		}
+		ids = append(ids, suite.ID)
+	}
+	return ids, insecureNames, nil
+}
+
+// loadCAFile читает корневые сертификаты PEM из файла
+func loadCAFile(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA file %s contains no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+// parseProxy разбирает адрес прокси http, https или socks5. Пароль из переменной passwordEnv,
+// если она задана, заменяет пароль в адресе, чтобы не хранить его в файле настроек
+func parseProxy(raw, passwordEnv string) (*url.URL, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, expected http, https or socks5", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy address %q has no host", raw)
+	}
+	if passwordEnv != "" {
+		password, ok := os.LookupEnv(passwordEnv)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", passwordEnv)
+		}
+		if u.User == nil {
+			return nil, fmt.Errorf("proxy_password_env requires a user name in the proxy address")
+		}
+		u.User = url.UserPassword(u.User.Username(), password)
+	}
+	return u, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requiredFactFields перечисляет поля, без которых API не примет факт
+var requiredFactFields = []string{"period_start", "period_end", "period_key", "indicator_to_mo_id", "value", "fact_time"}
+
+// decimalPattern задает запись value, которую принимает API: десятичное число с точкой без экспоненты.
+// strconv.ParseFloat пропускает и NaN, Inf, шестнадцатеричные записи и разделители _
+var decimalPattern = regexp.MustCompile(`^-?\d+(\.\d+)?$`)
+
+// periodKeys перечисляет допустимые значения period_key
+var periodKeys = map[string]bool{"day": true, "week": true, "month": true, "quarter": true, "year": true}
+
+// Коды ошибок проверки полей факта
+const (
+	ValidationRequired  = "required"   // обязательное поле не задано
+	ValidationDate      = "date"       // дата не в формате YYYY-MM-DD
+	ValidationPeriod    = "period"     // конец периода раньше начала
+	ValidationPeriodKey = "period_key" // неизвестный вид периода
+	ValidationNumber    = "number"     // значение не число
+	ValidationInteger   = "integer"    // идентификатор не целое неотрицательное число
+	ValidationChoice    = "choice"     // значение не из допустимых
+)
+
+// FieldError описывает ошибку проверки одного поля факта
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Value   string `json:"value,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError перечисляет все ошибки проверки факта. Возвращается ValidateFact и Add,
+// если элемент отвергнут проверкой и не передан отправке
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		problems[i] = f.Error()
+	}
+	return strings.Join(problems, "; ")
+}
+
+// add добавляет ошибку поля
+func (e *ValidationError) add(field, code, value, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Code: code, Value: value, Message: fmt.Sprintf(format, args...)})
+}
+
+// ValidateFact проверяет поля факта перед отправкой и возвращает все найденные ошибки
+// как *ValidationError
+func ValidateFact(item map[string]string) error {
+	var errs ValidationError
+	for _, field := range requiredFactFields {
+		if strings.TrimSpace(item[field]) == "" {
+			errs.add(field, ValidationRequired, "", "is required")
+		}
+	}
+
+	dates := make(map[string]time.Time)
+	// date остается в элементе, только если по нему не удалось вычислить поля периода
+	for _, field := range []string{"period_start", "period_end", "fact_time", "date"} {
+		if value := item[field]; value != "" {
+			t, err := time.Parse(time.DateOnly, value)
+			if err != nil {
+				errs.add(field, ValidationDate, value, "invalid date %q, expected YYYY-MM-DD", value)
+				continue
+			}
+			dates[field] = t
+		}
+	}
+	start, okStart := dates["period_start"]
+	end, okEnd := dates["period_end"]
+	if okStart && okEnd && end.Before(start) {
+		errs.add("period_end", ValidationPeriod, item["period_end"], "%s is before period_start %s", item["period_end"], item["period_start"])
+	}
+
+	if key := item["period_key"]; key != "" && !periodKeys[key] {
+		errs.add("period_key", ValidationPeriodKey, key, "unknown period %q", key)
+	}
+	if value := item["value"]; value != "" {
+		if !decimalPattern.MatchString(value) {
+			errs.add("value", ValidationNumber, value, "%q is not a number", value)
+		}
+	}
+	for _, field := range []string{"indicator_to_mo_id", "indicator_to_mo_fact_id", "auth_user_id"} {
+		if value := item[field]; value != "" {
+			if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+				errs.add(field, ValidationInteger, value, "%q is not a non-negative integer", value)
+			}
+		}
+	}
+	if value := item["is_plan"]; value != "" && value != "0" && value != "1" {
+		errs.add("is_plan", ValidationChoice, value, "expected 0 or 1, got %q", value)
+	}
+	if len(errs.Fields) == 0 {
+		return nil
+	}
+	return &errs
+}
+
+// WithValidation проверяет каждый элемент функцией validate при добавлении: элемент с ошибкой
+// не попадает в очередь, Add возвращает ошибку проверки, а обработчик результата получает ее
+// сразу. Для фактов KPI-Drive подходит ValidateFact
+func WithValidation(validate func(item map[string]string) error) Option {
+	return func(b *Buffer) {
+		b.validate = validate
+	}
+}
+
+// PrepareItem дополняет строку входного файла полями шаблона и, если не задан skip_validation,
+// проверяет результат
+func (c *Config) PrepareItem(fields map[string]string) (map[string]string, error) {
+	if c.SkipValidation {
+		return c.Item(fields), nil
+	}
+	return c.checkItem(fields)
+}
+
+// checkItem дополняет строку полями шаблона и проверяет результат независимо от skip_validation,
+// как команда validate
+func (c *Config) checkItem(fields map[string]string) (map[string]string, error) {
+	item := c.Item(fields)
+	return item, ValidateFact(item)
+}
+
+// ValidationSummary подводит итоги проверки файла
+type ValidationSummary struct {
+	File    string     `json:"file"`
+	Rows    int        `json:"rows"`
+	Valid   int        `json:"valid"`
+	Invalid int        `json:"invalid"`
+	Errors  []RowError `json:"errors,omitempty"`
+}
+
+// ValidateFile разбирает и проверяет все строки файла так же, как при импорте, ничего не отправляя
+func ValidateFile(path string, prepare func(map[string]string) (map[string]string, error)) (ValidationSummary, error) {
+	summary := ValidationSummary{File: path}
+	f, err := os.Open(path)
+	if err != nil {
+		return summary, err
+	}
+	defer f.Close()
+	rows, err := newRowReader(path, f, f)
+	if err != nil {
+		return summary, err
+	}
+	if c, ok := rows.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	for row := 1; ; row++ {
+		item, err := rows.Next()
+		if errors.Is(err, io.EOF) {
+			return summary, nil
+		}
+		var rowErr *rowParseError
+		if err != nil && !errors.As(err, &rowErr) {
+			return summary, err
+		}
+		summary.Rows++
+		if err == nil && prepare != nil {
+			_, err = prepare(item)
+		}
+		if err != nil {
+			summary.Invalid++
+			summary.Errors = append(summary.Errors, RowError{Row: row, Error: err.Error()})
+			continue
+		}
+		summary.Valid++
+	}
+}
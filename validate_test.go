@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateFactValue(t *testing.T) {
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{"1", true},
+		{"0", true},
+		{"-12.5", true},
+		{"1234.5600", true},
+		{"NaN", false},
+		{"Inf", false},
+		{"+Inf", false},
+		{"-Infinity", false},
+		{"0x1p-2", false},
+		{"1_000", false},
+		{"1e3", false},
+		{"+1", false},
+		{".5", false},
+		{"5.", false},
+		{"1,5", false},
+		{" 1", false},
+		{"١٢", false}, // цифры других алфавитов
+	}
+	for _, tt := range tests {
+		fact := map[string]string{
+			"period_start": "2024-05-01", "period_end": "2024-05-31", "period_key": "month",
+			"indicator_to_mo_id": "227373", "value": tt.value, "fact_time": "2024-05-31",
+		}
+		err := ValidateFact(fact)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("value %q: %v", tt.value, err)
+			}
+			continue
+		}
+		var verr *ValidationError
+		if !errors.As(err, &verr) || len(verr.Fields) != 1 || verr.Fields[0].Field != "value" || verr.Fields[0].Code != ValidationNumber {
+			t.Errorf("value %q: got %v, want a number error for value", tt.value, err)
+		}
+	}
+}
+
+func TestNormalizedValuesValidate(t *testing.T) {
+	// Значения, приведенные ValueFormat, должны проходить проверку
+	precision := 2
+	for _, f := range []ValueFormat{{DecimalSeparator: "auto"}, {DecimalSeparator: ",", Precision: &precision, Percent: PercentFraction}} {
+		for _, value := range []string{"1 234,56", "-0,5", "15%", "1e3", "1.5E-3", "0.000"} {
+			normalized, err := f.Normalize(value)
+			if err != nil {
+				t.Fatalf("Normalize(%q): %v", value, err)
+			}
+			if !decimalPattern.MatchString(normalized) {
+				t.Errorf("Normalize(%q) = %q, rejected by validation", value, normalized)
+			}
+		}
+	}
+}
+
+func TestPrepareItemSkipValidation(t *testing.T) {
+	fields := map[string]string{"indicator_to_mo_id": "227373", "value": "abc"}
+	cfg := &Config{}
+	if _, err := cfg.PrepareItem(fields); err == nil {
+		t.Fatal("invalid row prepared without an error")
+	}
+	cfg.SkipValidation = true
+	item, err := cfg.PrepareItem(fields)
+	if err != nil || item["value"] != "abc" {
+		t.Fatalf("with skip_validation got %v, %v", item, err)
+	}
+	// Команда validate проверяет строки и с skip_validation
+	if _, err := cfg.checkItem(fields); err == nil {
+		t.Error("checkItem accepted an invalid row with skip_validation")
+	}
+}
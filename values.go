@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Режимы разбора значений в процентах
+const (
+	PercentKeep     = "keep"     // 15% передается как 15
+	PercentFraction = "fraction" // 15% передается как 0.15
+)
+
+// maxValuePrecision ограничивает количество знаков после запятой в приведенном значении
+const maxValuePrecision = 20
+
+// maxValueExponent ограничивает порядок в экспоненциальной записи: API хранит значения как double,
+// а запись 1e1000000 развернулась бы в мегабайт цифр
+const maxValueExponent = 308
+
+// ValueFormat задает приведение числовых полей из форматов систем-источников, например "1 234,56",
+// "15%" или "1 200 руб.", к виду, который принимает API. Без настроек значения не меняются
+type ValueFormat struct {
+	Fields             []string `yaml:"fields"`              // приводимые поля, по умолчанию value
+	DecimalSeparator   string   `yaml:"decimal_separator"`   // ".", "," или auto - последний из точки и запятой; по умолчанию "."
+	ThousandsSeparator string   `yaml:"thousands_separator"` // символы-разделители разрядов, например "'" или "."; пробелы удаляются всегда
+	Precision          *int     `yaml:"precision"`           // знаков после запятой при округлении, половина - от нуля; не задано - без округления
+	StripUnits         bool     `yaml:"strip_units"`         // отбрасывать единицы измерения и знак валюты вокруг числа
+	Percent            string   `yaml:"percent"`             // keep (по умолчанию) или fraction - делить значения со знаком % на 100
+}
+
+// enabled сообщает, задано ли приведение значений
+func (f ValueFormat) enabled() bool {
+	return f.DecimalSeparator != "" || f.ThousandsSeparator != "" || f.Precision != nil || f.StripUnits || f.Percent != ""
+}
+
+// validate проверяет настройки приведения
+func (f ValueFormat) validate() error {
+	switch f.DecimalSeparator {
+	case "", ".", ",", "auto":
+	default:
+		return fmt.Errorf("values.decimal_separator must be \".\", \",\" or auto, got %q", f.DecimalSeparator)
+	}
+	if f.DecimalSeparator != "auto" && f.DecimalSeparator != "" && strings.Contains(f.ThousandsSeparator, f.DecimalSeparator) {
+		return fmt.Errorf("values.thousands_separator must not contain the decimal separator %q", f.DecimalSeparator)
+	}
+	if f.DecimalSeparator == "" && strings.Contains(f.ThousandsSeparator, ".") {
+		return fmt.Errorf("values.thousands_separator contains \".\", set values.decimal_separator")
+	}
+	if f.Precision != nil && (*f.Precision < 0 || *f.Precision > maxValuePrecision) {
+		return fmt.Errorf("values.precision must be between 0 and %d", maxValuePrecision)
+	}
+	switch f.Percent {
+	case "", PercentKeep, PercentFraction:
+	default:
+		return fmt.Errorf("values.percent must be %s or %s, got %q", PercentKeep, PercentFraction, f.Percent)
+	}
+	return nil
+}
+
+// WithValueFormat приводит числовые поля каждого элемента по f при добавлении, до проверки
+// WithValidation. Значение, которое не удалось привести, остается как есть
+func WithValueFormat(f ValueFormat) Option {
+	return func(b *Buffer) {
+		if f.enabled() {
+			b.values = &f
+		}
+	}
+}
+
+// apply приводит поля элемента на месте и сообщает, изменилось ли хотя бы одно
+func (f ValueFormat) apply(item map[string]string) bool {
+	fields := f.Fields
+	if len(fields) == 0 {
+		fields = []string{"value"}
+	}
+	changed := false
+	for _, field := range fields {
+		value, ok := item[field]
+		if !ok || value == "" {
+			continue
+		}
+		normalized, err := f.Normalize(value)
+		if err != nil {
+			logDebugf("Leaving %s %q as is: %v", field, value, err)
+			continue
+		}
+		if normalized != value {
+			item[field] = normalized
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Normalize приводит одно значение к десятичной записи с точкой, например "1 234,56" к "1234.56"
+func (f ValueFormat) Normalize(value string) (string, error) {
+	s := strings.TrimSpace(value)
+	s = strings.ReplaceAll(s, "−", "-")
+	percent := strings.HasSuffix(s, "%") || strings.HasPrefix(s, "%")
+	s = strings.TrimSpace(strings.Trim(s, "%"))
+	if f.StripUnits {
+		percent = percent || strings.Contains(s, "%")
+		s = stripUnits(s)
+	}
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || strings.ContainsRune(f.ThousandsSeparator, r) {
+			return -1
+		}
+		return r
+	}, s)
+	if s == "" {
+		return "", fmt.Errorf("no digits in %q", value)
+	}
+
+	switch f.DecimalSeparator {
+	case ",":
+		// Запись без запятой с точкой уже приведена, например при повторном приведении
+		if strings.Contains(s, ",") {
+			if strings.Contains(s, ".") {
+				return "", fmt.Errorf("unexpected \".\" in %q with decimal comma", value)
+			}
+			s = strings.ReplaceAll(s, ",", ".")
+		}
+	case "auto":
+		s = autoDecimal(s)
+	}
+	if strings.Count(s, ".") > 1 || strings.Contains(s, ",") {
+		return "", fmt.Errorf("ambiguous separators in %q", value)
+	}
+
+	// big.Rat принимает и дроби вида 1/3 и шестнадцатеричные записи, а API - только десятичные
+	if strings.IndexFunc(s, func(r rune) bool { return !strings.ContainsRune("0123456789+-.eE", r) }) >= 0 {
+		return "", fmt.Errorf("%q is not a number", value)
+	}
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		exp, err := strconv.Atoi(s[i+1:])
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return "", fmt.Errorf("%q is not a number", value)
+		}
+		if err != nil || exp > maxValueExponent || exp < -maxValueExponent {
+			return "", fmt.Errorf("%q is out of range", value)
+		}
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return "", fmt.Errorf("%q is not a number", value)
+	}
+	if f, _ := r.Float64(); math.IsInf(f, 0) {
+		return "", fmt.Errorf("%q is out of range", value)
+	}
+	if percent && f.Percent == PercentFraction {
+		r.Quo(r, big.NewRat(100, 1))
+	}
+	if f.Precision != nil {
+		return trimDecimal(r.FloatString(*f.Precision)), nil
+	}
+	return trimDecimal(r.FloatString(decimalDigits(r))), nil
+}
+
+// stripUnits оставляет запись числа от первой до последней цифры вместе со знаком и разделителем
+// перед первой цифрой, например "₽ -1 200,50 руб." становится "-1 200,50"
+func stripUnits(s string) string {
+	first := strings.IndexFunc(s, unicode.IsDigit)
+	if first < 0 {
+		return ""
+	}
+	last := strings.LastIndexFunc(s, unicode.IsDigit)
+	if first > 0 && (s[first-1] == '.' || s[first-1] == ',') {
+		first--
+	}
+	if first > 0 && (s[first-1] == '-' || s[first-1] == '+') {
+		first--
+	}
+	return s[first : last+1]
+}
+
+// autoDecimal определяет десятичный разделитель по записи: из точки и запятой десятичным считается
+// последний, а если встречается только один из них, то одиночный - десятичный, повторяющийся -
+// разделитель разрядов. Возвращает запись с точкой
+func autoDecimal(s string) string {
+	dot, comma := strings.LastIndex(s, "."), strings.LastIndex(s, ",")
+	switch {
+	case dot >= 0 && comma >= 0:
+		if comma > dot {
+			return strings.ReplaceAll(strings.ReplaceAll(s, ".", ""), ",", ".")
+		}
+		return strings.ReplaceAll(s, ",", "")
+	case comma >= 0:
+		if strings.Count(s, ",") > 1 {
+			return strings.ReplaceAll(s, ",", "")
+		}
+		return strings.ReplaceAll(s, ",", ".")
+	case dot >= 0 && strings.Count(s, ".") > 1:
+		return strings.ReplaceAll(s, ".", "")
+	}
+	return s
+}
+
+// decimalDigits возвращает количество знаков после запятой, достаточное для точной записи r
+func decimalDigits(r *big.Rat) int {
+	denom := new(big.Int).Set(r.Denom())
+	ten := big.NewInt(10)
+	for n := 0; n < maxValuePrecision; n++ {
+		if new(big.Int).Mod(new(big.Int).Exp(ten, big.NewInt(int64(n)), nil), denom).Sign() == 0 {
+			return n
+		}
+	}
+	return maxValuePrecision
+}
+
+// trimDecimal убирает незначащие нули после запятой и отрицательный ноль
+func trimDecimal(s string) string {
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
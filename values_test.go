@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeExponent(t *testing.T) {
+	tests := []struct {
+		value, want, err string
+	}{
+		{"1e3", "1000", ""},
+		{"1.5E-3", "0.0015", ""},
+		{"-2e+2", "-200", ""},
+		{"1e-400", "", "out of range"},
+		{"1e308", "1" + strings.Repeat("0", 308), ""},
+		{"2e308", "", "out of range"},
+		{"1e1000000", "", "out of range"},
+		{"1e-1000000", "", "out of range"},
+		{"1e99999999999999999999", "", "out of range"},
+		{"1e", "", "not a number"},
+		{"1e2e3", "", "not a number"},
+	}
+	for _, tt := range tests {
+		got, err := ValueFormat{}.Normalize(tt.value)
+		switch {
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("Normalize(%q) = %q, %v, want error %q", tt.value, got, err, tt.err)
+		case tt.err == "" && (err != nil || got != tt.want):
+			t.Errorf("Normalize(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+	}
+}
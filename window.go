@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// windowSearchLimit ограничивает поиск ближайшего открытия окна отправки
+const windowSearchLimit = 8 * 24 * time.Hour
+
+// DeliveryConfig задает окна, в которые разрешена отправка, и периоды, когда она запрещена,
+// например на время обслуживания API. Элементы вне окна ждут в очереди его открытия
+type DeliveryConfig struct {
+	Timezone  string   `yaml:"timezone"`  // пояс IANA времени окон, пусто - местный
+	Windows   []string `yaml:"windows"`   // например "22:00-06:00" или "mon-fri 09:00-18:00"; пусто - в любое время
+	Blackouts []string `yaml:"blackouts"` // например "sun 01:00-03:00" или "2024-06-01 00:00/2024-06-01 06:00"
+}
+
+// enabled сообщает, ограничено ли время отправки
+func (c DeliveryConfig) enabled() bool {
+	return len(c.Windows) > 0 || len(c.Blackouts) > 0
+}
+
+// dailyWindow описывает ежедневный промежуток в выбранные дни недели; промежуток, конец которого
+// раньше начала, продолжается на следующий день
+type dailyWindow struct {
+	days     uint64 // битовая маска дней недели начала промежутка, 0 - воскресенье
+	from, to int    // минуты от полуночи
+}
+
+// period описывает разовый промежуток времени
+type period struct {
+	from, to time.Time
+}
+
+// DeliverySchedule сообщает, разрешена ли отправка в заданный момент
+type DeliverySchedule struct {
+	loc       *time.Location
+	windows   []dailyWindow
+	blackouts []dailyWindow
+	periods   []period
+}
+
+// NewDeliverySchedule разбирает окна и запрещенные периоды отправки
+func NewDeliverySchedule(c DeliveryConfig) (*DeliverySchedule, error) {
+	loc, err := loadLocation(c.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("timezone: %w", err)
+	}
+	s := &DeliverySchedule{loc: loc}
+	for i, text := range c.Windows {
+		w, err := parseDailyWindow(text)
+		if err != nil {
+			return nil, fmt.Errorf("windows[%d]: %w", i, err)
+		}
+		s.windows = append(s.windows, w)
+	}
+	for i, text := range c.Blackouts {
+		if from, to, ok := strings.Cut(text, "/"); ok {
+			p, err := parsePeriod(from, to, loc)
+			if err != nil {
+				return nil, fmt.Errorf("blackouts[%d]: %w", i, err)
+			}
+			s.periods = append(s.periods, p)
+			continue
+		}
+		w, err := parseDailyWindow(text)
+		if err != nil {
+			return nil, fmt.Errorf("blackouts[%d]: %w", i, err)
+		}
+		s.blackouts = append(s.blackouts, w)
+	}
+	return s, nil
+}
+
+// WithDeliverySchedule разрешает брать элементы из очереди только в окна отправки s. Элементы,
+// добавленные вне окна, ждут его открытия; начатые отправки и их повторы завершаются как обычно.
+// Flush и Close, как и после Pause, отправляют элементы и вне окна
+func WithDeliverySchedule(s *DeliverySchedule) Option {
+	return func(b *Buffer) {
+		b.window = s
+	}
+}
+
+// parseDailyWindow разбирает промежуток вида "[дни] ЧЧ:ММ-ЧЧ:ММ"; дни задаются как в cron,
+// например mon-fri или sat,sun
+func parseDailyWindow(text string) (dailyWindow, error) {
+	w := dailyWindow{days: 1<<7 - 1}
+	fields := strings.Fields(text)
+	switch len(fields) {
+	case 1:
+	case 2:
+		days, err := cronFields[4].parse(fields[0])
+		if err != nil {
+			return w, fmt.Errorf("%q: %w", text, err)
+		}
+		if days&(1<<7) != 0 {
+			days |= 1
+		}
+		w.days = days &^ (1 << 7)
+		fields = fields[1:]
+	default:
+		return w, fmt.Errorf("%q: expected [days] HH:MM-HH:MM", text)
+	}
+	from, to, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return w, fmt.Errorf("%q: expected [days] HH:MM-HH:MM", text)
+	}
+	var err error
+	if w.from, err = parseClock(from); err != nil {
+		return w, fmt.Errorf("%q: %w", text, err)
+	}
+	if w.to, err = parseClock(to); err != nil {
+		return w, fmt.Errorf("%q: %w", text, err)
+	}
+	return w, nil
+}
+
+// parseClock разбирает время суток ЧЧ:ММ в минуты от полуночи; 24:00 - конец суток
+func parseClock(text string) (int, error) {
+	h, m, ok := strings.Cut(text, ":")
+	hour, errH := strconv.Atoi(h)
+	minute, errM := strconv.Atoi(m)
+	if !ok || errH != nil || errM != nil || hour < 0 || minute < 0 || minute > 59 || hour > 24 || hour == 24 && minute != 0 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", text)
+	}
+	return hour*60 + minute, nil
+}
+
+// parsePeriod разбирает разовый промежуток из начала и конца вида "2006-01-02 15:04"
+func parsePeriod(from, to string, loc *time.Location) (period, error) {
+	var p period
+	var err error
+	if p.from, err = time.ParseInLocation("2006-01-02 15:04", strings.TrimSpace(from), loc); err != nil {
+		return p, fmt.Errorf("invalid start %q, expected 2006-01-02 15:04", from)
+	}
+	if p.to, err = time.ParseInLocation("2006-01-02 15:04", strings.TrimSpace(to), loc); err != nil {
+		return p, fmt.Errorf("invalid end %q, expected 2006-01-02 15:04", to)
+	}
+	if !p.to.After(p.from) {
+		return p, fmt.Errorf("end %q is not after start %q", to, from)
+	}
+	return p, nil
+}
+
+// contains сообщает, попадает ли t в промежуток
+func (w dailyWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	today := w.days&(1<<uint(t.Weekday())) != 0
+	if w.from < w.to {
+		return today && minute >= w.from && minute < w.to
+	}
+	yesterday := w.days&(1<<uint(t.AddDate(0, 0, -1).Weekday())) != 0
+	return today && minute >= w.from || yesterday && minute < w.to
+}
+
+// Open сообщает, разрешена ли отправка в момент t
+func (s *DeliverySchedule) Open(t time.Time) bool {
+	t = t.In(s.loc)
+	for _, p := range s.periods {
+		if !t.Before(p.from) && t.Before(p.to) {
+			return false
+		}
+	}
+	for _, w := range s.blackouts {
+		if w.contains(t) {
+			return false
+		}
+	}
+	if len(s.windows) == 0 {
+		return true
+	}
+	for _, w := range s.windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpen возвращает ближайший момент не раньше t, когда отправка разрешена, или нулевое время,
+// если окно не откроется в ближайшие восемь дней после разовых запретов
+func (s *DeliverySchedule) NextOpen(t time.Time) time.Time {
+	if s.Open(t) {
+		return t
+	}
+	next := t.In(s.loc).Truncate(time.Minute).Add(time.Minute)
+	limit := next.Add(windowSearchLimit)
+	for next.Before(limit) {
+		if p, ok := s.periodAt(next); ok {
+			limit = limit.Add(p.to.Sub(next))
+			next = p.to
+			continue
+		}
+		if s.Open(next) {
+			return next
+		}
+		next = next.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+// periodAt возвращает разовый запрет, в который попадает t
+func (s *DeliverySchedule) periodAt(t time.Time) (period, bool) {
+	for _, p := range s.periods {
+		if !t.Before(p.from) && t.Before(p.to) {
+			return p, true
+		}
+	}
+	return period{}, false
+}
+
+// windowWaitLocked возвращает время до открытия окна отправки; ok не задан, если окно открыто
+// или очередь пуста. Вызывается с захваченным b.mu
+func (b *Buffer) windowWaitLocked() (wait time.Duration, ok bool) {
+	if b.window == nil || b.flushing > 0 {
+		return 0, false
+	}
+	now := b.clock.Now()
+	if b.window.Open(now) {
+		if b.outside {
+			b.outside = false
+			logInfof("Delivery window is open, resuming delivery")
+		}
+		return 0, false
+	}
+	if b.queue.Len() == 0 && len(b.ready) == 0 {
+		return 0, false
+	}
+	next := b.window.NextOpen(now)
+	if !b.outside {
+		b.outside = true
+		if next.IsZero() {
+			logWarnf("outside the delivery window, and it does not open in the next 8 days")
+		} else {
+			logInfof("Outside the delivery window, %d items wait until %s", b.queue.Len(), next.Format(time.DateTime))
+		}
+	}
+	if next.IsZero() {
+		return time.Hour, true
+	}
+	return next.Sub(now), true
+}
+
+// windowClosedLocked сообщает, закрыто ли окно отправки. Вызывается с захваченным b.mu
+func (b *Buffer) windowClosedLocked() bool {
+	return b.window != nil && !b.window.Open(b.clock.Now())
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"buffer/kpidrivetest"
+	"buffer/mockkpi"
+)
+
+func TestDeliverySchedule(t *testing.T) {
+	s, err := NewDeliverySchedule(DeliveryConfig{
+		Timezone:  "UTC",
+		Windows:   []string{"22:00-06:00"},
+		Blackouts: []string{"sun 01:00-03:00", "2024-06-03 00:00/2024-06-03 02:00"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(s string) time.Time {
+		t, _ := time.Parse("2006-01-02 15:04", s)
+		return t
+	}
+	// 1 июня 2024 - суббота
+	tests := []struct {
+		now, next string
+	}{
+		{"2024-06-01 23:00", "2024-06-01 23:00"},
+		{"2024-06-01 12:00", "2024-06-01 22:00"},
+		{"2024-06-02 05:59", "2024-06-02 05:59"}, // окно субботы продолжается в воскресенье
+		{"2024-06-02 06:00", "2024-06-02 22:00"},
+		{"2024-06-03 01:30", "2024-06-03 02:00"}, // ежедневное окно внутри разового запрета
+		{"2024-06-09 00:30", "2024-06-09 00:30"},
+		{"2024-06-09 01:00", "2024-06-09 03:00"}, // запрет по воскресеньям
+	}
+	for _, tt := range tests {
+		now, want := at(tt.now), at(tt.next)
+		if got := s.NextOpen(now); !got.Equal(want) {
+			t.Errorf("NextOpen(%s) = %s, want %s", tt.now, got.Format(time.DateTime), tt.next)
+		}
+		if open := s.Open(now); open != now.Equal(want) {
+			t.Errorf("Open(%s) = %v", tt.now, open)
+		}
+	}
+	for _, bad := range []DeliveryConfig{{Windows: []string{"25:00-06:00"}}, {Blackouts: []string{"2024-06-03 02:00/2024-06-03 01:00"}}, {Timezone: "Mars/Olympus"}} {
+		if _, err := NewDeliverySchedule(bad); err == nil {
+			t.Errorf("schedule %+v accepted", bad)
+		}
+	}
+}
+
+// TestDeliveryWindowHoldsItems проверяет, что элемент, добавленный вне окна, отправляется
+// при его открытии по часам буфера
+func TestDeliveryWindowHoldsItems(t *testing.T) {
+	SetLogLevel(LevelError)
+	srv := kpidrivetest.NewServer(mockkpi.Options{})
+	defer srv.Close()
+	s, err := NewDeliverySchedule(DeliveryConfig{Timezone: "UTC", Windows: []string{"22:00-06:00"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := NewFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	b := NewBuffer(srv.SaveFactURL(), "token", WithClock(clock), WithDeliverySchedule(s))
+	defer b.Close()
+
+	if err := b.Add(benchmarkItem); err != nil {
+		t.Fatal(err)
+	}
+	clock.BlockUntil(1)
+	clock.Advance(10*time.Hour - time.Minute)
+	clock.BlockUntil(1)
+	if n := len(srv.Requests()); n != 0 || b.Pending() != 1 {
+		t.Fatalf("%d requests and %d pending items before the window opened, want 0 and 1", n, b.Pending())
+	}
+	clock.Advance(time.Minute)
+	waitFor(t, "delivery in the window", func() bool { return b.Stats().Sent == 1 })
+}
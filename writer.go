@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// maxWriterLine ограничивает длину строки LineWriter, как у построчного чтения источников
+const maxWriterLine = 16 * 1024 * 1024
+
+// LineWriter принимает факты потоком строк JSON: каждая строка с JSON-объектом становится
+// элементом буфера. Через него буфер подключается к журналам и компонентам, которые умеют
+// только писать в io.Writer. Строка может приходить частями в нескольких вызовах Write
+type LineWriter struct {
+	b       *Buffer
+	prepare func(map[string]string) (map[string]string, error)
+	opts    []ItemOption
+
+	maxLine int // наибольшая длина строки, по умолчанию maxWriterLine
+
+	mu       sync.Mutex
+	partial  []byte // начало строки без перевода строки
+	skipping bool   // строка длиннее maxLine отбрасывается до перевода строки
+	lines    int
+}
+
+// NewLineWriter создает LineWriter, добавляющий элементы в b с параметрами opts. Если задан
+// prepare, он преобразует и проверяет каждый элемент перед добавлением, как ImportOptions.Prepare
+func NewLineWriter(b *Buffer, prepare func(map[string]string) (map[string]string, error), opts ...ItemOption) *LineWriter {
+	return &LineWriter{b: b, prepare: prepare, opts: opts, maxLine: maxWriterLine}
+}
+
+// Write добавляет в буфер элементы из всех полных строк p и запоминает незаконченную строку до
+// следующего вызова. Пустые строки пропускаются. Write всегда принимает все байты: строки, которые
+// не разбираются, слишком длинные или отвергнуты буфером, пропускаются, а Write возвращает их
+// ошибки вместе
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var errs []error
+	data := p
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if w.skipping {
+			if i < 0 {
+				break
+			}
+			w.skipping = false
+			data = data[i+1:]
+			continue
+		}
+		end := i
+		if i < 0 {
+			end = len(data)
+		}
+		if len(w.partial)+end > w.maxLine {
+			w.lines++
+			errs = append(errs, fmt.Errorf("line %d is longer than %d bytes", w.lines, w.maxLine))
+			w.partial = w.partial[:0]
+			w.skipping = true
+			continue
+		}
+		if i < 0 {
+			w.partial = append(w.partial, data...)
+			break
+		}
+		line := data[:i]
+		if len(w.partial) > 0 {
+			line = append(w.partial, line...)
+		}
+		w.lines++
+		if err := w.add(line); err != nil {
+			errs = append(errs, err)
+		}
+		w.partial = w.partial[:0]
+		data = data[i+1:]
+	}
+	return len(p), errors.Join(errs...)
+}
+
+// Close добавляет последнюю строку, если она не закончена переводом строки
+func (w *LineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.skipping {
+		w.skipping = false
+		return nil
+	}
+	if len(w.partial) == 0 {
+		return nil
+	}
+	w.lines++
+	err := w.add(w.partial)
+	w.partial = nil
+	return err
+}
+
+// add добавляет в буфер элемент из строки с номером w.lines
+func (w *LineWriter) add(line []byte) error {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil
+	}
+	item, err := decodeJSONItem(line)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", w.lines, err)
+	}
+	if w.prepare != nil {
+		if item, err = w.prepare(item); err != nil {
+			return fmt.Errorf("line %d: %w", w.lines, err)
+		}
+	}
+	if err := w.b.Add(item, w.opts...); err != nil {
+		return fmt.Errorf("line %d rejected: %w", w.lines, err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"strings"
+	"testing"
+
+	"buffer/kpidrivetest"
+	"buffer/mockkpi"
+)
+
+// writerServer запускает поддельный API и буфер, отправляющий на него
+func writerServer(t *testing.T) (*kpidrivetest.Server, *Buffer) {
+	t.Helper()
+	SetLogLevel(LevelError)
+	srv := kpidrivetest.NewServer(mockkpi.Options{})
+	t.Cleanup(srv.Close)
+	b := NewBuffer(srv.SaveFactURL(), "token")
+	t.Cleanup(b.Close)
+	return srv, b
+}
+
+// factLine возвращает строку JSON с фактом benchmarkItem показателя indicator и комментарием comment
+func factLine(indicator, comment string) string {
+	item := maps.Clone(benchmarkItem)
+	item["indicator_to_mo_id"], item["comment"] = indicator, comment
+	line, _ := json.Marshal(item)
+	return string(line)
+}
+
+// sentIndicators возвращает indicator_to_mo_id отправленных элементов по порядку
+func sentIndicators(srv *kpidrivetest.Server) []string {
+	var ids []string
+	for _, r := range srv.Requests() {
+		ids = append(ids, r.Fields["indicator_to_mo_id"])
+	}
+	return ids
+}
+
+func TestLineWriterSplitLines(t *testing.T) {
+	srv, b := writerServer(t)
+	w := NewLineWriter(b, nil)
+	second := factLine("2", "")
+	for _, chunk := range []string{factLine("1", "") + "\n" + second[:20], second[20:], "\n\n"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Write(%q): %v", chunk, err)
+		}
+	}
+	b.Flush()
+	if got := sentIndicators(srv); strings.Join(got, ",") != "1,2" {
+		t.Errorf("sent indicators %v, want [1 2]", got)
+	}
+}
+
+func TestLineWriterSkipsLongLine(t *testing.T) {
+	srv, b := writerServer(t)
+	w := NewLineWriter(b, nil)
+	w.maxLine = 512
+	long := factLine("1", strings.Repeat("x", 1024))
+
+	// Хвост длинной строки в следующем вызове не разбирается как новая строка
+	_, err := w.Write([]byte(long[:600]))
+	if err == nil || !strings.Contains(err.Error(), "line 1 is longer than 512 bytes") {
+		t.Fatalf("long line: %v", err)
+	}
+	_, err = w.Write([]byte(long[600:] + "\n" + factLine("2", "") + "\nnot json\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 3:") || strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("lines after the long one: %v", err)
+	}
+	// Длинная строка целиком в одном вызове тоже пропускается
+	if _, err := w.Write([]byte(long + "\n")); err == nil || !strings.Contains(err.Error(), "line 4 is longer") {
+		t.Fatalf("long complete line: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b.Flush()
+	if got := sentIndicators(srv); strings.Join(got, ",") != "2" {
+		t.Errorf("sent indicators %v, want [2]", got)
+	}
+}
+
+func TestLineWriterCloseAddsLastLine(t *testing.T) {
+	srv, b := writerServer(t)
+	w := NewLineWriter(b, nil)
+	if _, err := w.Write([]byte(factLine("3", ""))); err != nil {
+		t.Fatal(err)
+	}
+	b.Flush()
+	if n := len(srv.Requests()); n != 0 {
+		t.Fatalf("unterminated line sent before Close: %d requests", n)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b.Flush()
+	if got := sentIndicators(srv); strings.Join(got, ",") != "3" {
+		t.Errorf("sent indicators %v, want [3]", got)
+	}
+}
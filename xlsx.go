@@ -0,0 +1,418 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// xlsxEpoch задает начало отсчета дат Excel; дни считаются от 30.12.1899 с учетом ошибки 1900 года
+var xlsxEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// xlsxRowReader читает строки первого листа книги XLSX, используя первую строку как имена полей.
+// Лист разбирается потоково прямо из архива, в памяти держатся только общие строки и стили
+type xlsxRowReader struct {
+	sheet   io.ReadCloser
+	counter *countingReader
+	total   int64
+	size    int64
+	dec     *xml.Decoder
+	strings []string
+	dates   []bool // признак формата даты для каждого стиля ячеек
+	header  []string
+}
+
+// newXLSXRowReader открывает книгу r размером size и читает строку заголовков первого листа
+func newXLSXRowReader(r io.ReaderAt, size int64) (*xlsxRowReader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("opening XLSX: %w", err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	x := &xlsxRowReader{size: size}
+	if f := files["xl/sharedStrings.xml"]; f != nil {
+		if x.strings, err = readSharedStrings(f); err != nil {
+			return nil, fmt.Errorf("reading XLSX shared strings: %w", err)
+		}
+	}
+	if f := files["xl/styles.xml"]; f != nil {
+		if x.dates, err = readDateStyles(f); err != nil {
+			return nil, fmt.Errorf("reading XLSX styles: %w", err)
+		}
+	}
+	name, err := firstSheet(files)
+	if err != nil {
+		return nil, err
+	}
+	sheet := files[name]
+	if sheet == nil {
+		return nil, fmt.Errorf("XLSX sheet %s not found", name)
+	}
+	if x.sheet, err = sheet.Open(); err != nil {
+		return nil, err
+	}
+	x.total = int64(sheet.UncompressedSize64)
+	x.counter = &countingReader{r: x.sheet}
+	x.dec = xml.NewDecoder(x.counter)
+
+	header, err := x.nextRow()
+	if errors.Is(err, io.EOF) {
+		x.sheet.Close()
+		return nil, fmt.Errorf("reading XLSX header: empty sheet")
+	}
+	if err != nil {
+		x.sheet.Close()
+		return nil, fmt.Errorf("reading XLSX header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+	x.header = header
+	return x, nil
+}
+
+func (x *xlsxRowReader) Next() (map[string]string, error) {
+	for {
+		record, err := x.nextRow()
+		if err != nil {
+			return nil, err
+		}
+		item := make(map[string]string, len(x.header))
+		empty := true
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+			if i >= len(x.header) || x.header[i] == "" {
+				return nil, &rowParseError{err: fmt.Errorf("value in column %s has no header", columnName(i))}
+			}
+			item[x.header[i]] = value
+			empty = false
+		}
+		// Пустые строки в конце листа часто остаются после удаления данных
+		if !empty {
+			return item, nil
+		}
+	}
+}
+
+// Offset возвращает размер файла: смещение строки в сжатом архиве не имеет смысла,
+// поэтому контрольная точка проверяет, что книга не изменилась целиком
+func (x *xlsxRowReader) Offset() int64 {
+	return x.size
+}
+
+// Progress возвращает количество разобранных байт листа и его полный размер
+func (x *xlsxRowReader) Progress() (int64, int64) {
+	return x.counter.Count(), x.total
+}
+
+// Close закрывает поток листа
+func (x *xlsxRowReader) Close() error {
+	return x.sheet.Close()
+}
+
+// nextRow читает значения ячеек следующей строки листа по номерам столбцов
+func (x *xlsxRowReader) nextRow() ([]string, error) {
+	var (
+		record  []string
+		inRow   bool
+		cell    xlsxCell
+		inCell  bool
+		inValue bool
+		text    strings.Builder
+	)
+	for {
+		tok, err := x.dec.Token()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing XLSX sheet: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				inRow, record = true, record[:0]
+			case "c":
+				if inRow {
+					inCell, cell = true, xlsxCell{column: len(record), style: -1}
+					for _, attr := range t.Attr {
+						switch attr.Name.Local {
+						case "r":
+							if col, ok := parseCellColumn(attr.Value); ok {
+								cell.column = col
+							}
+						case "t":
+							cell.kind = attr.Value
+						case "s":
+							if s, err := strconv.Atoi(attr.Value); err == nil {
+								cell.style = s
+							}
+						}
+					}
+					text.Reset()
+				}
+			case "v", "t":
+				inValue = inCell
+			}
+		case xml.CharData:
+			if inValue {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "c":
+				if inCell {
+					inCell = false
+					value, err := x.cellValue(cell, text.String())
+					if err != nil {
+						return nil, &rowParseError{err: fmt.Errorf("column %s: %w", columnName(cell.column), err)}
+					}
+					for len(record) < cell.column {
+						record = append(record, "")
+					}
+					record = append(record, value)
+				}
+			case "row":
+				if inRow {
+					return record, nil
+				}
+			}
+		}
+	}
+}
+
+// xlsxCell описывает атрибуты разбираемой ячейки
+type xlsxCell struct {
+	column int
+	kind   string
+	style  int
+}
+
+// cellValue приводит значение ячейки к строке: общие строки подставляются, даты выводятся как YYYY-MM-DD
+func (x *xlsxRowReader) cellValue(cell xlsxCell, raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	switch cell.kind {
+	case "s":
+		i, err := strconv.Atoi(raw)
+		if err != nil || i < 0 || i >= len(x.strings) {
+			return "", fmt.Errorf("invalid shared string index %q", raw)
+		}
+		return x.strings[i], nil
+	case "b":
+		if raw == "1" {
+			return "true", nil
+		}
+		return "false", nil
+	case "str", "inlineStr", "e":
+		return raw, nil
+	}
+	if cell.style < 0 || cell.style >= len(x.dates) || !x.dates[cell.style] {
+		return raw, nil
+	}
+	serial, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return raw, nil
+	}
+	days, frac := math.Modf(serial)
+	t := xlsxEpoch.AddDate(0, 0, int(days)).Add(time.Duration(frac * float64(24*time.Hour)).Round(time.Second))
+	if frac == 0 {
+		return t.Format("2006-01-02"), nil
+	}
+	return t.Format("2006-01-02 15:04:05"), nil
+}
+
+// firstSheet находит файл первого листа книги по workbook.xml и его связям
+func firstSheet(files map[string]*zip.File) (string, error) {
+	const fallback = "xl/worksheets/sheet1.xml"
+	wb, rels := files["xl/workbook.xml"], files["xl/_rels/workbook.xml.rels"]
+	if wb == nil || rels == nil {
+		return fallback, nil
+	}
+	var workbook struct {
+		Sheets []struct {
+			ID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodeZipXML(wb, &workbook); err != nil {
+		return "", fmt.Errorf("reading XLSX workbook: %w", err)
+	}
+	var relationships struct {
+		Items []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodeZipXML(rels, &relationships); err != nil {
+		return "", fmt.Errorf("reading XLSX workbook relationships: %w", err)
+	}
+	if len(workbook.Sheets) == 0 {
+		return "", fmt.Errorf("XLSX workbook has no sheets")
+	}
+	for _, rel := range relationships.Items {
+		if rel.ID == workbook.Sheets[0].ID {
+			if strings.HasPrefix(rel.Target, "/") {
+				return strings.TrimPrefix(rel.Target, "/"), nil
+			}
+			return path.Join("xl", rel.Target), nil
+		}
+	}
+	return fallback, nil
+}
+
+// readSharedStrings загружает таблицу общих строк книги
+func readSharedStrings(f *zip.File) ([]string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	dec := xml.NewDecoder(rc)
+	var (
+		out    []string
+		text   strings.Builder
+		inItem bool
+		inText bool
+		inRuby bool // фонетические подсказки rPh не входят в значение
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				inItem = true
+				text.Reset()
+			case "t":
+				inText = inItem && !inRuby
+			case "rPh":
+				inRuby = true
+			}
+		case xml.CharData:
+			if inText {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "rPh":
+				inRuby = false
+			case "si":
+				inItem = false
+				out = append(out, text.String())
+			}
+		}
+	}
+}
+
+// readDateStyles определяет, какие стили ячеек задают формат даты
+func readDateStyles(f *zip.File) ([]bool, error) {
+	var styles struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		CellXfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if err := decodeZipXML(f, &styles); err != nil {
+		return nil, err
+	}
+	custom := make(map[int]string, len(styles.NumFmts))
+	for _, nf := range styles.NumFmts {
+		custom[nf.ID] = nf.Code
+	}
+	dates := make([]bool, len(styles.CellXfs))
+	for i, xf := range styles.CellXfs {
+		if code, ok := custom[xf.NumFmtID]; ok {
+			dates[i] = isDateFormat(code)
+		} else {
+			dates[i] = isBuiltinDateFormat(xf.NumFmtID)
+		}
+	}
+	return dates, nil
+}
+
+// isBuiltinDateFormat сообщает, является ли встроенный формат Excel форматом даты
+func isBuiltinDateFormat(id int) bool {
+	return id >= 14 && id <= 22 || id >= 27 && id <= 36 || id >= 45 && id <= 47 || id >= 50 && id <= 58
+}
+
+// isDateFormat сообщает, содержит ли пользовательский формат числа элементы даты;
+// текст в кавычках и секции в квадратных скобках не учитываются
+func isDateFormat(code string) bool {
+	quoted, bracket := false, false
+	for _, r := range strings.ToLower(code) {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '[':
+			bracket = true
+		case r == ']':
+			bracket = false
+		case bracket:
+		case r == 'y' || r == 'd':
+			return true
+		}
+	}
+	return false
+}
+
+// decodeZipXML разбирает XML-файл архива целиком; используется для небольших служебных файлов
+func decodeZipXML(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+// parseCellColumn возвращает номер столбца (с нуля) из ссылки на ячейку вида AB12
+func parseCellColumn(ref string) (int, bool) {
+	col := 0
+	n := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+		n++
+	}
+	return col - 1, n > 0
+}
+
+// columnName возвращает буквенное имя столбца по номеру с нуля
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}